@@ -44,18 +44,78 @@ func GetRateLimitConf() []RateLimitConf {
 	return globalConfig.RateLimit
 }
 
+func GetRateLimitFailoverConf() RateLimitFailoverConf {
+	return globalConfig.RateLimitFailover
+}
+
+func GetQuotaConf() QuotaConf {
+	return globalConfig.Quota
+}
+
 func GetLoggerConf() LoggerConf {
 	return globalConfig.Logger
 }
 
+func GetClientIPConf() ClientIPConf {
+	return globalConfig.ClientIP
+}
+
+func GetIPFilterConf() IPFilterConf {
+	return globalConfig.IPFilter
+}
+
 func GetLoginProtectionConf() LoginProtectionConf {
 	return globalConfig.LoginProtection
 }
 
+func GetAccountProtectionConf() AccountProtectionConf {
+	return globalConfig.AccountProtection
+}
+
 func GetRegisterProtectionConf() RegisterProtectionConf {
 	return globalConfig.RegisterProtection
 }
 
+func GetChallengeConf() ChallengeConf {
+	return globalConfig.Challenge
+}
+
+func GetPasswordConf() PasswordConf {
+	return globalConfig.Password
+}
+
+func GetMFAConf() MFAConf {
+	return globalConfig.MFA
+}
+
+func GetWebAuthnConf() WebAuthnConf {
+	return globalConfig.WebAuthn
+}
+
+func GetOIDCConf() OIDCConf {
+	return globalConfig.OIDC
+}
+
+func GetMailerConf() MailerConf {
+	return globalConfig.Mailer
+}
+
+func GetPasswordResetConf() PasswordResetConf {
+	return globalConfig.PasswordReset
+}
+
+func GetEmailVerificationConf() EmailVerificationConf {
+	return globalConfig.EmailVerification
+}
+
+func GetAuthzConf() AuthzConf {
+	return globalConfig.Authz
+}
+
+func GetAuditConf() AuditConf {
+	return globalConfig.Audit
+}
+
 var globalConfig ServiceConf
 
 type ServiceConf struct {
@@ -65,12 +125,46 @@ type ServiceConf struct {
 	CORS               CORSConf               `yaml:"cors"`
 	Session            SessionConf            `yaml:"session"`
 	RateLimit          []RateLimitConf        `yaml:"rate_limit"`
+	RateLimitFailover  RateLimitFailoverConf  `yaml:"rate_limit_failover"`
+	Quota              QuotaConf              `yaml:"quota"`
 	Logger             LoggerConf             `yaml:"logger"`
+	ClientIP           ClientIPConf           `yaml:"client_ip"`
+	IPFilter           IPFilterConf           `yaml:"ip_filter"`
 	LoginProtection    LoginProtectionConf    `yaml:"login_protection"`
+	AccountProtection  AccountProtectionConf  `yaml:"account_protection"`
 	RegisterProtection RegisterProtectionConf `yaml:"register_protection"`
+	Challenge          ChallengeConf          `yaml:"challenge"`
+	Password           PasswordConf           `yaml:"password"`
+	MFA                MFAConf                `yaml:"mfa"`
+	WebAuthn           WebAuthnConf           `yaml:"webauthn"`
+	OIDC               OIDCConf               `yaml:"oidc"`
+	Mailer             MailerConf             `yaml:"mailer"`
+	PasswordReset      PasswordResetConf      `yaml:"password_reset"`
+	EmailVerification  EmailVerificationConf  `yaml:"email_verification"`
+	Authz              AuthzConf              `yaml:"authz"`
+	Audit              AuditConf              `yaml:"audit"`
+}
+
+// ClientIPConf tells which proxies are trusted to pass the client IP in the headers. Without
+// trusted proxies the address of the connection is the client IP.
+type ClientIPConf struct {
+	TrustedProxies []string `yaml:"trusted_proxies"` // IPs or CIDR ranges
+	Headers        []string `yaml:"headers"`         // X-Forwarded-For and X-Real-IP when empty
+}
+
+// IPFilterConf rejects requests by client IP. Denied IPs are rejected, and when there are allowed
+// ranges every IP outside them is rejected as well. The ranges set at runtime add to these.
+type IPFilterConf struct {
+	Allow          []string `yaml:"allow"` // IPs or CIDR ranges
+	Deny           []string `yaml:"deny"`
+	RefreshSeconds int      `yaml:"refresh_seconds"` // how often the ranges set at runtime are reloaded
 }
 
 type LoginProtectionConf struct {
+	// Tiers escalate the blocks of an IP failing to log in again and again, in order. Without
+	// tiers the two tiers of the fields below are used.
+	Tiers []LoginBlockTierConf `yaml:"tiers"`
+
 	WindowSeconds     int `yaml:"window_seconds"`
 	Limit             int `yaml:"limit"`
 	BlockMinDuration  int `yaml:"block_min_duration"`
@@ -81,10 +175,129 @@ type LoginProtectionConf struct {
 	SuccessLimit         int `yaml:"success_limit"`
 }
 
+// LoginBlockTierConf blocks an IP which fails Failures times within the window. The tier stays
+// reached for the decay period after the block, further failures then count for the next tier.
+type LoginBlockTierConf struct {
+	Failures      int `yaml:"failures"`
+	WindowSeconds int `yaml:"window_seconds"`
+	BlockSeconds  int `yaml:"block_seconds"`
+	DecaySeconds  int `yaml:"decay_seconds"`
+}
+
+// AccountProtectionConf counts login failures per account, so attacks spread over many IPs are
+// slowed down and locked out as well, and flags IPs failing on many different accounts.
+type AccountProtectionConf struct {
+	WindowSeconds int `yaml:"window_seconds"` // failures of an account are counted in this window
+	DelayAfter    int `yaml:"delay_after"`    // failures before logins of the account are delayed
+	DelayStepMs   int `yaml:"delay_step_ms"`  // the delay grows by this for every further failure
+	MaxDelayMs    int `yaml:"max_delay_ms"`
+	LockAfter     int `yaml:"lock_after"` // failures which lock the account temporarily
+	LockMinutes   int `yaml:"lock_minutes"`
+
+	SprayWindowSeconds int `yaml:"spray_window_seconds"`
+	SprayAccounts      int `yaml:"spray_accounts"` // distinct failing accounts which flag an IP as spraying
+	SprayBlockMinutes  int `yaml:"spray_block_minutes"`
+}
+
 type RegisterProtectionConf struct {
 	BlockMinutes int `yaml:"block_minutes"`
 }
 
+// ChallengeConf makes clients with recent login failures solve a challenge before logins and
+// registrations are processed.
+type ChallengeConf struct {
+	Provider      string `yaml:"provider"` // pow
+	After         int    `yaml:"after"`    // login failures of an IP before challenges are asked, 0 disables them
+	WindowSeconds int    `yaml:"window_seconds"`
+	TTLSeconds    int    `yaml:"ttl_seconds"` // how long an issued challenge can be solved
+	Difficulty    int    `yaml:"difficulty"`  // leading zero bits of the proof-of-work hash
+}
+
+type PasswordConf struct {
+	Algorithm string       `yaml:"algorithm"` // argon2id, bcrypt
+	Argon2id  Argon2idConf `yaml:"argon2id"`
+	Bcrypt    BcryptConf   `yaml:"bcrypt"`
+}
+
+type Argon2idConf struct {
+	Memory      uint32 `yaml:"memory"` // KiB
+	Iterations  uint32 `yaml:"iterations"`
+	Parallelism uint8  `yaml:"parallelism"`
+	SaltLength  uint32 `yaml:"salt_length"`
+	KeyLength   uint32 `yaml:"key_length"`
+}
+
+type BcryptConf struct {
+	Cost int `yaml:"cost"`
+}
+
+type MFAConf struct {
+	Issuer            string `yaml:"issuer"`              // otpauth issuer shown in authenticator apps
+	Skew              int    `yaml:"skew"`                // accepted clock drift in 30s steps
+	ChallengeTTL      int    `yaml:"challenge_ttl"`       // seconds
+	MaxAttempts       int    `yaml:"max_attempts"`        // code attempts per login challenge
+	RecoveryCodeCount int    `yaml:"recovery_code_count"` // recovery codes issued on confirmation
+}
+
+type WebAuthnConf struct {
+	RPID          string   `yaml:"rp_id"` // domain without scheme and port
+	RPDisplayName string   `yaml:"rp_display_name"`
+	RPOrigins     []string `yaml:"rp_origins"` // full origins, e.g. https://example.com
+	Timeout       int      `yaml:"timeout"`    // s
+}
+
+type OIDCConf struct {
+	Providers []OIDCProviderConf `yaml:"providers"`
+}
+
+type OIDCProviderConf struct {
+	Name          string   `yaml:"name"`   // used in routes, e.g. google
+	Issuer        string   `yaml:"issuer"` // discovery URL, must equal the iss claim
+	ClientID      string   `yaml:"client_id"`
+	ClientSecret  string   `yaml:"client_secret"`
+	RedirectURL   string   `yaml:"redirect_url"`   // frontend callback registered at the IdP
+	Scopes        []string `yaml:"scopes"`         // default: openid profile email
+	AutoProvision bool     `yaml:"auto_provision"` // create a user on first login of an unlinked identity
+}
+
+type MailerConf struct {
+	Driver string   `yaml:"driver"` // smtp, file or memory, default: file
+	From   string   `yaml:"from"`
+	Dir    string   `yaml:"dir"` // file driver, default: ./mail
+	SMTP   SMTPConf `yaml:"smtp"`
+}
+
+type SMTPConf struct {
+	Host        string `yaml:"host"`
+	Port        int    `yaml:"port"`
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	ImplicitTLS bool   `yaml:"implicit_tls"` // port 465, otherwise STARTTLS is used when offered
+}
+
+type PasswordResetConf struct {
+	TokenTTL int    `yaml:"token_ttl"` // seconds
+	URL      string `yaml:"url"`       // reset page of the frontend, the token is appended as ?token=
+}
+
+type EmailVerificationConf struct {
+	Secret   string `yaml:"secret"`    // signs the verification links
+	TokenTTL int    `yaml:"token_ttl"` // seconds
+	URL      string `yaml:"url"`       // verification page of the frontend, the token is appended as ?token=
+	Required bool   `yaml:"required"`  // password login is refused until the email is verified
+}
+
+type AuthzConf struct {
+	// TokenRoles carries the roles of the user in the access token, so permission checks skip the
+	// role lookup. Role changes then take effect with the next token.
+	TokenRoles bool `yaml:"token_roles"`
+}
+
+type AuditConf struct {
+	QueueSize int `yaml:"queue_size"` // events waiting to be written, more are dropped
+	BatchSize int `yaml:"batch_size"` // events written per insert
+}
+
 type MySQLConf struct {
 	DBName        string `yaml:"db_name"`
 	IP            string `yaml:"ip"`
@@ -110,6 +323,28 @@ type JWTConf struct {
 
 	AccessExpiration  int `yaml:"access_expiration"`
 	RefreshExpiration int `yaml:"refresh_expiration"`
+
+	// AccessTokenSecret and RefreshTokenSecret sign only when no keys or secrets are configured,
+	// otherwise they verify tokens without kid
+	SigningKeyID        string          `yaml:"signing_key_id"` // default: first key able to sign
+	AccessTokenKeys     []JWTKeyConf    `yaml:"access_token_keys"`
+	AccessTokenSecrets  []JWTSecretConf `yaml:"access_token_secrets"`  // HS256
+	RefreshTokenSecrets []JWTSecretConf `yaml:"refresh_token_secrets"` // HS256, the first one signs
+}
+
+type JWTSecretConf struct {
+	ID     string `yaml:"id"` // kid
+	Secret string `yaml:"secret"`
+}
+
+type JWTKeyConf struct {
+	ID             string `yaml:"id"`        // kid
+	Algorithm      string `yaml:"algorithm"` // HS256, RS256, ES256, EdDSA
+	Secret         string `yaml:"secret"`    // HS256 only
+	PrivateKey     string `yaml:"private_key"`
+	PrivateKeyFile string `yaml:"private_key_file"`
+	PublicKey      string `yaml:"public_key"` // verify only, for retired keys
+	PublicKeyFile  string `yaml:"public_key_file"`
 }
 
 type CORSConf struct {
@@ -129,13 +364,42 @@ type SessionConf struct {
 	Secure      bool   `yaml:"secure"`
 	HTTPOnly    bool   `yaml:"http_only"`
 	SameSite    string `yaml:"same_site"`
+
+	// HMAC keys signing the session cookie, the first one signs. Cookies are plain session IDs when empty.
+	Keys          []string `yaml:"keys"`
+	AllowUnsigned bool     `yaml:"allow_unsigned"` // accept plain cookies while keys are first rolled out
 }
 
 type RateLimitConf struct {
-	Path          string `yaml:"path"`
-	WindowSeconds int    `yaml:"window_seconds"`
-	Limit         int64  `yaml:"limit"`
-	HasSession    bool   `yaml:"has_session"`
+	Name string `yaml:"name"` // bucket name, path and position in the list when empty
+	// Path is a route template as registered, e.g. /api/v1/admin/users/:user_id,
+	// a trailing * matches every route under the prefix and * alone matches every route
+	Path    string   `yaml:"path"`
+	Methods []string `yaml:"methods"` // every method when empty
+	// Key combines ip, session, user, route, method and header:<name>, ip when empty.
+	// Anonymous requests are keyed by client IP in place of user.
+	Key           []string `yaml:"key"`
+	Fallback      bool     `yaml:"fallback"` // only applies when no other rule matches the request
+	WindowSeconds int      `yaml:"window_seconds"`
+	Limit         int64    `yaml:"limit"`
+	HasSession    bool     `yaml:"has_session"` // same as key: [session]
+	Algorithm     string   `yaml:"algorithm"`   // fixed_window (default), sliding_log, sliding_window, token_bucket
+	Burst         int64    `yaml:"burst"`       // token bucket size, limit when 0; the bucket refills at limit per window
+}
+
+// RateLimitFailoverConf configures the in-process limiter which takes over while Redis fails.
+type RateLimitFailoverConf struct {
+	FailOpen         bool `yaml:"fail_open"`         // allow every request while Redis fails instead of limiting locally
+	Instances        int  `yaml:"instances"`         // instances sharing the limits, each one allows its share locally, 1 when 0
+	FailureThreshold int  `yaml:"failure_threshold"` // consecutive Redis errors which open the circuit breaker, 5 when 0
+	OpenSeconds      int  `yaml:"open_seconds"`      // how long Redis is skipped before it is probed again, 10 when 0
+}
+
+// QuotaConf configures the daily and monthly quotas of the plans, the plans themselves are kept in MySQL.
+type QuotaConf struct {
+	DefaultPlan      string `yaml:"default_plan"`       // plan of users without one assigned, none are metered when empty
+	FlushSeconds     int    `yaml:"flush_seconds"`      // how often the usage counted in Redis is rolled up to MySQL, 60 when 0
+	PlanCacheSeconds int    `yaml:"plan_cache_seconds"` // how long plans and assignments are cached, 300 when 0
 }
 
 type LoggerConf struct {
@@ -0,0 +1,74 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"doing_now/be/biz/model/storage"
+
+	"gorm.io/gorm"
+)
+
+// AuditEventRepository only appends and reads, audit events are never changed.
+type AuditEventRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditEventRepository(db *gorm.DB) *AuditEventRepository {
+	return &AuditEventRepository{db: db}
+}
+
+func (r *AuditEventRepository) Create(ctx context.Context, events []*storage.AuditEventRecord) error {
+	return r.db.WithContext(ctx).Create(events).Error
+}
+
+// AuditEventFilter narrows an audit event search, zero fields do not filter.
+type AuditEventFilter struct {
+	// UserID matches events where the user is the actor or the target
+	UserID    string
+	ActorID   string
+	TargetID  string
+	EventType string
+	IP        string
+	From      time.Time
+	To        time.Time
+	Offset    int
+	Limit     int
+}
+
+// Search returns a page of events, newest first, and the total count.
+func (r *AuditEventRepository) Search(ctx context.Context, f AuditEventFilter) ([]*storage.AuditEventRecord, int64, error) {
+	q := r.db.WithContext(ctx).Model(&storage.AuditEventRecord{})
+	if f.UserID != "" {
+		q = q.Where("actor_id = ? OR target_id = ?", f.UserID, f.UserID)
+	}
+	if f.ActorID != "" {
+		q = q.Where("actor_id = ?", f.ActorID)
+	}
+	if f.TargetID != "" {
+		q = q.Where("target_id = ?", f.TargetID)
+	}
+	if f.EventType != "" {
+		q = q.Where("event_type = ?", f.EventType)
+	}
+	if f.IP != "" {
+		q = q.Where("ip = ?", f.IP)
+	}
+	if !f.From.IsZero() {
+		q = q.Where("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		q = q.Where("created_at < ?", f.To)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []*storage.AuditEventRecord
+	err := q.Order("created_at DESC, id DESC").Offset(f.Offset).Limit(f.Limit).Find(&list).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
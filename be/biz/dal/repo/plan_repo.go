@@ -0,0 +1,107 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"doing_now/be/biz/model/storage"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PlanRepository struct {
+	db *gorm.DB
+}
+
+func NewPlanRepository(db *gorm.DB) *PlanRepository {
+	return &PlanRepository{db: db}
+}
+
+func (r *PlanRepository) FindByName(ctx context.Context, name string) (*storage.PlanRecord, error) {
+	var m storage.PlanRecord
+	err := r.db.WithContext(ctx).Where("name = ?", name).First(&m).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+type PlanQuotaRepository struct {
+	db *gorm.DB
+}
+
+func NewPlanQuotaRepository(db *gorm.DB) *PlanQuotaRepository {
+	return &PlanQuotaRepository{db: db}
+}
+
+func (r *PlanQuotaRepository) FindByPlan(ctx context.Context, plan string) ([]storage.PlanQuotaRecord, error) {
+	var list []storage.PlanQuotaRecord
+	err := r.db.WithContext(ctx).Where("plan = ?", plan).Order("feature").Find(&list).Error
+	return list, err
+}
+
+type UserPlanRepository struct {
+	db *gorm.DB
+}
+
+func NewUserPlanRepository(db *gorm.DB) *UserPlanRepository {
+	return &UserPlanRepository{db: db}
+}
+
+// FindPlan returns the plan assigned to the user, empty when there is none.
+func (r *UserPlanRepository) FindPlan(ctx context.Context, userID string) (string, error) {
+	var m storage.UserPlanRecord
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&m).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	return m.Plan, nil
+}
+
+// Assign sets the plan of the user, replacing the one assigned before.
+func (r *UserPlanRepository) Assign(ctx context.Context, userID, plan string) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]any{"plan": plan, "updated_at": time.Now()}),
+	}).Create(&storage.UserPlanRecord{UserId: userID, Plan: plan}).Error
+}
+
+type QuotaUsageRepository struct {
+	db *gorm.DB
+}
+
+func NewQuotaUsageRepository(db *gorm.DB) *QuotaUsageRepository {
+	return &QuotaUsageRepository{db: db}
+}
+
+// Upsert writes the usage of the period, the total only grows so a late write never lowers it.
+func (r *QuotaUsageRepository) Upsert(ctx context.Context, m *storage.QuotaUsageRecord) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "feature"}, {Name: "period"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"used":       gorm.Expr("CASE WHEN used > ? THEN used ELSE ? END", m.Used, m.Used),
+			"updated_at": time.Now(),
+		}),
+	}).Create(m).Error
+}
+
+func (r *QuotaUsageRepository) Find(ctx context.Context, userID, feature, period string) (*storage.QuotaUsageRecord, error) {
+	var m storage.QuotaUsageRecord
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND feature = ? AND period = ?", userID, feature, period).
+		First(&m).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
@@ -0,0 +1,76 @@
+package repo
+
+import (
+	"context"
+
+	"doing_now/be/biz/model/storage"
+
+	"gorm.io/gorm"
+)
+
+type RoleRepository struct {
+	db *gorm.DB
+}
+
+func NewRoleRepository(db *gorm.DB) *RoleRepository {
+	return &RoleRepository{db: db}
+}
+
+func (r *RoleRepository) FindByName(ctx context.Context, name string) (*storage.RoleRecord, error) {
+	var m storage.RoleRecord
+	err := r.db.WithContext(ctx).Where("name = ?", name).First(&m).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+type RolePermissionRepository struct {
+	db *gorm.DB
+}
+
+func NewRolePermissionRepository(db *gorm.DB) *RolePermissionRepository {
+	return &RolePermissionRepository{db: db}
+}
+
+// FindPermissions returns the distinct permissions granted by the roles.
+func (r *RolePermissionRepository) FindPermissions(ctx context.Context, roles []string) ([]string, error) {
+	if len(roles) == 0 {
+		return nil, nil
+	}
+	var perms []string
+	err := r.db.WithContext(ctx).Model(&storage.RolePermissionRecord{}).
+		Where("role IN ?", roles).
+		Distinct().Pluck("permission", &perms).Error
+	return perms, err
+}
+
+type UserRoleRepository struct {
+	db *gorm.DB
+}
+
+func NewUserRoleRepository(db *gorm.DB) *UserRoleRepository {
+	return &UserRoleRepository{db: db}
+}
+
+func (r *UserRoleRepository) FindRoles(ctx context.Context, userID string) ([]string, error) {
+	var roles []string
+	err := r.db.WithContext(ctx).Model(&storage.UserRoleRecord{}).
+		Where("user_id = ?", userID).
+		Order("role").Pluck("role", &roles).Error
+	return roles, err
+}
+
+func (r *UserRoleRepository) Create(ctx context.Context, m *storage.UserRoleRecord) error {
+	return r.db.WithContext(ctx).Create(m).Error
+}
+
+// Delete removes the assignment permanently, so the role can be assigned again.
+func (r *UserRoleRepository) Delete(ctx context.Context, userID, role string) error {
+	return r.db.WithContext(ctx).Unscoped().
+		Where("user_id = ? AND role = ?", userID, role).
+		Delete(&storage.UserRoleRecord{}).Error
+}
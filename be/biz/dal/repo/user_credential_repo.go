@@ -48,3 +48,10 @@ func (r *UserCredentialRepository) FindByUserIDLock(ctx context.Context, userID
 func (r *UserCredentialRepository) Update(ctx context.Context, c *storage.UserCredentialRecord) error {
 	return r.db.WithContext(ctx).Save(c).Error
 }
+
+// Restore brings back the soft-deleted credential of a user.
+func (r *UserCredentialRepository) Restore(ctx context.Context, userID string) error {
+	return r.db.WithContext(ctx).Unscoped().Model(&storage.UserCredentialRecord{}).
+		Where("user_id = ? AND deleted_at <> 0", userID).
+		Update("deleted_at", 0).Error
+}
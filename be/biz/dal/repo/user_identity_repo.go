@@ -0,0 +1,43 @@
+package repo
+
+import (
+	"context"
+
+	"doing_now/be/biz/model/storage"
+
+	"gorm.io/gorm"
+)
+
+type UserIdentityRepository struct {
+	db *gorm.DB
+}
+
+func NewUserIdentityRepository(db *gorm.DB) *UserIdentityRepository {
+	return &UserIdentityRepository{db: db}
+}
+
+func (r *UserIdentityRepository) Create(ctx context.Context, m *storage.UserIdentityRecord) error {
+	return r.db.WithContext(ctx).Create(m).Error
+}
+
+func (r *UserIdentityRepository) FindBySubject(ctx context.Context, issuer, subject string) (*storage.UserIdentityRecord, error) {
+	var m storage.UserIdentityRecord
+	err := r.db.WithContext(ctx).Where("issuer = ? AND subject = ?", issuer, subject).First(&m).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (r *UserIdentityRepository) ListByUserID(ctx context.Context, userID string) ([]*storage.UserIdentityRecord, error) {
+	var list []*storage.UserIdentityRecord
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&list).Error
+	return list, err
+}
+
+func (r *UserIdentityRepository) Update(ctx context.Context, m *storage.UserIdentityRecord) error {
+	return r.db.WithContext(ctx).Save(m).Error
+}
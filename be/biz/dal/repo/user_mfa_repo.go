@@ -0,0 +1,83 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"doing_now/be/biz/model/storage"
+
+	"gorm.io/gorm"
+)
+
+type UserTOTPRepository struct {
+	db *gorm.DB
+}
+
+func NewUserTOTPRepository(db *gorm.DB) *UserTOTPRepository {
+	return &UserTOTPRepository{db: db}
+}
+
+func (r *UserTOTPRepository) Create(ctx context.Context, m *storage.UserTOTPRecord) error {
+	return r.db.WithContext(ctx).Create(m).Error
+}
+
+func (r *UserTOTPRepository) FindByUserID(ctx context.Context, userID string) (*storage.UserTOTPRecord, error) {
+	var m storage.UserTOTPRecord
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&m).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (r *UserTOTPRepository) Update(ctx context.Context, m *storage.UserTOTPRecord) error {
+	return r.db.WithContext(ctx).Save(m).Error
+}
+
+type UserRecoveryCodeRepository struct {
+	db *gorm.DB
+}
+
+func NewUserRecoveryCodeRepository(db *gorm.DB) *UserRecoveryCodeRepository {
+	return &UserRecoveryCodeRepository{db: db}
+}
+
+func (r *UserRecoveryCodeRepository) CreateBatch(ctx context.Context, list []*storage.UserRecoveryCodeRecord) error {
+	if len(list) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Create(&list).Error
+}
+
+func (r *UserRecoveryCodeRepository) FindUnused(ctx context.Context, userID, codeHash string) (*storage.UserRecoveryCodeRecord, error) {
+	var m storage.UserRecoveryCodeRecord
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		First(&m).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+// MarkUsed consumes the code, it returns false when the code has already been used concurrently.
+func (r *UserRecoveryCodeRepository) MarkUsed(ctx context.Context, id uint, usedAt time.Time) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&storage.UserRecoveryCodeRecord{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", usedAt)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// DeleteByUserID removes codes permanently, old codes must not come back with a restore.
+func (r *UserRecoveryCodeRepository) DeleteByUserID(ctx context.Context, userID string) error {
+	return r.db.WithContext(ctx).Unscoped().Where("user_id = ?", userID).Delete(&storage.UserRecoveryCodeRecord{}).Error
+}
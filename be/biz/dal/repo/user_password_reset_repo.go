@@ -0,0 +1,52 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"doing_now/be/biz/model/storage"
+
+	"gorm.io/gorm"
+)
+
+type UserPasswordResetRepository struct {
+	db *gorm.DB
+}
+
+func NewUserPasswordResetRepository(db *gorm.DB) *UserPasswordResetRepository {
+	return &UserPasswordResetRepository{db: db}
+}
+
+func (r *UserPasswordResetRepository) Create(ctx context.Context, m *storage.UserPasswordResetRecord) error {
+	return r.db.WithContext(ctx).Create(m).Error
+}
+
+func (r *UserPasswordResetRepository) FindByTokenHash(ctx context.Context, tokenHash string) (*storage.UserPasswordResetRecord, error) {
+	var m storage.UserPasswordResetRecord
+	err := r.db.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&m).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+// MarkUsed consumes the token, it returns false when the token has already been used concurrently.
+func (r *UserPasswordResetRepository) MarkUsed(ctx context.Context, id uint, usedAt time.Time) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&storage.UserPasswordResetRecord{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", usedAt)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// DeleteUnusedByUserID drops the outstanding tokens of a user, only the latest mail stays valid.
+func (r *UserPasswordResetRepository) DeleteUnusedByUserID(ctx context.Context, userID string) error {
+	return r.db.WithContext(ctx).Unscoped().
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Delete(&storage.UserPasswordResetRecord{}).Error
+}
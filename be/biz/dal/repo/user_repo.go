@@ -2,6 +2,8 @@ package repo
 
 import (
 	"context"
+	"strings"
+	"time"
 
 	"doing_now/be/biz/model/storage"
 
@@ -72,6 +74,30 @@ func (r *UserRepository) FindByAccountLock(ctx context.Context, account string)
 	return &m, nil
 }
 
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*storage.UserRecord, error) {
+	var m storage.UserRecord
+	err := r.db.WithContext(ctx).Where("email = ?", email).First(&m).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (r *UserRepository) FindByEmailLock(ctx context.Context, email string) (*storage.UserRecord, error) {
+	var m storage.UserRecord
+	err := r.db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Where("email = ?", email).First(&m).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
 func (r *UserRepository) FindByID(ctx context.Context, id uint64) (*storage.UserRecord, error) {
 	var m storage.UserRecord
 	err := r.db.WithContext(ctx).Where("id = ?", id).First(&m).Error
@@ -87,3 +113,71 @@ func (r *UserRepository) FindByID(ctx context.Context, id uint64) (*storage.User
 func (r *UserRepository) Update(ctx context.Context, u *storage.UserRecord) error {
 	return r.db.WithContext(ctx).Save(u).Error
 }
+
+// UserFilter narrows a user search, zero fields do not filter.
+type UserFilter struct {
+	UserID        string
+	AccountPrefix string
+	CreatedFrom   time.Time
+	CreatedTo     time.Time
+	WithDeleted   bool
+	Offset        int
+	Limit         int
+}
+
+// Search returns a page of users ordered by creation, newest first, and the total count.
+func (r *UserRepository) Search(ctx context.Context, f UserFilter) ([]*storage.UserRecord, int64, error) {
+	q := r.db.WithContext(ctx).Model(&storage.UserRecord{})
+	if f.WithDeleted {
+		q = q.Unscoped()
+	}
+	if f.UserID != "" {
+		q = q.Where("user_id = ?", f.UserID)
+	}
+	if f.AccountPrefix != "" {
+		q = q.Where("account LIKE ? ESCAPE '\\'", likeEscaper.Replace(f.AccountPrefix)+"%")
+	}
+	if !f.CreatedFrom.IsZero() {
+		q = q.Where("created_at >= ?", f.CreatedFrom)
+	}
+	if !f.CreatedTo.IsZero() {
+		q = q.Where("created_at < ?", f.CreatedTo)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []*storage.UserRecord
+	err := q.Order("created_at DESC, id DESC").Offset(f.Offset).Limit(f.Limit).Find(&list).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+var likeEscaper = strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+
+// FindByUserIDUnscoped also finds soft-deleted users.
+func (r *UserRepository) FindByUserIDUnscoped(ctx context.Context, userID string) (*storage.UserRecord, error) {
+	var m storage.UserRecord
+	err := r.db.WithContext(ctx).Unscoped().Where("user_id = ?", userID).First(&m).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Restore brings back a soft-deleted user, it returns false when there is no such deleted user.
+func (r *UserRepository) Restore(ctx context.Context, userID string) (bool, error) {
+	res := r.db.WithContext(ctx).Unscoped().Model(&storage.UserRecord{}).
+		Where("user_id = ? AND deleted_at <> 0", userID).
+		Update("deleted_at", 0)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
@@ -3,6 +3,7 @@ package repo
 import (
 	"context"
 	"testing"
+	"time"
 
 	"doing_now/be/biz/model/storage"
 
@@ -14,7 +15,7 @@ import (
 func setupTestDB(t *testing.T) *gorm.DB {
 	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	assert.NoError(t, err)
-	err = db.AutoMigrate(&storage.UserRecord{}, &storage.UserCredentialRecord{})
+	err = db.AutoMigrate(&storage.UserRecord{}, &storage.UserCredentialRecord{}, &storage.UserTOTPRecord{}, &storage.UserRecoveryCodeRecord{}, &storage.UserWebAuthnCredentialRecord{}, &storage.UserIdentityRecord{})
 	assert.NoError(t, err)
 	return db
 }
@@ -158,3 +159,82 @@ func TestUserCredentialRepository_FindByUserID(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Nil(t, found)
 }
+
+func TestUserRepository_Search(t *testing.T) {
+	db := setupTestDB(t)
+	r := NewUserRepository(db)
+	ctx := context.Background()
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, account := range []string{"alice", "alice_2", "alicex", "bob"} {
+		u := &storage.UserRecord{
+			UserId:  "user_" + account,
+			Account: account,
+			Name:    account,
+		}
+		u.CreatedAt = base.Add(time.Duration(i) * time.Hour)
+		assert.NoError(t, db.Create(u).Error)
+	}
+	assert.NoError(t, db.Delete(&storage.UserRecord{}, "user_id = ?", "user_alicex").Error)
+
+	list, total, err := r.Search(ctx, UserFilter{AccountPrefix: "alice", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Equal(t, "alice_2", list[0].Account)
+	assert.Equal(t, "alice", list[1].Account)
+
+	// _ is matched literally
+	_, total, err = r.Search(ctx, UserFilter{AccountPrefix: "alice_", Limit: 10, WithDeleted: true})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+
+	list, total, err = r.Search(ctx, UserFilter{AccountPrefix: "alice", Limit: 10, WithDeleted: true})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	assert.Equal(t, "alicex", list[0].Account)
+
+	list, total, err = r.Search(ctx, UserFilter{CreatedFrom: base.Add(time.Hour), CreatedTo: base.Add(4 * time.Hour), Offset: 1, Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "alice_2", list[0].Account)
+
+	list, total, err = r.Search(ctx, UserFilter{UserID: "user_bob", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, "bob", list[0].Account)
+}
+
+func TestUserRepository_Restore(t *testing.T) {
+	db := setupTestDB(t)
+	r := NewUserRepository(db)
+	ctx := context.Background()
+
+	u := &storage.UserRecord{
+		UserId:  "test_user_id",
+		Account: "test_account",
+		Name:    "test_name",
+	}
+	db.Create(u)
+
+	// not deleted
+	ok, err := r.Restore(ctx, "test_user_id")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, db.Delete(u).Error)
+	found, err := r.FindByUserID(ctx, "test_user_id")
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+	found, err = r.FindByUserIDUnscoped(ctx, "test_user_id")
+	assert.NoError(t, err)
+	assert.NotNil(t, found)
+	assert.NotEqual(t, 0, int(found.DeletedAt))
+
+	ok, err = r.Restore(ctx, "test_user_id")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	found, err = r.FindByUserID(ctx, "test_user_id")
+	assert.NoError(t, err)
+	assert.NotNil(t, found)
+}
@@ -0,0 +1,43 @@
+package repo
+
+import (
+	"context"
+
+	"doing_now/be/biz/model/storage"
+
+	"gorm.io/gorm"
+)
+
+type UserWebAuthnCredentialRepository struct {
+	db *gorm.DB
+}
+
+func NewUserWebAuthnCredentialRepository(db *gorm.DB) *UserWebAuthnCredentialRepository {
+	return &UserWebAuthnCredentialRepository{db: db}
+}
+
+func (r *UserWebAuthnCredentialRepository) Create(ctx context.Context, m *storage.UserWebAuthnCredentialRecord) error {
+	return r.db.WithContext(ctx).Create(m).Error
+}
+
+func (r *UserWebAuthnCredentialRepository) ListByUserID(ctx context.Context, userID string) ([]*storage.UserWebAuthnCredentialRecord, error) {
+	var list []*storage.UserWebAuthnCredentialRecord
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&list).Error
+	return list, err
+}
+
+func (r *UserWebAuthnCredentialRepository) FindByCredentialID(ctx context.Context, credentialID string) (*storage.UserWebAuthnCredentialRecord, error) {
+	var m storage.UserWebAuthnCredentialRecord
+	err := r.db.WithContext(ctx).Where("credential_id = ?", credentialID).First(&m).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (r *UserWebAuthnCredentialRepository) Update(ctx context.Context, m *storage.UserWebAuthnCredentialRecord) error {
+	return r.db.WithContext(ctx).Save(m).Error
+}
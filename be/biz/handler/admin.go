@@ -0,0 +1,339 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/middleware/jwt"
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/dto"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/audit"
+	"doing_now/be/biz/service/lockout"
+	"doing_now/be/biz/service/rbac"
+	"doing_now/be/biz/service/reset"
+	"doing_now/be/biz/service/user"
+	"doing_now/be/biz/util/resp"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+const (
+	defaultAdminPageSize = 20
+)
+
+// AdminListUsers 管理员用户列表接口
+//
+//	@Tags			admin
+//	@Summary		管理员用户列表接口
+//	@Description	按账号前缀、用户ID、创建时间筛选并分页列出用户，deleted为true时包含已删除用户，需要user:read权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			page			query		int		false	"page, from 1"
+//	@Param			page_size		query		int		false	"page size, at most 100"
+//	@Param			account			query		string	false	"account prefix"
+//	@Param			user_id			query		string	false	"user id"
+//	@Param			created_from	query		int		false	"created at or after, unix seconds"
+//	@Param			created_to		query		int		false	"created before, unix seconds"
+//	@Param			deleted			query		bool	false	"include deleted users"
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminListUsersResp}
+//	@Router			/api/v1/admin/users [GET]
+func AdminListUsers(ctx context.Context, c *app.RequestContext) {
+	var req dto.AdminListUsersReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+	if req.Page == 0 {
+		req.Page = 1
+	}
+	if req.PageSize == 0 {
+		req.PageSize = defaultAdminPageSize
+	}
+
+	filter := repo.UserFilter{
+		UserID:        req.UserID,
+		AccountPrefix: req.Account,
+		CreatedFrom:   unixTime(req.CreatedFrom),
+		CreatedTo:     unixTime(req.CreatedTo),
+		WithDeleted:   req.Deleted,
+		Offset:        (req.Page - 1) * req.PageSize,
+		Limit:         req.PageSize,
+	}
+
+	users, total, bizErr := user.NewDefault().Search(ctx, filter)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	list := make([]dto.AdminUserInfo, 0, len(users))
+	for _, u := range users {
+		list = append(list, adminUserInfo(u))
+	}
+	resp.SuccessResp(c, dto.AdminListUsersResp{
+		Users:    list,
+		Total:    total,
+		Page:     req.Page,
+		PageSize: req.PageSize,
+	})
+}
+
+// AdminGetUser 管理员用户详情接口
+//
+//	@Tags			admin
+//	@Summary		管理员用户详情接口
+//	@Description	查看用户详情及其角色，包含已删除用户，需要user:read权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			user_id			path		string	true	"user id"
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminGetUserResp}
+//	@Router			/api/v1/admin/users/{user_id} [GET]
+func AdminGetUser(ctx context.Context, c *app.RequestContext) {
+	var req dto.AdminGetUserReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	u, bizErr := user.NewDefault().GetByUserIDUnscoped(ctx, req.UserID)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+	roles, bizErr := rbac.NewDefault().Roles(ctx, req.UserID)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+
+	resp.SuccessResp(c, dto.AdminGetUserResp{
+		AdminUserInfo: adminUserInfo(u),
+		Roles:         roles,
+	})
+}
+
+// AdminDisableUser 管理员禁用用户接口
+//
+//	@Tags			admin
+//	@Summary		管理员禁用用户接口
+//	@Description	禁用用户并注销其所有会话，禁用后无法登录，需要user:write权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			user_id			path		string							true	"user id"
+//	@Param			req				body		dto.AdminChangeUserStatusReq	true	"change status request body"
+//	@Param			Authorization	header		string							true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminChangeUserStatusResp}
+//	@Router			/api/v1/admin/users/{user_id}/disable [POST]
+func AdminDisableUser(ctx context.Context, c *app.RequestContext) {
+	adminChangeUserStatus(ctx, c, domain.UserStatusDisabled)
+}
+
+// AdminEnableUser 管理员启用用户接口
+//
+//	@Tags			admin
+//	@Summary		管理员启用用户接口
+//	@Description	将已禁用或锁定的用户恢复为正常状态，需要user:write权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			user_id			path		string							true	"user id"
+//	@Param			req				body		dto.AdminChangeUserStatusReq	true	"change status request body"
+//	@Param			Authorization	header		string							true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminChangeUserStatusResp}
+//	@Router			/api/v1/admin/users/{user_id}/enable [POST]
+func AdminEnableUser(ctx context.Context, c *app.RequestContext) {
+	adminChangeUserStatus(ctx, c, domain.UserStatusActive)
+}
+
+func adminChangeUserStatus(ctx context.Context, c *app.RequestContext, status domain.UserStatus) {
+	var req dto.AdminChangeUserStatusReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	operator := jwt.GetPayload(ctx).UserID
+	if status != domain.UserStatusActive && req.UserID == operator {
+		resp.FailResp(c, errs.ParamError.SetMsg("cannot disable yourself"))
+		return
+	}
+
+	if bizErr := user.NewDefault().ChangeStatus(ctx, req.UserID, status, req.Reason); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+	// the bumped credential version already rejects the tokens, this drops the sessions as well
+	if !status.Active() {
+		if _, bizErr := revokeUserSessions(ctx, req.UserID); bizErr != nil {
+			resp.FailResp(c, bizErr)
+			return
+		}
+	}
+
+	hlog.CtxInfof(ctx, "user %s set to %s by admin %s", req.UserID, status, operator)
+	resp.SuccessResp(c, dto.AdminChangeUserStatusResp{})
+}
+
+// AdminResetPassword 管理员强制重置密码接口
+//
+//	@Tags			admin
+//	@Summary		管理员强制重置密码接口
+//	@Description	清除用户密码并注销其所有会话，用户有已验证邮箱时发送重置链接，需要user:write权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			user_id			path		string	true	"user id"
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminResetPasswordResp}
+//	@Router			/api/v1/admin/users/{user_id}/reset_password [POST]
+func AdminResetPassword(ctx context.Context, c *app.RequestContext) {
+	var req dto.AdminResetPasswordReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	mailed, bizErr := reset.NewDefault().ForceReset(ctx, req.UserID)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+	if _, bizErr := revokeUserSessions(ctx, req.UserID); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	hlog.CtxInfof(ctx, "password of user %s reset by admin %s", req.UserID, jwt.GetPayload(ctx).UserID)
+	resp.SuccessResp(c, dto.AdminResetPasswordResp{Mailed: mailed})
+}
+
+// AdminRevokeSessions 管理员注销用户会话接口
+//
+//	@Tags			admin
+//	@Summary		管理员注销用户会话接口
+//	@Description	注销用户的所有会话，所有access token与refresh token立即失效，需要user:write权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			user_id			path		string	true	"user id"
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminRevokeSessionsResp}
+//	@Router			/api/v1/admin/users/{user_id}/revoke_sessions [POST]
+func AdminRevokeSessions(ctx context.Context, c *app.RequestContext) {
+	var req dto.AdminRevokeSessionsReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	// sessions from before device records, or whose record failed, are not listed
+	if bizErr := user.NewDefault().RevokeCredentials(ctx, req.UserID); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+	revoked, bizErr := revokeUserSessions(ctx, req.UserID)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+	audit.Record(ctx, audit.Event{
+		Type:     domain.AuditSessionsRevoke,
+		TargetID: req.UserID,
+		Details:  map[string]any{"revoked": revoked},
+	})
+
+	hlog.CtxInfof(ctx, "%d sessions of user %s revoked by admin %s", revoked, req.UserID, jwt.GetPayload(ctx).UserID)
+	resp.SuccessResp(c, dto.AdminRevokeSessionsResp{Revoked: revoked})
+}
+
+// AdminUnlockUser 管理员解锁账号接口
+//
+//	@Tags			admin
+//	@Summary		管理员解锁账号接口
+//	@Description	解除因登录失败次数过多导致的账号临时锁定，并清空失败计数，需要user:write权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			user_id			path		string	true	"user id"
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminUnlockUserResp}
+//	@Router			/api/v1/admin/users/{user_id}/unlock [POST]
+func AdminUnlockUser(ctx context.Context, c *app.RequestContext) {
+	var req dto.AdminUnlockUserReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	u, bizErr := user.NewDefault().GetByUserID(ctx, req.UserID)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+	if bizErr := lockout.NewDefault().Unlock(ctx, u.UserID); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	hlog.CtxInfof(ctx, "user %s unlocked by admin %s", req.UserID, jwt.GetPayload(ctx).UserID)
+	resp.SuccessResp(c, dto.AdminUnlockUserResp{})
+}
+
+// AdminRestoreUser 管理员恢复已删除用户接口
+//
+//	@Tags			admin
+//	@Summary		管理员恢复已删除用户接口
+//	@Description	恢复被软删除的用户及其凭证，需要user:write权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			user_id			path		string	true	"user id"
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminRestoreUserResp}
+//	@Router			/api/v1/admin/users/{user_id}/restore [POST]
+func AdminRestoreUser(ctx context.Context, c *app.RequestContext) {
+	var req dto.AdminRestoreUserReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	if bizErr := user.NewDefault().Restore(ctx, req.UserID); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	hlog.CtxInfof(ctx, "user %s restored by admin %s", req.UserID, jwt.GetPayload(ctx).UserID)
+	resp.SuccessResp(c, dto.AdminRestoreUserResp{})
+}
+
+func adminUserInfo(u *domain.User) dto.AdminUserInfo {
+	info := dto.AdminUserInfo{
+		UserID:        u.UserID,
+		Account:       u.Account,
+		Name:          u.Name,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		Status:        string(u.Status),
+		StatusReason:  u.StatusReason,
+		CreatedAt:     u.CreatedAt.Unix(),
+		UpdatedAt:     u.UpdatedAt.Unix(),
+	}
+	if u.StatusChangedAt != nil {
+		info.StatusChangedAt = u.StatusChangedAt.Unix()
+	}
+	if u.DeletedAt != nil {
+		info.DeletedAt = u.DeletedAt.Unix()
+	}
+	return info
+}
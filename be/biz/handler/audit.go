@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/middleware/jwt"
+	"doing_now/be/biz/model/dto"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/audit"
+	"doing_now/be/biz/util/resp"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// ListMyAuditEvents 当前用户安全日志接口
+//
+//	@Tags			user
+//	@Summary		当前用户安全日志接口
+//	@Description	分页查询当前用户作为操作者或被操作者的安全事件，如登录、修改密码、登出
+//	@Accept			json
+//	@Produce		json
+//	@Param			page			query		int		false	"page, from 1"
+//	@Param			page_size		query		int		false	"page size, at most 100"
+//	@Param			event_type		query		string	false	"event type, e.g. login.failure"
+//	@Param			from			query		int		false	"at or after, unix seconds"
+//	@Param			to				query		int		false	"before, unix seconds"
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.ListAuditEventsResp}
+//	@Router			/api/v1/user/audit_events [GET]
+func ListMyAuditEvents(ctx context.Context, c *app.RequestContext) {
+	var req dto.ListMyAuditEventsReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	payload := jwt.GetPayload(ctx)
+	if payload.UserID == "" {
+		resp.FailResp(c, errs.Unauthorized)
+		return
+	}
+
+	listAuditEvents(ctx, c, req.Page, req.PageSize, repo.AuditEventFilter{
+		UserID:    payload.UserID,
+		EventType: req.EventType,
+		From:      unixTime(req.From),
+		To:        unixTime(req.To),
+	})
+}
+
+// AdminListAuditEvents 管理员安全日志接口
+//
+//	@Tags			admin
+//	@Summary		管理员安全日志接口
+//	@Description	按用户、操作者、被操作者、事件类型、IP、时间筛选并分页查询所有安全事件，需要audit:read权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			page			query		int		false	"page, from 1"
+//	@Param			page_size		query		int		false	"page size, at most 100"
+//	@Param			user_id			query		string	false	"actor or target user id"
+//	@Param			actor_id		query		string	false	"actor user id"
+//	@Param			target_id		query		string	false	"target user id"
+//	@Param			event_type		query		string	false	"event type, e.g. login.failure"
+//	@Param			ip				query		string	false	"client ip"
+//	@Param			from			query		int		false	"at or after, unix seconds"
+//	@Param			to				query		int		false	"before, unix seconds"
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.ListAuditEventsResp}
+//	@Router			/api/v1/admin/audit_events [GET]
+func AdminListAuditEvents(ctx context.Context, c *app.RequestContext) {
+	var req dto.AdminListAuditEventsReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	listAuditEvents(ctx, c, req.Page, req.PageSize, repo.AuditEventFilter{
+		UserID:    req.UserID,
+		ActorID:   req.ActorID,
+		TargetID:  req.TargetID,
+		EventType: req.EventType,
+		IP:        req.IP,
+		From:      unixTime(req.From),
+		To:        unixTime(req.To),
+	})
+}
+
+func listAuditEvents(ctx context.Context, c *app.RequestContext, page, pageSize int, filter repo.AuditEventFilter) {
+	if page == 0 {
+		page = 1
+	}
+	if pageSize == 0 {
+		pageSize = defaultAdminPageSize
+	}
+	filter.Offset = (page - 1) * pageSize
+	filter.Limit = pageSize
+
+	events, total, bizErr := audit.NewDefault().Search(ctx, filter)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	list := make([]dto.AuditEventInfo, 0, len(events))
+	for _, e := range events {
+		list = append(list, dto.AuditEventInfo{
+			ID:        e.ID,
+			EventType: string(e.Type),
+			ActorID:   e.ActorID,
+			TargetID:  e.TargetID,
+			IP:        e.IP,
+			UserAgent: e.UserAgent,
+			LogID:     e.LogID,
+			Details:   e.Details,
+			CreatedAt: e.CreatedAt.Unix(),
+		})
+	}
+	resp.SuccessResp(c, dto.ListAuditEventsResp{
+		Events:   list,
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	})
+}
+
+// unixTime converts unix seconds from a query, zero means no time.
+func unixTime(sec int64) time.Time {
+	if sec <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"doing_now/be/biz/middleware/jwt"
+	"doing_now/be/biz/model/dto"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/emailverify"
+	"doing_now/be/biz/service/user"
+	"doing_now/be/biz/util/resp"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// UpdateEmail 设置邮箱接口
+//
+//	@Tags			user
+//	@Summary		设置邮箱接口
+//	@Description	设置或更换邮箱并发送验证链接，邮箱验证前不能用于找回密码；重复提交当前未验证的邮箱会重新发送验证链接
+//	@Accept			json
+//	@Produce		json
+//	@Param			req				body		dto.UpdateEmailReq	true	"update email request body"
+//	@Param			Authorization	header		string				true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.UpdateEmailResp}
+//	@Router			/api/v1/user/update_email [POST]
+func UpdateEmail(ctx context.Context, c *app.RequestContext) {
+	var req dto.UpdateEmailReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	payload := jwt.GetPayload(ctx)
+	if payload.UserID == "" {
+		resp.FailResp(c, errs.Unauthorized)
+		return
+	}
+
+	u, bizErr := user.NewDefault().UpdateEmail(ctx, payload.UserID, req.Email)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	if bizErr := emailverify.NewDefault().Send(ctx, u); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	resp.SuccessResp(c, dto.UpdateEmailResp{})
+}
+
+// VerifyEmail 验证邮箱接口
+//
+//	@Tags			user
+//	@Summary		验证邮箱接口
+//	@Description	使用验证邮件中的令牌确认邮箱，令牌在邮箱更换后失效
+//	@Accept			json
+//	@Produce		json
+//	@Param			req	body		dto.VerifyEmailReq	true	"verify email request body"
+//	@Success		200	{object}	dto.CommonResp{data=dto.VerifyEmailResp}
+//	@Router			/api/v1/user/verify_email [POST]
+func VerifyEmail(ctx context.Context, c *app.RequestContext) {
+	var req dto.VerifyEmailReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	if bizErr := emailverify.NewDefault().Verify(ctx, req.Token); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	resp.SuccessResp(c, dto.VerifyEmailResp{})
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"doing_now/be/biz/middleware/jwt"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/util/resp"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// JWKS 访问令牌公钥接口
+//
+//	@Tags			jwks
+//	@Summary		访问令牌公钥接口
+//	@Description	返回JWK Set格式的公钥，供其他服务离线校验access token，HS256密钥不会公开
+//	@Produce		json
+//	@Success		200	{object}	jwt.JWKSet
+//	@Router			/.well-known/jwks.json [GET]
+func JWKS(ctx context.Context, c *app.RequestContext) {
+	keyring, err := jwt.AccessKeyring()
+	if err != nil {
+		hlog.CtxErrorf(ctx, "load jwt keyring err: %v", err)
+		resp.AbortWithErr(c, errs.ServerError, http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, keyring.JWKS())
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"doing_now/be/biz/middleware/jwt"
+	"doing_now/be/biz/model/dto"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/mfa"
+	"doing_now/be/biz/util/resp"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// VerifyMFA 两步验证登录接口
+//
+//	@Tags			mfa
+//	@Summary		两步验证登录接口
+//	@Description	使用登录接口返回的mfa_challenge和TOTP验证码或恢复码完成登录
+//	@Accept			json
+//	@Produce		json
+//	@Param			req	body		dto.VerifyMFAReq	true	"verify mfa request body"
+//	@Success		200	{object}	dto.CommonResp{data=dto.LoginResp}
+//	@Header			200	{string}	set-cookie	"cookie"
+//	@Router			/api/v1/user/mfa/verify [POST]
+func VerifyMFA(ctx context.Context, c *app.RequestContext) {
+	var req dto.VerifyMFAReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError.SetMsg(err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	ch, bizErr := mfa.NewDefault().VerifyChallenge(ctx, req.Challenge, req.Code)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	loginSuccess(ctx, c, ch.UserID, ch.Account, ch.CredentialVersion)
+}
+
+// EnrollTOTP 开始绑定TOTP接口
+//
+//	@Tags			mfa
+//	@Summary		开始绑定TOTP接口
+//	@Description	生成待确认的TOTP密钥，需调用确认接口后才会生效
+//	@Accept			json
+//	@Produce		json
+//	@Param			req				body		dto.EnrollTOTPReq	true	"enroll totp request body"
+//	@Param			Authorization	header		string				true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.EnrollTOTPResp}
+//	@Router			/api/v1/user/mfa/totp/enroll [POST]
+func EnrollTOTP(ctx context.Context, c *app.RequestContext) {
+	var req dto.EnrollTOTPReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	payload := jwt.GetPayload(ctx)
+	if payload.UserID == "" {
+		resp.FailResp(c, errs.Unauthorized)
+		return
+	}
+
+	secret, uri, bizErr := mfa.NewDefault().EnrollTOTP(ctx, payload.UserID, payload.Account)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	resp.SuccessResp(c, dto.EnrollTOTPResp{
+		Secret:     secret,
+		OTPAuthURL: uri,
+	})
+}
+
+// ConfirmTOTP 确认绑定TOTP接口
+//
+//	@Tags			mfa
+//	@Summary		确认绑定TOTP接口
+//	@Description	校验验证码后启用两步验证，并返回仅展示一次的恢复码
+//	@Accept			json
+//	@Produce		json
+//	@Param			req				body		dto.ConfirmTOTPReq	true	"confirm totp request body"
+//	@Param			Authorization	header		string				true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.ConfirmTOTPResp}
+//	@Router			/api/v1/user/mfa/totp/confirm [POST]
+func ConfirmTOTP(ctx context.Context, c *app.RequestContext) {
+	var req dto.ConfirmTOTPReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	payload := jwt.GetPayload(ctx)
+	if payload.UserID == "" {
+		resp.FailResp(c, errs.Unauthorized)
+		return
+	}
+
+	codes, bizErr := mfa.NewDefault().ConfirmTOTP(ctx, payload.UserID, req.Code)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	resp.SuccessResp(c, dto.ConfirmTOTPResp{RecoveryCodes: codes})
+}
+
+// DisableTOTP 关闭TOTP接口
+//
+//	@Tags			mfa
+//	@Summary		关闭TOTP接口
+//	@Description	使用TOTP验证码或恢复码关闭两步验证
+//	@Accept			json
+//	@Produce		json
+//	@Param			req				body		dto.DisableTOTPReq	true	"disable totp request body"
+//	@Param			Authorization	header		string				true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.DisableTOTPResp}
+//	@Router			/api/v1/user/mfa/totp/disable [POST]
+func DisableTOTP(ctx context.Context, c *app.RequestContext) {
+	var req dto.DisableTOTPReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	payload := jwt.GetPayload(ctx)
+	if payload.UserID == "" {
+		resp.FailResp(c, errs.Unauthorized)
+		return
+	}
+
+	if bizErr := mfa.NewDefault().DisableTOTP(ctx, payload.UserID, req.Code); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	resp.SuccessResp(c, dto.DisableTOTPResp{})
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"doing_now/be/biz/middleware/jwt"
+	"doing_now/be/biz/model/dto"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/oidc"
+	"doing_now/be/biz/util/resp"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+const (
+	sessKeyOIDCLogin = "oidc_login"
+	sessKeyOIDCLink  = "oidc_link"
+)
+
+// OIDCAuthorize 第三方登录接口
+//
+//	@Tags			oidc
+//	@Summary		第三方登录接口
+//	@Description	返回IdP授权地址，state、nonce和PKCE verifier保存在会话中
+//	@Accept			json
+//	@Produce		json
+//	@Param			provider	path		string	true	"provider name"
+//	@Success		200			{object}	dto.CommonResp{data=dto.OIDCAuthorizeResp}
+//	@Header			200			{string}	set-cookie	"cookie"
+//	@Router			/api/v1/user/oidc/{provider}/authorize [POST]
+func OIDCAuthorize(ctx context.Context, c *app.RequestContext) {
+	oidcAuthorize(ctx, c, sessKeyOIDCLogin)
+}
+
+// OIDCCallback 第三方登录回调接口
+//
+//	@Tags			oidc
+//	@Summary		第三方登录回调接口
+//	@Description	使用IdP回调的code和state完成登录，未绑定的外部账号按配置自动创建用户
+//	@Accept			json
+//	@Produce		json
+//	@Param			req	body		dto.OIDCCallbackReq	true	"oidc callback request body"
+//	@Success		200	{object}	dto.CommonResp{data=dto.LoginResp}
+//	@Header			200	{string}	set-cookie	"cookie"
+//	@Router			/api/v1/user/oidc/callback [POST]
+func OIDCCallback(ctx context.Context, c *app.RequestContext) {
+	var req dto.OIDCCallbackReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError.SetMsg(err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	flow, ok := popFlow(ctx, c, sessKeyOIDCLogin)
+	if !ok {
+		return
+	}
+
+	u, credentialVersion, bizErr := oidc.NewDefault().Login(ctx, flow, req.State, req.Code)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	loginOrChallenge(ctx, c, u.UserID, u.Account, credentialVersion)
+}
+
+// OIDCLink 绑定第三方账号接口
+//
+//	@Tags			oidc
+//	@Summary		绑定第三方账号接口
+//	@Description	返回IdP授权地址，回调后调用/api/v1/user/oidc/link/callback完成绑定
+//	@Accept			json
+//	@Produce		json
+//	@Param			provider		path		string	true	"provider name"
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.OIDCAuthorizeResp}
+//	@Router			/api/v1/user/oidc/{provider}/link [POST]
+func OIDCLink(ctx context.Context, c *app.RequestContext) {
+	oidcAuthorize(ctx, c, sessKeyOIDCLink)
+}
+
+// OIDCLinkCallback 绑定第三方账号回调接口
+//
+//	@Tags			oidc
+//	@Summary		绑定第三方账号回调接口
+//	@Description	将外部账号绑定到当前登录用户
+//	@Accept			json
+//	@Produce		json
+//	@Param			req				body		dto.OIDCCallbackReq	true	"oidc callback request body"
+//	@Param			Authorization	header		string				true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.OIDCLinkCallbackResp}
+//	@Router			/api/v1/user/oidc/link/callback [POST]
+func OIDCLinkCallback(ctx context.Context, c *app.RequestContext) {
+	var req dto.OIDCCallbackReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError.SetMsg(err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	payload := jwt.GetPayload(ctx)
+	if payload.UserID == "" {
+		resp.FailResp(c, errs.Unauthorized)
+		return
+	}
+
+	flow, ok := popFlow(ctx, c, sessKeyOIDCLink)
+	if !ok {
+		return
+	}
+
+	if bizErr := oidc.NewDefault().Link(ctx, payload.UserID, flow, req.State, req.Code); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	resp.SuccessResp(c, dto.OIDCLinkCallbackResp{})
+}
+
+func oidcAuthorize(ctx context.Context, c *app.RequestContext, sessKey string) {
+	var req dto.OIDCAuthorizeReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError.SetMsg(err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	authURL, flow, bizErr := oidc.NewDefault().Authorize(ctx, req.Provider)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+	if err := saveCeremony(c, sessKey, flow); err != nil {
+		hlog.CtxErrorf(ctx, "sess.Save err: %v", err)
+		resp.AbortWithErr(c, errs.ServerError.SetErr(err), http.StatusInternalServerError)
+		return
+	}
+
+	resp.SuccessResp(c, dto.OIDCAuthorizeResp{AuthorizationURL: authURL})
+}
+
+func popFlow(ctx context.Context, c *app.RequestContext, sessKey string) ([]byte, bool) {
+	flow, err := popCeremony(c, sessKey)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "sess.Save err: %v", err)
+		resp.AbortWithErr(c, errs.ServerError.SetErr(err), http.StatusInternalServerError)
+		return nil, false
+	}
+	if flow == nil {
+		resp.FailResp(c, errs.OIDCStateInvalid)
+		return nil, false
+	}
+	return flow, true
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"doing_now/be/biz/middleware/jwt"
+	"doing_now/be/biz/model/dto"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/passkey"
+	"doing_now/be/biz/util/resp"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/hertz-contrib/sessions"
+)
+
+const (
+	sessKeyPasskeyRegistration = "webauthn_registration"
+	sessKeyPasskeyLogin        = "webauthn_login"
+)
+
+// BeginPasskeyRegistration 开始注册通行密钥接口
+//
+//	@Tags			passkey
+//	@Summary		开始注册通行密钥接口
+//	@Description	返回navigator.credentials.create所需参数，challenge保存在会话中
+//	@Accept			json
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.BeginPasskeyRegistrationResp}
+//	@Router			/api/v1/user/passkey/register/begin [POST]
+func BeginPasskeyRegistration(ctx context.Context, c *app.RequestContext) {
+	payload := jwt.GetPayload(ctx)
+	if payload.UserID == "" {
+		resp.FailResp(c, errs.Unauthorized)
+		return
+	}
+
+	creation, state, bizErr := passkey.NewDefault().BeginRegistration(ctx, payload.UserID)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+	if err := saveCeremony(c, sessKeyPasskeyRegistration, state); err != nil {
+		hlog.CtxErrorf(ctx, "sess.Save err: %v", err)
+		resp.AbortWithErr(c, errs.ServerError.SetErr(err), http.StatusInternalServerError)
+		return
+	}
+
+	resp.SuccessResp(c, dto.BeginPasskeyRegistrationResp{Options: creation})
+}
+
+// FinishPasskeyRegistration 完成注册通行密钥接口
+//
+//	@Tags			passkey
+//	@Summary		完成注册通行密钥接口
+//	@Description	请求体为navigator.credentials.create返回的PublicKeyCredential
+//	@Accept			json
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.FinishPasskeyRegistrationResp}
+//	@Router			/api/v1/user/passkey/register/finish [POST]
+func FinishPasskeyRegistration(ctx context.Context, c *app.RequestContext) {
+	payload := jwt.GetPayload(ctx)
+	if payload.UserID == "" {
+		resp.FailResp(c, errs.Unauthorized)
+		return
+	}
+
+	state, err := popCeremony(c, sessKeyPasskeyRegistration)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "sess.Save err: %v", err)
+		resp.AbortWithErr(c, errs.ServerError.SetErr(err), http.StatusInternalServerError)
+		return
+	}
+	if state == nil {
+		resp.FailResp(c, errs.PasskeyCeremonyExpired)
+		return
+	}
+
+	if bizErr := passkey.NewDefault().FinishRegistration(ctx, payload.UserID, state, c.Request.Body()); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	resp.SuccessResp(c, dto.FinishPasskeyRegistrationResp{})
+}
+
+// BeginPasskeyLogin 开始通行密钥登录接口
+//
+//	@Tags			passkey
+//	@Summary		开始通行密钥登录接口
+//	@Description	返回navigator.credentials.get所需参数，无需填写账号
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	dto.CommonResp{data=dto.BeginPasskeyLoginResp}
+//	@Header			200	{string}	set-cookie	"cookie"
+//	@Router			/api/v1/user/passkey/login/begin [POST]
+func BeginPasskeyLogin(ctx context.Context, c *app.RequestContext) {
+	assertion, state, bizErr := passkey.NewDefault().BeginLogin(ctx)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+	if err := saveCeremony(c, sessKeyPasskeyLogin, state); err != nil {
+		hlog.CtxErrorf(ctx, "sess.Save err: %v", err)
+		resp.AbortWithErr(c, errs.ServerError.SetErr(err), http.StatusInternalServerError)
+		return
+	}
+
+	resp.SuccessResp(c, dto.BeginPasskeyLoginResp{Options: assertion})
+}
+
+// FinishPasskeyLogin 完成通行密钥登录接口
+//
+//	@Tags			passkey
+//	@Summary		完成通行密钥登录接口
+//	@Description	请求体为navigator.credentials.get返回的PublicKeyCredential，成功后与密码登录一样签发token
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	dto.CommonResp{data=dto.LoginResp}
+//	@Header			200	{string}	set-cookie	"cookie"
+//	@Router			/api/v1/user/passkey/login/finish [POST]
+func FinishPasskeyLogin(ctx context.Context, c *app.RequestContext) {
+	state, err := popCeremony(c, sessKeyPasskeyLogin)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "sess.Save err: %v", err)
+		resp.AbortWithErr(c, errs.ServerError.SetErr(err), http.StatusInternalServerError)
+		return
+	}
+	if state == nil {
+		resp.FailResp(c, errs.PasskeyCeremonyExpired)
+		return
+	}
+
+	u, credentialVersion, bizErr := passkey.NewDefault().FinishLogin(ctx, state, c.Request.Body())
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	loginSuccess(ctx, c, u.UserID, u.Account, credentialVersion)
+}
+
+func saveCeremony(c *app.RequestContext, key string, state []byte) error {
+	sess := sessions.Default(c)
+	sess.Set(key, string(state))
+	return sess.Save()
+}
+
+// popCeremony removes the ceremony state from the session, so every challenge is answered at most once.
+func popCeremony(c *app.RequestContext, key string) ([]byte, error) {
+	sess := sessions.Default(c)
+	state, _ := sess.Get(key).(string)
+	if state == "" {
+		return nil, nil
+	}
+	sess.Delete(key)
+	if err := sess.Save(); err != nil {
+		return nil, err
+	}
+	return []byte(state), nil
+}
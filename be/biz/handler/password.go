@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"doing_now/be/biz/model/dto"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/reset"
+	"doing_now/be/biz/util/resp"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// ForgotPassword 忘记密码接口
+//
+//	@Tags			user
+//	@Summary		忘记密码接口
+//	@Description	向已验证的邮箱发送一次性密码重置链接，account可填写账号或邮箱，账号不存在时同样返回成功
+//	@Accept			json
+//	@Produce		json
+//	@Param			req	body		dto.ForgotPasswordReq	true	"forgot password request body"
+//	@Success		200	{object}	dto.CommonResp{data=dto.ForgotPasswordResp}
+//	@Router			/api/v1/user/password/forgot [POST]
+func ForgotPassword(ctx context.Context, c *app.RequestContext) {
+	var req dto.ForgotPasswordReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	if bizErr := reset.NewDefault().Forgot(ctx, req.Account); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	resp.SuccessResp(c, dto.ForgotPasswordResp{})
+}
+
+// ResetPassword 重置密码接口
+//
+//	@Tags			user
+//	@Summary		重置密码接口
+//	@Description	使用邮件中的重置令牌设置新密码，令牌仅可使用一次，重置后所有已登录会话失效
+//	@Accept			json
+//	@Produce		json
+//	@Param			req	body		dto.ResetPasswordReq	true	"reset password request body"
+//	@Success		200	{object}	dto.CommonResp{data=dto.ResetPasswordResp}
+//	@Router			/api/v1/user/password/reset [POST]
+func ResetPassword(ctx context.Context, c *app.RequestContext) {
+	var req dto.ResetPasswordReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	if bizErr := reset.NewDefault().Reset(ctx, req.Token, req.NewPassword); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	resp.SuccessResp(c, dto.ResetPasswordResp{})
+}
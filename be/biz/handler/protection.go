@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"doing_now/be/biz/middleware/jwt"
+	"doing_now/be/biz/model/dto"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/blocklist"
+	"doing_now/be/biz/service/ipfilter"
+	"doing_now/be/biz/util/resp"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// AdminListBlocks 管理员查看封禁列表接口
+//
+//	@Tags			admin
+//	@Summary		管理员查看封禁列表接口
+//	@Description	列出当前被封禁的IP与账号，包含封禁原因与剩余时间，需要protection:read权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminListBlocksResp}
+//	@Router			/api/v1/admin/blocks [GET]
+func AdminListBlocks(ctx context.Context, c *app.RequestContext) {
+	blocks, bizErr := blocklist.NewDefault().List(ctx)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	now := time.Now()
+	list := make([]dto.BlockInfo, 0, len(blocks))
+	for _, b := range blocks {
+		info := dto.BlockInfo{
+			Target: b.Target,
+			Value:  b.Value,
+			Reason: b.Reason,
+			Detail: b.Detail,
+		}
+		if b.Remaining > 0 {
+			info.RetryAfter = int64(b.Remaining.Seconds())
+			info.BlockedUntil = now.Add(b.Remaining).Unix()
+		}
+		list = append(list, info)
+	}
+	resp.SuccessResp(c, dto.AdminListBlocksResp{Blocks: list})
+}
+
+// AdminBlock 管理员手动封禁接口
+//
+//	@Tags			admin
+//	@Summary		管理员手动封禁接口
+//	@Description	手动封禁IP或账号，minutes为0时封禁直到手动解封，需要protection:write权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			req				body		dto.AdminBlockReq	true	"block request body"
+//	@Param			Authorization	header		string				true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminBlockResp}
+//	@Router			/api/v1/admin/blocks [POST]
+func AdminBlock(ctx context.Context, c *app.RequestContext) {
+	var req dto.AdminBlockReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	target, value := blockTarget(req.IP, req.Account)
+	d := time.Duration(req.Minutes) * time.Minute
+	if bizErr := blocklist.NewDefault().Block(ctx, target, value, d, req.Note); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	hlog.CtxInfof(ctx, "%s %s blocked by admin %s", target, value, jwt.GetPayload(ctx).UserID)
+	resp.SuccessResp(c, dto.AdminBlockResp{})
+}
+
+// AdminUnblock 管理员解除封禁接口
+//
+//	@Tags			admin
+//	@Summary		管理员解除封禁接口
+//	@Description	解除IP或账号的全部封禁（自动与手动）并清空相关失败计数，需要protection:write权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			req				body		dto.AdminUnblockReq	true	"unblock request body"
+//	@Param			Authorization	header		string				true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminUnblockResp}
+//	@Router			/api/v1/admin/blocks/unblock [POST]
+func AdminUnblock(ctx context.Context, c *app.RequestContext) {
+	var req dto.AdminUnblockReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	target, value := blockTarget(req.IP, req.Account)
+	unblocked, bizErr := blocklist.NewDefault().Unblock(ctx, target, value)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	hlog.CtxInfof(ctx, "%s %s unblocked by admin %s", target, value, jwt.GetPayload(ctx).UserID)
+	resp.SuccessResp(c, dto.AdminUnblockResp{Unblocked: unblocked})
+}
+
+// AdminListAllowlist 管理员查看白名单接口
+//
+//	@Tags			admin
+//	@Summary		管理员查看白名单接口
+//	@Description	列出登录与注册保护永不封禁的IP与网段，需要protection:read权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminAllowlistResp}
+//	@Router			/api/v1/admin/allowlist [GET]
+func AdminListAllowlist(ctx context.Context, c *app.RequestContext) {
+	entries, bizErr := blocklist.NewDefault().Allowlist(ctx)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+	resp.SuccessResp(c, dto.AdminAllowlistResp{Entries: entries})
+}
+
+// AdminAddAllowlist 管理员添加白名单接口
+//
+//	@Tags			admin
+//	@Summary		管理员添加白名单接口
+//	@Description	添加IP或CIDR网段到白名单，白名单永久有效直到移除，需要protection:write权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			req				body		dto.AdminAllowlistReq	true	"allowlist request body"
+//	@Param			Authorization	header		string					true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminAddAllowlistResp}
+//	@Router			/api/v1/admin/allowlist [POST]
+func AdminAddAllowlist(ctx context.Context, c *app.RequestContext) {
+	var req dto.AdminAllowlistReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	if bizErr := blocklist.NewDefault().Allow(ctx, req.Entry); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	hlog.CtxInfof(ctx, "allowlist entry %s added by admin %s", req.Entry, jwt.GetPayload(ctx).UserID)
+	resp.SuccessResp(c, dto.AdminAddAllowlistResp{})
+}
+
+// AdminRemoveAllowlist 管理员移除白名单接口
+//
+//	@Tags			admin
+//	@Summary		管理员移除白名单接口
+//	@Description	从白名单移除IP或CIDR网段，需要protection:write权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			req				body		dto.AdminAllowlistReq	true	"allowlist request body"
+//	@Param			Authorization	header		string					true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminRemoveAllowlistResp}
+//	@Router			/api/v1/admin/allowlist/remove [POST]
+func AdminRemoveAllowlist(ctx context.Context, c *app.RequestContext) {
+	var req dto.AdminAllowlistReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	removed, bizErr := blocklist.NewDefault().Disallow(ctx, req.Entry)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	hlog.CtxInfof(ctx, "allowlist entry %s removed by admin %s", req.Entry, jwt.GetPayload(ctx).UserID)
+	resp.SuccessResp(c, dto.AdminRemoveAllowlistResp{Removed: removed})
+}
+
+// AdminGetIPFilter 管理员查看IP过滤规则接口
+//
+//	@Tags			admin
+//	@Summary		管理员查看IP过滤规则接口
+//	@Description	查看运行时设置的IP允许与拒绝列表，以及配置文件中的列表，需要protection:read权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminIPFilterResp}
+//	@Router			/api/v1/admin/ip_filter [GET]
+func AdminGetIPFilter(ctx context.Context, c *app.RequestContext) {
+	static, runtime, bizErr := ipfilter.Default().Rules(ctx)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+	resp.SuccessResp(c, dto.AdminIPFilterResp{
+		Allow:       nonNil(runtime.Allow),
+		Deny:        nonNil(runtime.Deny),
+		ConfigAllow: nonNil(static.Allow),
+		ConfigDeny:  nonNil(static.Deny),
+	})
+}
+
+// AdminSetIPFilter 管理员设置IP过滤规则接口
+//
+//	@Tags			admin
+//	@Summary		管理员设置IP过滤规则接口
+//	@Description	替换运行时的IP允许与拒绝列表（IP或CIDR），所有实例在下次刷新时生效；允许列表非空时其他IP均被拒绝，需要protection:write权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			req				body		dto.AdminSetIPFilterReq	true	"ip filter request body"
+//	@Param			Authorization	header		string					true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminSetIPFilterResp}
+//	@Router			/api/v1/admin/ip_filter [POST]
+func AdminSetIPFilter(ctx context.Context, c *app.RequestContext) {
+	var req dto.AdminSetIPFilterReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	rules := ipfilter.Rules{Allow: req.Allow, Deny: req.Deny}
+	if bizErr := ipfilter.Default().SetRules(ctx, rules); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	hlog.CtxInfof(ctx, "ip filter rules set by admin %s", jwt.GetPayload(ctx).UserID)
+	resp.SuccessResp(c, dto.AdminSetIPFilterResp{})
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+// blockTarget picks the target of a block request, which carries either an IP or an account.
+func blockTarget(ip, account string) (target, value string) {
+	if ip != "" {
+		return blocklist.TargetIP, ip
+	}
+	return blocklist.TargetAccount, account
+}
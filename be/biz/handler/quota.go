@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"doing_now/be/biz/middleware/jwt"
+	"doing_now/be/biz/model/dto"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/quota"
+	"doing_now/be/biz/util/resp"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// GetQuotas 当前用户套餐配额接口
+//
+//	@Tags			user
+//	@Summary		当前用户套餐配额接口
+//	@Description	返回当前用户的套餐及各功能的每日、每月配额与用量（UTC周期），本接口不计入配额
+//	@Accept			json
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.GetQuotasResp}
+//	@Router			/api/v1/user/quotas [GET]
+func GetQuotas(ctx context.Context, c *app.RequestContext) {
+	var req dto.GetQuotasReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	payload := jwt.GetPayload(ctx)
+	if payload.UserID == "" {
+		resp.FailResp(c, errs.Unauthorized)
+		return
+	}
+
+	plan, usages, bizErr := quota.Default().Usage(ctx, payload.UserID)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	data := dto.GetQuotasResp{Plan: plan, Quotas: make([]dto.FeatureQuota, 0, len(usages))}
+	for _, u := range usages {
+		data.Quotas = append(data.Quotas, dto.FeatureQuota{
+			Feature:      u.Feature,
+			Daily:        u.Limits.Daily,
+			DailyUsed:    u.DailyUsed,
+			DailyReset:   u.DailyReset.Unix(),
+			Monthly:      u.Limits.Monthly,
+			MonthlyUsed:  u.MonthlyUsed,
+			MonthlyReset: u.MonthlyReset.Unix(),
+		})
+	}
+	resp.SuccessResp(c, data)
+}
+
+// AdminAssignPlan 管理员设置用户套餐接口
+//
+//	@Tags			admin
+//	@Summary		管理员设置用户套餐接口
+//	@Description	为用户指定套餐，替换原有套餐，本周期已用配额保留，需要user:write权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			user_id			path		string					true	"user id"
+//	@Param			req				body		dto.AdminAssignPlanReq	true	"assign plan request body"
+//	@Param			Authorization	header		string					true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminAssignPlanResp}
+//	@Router			/api/v1/admin/users/{user_id}/plan [POST]
+func AdminAssignPlan(ctx context.Context, c *app.RequestContext) {
+	var req dto.AdminAssignPlanReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	if bizErr := quota.Default().AssignPlan(ctx, req.UserID, req.Plan); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	hlog.CtxInfof(ctx, "plan of user %s set to %s by admin %s", req.UserID, req.Plan, jwt.GetPayload(ctx).UserID)
+	resp.SuccessResp(c, dto.AdminAssignPlanResp{})
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"doing_now/be/biz/middleware/ratelimit"
+	"doing_now/be/biz/model/dto"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/util/interceptor"
+	"doing_now/be/biz/util/resp"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// GetRateLimits 当前用户限流配额接口
+//
+//	@Tags			user
+//	@Summary		当前用户限流配额接口
+//	@Description	返回当前用户在各限流规则下的剩余配额，不消耗配额；按路由区分计数的规则不返回
+//	@Accept			json
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.GetRateLimitsResp}
+//	@Router			/api/v1/user/rate_limits [GET]
+func GetRateLimits(ctx context.Context, c *app.RequestContext) {
+	var req dto.GetRateLimitsReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	quotas, err := ratelimit.Quotas(ctx, c)
+	if err != nil {
+		resp.FailResp(c, errs.ServerError.SetErr(err))
+		return
+	}
+
+	data := dto.GetRateLimitsResp{Quotas: make([]dto.RateLimitQuota, 0, len(quotas))}
+	for _, q := range quotas {
+		methods := q.Methods
+		if methods == nil {
+			methods = []string{}
+		}
+		data.Quotas = append(data.Quotas, dto.RateLimitQuota{
+			Name:          q.Name,
+			Path:          q.Path,
+			Methods:       methods,
+			WindowSeconds: int64(q.Window.Seconds()),
+			Limit:         q.Limit,
+			Remaining:     q.Remaining,
+			Reset:         ratelimit.Seconds(q.Reset),
+			RetryAfter:    ratelimit.Seconds(q.RetryAfter),
+		})
+	}
+	resp.SuccessResp(c, data)
+}
+
+// AdminGetRateLimitStatus 管理员查看限流状态接口
+//
+//	@Tags			admin
+//	@Summary		管理员查看限流状态接口
+//	@Description	查看本实例限流是否因Redis故障切换为进程内限流，需要protection:read权限
+//	@Accept			json
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.AdminRateLimitStatusResp}
+//	@Router			/api/v1/admin/rate_limit/status [GET]
+func AdminGetRateLimitStatus(ctx context.Context, c *app.RequestContext) {
+	status := interceptor.FailoverStatus()
+	data := dto.AdminRateLimitStatusResp{
+		Mode:      status.Mode,
+		Failures:  status.Failures,
+		Instances: status.Instances,
+		LocalKeys: status.LocalKeys,
+	}
+	if !status.Since.IsZero() {
+		data.Since = status.Since.Unix()
+	}
+	resp.SuccessResp(c, data)
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"doing_now/be/biz/middleware/jwt"
+	"doing_now/be/biz/model/dto"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/rbac"
+	"doing_now/be/biz/util/resp"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// GetPermissions 当前用户权限接口
+//
+//	@Tags			user
+//	@Summary		当前用户权限接口
+//	@Description	返回当前用户的角色及其授予的权限，前端据此展示可用功能
+//	@Accept			json
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.GetPermissionsResp}
+//	@Router			/api/v1/user/permissions [GET]
+func GetPermissions(ctx context.Context, c *app.RequestContext) {
+	var req dto.GetPermissionsReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	payload := jwt.GetPayload(ctx)
+	if payload.UserID == "" {
+		resp.FailResp(c, errs.Unauthorized)
+		return
+	}
+
+	svc := rbac.NewDefault()
+	roles, bizErr := svc.Roles(ctx, payload.UserID)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+	perms, bizErr := svc.Permissions(ctx, roles)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	if roles == nil {
+		roles = []string{}
+	}
+	resp.SuccessResp(c, dto.GetPermissionsResp{
+		Roles:       roles,
+		Permissions: perms.List(),
+	})
+}
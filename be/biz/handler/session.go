@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"doing_now/be/biz/middleware/jwt"
+	"doing_now/be/biz/middleware/session"
+	"doing_now/be/biz/model/dto"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/device"
+	"doing_now/be/biz/util/resp"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/hertz-contrib/sessions"
+)
+
+// ListSessions 登录设备列表接口
+//
+//	@Tags			session
+//	@Summary		登录设备列表接口
+//	@Description	列出当前用户所有登录中的会话及其设备信息，current标记当前会话
+//	@Accept			json
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.ListSessionsResp}
+//	@Router			/api/v1/user/sessions [GET]
+func ListSessions(ctx context.Context, c *app.RequestContext) {
+	var req dto.ListSessionsReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	payload := jwt.GetPayload(ctx)
+	if payload.UserID == "" {
+		resp.FailResp(c, errs.Unauthorized)
+		return
+	}
+
+	devices, bizErr := device.NewDefault().List(ctx, payload.UserID)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	sessID := sessions.Default(c).ID()
+	list := make([]dto.SessionInfo, 0, len(devices))
+	for _, d := range devices {
+		list = append(list, dto.SessionInfo{
+			ID:         d.ID,
+			UserAgent:  d.UserAgent,
+			IP:         d.IP,
+			CreatedAt:  d.CreatedAt.Unix(),
+			LastSeenAt: d.LastSeenAt.Unix(),
+			Current:    d.SessionID == sessID,
+		})
+	}
+
+	resp.SuccessResp(c, dto.ListSessionsResp{Sessions: list})
+}
+
+// RevokeSession 注销登录设备接口
+//
+//	@Tags			session
+//	@Summary		注销登录设备接口
+//	@Description	注销指定会话，该会话的access token与refresh token立即失效，其他会话不受影响
+//	@Accept			json
+//	@Produce		json
+//	@Param			id				path		string	true	"session id"
+//	@Param			Authorization	header		string	true	"jwt"
+//	@Success		200				{object}	dto.CommonResp{data=dto.RevokeSessionResp}
+//	@Router			/api/v1/user/sessions/{id} [DELETE]
+func RevokeSession(ctx context.Context, c *app.RequestContext) {
+	var req dto.RevokeSessionReq
+	if err := c.BindAndValidate(&req); err != nil {
+		hlog.CtxNoticef(ctx, "BindAndValidate err: %v", err)
+		resp.AbortWithErr(c, errs.ParamError, http.StatusBadRequest)
+		return
+	}
+
+	payload := jwt.GetPayload(ctx)
+	if payload.UserID == "" {
+		resp.FailResp(c, errs.Unauthorized)
+		return
+	}
+
+	d, bizErr := device.NewDefault().Find(ctx, payload.UserID, req.ID)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	if bizErr := revokeSession(ctx, d.SessionID); bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+
+	// revoking the current session is a logout
+	if d.SessionID == sessions.Default(c).ID() {
+		jwt.ClearRefreshTokenCookie(c)
+		if err := session.Remove(c); err != nil {
+			hlog.CtxErrorf(ctx, "RemoveSession err: %v", err)
+		}
+	}
+
+	hlog.CtxInfof(ctx, "session revoked: %s", d.ID)
+	resp.SuccessResp(c, dto.RevokeSessionResp{})
+}
+
+// revokeSession invalidates the tokens of a session and drops its data and device record.
+func revokeSession(ctx context.Context, sessID string) errs.Error {
+	if err := jwt.RevokeSession(ctx, sessID); err != nil {
+		hlog.CtxErrorf(ctx, "RevokeSession err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	if err := session.Destroy(ctx, sessID); err != nil {
+		hlog.CtxErrorf(ctx, "DestroySession err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	return device.NewDefault().Remove(ctx, sessID)
+}
+
+// revokeUserSessions revokes every session of the user which has a device record and returns how
+// many were revoked. Sessions without one are only ended by a bumped credential version, callers
+// bump it first.
+func revokeUserSessions(ctx context.Context, userID string) (int, errs.Error) {
+	devices, bizErr := device.NewDefault().List(ctx, userID)
+	if bizErr != nil {
+		return 0, bizErr
+	}
+	for i, d := range devices {
+		if bizErr := revokeSession(ctx, d.SessionID); bizErr != nil {
+			return i, bizErr
+		}
+	}
+	return len(devices), nil
+}
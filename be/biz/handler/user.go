@@ -5,10 +5,17 @@ import (
 	"errors"
 	"net/http"
 
+	"doing_now/be/biz/config"
 	"doing_now/be/biz/middleware/jwt"
 	"doing_now/be/biz/middleware/session"
+	"doing_now/be/biz/model/domain"
 	"doing_now/be/biz/model/dto"
 	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/audit"
+	"doing_now/be/biz/service/device"
+	"doing_now/be/biz/service/emailverify"
+	"doing_now/be/biz/service/mfa"
+	"doing_now/be/biz/service/rbac"
 	"doing_now/be/biz/service/user"
 	"doing_now/be/biz/util/resp"
 
@@ -21,7 +28,7 @@ import (
 //
 //	@Tags			user
 //	@Summary		用户注册接口
-//	@Description	用户注册接口
+//	@Description	用户注册接口，填写邮箱时发送邮箱验证链接
 //	@Accept			json
 //	@Produce		json
 //	@Param			req	body		dto.RegisterReq	true	"register request body"
@@ -35,12 +42,15 @@ func Register(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	u, err := user.NewDefault().Register(ctx, req.Account, req.Name, req.Password)
+	u, err := user.NewDefault().Register(ctx, req.Account, req.Name, req.Password, req.Email)
 	if err != nil {
 		resp.FailResp(c, err)
 		return
 	}
 
+	// the user exists already, a lost mail can be sent again through update_email
+	_ = emailverify.NewDefault().Send(ctx, u)
+
 	resp.SuccessResp(c, dto.RegisterResp{UserID: u.UserID})
 }
 
@@ -48,7 +58,7 @@ func Register(ctx context.Context, c *app.RequestContext) {
 //
 //	@Tags			user
 //	@Summary		用户登录接口
-//	@Description	用户登录接口
+//	@Description	用户登录接口，account可填写账号或邮箱，开启两步验证的账号返回mfa_challenge，需调用/api/v1/user/mfa/verify完成登录
 //	@Accept			json
 //	@Produce		json
 //	@Param			req	body		dto.LoginReq	true	"login request body"
@@ -69,9 +79,43 @@ func Login(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
+	loginOrChallenge(ctx, c, u.UserID, u.Account, credentialVersion)
+}
+
+// loginOrChallenge finishes a first-factor login, accounts with two-factor authentication enabled
+// get an mfa challenge instead of the tokens.
+func loginOrChallenge(ctx context.Context, c *app.RequestContext, userID, account string, credentialVersion uint) {
+	mfaSvc := mfa.NewDefault()
+	enabled, bizErr := mfaSvc.IsEnabled(ctx, userID)
+	if bizErr != nil {
+		resp.FailResp(c, bizErr)
+		return
+	}
+	if enabled {
+		challenge, bizErr := mfaSvc.CreateChallenge(ctx, mfa.Challenge{
+			UserID:            userID,
+			Account:           account,
+			CredentialVersion: credentialVersion,
+		})
+		if bizErr != nil {
+			resp.FailResp(c, bizErr)
+			return
+		}
+		resp.SuccessResp(c, dto.LoginResp{
+			MFARequired:  true,
+			MFAChallenge: challenge,
+		})
+		return
+	}
+
+	loginSuccess(ctx, c, userID, account, credentialVersion)
+}
+
+// loginSuccess creates the session, access token and refresh token of an authenticated user.
+func loginSuccess(ctx context.Context, c *app.RequestContext, userID, account string, credentialVersion uint) {
 	sess := sessions.Default(c)
-	sess.Set("user_id", u.UserID)
-	sess.Set("account", u.Account)
+	sess.Set("user_id", userID)
+	sess.Set("account", account)
 	sess.Set("credential_version", credentialVersion)
 	if err := sess.Save(); err != nil {
 		hlog.CtxErrorf(ctx, "sess.Save err: %v", err)
@@ -79,10 +123,7 @@ func Login(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	payload := jwt.Payload{
-		UserID:  u.UserID,
-		Account: u.Account,
-	}
+	payload := tokenPayload(ctx, userID, account)
 
 	accessToken, expAt, jwtErr := jwt.GenerateToken(ctx, payload, sess.ID())
 	if jwtErr != nil {
@@ -97,12 +138,31 @@ func Login(ctx context.Context, c *app.RequestContext) {
 	}
 	jwt.SetRefreshTokenCookie(c, refreshToken, refreshExpAt)
 
+	// the login itself has succeeded, a missing device record only hides it from the session list
+	_ = device.NewDefault().Record(ctx, userID, sess.ID(), string(c.UserAgent()), c.ClientIP())
+	audit.Record(ctx, audit.Event{Type: domain.AuditLoginSuccess, ActorID: userID, TargetID: userID})
+
 	resp.SuccessResp(c, dto.LoginResp{
 		AccessToken: accessToken,
 		ExpiresAt:   expAt,
 	})
 }
 
+// tokenPayload is the payload of the access tokens of a user, it carries the roles when configured.
+func tokenPayload(ctx context.Context, userID, account string) jwt.Payload {
+	payload := jwt.Payload{
+		UserID:  userID,
+		Account: account,
+	}
+	if userID != "" && config.GetAuthzConf().TokenRoles {
+		// without roles in the token the permission checks look them up
+		if roles, bizErr := rbac.NewDefault().Roles(ctx, userID); bizErr == nil {
+			payload.Roles = roles
+		}
+	}
+	return payload
+}
+
 // RefreshToken 刷新token接口
 //
 //	@Tags			user
@@ -122,14 +182,6 @@ func RefreshToken(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	sess := sessions.Default(c)
-	sessID := sess.ID()
-	if sessID == "" {
-		hlog.CtxNoticef(ctx, "sessID is empty")
-		resp.FailResp(c, errs.Unauthorized)
-		return
-	}
-
 	refreshToken := jwt.GetRefreshTokenFromCookie(c)
 	if refreshToken == "" {
 		hlog.CtxNoticef(ctx, "refreshToken is empty")
@@ -137,51 +189,90 @@ func RefreshToken(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	if err := jwt.RemoveRefreshToken(ctx, refreshToken, sessID); err != nil {
+	// an empty session fails the session check of the token, but a reused token is still detected
+	sess := sessions.Default(c)
+	sessID := sess.ID()
+	userID, _ := sess.Get("user_id").(string)
+	account, _ := sess.Get("account").(string)
+	if userID != "" {
+		if bizErr := checkSessionCredential(ctx, userID, sess); bizErr != nil {
+			audit.Record(ctx, audit.Event{
+				Type:     domain.AuditRefreshFailure,
+				ActorID:  userID,
+				TargetID: userID,
+				Details:  map[string]any{"reason": bizErr.Msg()},
+			})
+			_ = revokeSession(ctx, sessID)
+			jwt.ClearRefreshTokenCookie(c)
+			resp.FailResp(c, bizErr)
+			return
+		}
+	}
+	pair, err := jwt.RefreshTokens(ctx, refreshToken, sessID, tokenPayload(ctx, userID, account))
+	if err != nil {
+		var reuseErr *jwt.RefreshTokenReuseError
+		if errors.As(err, &reuseErr) {
+			hlog.CtxWarnf(ctx, "security event: refresh token reused, family: %s, session revoked: %t, ip: %s, user agent: %s",
+				reuseErr.Family, reuseErr.SessionID != "", c.ClientIP(), c.UserAgent())
+			audit.Record(ctx, audit.Event{
+				Type:     domain.AuditRefreshReuse,
+				ActorID:  userID,
+				TargetID: userID,
+				Details:  map[string]any{"family": reuseErr.Family, "session_revoked": reuseErr.SessionID != ""},
+			})
+			if reuseErr.SessionID != "" {
+				_ = revokeSession(ctx, reuseErr.SessionID)
+			}
+			jwt.ClearRefreshTokenCookie(c)
+		} else if errors.Is(err, jwt.ErrRefreshTokenInvalid) {
+			audit.Record(ctx, audit.Event{
+				Type:     domain.AuditRefreshFailure,
+				ActorID:  userID,
+				TargetID: userID,
+				Details:  map[string]any{"reason": err.Error()},
+			})
+		}
 		if errors.Is(err, jwt.ErrRefreshTokenInvalid) {
 			resp.FailResp(c, errs.Unauthorized.SetErr(err))
 			return
 		}
-		hlog.CtxErrorf(ctx, "RemoveRefreshToken err: %v", err)
+		hlog.CtxErrorf(ctx, "RefreshTokens err: %v", err)
 		resp.FailResp(c, errs.ServerError.SetErr(err))
 		return
 	}
-
-	userID, _ := sess.Get("user_id").(string)
-	account, _ := sess.Get("account").(string)
-	if userID == "" || account == "" {
-		hlog.CtxNoticef(ctx, "userID or account is empty")
-		resp.FailResp(c, errs.Unauthorized)
-		return
-	}
-
-	newAccessToken, accessExpAt, accessErr := jwt.GenerateToken(ctx,
-		jwt.Payload{
-			UserID:  userID,
-			Account: account,
-		}, sessID)
-	if accessErr != nil {
-		hlog.CtxErrorf(ctx, "GenerateToken err: %v", accessErr)
-		resp.FailResp(c, errs.ServerError.SetErr(accessErr))
-		return
-	}
-
-	newRefreshToken, refreshExpAt, refreshErr := jwt.GenerateRefreshToken(ctx, sessID)
-	if refreshErr != nil {
-		hlog.CtxErrorf(ctx, "GenerateRefreshToken err: %v", refreshErr)
-		resp.FailResp(c, errs.ServerError.SetErr(refreshErr))
-		return
-	}
-	jwt.SetRefreshTokenCookie(c, newRefreshToken, refreshExpAt)
+	jwt.SetRefreshTokenCookie(c, pair.RefreshToken, pair.RefreshExpiresAt)
+	_ = device.NewDefault().Touch(ctx, sessID, c.ClientIP())
 
 	resp.SuccessResp(c, dto.RefreshTokenResp{
-		AccessToken:      newAccessToken,
-		ExpiresAt:        accessExpAt,
-		RefreshToken:     newRefreshToken,
-		RefreshExpiresAt: refreshExpAt,
+		AccessToken:      pair.AccessToken,
+		ExpiresAt:        pair.ExpiresAt,
+		RefreshToken:     pair.RefreshToken,
+		RefreshExpiresAt: pair.RefreshExpiresAt,
 	})
 }
 
+// checkSessionCredential rejects sessions of users who are no longer active or whose credential
+// has changed since the login, the same way the credential check does for access tokens.
+func checkSessionCredential(ctx context.Context, userID string, sess sessions.Session) errs.Error {
+	sessCV, _ := sess.Get("credential_version").(uint)
+	currentCV, bizErr := user.NewDefault().GetActiveCredentialVersion(ctx, userID)
+	switch {
+	case errs.ErrorEqual(errs.UserStatusInvalid, bizErr):
+		hlog.CtxInfof(ctx, "refresh rejected, user is not active. UserID=%s", userID)
+		return errs.UserStatusInvalid
+	case errs.ErrorEqual(errs.UserNotExist, bizErr):
+		return errs.SessionExpired
+	case bizErr != nil:
+		// fail open on DB errors like the credential check
+		hlog.CtxErrorf(ctx, "GetActiveCredentialVersion err: %v", bizErr)
+		return nil
+	case currentCV != sessCV:
+		hlog.CtxInfof(ctx, "refresh rejected, credential version mismatch: session=%v, db=%v. UserID=%s", sessCV, currentCV, userID)
+		return errs.SessionExpired
+	}
+	return nil
+}
+
 // Logout 用户登出接口
 //
 //	@Tags			user
@@ -218,7 +309,11 @@ func Logout(ctx context.Context, c *app.RequestContext) {
 	if err := session.Remove(c); err != nil {
 		hlog.CtxErrorf(ctx, "RemoveSession err: %v", err)
 	}
+	_ = device.NewDefault().Remove(ctx, sessID)
 	hlog.CtxInfof(ctx, "Logout success")
+	if userID := jwt.GetPayload(ctx).UserID; userID != "" {
+		audit.Record(ctx, audit.Event{Type: domain.AuditLogout, TargetID: userID})
+	}
 	resp.SuccessResp(c, dto.LogoutResp{})
 }
 
@@ -253,11 +348,13 @@ func GetUserInfo(ctx context.Context, c *app.RequestContext) {
 	}
 
 	resp.SuccessResp(c, dto.GetUserInfoResp{
-		UserID:    u.UserID,
-		Account:   u.Account,
-		Name:      u.Name,
-		CreatedAt: u.CreatedAt.Unix(),
-		UpdatedAt: u.UpdatedAt.Unix(),
+		UserID:        u.UserID,
+		Account:       u.Account,
+		Name:          u.Name,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		CreatedAt:     u.CreatedAt.Unix(),
+		UpdatedAt:     u.UpdatedAt.Unix(),
 	})
 }
 
@@ -0,0 +1,52 @@
+package authz
+
+import (
+	"context"
+	"net/http"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/middleware/jwt"
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/rbac"
+	"doing_now/be/biz/util/resp"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// Require lets the request through only if the user holds all the permissions. It must run after
+// jwt.ValidateMW. The roles carried in the access token are used when authz.token_roles is
+// enabled, otherwise they are looked up.
+func Require(perms ...string) app.HandlerFunc {
+	return func(ctx context.Context, c *app.RequestContext) {
+		payload := jwt.GetPayload(ctx)
+		if payload.UserID == "" {
+			resp.AbortWithErr(c, errs.Unauthorized, http.StatusUnauthorized)
+			return
+		}
+
+		granted, bizErr := permissions(ctx, payload)
+		if bizErr != nil {
+			resp.AbortWithErr(c, bizErr, http.StatusInternalServerError)
+			return
+		}
+		for _, perm := range perms {
+			if !granted.Has(perm) {
+				hlog.CtxNoticef(ctx, "permission denied, user id: %s, permission: %s", payload.UserID, perm)
+				resp.AbortWithErr(c, errs.Forbidden, http.StatusForbidden)
+				return
+			}
+		}
+
+		c.Next(ctx)
+	}
+}
+
+func permissions(ctx context.Context, payload jwt.Payload) (domain.PermissionSet, errs.Error) {
+	svc := rbac.NewDefault()
+	if config.GetAuthzConf().TokenRoles && payload.Roles != nil {
+		return svc.Permissions(ctx, payload.Roles)
+	}
+	return svc.UserPermissions(ctx, payload.UserID)
+}
@@ -0,0 +1,86 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/db/mysql"
+	"doing_now/be/biz/middleware/jwt"
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/storage"
+
+	"github.com/bytedance/mockey"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestRequire(t *testing.T) {
+	mockey.PatchConvey("authz require", t, func() {
+		db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:authz_%d?mode=memory&cache=shared", time.Now().UnixNano())), &gorm.Config{})
+		assert.NoError(t, err)
+		assert.NoError(t, db.AutoMigrate(&storage.RolePermissionRecord{}, &storage.UserRoleRecord{}))
+		assert.NoError(t, db.Create(&[]*storage.RolePermissionRecord{
+			{Role: "support", Permission: domain.PermUserRead},
+			{Role: "admin", Permission: domain.PermAll},
+		}).Error)
+		assert.NoError(t, db.Create(&storage.UserRoleRecord{UserId: "user01", Role: "support"}).Error)
+		mockey.Mock(mysql.GetDbConn).Return(db).Build()
+
+		var payload jwt.Payload
+		mockey.Mock(jwt.GetPayload).To(func(ctx context.Context) jwt.Payload {
+			return payload
+		}).Build()
+		var authzConf config.AuthzConf
+		mockey.Mock(config.GetAuthzConf).To(func() config.AuthzConf {
+			return authzConf
+		}).Build()
+
+		run := func(perms ...string) (*app.RequestContext, bool) {
+			called := false
+			c := app.NewContext(0)
+			c.SetHandlers(app.HandlersChain{Require(perms...), func(ctx context.Context, c *app.RequestContext) {
+				called = true
+			}})
+			c.Next(context.Background())
+			return c, called
+		}
+
+		t.Run("anonymous", func(t *testing.T) {
+			payload = jwt.Payload{}
+			c, called := run(domain.PermUserRead)
+			assert.False(t, called)
+			assert.Equal(t, http.StatusUnauthorized, c.Response.StatusCode())
+		})
+
+		t.Run("roles looked up", func(t *testing.T) {
+			payload = jwt.Payload{UserID: "user01"}
+			_, called := run(domain.PermUserRead)
+			assert.True(t, called)
+
+			c, called := run(domain.PermUserRead, domain.PermUserWrite)
+			assert.False(t, called)
+			assert.Equal(t, http.StatusForbidden, c.Response.StatusCode())
+		})
+
+		t.Run("roles carried in the token", func(t *testing.T) {
+			payload = jwt.Payload{UserID: "user01", Roles: []string{"admin"}}
+			_, called := run(domain.PermUserWrite)
+			assert.False(t, called)
+
+			authzConf.TokenRoles = true
+			_, called = run(domain.PermUserWrite)
+			assert.True(t, called)
+
+			// tokens issued without roles fall back to the lookup
+			payload = jwt.Payload{UserID: "user01"}
+			_, called = run(domain.PermUserWrite)
+			assert.False(t, called)
+		})
+	})
+}
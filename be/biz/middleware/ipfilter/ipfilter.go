@@ -0,0 +1,35 @@
+package ipfilter
+
+import (
+	"context"
+	"net/http"
+
+	"doing_now/be/biz/model/dto"
+	"doing_now/be/biz/model/errs"
+	ipfiltersvc "doing_now/be/biz/service/ipfilter"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+const msgIPNotAllowed = "IP not allowed"
+
+// New rejects requests from denied IPs, and from IPs outside the allowed ranges when there are any.
+func New() app.HandlerFunc {
+	filter := ipfiltersvc.Default()
+
+	return func(ctx context.Context, c *app.RequestContext) {
+		ip := c.ClientIP()
+		if filter.Allowed(ctx, ip) {
+			c.Next(ctx)
+			return
+		}
+
+		hlog.CtxInfof(ctx, "IP filter: request from %s rejected", ip)
+		c.AbortWithStatusJSON(http.StatusForbidden, dto.CommonResp{
+			Code:    int(errs.RequestBlocked.Code()),
+			Message: msgIPNotAllowed,
+			Success: false,
+		})
+	}
+}
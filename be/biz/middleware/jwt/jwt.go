@@ -7,6 +7,7 @@ import (
 	"doing_now/be/biz/model/errs"
 	"doing_now/be/biz/util/encode"
 	"doing_now/be/biz/util/resp"
+	"doing_now/be/biz/util/trace_info"
 	"errors"
 	"fmt"
 	"net/http"
@@ -25,11 +26,11 @@ var (
 	ErrJwtInvalid          = errors.New("jwt is invalid")
 	ErrJwtExpired          = errors.New("jwt is expired")
 	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+	ErrRefreshTokenReused  = errors.New("refresh token is reused")
 )
 
 func ValidateMW() app.HandlerFunc {
 	return func(ctx context.Context, c *app.RequestContext) {
-		jwtConf := config.GetJWTConfig()
 		jwtStr := exactJWT(c)
 		if jwtStr == "" {
 			hlog.CtxNoticef(ctx, "authorization failed, token is empty")
@@ -37,8 +38,15 @@ func ValidateMW() app.HandlerFunc {
 			return
 		}
 
+		keyring, err := AccessKeyring()
+		if err != nil {
+			hlog.CtxErrorf(ctx, "load jwt keyring err: %v", err)
+			resp.AbortWithErr(c, errs.ServerError, http.StatusInternalServerError)
+			return
+		}
+
 		// 0. basic validation
-		claims, err := validateToken(jwtStr, jwtConf.AccessTokenSecret)
+		claims, err := validateToken(jwtStr, keyring)
 		if err != nil {
 			hlog.CtxNoticef(ctx, "jwt invalid: %v", err)
 			resp.AbortWithErr(c, errs.Unauthorized.SetErr(err), http.StatusUnauthorized)
@@ -66,15 +74,21 @@ func ValidateMW() app.HandlerFunc {
 		}
 
 		// set claims
-		ctx = context.WithValue(ctx, Payload{}, claims)
+		ctx = context.WithValue(ctx, claimsKey{}, claims)
+		ctx = trace_info.WithUserId(ctx, claims.UserID)
 
 		c.Next(ctx)
 	}
 }
 
+// claimsKey keeps the claims of a validated access token in the context.
+type claimsKey struct{}
+
 type Payload struct {
 	UserID  string `json:"user_id,omitempty"`
 	Account string `json:"account,omitempty"`
+	// Roles is only carried when authz.token_roles is enabled
+	Roles []string `json:"roles,omitempty"`
 }
 
 type Claims struct {
@@ -82,6 +96,8 @@ type Claims struct {
 	Payload
 
 	Sum string `json:"sum,omitempty"`
+	// Family is set on refresh tokens only, all tokens rotated from one login share it
+	Family string `json:"fam,omitempty"`
 }
 
 func (c *Claims) CheckSum(sessID string) bool {
@@ -95,14 +111,19 @@ func GenerateToken(ctx context.Context, payload Payload, sessID string) (string,
 	exp := accessExpiration(jwtConf)
 	expAt := time.Now().Add(exp).Unix()
 
-	jwtStr, err := generateToken(payload, exp, tokenID, sessID, jwtConf.AccessTokenSecret, jwtConf.Issuer)
+	keyring, err := AccessKeyring()
+	if err != nil {
+		hlog.CtxErrorf(ctx, "load jwt keyring err: %v", err)
+		return "", 0, err
+	}
+
+	jwtStr, err := generateToken(payload, exp, tokenID, sessID, keyring, jwtConf.Issuer)
 	if err != nil {
 		hlog.CtxErrorf(ctx, "generate access token err: %v", err)
 		return "", 0, err
 	}
 
-	if err := rediscli.GetRedisClient().
-		Set(ctx, tokenExistKey(tokenID), true, exp).Err(); err != nil {
+	if err := cacheSessionToken(ctx, tokenExistKey(tokenID), sessID, exp); err != nil {
 		hlog.CtxErrorf(ctx, "cache token id err: %v", err)
 		return "", 0, err
 	}
@@ -111,15 +132,34 @@ func GenerateToken(ctx context.Context, payload Payload, sessID string) (string,
 }
 
 func GetPayload(ctx context.Context) Payload {
-	claims, ok := ctx.Value(Payload{}).(*Claims)
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
 	if ok {
 		return claims.Payload
 	}
 	return Payload{}
 }
 
+// PeekPayload reads the payload of the request's access token without the session and
+// revocation checks of ValidateMW, for middlewares that run before it. ok is false when
+// the token is missing, expired or not signed by us.
+func PeekPayload(c *app.RequestContext) (Payload, bool) {
+	jwtStr := exactJWT(c)
+	if jwtStr == "" {
+		return Payload{}, false
+	}
+	keyring, err := AccessKeyring()
+	if err != nil {
+		return Payload{}, false
+	}
+	claims, err := validateToken(jwtStr, keyring)
+	if err != nil {
+		return Payload{}, false
+	}
+	return claims.Payload, true
+}
+
 func RemoveToken(ctx context.Context, sessID string) error {
-	if claims, ok := ctx.Value(Payload{}).(*Claims); ok {
+	if claims, ok := ctx.Value(claimsKey{}).(*Claims); ok {
 		if !claims.CheckSum(sessID) {
 			return ErrJwtInvalid
 		}
@@ -140,8 +180,34 @@ func RemoveToken(ctx context.Context, sessID string) error {
 	return nil
 }
 
-func generateToken(payload Payload, expiration time.Duration, tokenID, sessID, secret, issuer string) (string, error) {
-	claims := Claims{
+// RevokeSession invalidates every access and refresh token issued to the session.
+func RevokeSession(ctx context.Context, sessID string) error {
+	rdb := rediscli.GetRedisClient()
+	keys, err := rdb.SMembers(ctx, sessionTokensKey(sessID)).Result()
+	if err != nil {
+		return err
+	}
+	return rdb.Del(ctx, append(keys, sessionTokensKey(sessID))...).Err()
+}
+
+// cacheSessionToken marks the token key valid and remembers it under the session, so that
+// RevokeSession can find it.
+func cacheSessionToken(ctx context.Context, key, sessID string, exp time.Duration) error {
+	pipe := rediscli.GetRedisClient().TxPipeline()
+	pipe.Set(ctx, key, true, exp)
+	pipe.SAdd(ctx, sessionTokensKey(sessID), key)
+	// refresh tokens live longest, keep the index as long as any of them
+	pipe.Expire(ctx, sessionTokensKey(sessID), max(exp, refreshExpiration(config.GetJWTConfig())))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func generateToken(payload Payload, expiration time.Duration, tokenID, sessID string, keyring *Keyring, issuer string) (string, error) {
+	return keyring.Sign(newClaims(payload, expiration, tokenID, sessID, issuer))
+}
+
+func newClaims(payload Payload, expiration time.Duration, tokenID, sessID string, issuer string) Claims {
+	return Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiration)),
 			Issuer:    issuer,
@@ -150,18 +216,11 @@ func generateToken(payload Payload, expiration time.Duration, tokenID, sessID, s
 		Payload: payload,
 		Sum:     encode.EncodePassword(tokenID, sessID),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
 }
 
-func validateToken(tokenStr, secret string) (*Claims, error) {
+func validateToken(tokenStr string, keyring *Keyring) (*Claims, error) {
 	var claims Claims
-	token, err := jwt.ParseWithClaims(tokenStr, &claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrHashUnavailable
-		}
-		return []byte(secret), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenStr, &claims, keyring.Keyfunc)
 	if err != nil {
 		if errors.Is(err, jwt.ErrHashUnavailable) {
 			return nil, ErrUnexpectedJwtMethod
@@ -169,7 +228,7 @@ func validateToken(tokenStr, secret string) (*Claims, error) {
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			return nil, ErrJwtExpired
 		}
-		if errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+		if errors.Is(err, jwt.ErrTokenSignatureInvalid) || errors.Is(err, ErrUnknownKeyID) {
 			return nil, ErrJwtInvalid
 		}
 		return nil, err
@@ -185,6 +244,10 @@ func tokenExistKey(tid string) string {
 	return fmt.Sprintf("jwt_id_exist:%s", tid)
 }
 
+func sessionTokensKey(sessID string) string {
+	return fmt.Sprintf("session_tokens:%s", sessID)
+}
+
 func exactJWT(c *app.RequestContext) string {
 	return c.Request.Header.Get("Authorization")
 }
@@ -17,24 +17,24 @@ func TestJwt(t *testing.T) {
 
 	jwtStr, err := generateToken(Payload{
 		UserID: random.RandStr(32),
-	}, time.Second, tokenId, sessId, secret, "go test")
+	}, time.Second, tokenId, sessId, NewHMACKeyring(secret), "go test")
 	assert.Nil(t, err)
 	t.Log(jwtStr)
 
 	t.Run("success", func(t *testing.T) {
-		claims, err := validateToken(jwtStr, secret)
+		claims, err := validateToken(jwtStr, NewHMACKeyring(secret))
 		assert.Nil(t, err)
 		assert.True(t, claims.CheckSum(sessId))
 	})
 
 	t.Run("secret key invalid", func(t *testing.T) {
-		_, err := validateToken(jwtStr, secret+"123")
+		_, err := validateToken(jwtStr, NewHMACKeyring(secret+"123"))
 		assert.ErrorIs(t, ErrJwtInvalid, err)
 	})
 
 	t.Run("expired", func(t *testing.T) {
 		time.Sleep(time.Second * 2)
-		_, err := validateToken(jwtStr, secret)
+		_, err := validateToken(jwtStr, NewHMACKeyring(secret))
 		assert.ErrorIs(t, ErrJwtExpired, err)
 	})
 
@@ -48,7 +48,7 @@ func TestGetPayloadAndRemoveToken(t *testing.T) {
 
 	t.Run("GetPayload from context", func(t *testing.T) {
 		claims := &Claims{Payload: Payload{UserID: "u1", Account: "a"}}
-		ctx := context.WithValue(context.Background(), Payload{}, claims)
+		ctx := context.WithValue(context.Background(), claimsKey{}, claims)
 		p := GetPayload(ctx)
 		assert.Equal(t, "u1", p.UserID)
 		assert.Equal(t, "a", p.Account)
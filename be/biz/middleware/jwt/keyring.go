@@ -0,0 +1,300 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+
+	"doing_now/be/biz/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownKeyID       = errors.New("unknown jwt key id")
+	ErrNoSigningKey       = errors.New("no jwt signing key")
+	ErrUnsupportedJwtAlgo = errors.New("unsupported jwt algorithm")
+)
+
+// Key is one entry of a keyring, keys without private material can only verify.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	signKey   any
+	verifyKey any
+}
+
+// Keyring signs with one key and verifies with every key it holds, so tokens signed by
+// a retired key stay valid until they expire.
+type Keyring struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+var (
+	accessKeyringOnce sync.Once
+	accessKeyring     *Keyring
+	accessKeyringErr  error
+
+	refreshKeyringOnce sync.Once
+	refreshKeyring     *Keyring
+	refreshKeyringErr  error
+)
+
+// AccessKeyring returns the keyring of access tokens built from the config.
+func AccessKeyring() (*Keyring, error) {
+	accessKeyringOnce.Do(func() {
+		accessKeyring, accessKeyringErr = NewKeyring(config.GetJWTConfig())
+	})
+	return accessKeyring, accessKeyringErr
+}
+
+// RefreshKeyring returns the keyring of refresh tokens built from the config.
+func RefreshKeyring() (*Keyring, error) {
+	refreshKeyringOnce.Do(func() {
+		refreshKeyring, refreshKeyringErr = NewRefreshKeyring(config.GetJWTConfig())
+	})
+	return refreshKeyring, refreshKeyringErr
+}
+
+// NewKeyring builds the access token keyring from AccessTokenKeys and AccessTokenSecrets.
+// Without any of them it falls back to HS256 with AccessTokenSecret, otherwise AccessTokenSecret
+// only verifies tokens without kid, which were issued before the switch.
+func NewKeyring(conf config.JWTConf) (*Keyring, error) {
+	keys := append(append([]config.JWTKeyConf{}, conf.AccessTokenKeys...), secretKeys(conf.AccessTokenSecrets)...)
+	return buildKeyring(keys, conf.SigningKeyID, conf.AccessTokenSecret)
+}
+
+// NewRefreshKeyring builds the refresh token keyring, the first of RefreshTokenSecrets signs.
+func NewRefreshKeyring(conf config.JWTConf) (*Keyring, error) {
+	return buildKeyring(secretKeys(conf.RefreshTokenSecrets), "", conf.RefreshTokenSecret)
+}
+
+func buildKeyring(keys []config.JWTKeyConf, signingKeyID, legacySecret string) (*Keyring, error) {
+	if len(keys) == 0 {
+		return NewHMACKeyring(legacySecret), nil
+	}
+
+	k := &Keyring{keys: make(map[string]*Key, len(keys)+1)}
+	for _, kc := range keys {
+		key, err := parseKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", kc.ID, err)
+		}
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, fmt.Errorf("jwt key %q: duplicated id", kc.ID)
+		}
+		k.keys[key.ID] = key
+		if k.signing == nil && key.signKey != nil && (signingKeyID == "" || signingKeyID == key.ID) {
+			k.signing = key
+		}
+	}
+	if k.signing == nil {
+		return nil, fmt.Errorf("jwt signing key %q: %w", signingKeyID, ErrNoSigningKey)
+	}
+	if legacySecret != "" {
+		k.keys[""] = &Key{Method: jwt.SigningMethodHS256, verifyKey: []byte(legacySecret)}
+	}
+	return k, nil
+}
+
+func secretKeys(secrets []config.JWTSecretConf) []config.JWTKeyConf {
+	keys := make([]config.JWTKeyConf, 0, len(secrets))
+	for _, sc := range secrets {
+		keys = append(keys, config.JWTKeyConf{ID: sc.ID, Algorithm: jwt.SigningMethodHS256.Alg(), Secret: sc.Secret})
+	}
+	return keys
+}
+
+// NewHMACKeyring returns a keyring with a single HS256 key and no kid.
+func NewHMACKeyring(secret string) *Keyring {
+	key := &Key{Method: jwt.SigningMethodHS256, signKey: []byte(secret), verifyKey: []byte(secret)}
+	return &Keyring{signing: key, keys: map[string]*Key{"": key}}
+}
+
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signing.Method, claims)
+	if k.signing.ID != "" {
+		token.Header["kid"] = k.signing.ID
+	}
+	return token.SignedString(k.signing.signKey)
+}
+
+// Keyfunc picks the key by kid and rejects tokens whose alg does not match the key.
+func (k *Keyring) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, jwt.ErrHashUnavailable
+	}
+	return key.verifyKey, nil
+}
+
+// JWK is a public key in the JSON Web Key format, see RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the keyring, HMAC keys are never published.
+func (k *Keyring) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range k.sortedKeys() {
+		jwk := JWK{Kid: key.ID, Alg: key.Method.Alg(), Use: "sig"}
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = b64(pub.N.Bytes())
+			jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = pub.Curve.Params().Name
+			jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+			jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = b64(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// sortedKeys lists the signing key first, the order of the others follows the kid.
+func (k *Keyring) sortedKeys() []*Key {
+	list := []*Key{k.signing}
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		if id != k.signing.ID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		list = append(list, k.keys[id])
+	}
+	return list
+}
+
+func parseKey(kc config.JWTKeyConf) (*Key, error) {
+	if kc.ID == "" {
+		return nil, errors.New("id is required")
+	}
+	key := &Key{ID: kc.ID}
+
+	if kc.Algorithm == jwt.SigningMethodHS256.Alg() {
+		if kc.Secret == "" {
+			return nil, errors.New("secret is required")
+		}
+		key.Method = jwt.SigningMethodHS256
+		key.signKey, key.verifyKey = []byte(kc.Secret), []byte(kc.Secret)
+		return key, nil
+	}
+
+	privatePEM, err := readPEM(kc.PrivateKey, kc.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	publicPEM, err := readPEM(kc.PublicKey, kc.PublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if privatePEM == nil && publicPEM == nil {
+		return nil, errors.New("private or public key is required")
+	}
+
+	switch kc.Algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		key.Method = jwt.SigningMethodRS256
+		err = parsePair(key, privatePEM, publicPEM,
+			func(b []byte) (crypto.Signer, error) { return jwt.ParseRSAPrivateKeyFromPEM(b) },
+			func(b []byte) (crypto.PublicKey, error) { return jwt.ParseRSAPublicKeyFromPEM(b) })
+	case jwt.SigningMethodES256.Alg():
+		key.Method = jwt.SigningMethodES256
+		err = parsePair(key, privatePEM, publicPEM,
+			func(b []byte) (crypto.Signer, error) { return jwt.ParseECPrivateKeyFromPEM(b) },
+			func(b []byte) (crypto.PublicKey, error) { return jwt.ParseECPublicKeyFromPEM(b) })
+		if err == nil && key.verifyKey.(*ecdsa.PublicKey).Curve != elliptic.P256() {
+			err = errors.New("ES256 requires a P-256 key")
+		}
+	case jwt.SigningMethodEdDSA.Alg():
+		key.Method = jwt.SigningMethodEdDSA
+		err = parsePair(key, privatePEM, publicPEM,
+			func(b []byte) (crypto.Signer, error) {
+				k, err := jwt.ParseEdPrivateKeyFromPEM(b)
+				if err != nil {
+					return nil, err
+				}
+				return k.(crypto.Signer), nil
+			},
+			func(b []byte) (crypto.PublicKey, error) { return jwt.ParseEdPublicKeyFromPEM(b) })
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedJwtAlgo, kc.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// parsePair fills the keys of an asymmetric algorithm, the public key is derived from the private key when present.
+func parsePair(key *Key, privatePEM, publicPEM []byte,
+	parsePrivate func([]byte) (crypto.Signer, error), parsePublic func([]byte) (crypto.PublicKey, error)) error {
+	if privatePEM != nil {
+		signer, err := parsePrivate(privatePEM)
+		if err != nil {
+			return err
+		}
+		key.signKey = signer
+		key.verifyKey = signer.Public()
+		return nil
+	}
+	pub, err := parsePublic(publicPEM)
+	if err != nil {
+		return err
+	}
+	key.verifyKey = pub
+	return nil
+}
+
+func readPEM(inline, file string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+	if file != "" {
+		return os.ReadFile(file)
+	}
+	return nil, nil
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
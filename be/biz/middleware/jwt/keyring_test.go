@@ -0,0 +1,187 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"doing_now/be/biz/config"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func privatePEM(t *testing.T, key any) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func publicPEM(t *testing.T, key any) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	assert.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestKeyring_Algorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	cases := []config.JWTKeyConf{
+		{ID: "hs", Algorithm: "HS256", Secret: "secret"},
+		{ID: "rs", Algorithm: "RS256", PrivateKey: privatePEM(t, rsaKey)},
+		{ID: "es", Algorithm: "ES256", PrivateKey: privatePEM(t, ecKey)},
+		{ID: "ed", Algorithm: "EdDSA", PrivateKey: privatePEM(t, edKey)},
+	}
+	for _, kc := range cases {
+		t.Run(kc.Algorithm, func(t *testing.T) {
+			keyring, err := NewKeyring(config.JWTConf{AccessTokenKeys: []config.JWTKeyConf{kc}})
+			assert.NoError(t, err)
+
+			jwtStr, err := generateToken(Payload{UserID: "u1"}, time.Minute, "tid", "sid", keyring, "go test")
+			assert.NoError(t, err)
+			token, _, err := jwt.NewParser().ParseUnverified(jwtStr, &Claims{})
+			assert.NoError(t, err)
+			assert.Equal(t, kc.ID, token.Header["kid"])
+			assert.Equal(t, kc.Algorithm, token.Method.Alg())
+
+			claims, err := validateToken(jwtStr, keyring)
+			assert.NoError(t, err)
+			assert.Equal(t, "u1", claims.UserID)
+
+			jwks := keyring.JWKS()
+			if kc.Algorithm == "HS256" {
+				assert.Empty(t, jwks.Keys)
+			} else {
+				assert.Len(t, jwks.Keys, 1)
+				assert.Equal(t, kc.ID, jwks.Keys[0].Kid)
+			}
+		})
+	}
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	// before: HS256 only
+	legacy, err := NewKeyring(config.JWTConf{AccessTokenSecret: "secret"})
+	assert.NoError(t, err)
+	legacyToken, err := generateToken(Payload{}, time.Minute, "t0", "sid", legacy, "go test")
+	assert.NoError(t, err)
+
+	// step 1: sign with the old key
+	before, err := NewKeyring(config.JWTConf{AccessTokenKeys: []config.JWTKeyConf{
+		{ID: "old", Algorithm: "ES256", PrivateKey: privatePEM(t, oldKey)},
+	}})
+	assert.NoError(t, err)
+	oldToken, err := generateToken(Payload{}, time.Minute, "t1", "sid", before, "go test")
+	assert.NoError(t, err)
+
+	// step 2: sign with the new key, the old one only verifies
+	after, err := NewKeyring(config.JWTConf{
+		AccessTokenSecret: "secret",
+		SigningKeyID:      "new",
+		AccessTokenKeys: []config.JWTKeyConf{
+			{ID: "old", Algorithm: "ES256", PublicKey: publicPEM(t, &oldKey.PublicKey)},
+			{ID: "new", Algorithm: "ES256", PrivateKey: privatePEM(t, newKey)},
+		},
+	})
+	assert.NoError(t, err)
+	newToken, err := generateToken(Payload{}, time.Minute, "t2", "sid", after, "go test")
+	assert.NoError(t, err)
+
+	for _, tokenStr := range []string{legacyToken, oldToken, newToken} {
+		_, err := validateToken(tokenStr, after)
+		assert.NoError(t, err)
+	}
+	_, err = validateToken(newToken, before)
+	assert.ErrorIs(t, err, ErrJwtInvalid)
+
+	jwks := after.JWKS()
+	assert.Len(t, jwks.Keys, 2)
+	assert.Equal(t, "new", jwks.Keys[0].Kid)
+
+	t.Run("alg confusion rejected", func(t *testing.T) {
+		// an HS256 token signed with the published public key must not pass as the ES256 key
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}})
+		token.Header["kid"] = "new"
+		forged, err := token.SignedString([]byte(publicPEM(t, &newKey.PublicKey)))
+		assert.NoError(t, err)
+		_, err = validateToken(forged, after)
+		assert.ErrorIs(t, err, ErrUnexpectedJwtMethod)
+	})
+
+	t.Run("signing key must have a private key", func(t *testing.T) {
+		_, err := NewKeyring(config.JWTConf{
+			SigningKeyID:    "old",
+			AccessTokenKeys: []config.JWTKeyConf{{ID: "old", Algorithm: "ES256", PublicKey: publicPEM(t, &oldKey.PublicKey)}},
+		})
+		assert.ErrorIs(t, err, ErrNoSigningKey)
+	})
+}
+
+func TestKeyring_SecretRotation(t *testing.T) {
+	legacy, err := NewRefreshKeyring(config.JWTConf{RefreshTokenSecret: "secret"})
+	assert.NoError(t, err)
+	legacyToken, err := generateToken(Payload{}, time.Minute, "t0", "sid", legacy, "go test")
+	assert.NoError(t, err)
+
+	before, err := NewRefreshKeyring(config.JWTConf{
+		RefreshTokenSecret:  "secret",
+		RefreshTokenSecrets: []config.JWTSecretConf{{ID: "s1", Secret: "secret-1"}},
+	})
+	assert.NoError(t, err)
+	oldToken, err := generateToken(Payload{}, time.Minute, "t1", "sid", before, "go test")
+	assert.NoError(t, err)
+
+	// the new secret is put in front, the previous ones keep verifying
+	after, err := NewRefreshKeyring(config.JWTConf{
+		RefreshTokenSecret: "secret",
+		RefreshTokenSecrets: []config.JWTSecretConf{
+			{ID: "s2", Secret: "secret-2"},
+			{ID: "s1", Secret: "secret-1"},
+		},
+	})
+	assert.NoError(t, err)
+	newToken, err := generateToken(Payload{}, time.Minute, "t2", "sid", after, "go test")
+	assert.NoError(t, err)
+	token, _, err := jwt.NewParser().ParseUnverified(newToken, &Claims{})
+	assert.NoError(t, err)
+	assert.Equal(t, "s2", token.Header["kid"])
+
+	for _, tokenStr := range []string{legacyToken, oldToken, newToken} {
+		_, err := validateToken(tokenStr, after)
+		assert.NoError(t, err)
+	}
+	_, err = validateToken(newToken, before)
+	assert.ErrorIs(t, err, ErrJwtInvalid)
+	assert.Empty(t, after.JWKS().Keys)
+
+	t.Run("access secrets", func(t *testing.T) {
+		keyring, err := NewKeyring(config.JWTConf{
+			AccessTokenSecret:  "secret",
+			AccessTokenSecrets: []config.JWTSecretConf{{ID: "a1", Secret: "access-1"}},
+		})
+		assert.NoError(t, err)
+		tokenStr, err := generateToken(Payload{}, time.Minute, "t3", "sid", keyring, "go test")
+		assert.NoError(t, err)
+		token, _, err := jwt.NewParser().ParseUnverified(tokenStr, &Claims{})
+		assert.NoError(t, err)
+		assert.Equal(t, "a1", token.Header["kid"])
+		_, err = validateToken(legacyToken, keyring)
+		assert.NoError(t, err)
+	})
+}
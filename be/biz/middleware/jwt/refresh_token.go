@@ -4,6 +4,7 @@ import (
 	"context"
 	"doing_now/be/biz/config"
 	rediscli "doing_now/be/biz/db/redis"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -18,53 +19,236 @@ import (
 const TokenRemovalTTL = time.Minute / 2
 const refreshTokenCookieName = "refresh_token"
 
+const (
+	// a refresh holds the lock while it rotates, concurrent callers poll for its result meanwhile
+	refreshLockTTL      = 5 * time.Second
+	refreshPollInterval = 20 * time.Millisecond
+)
+
+// TokenPair is the result of a refresh, cached for the grace window of the consumed token.
+type TokenPair struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresAt        int64  `json:"expires_at"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresAt int64  `json:"refresh_expires_at"`
+}
+
+// RefreshTokenReuseError is returned for a refresh token presented again after it had been rotated
+// and its grace window had passed. The family and the session it belongs to have been revoked by
+// then. It matches ErrRefreshTokenInvalid and ErrRefreshTokenReused.
+type RefreshTokenReuseError struct {
+	Family    string
+	SessionID string
+}
+
+func (e *RefreshTokenReuseError) Error() string {
+	return fmt.Sprintf("refresh token of family %q is reused", e.Family)
+}
+
+func (e *RefreshTokenReuseError) Is(target error) bool {
+	return target == ErrRefreshTokenReused || target == ErrRefreshTokenInvalid
+}
+
+// GenerateRefreshToken issues the first refresh token of a login, which roots a new family.
 func GenerateRefreshToken(ctx context.Context, sessID string) (string, int64, error) {
+	return generateRefreshToken(ctx, sessID, uuid.New().String())
+}
+
+// RefreshTokens rotates a refresh token and issues a new access token for payload. Calls with the
+// same refresh token within its grace window get the pair of the first call, so parallel tabs
+// end up on a single chain instead of each minting their own.
+func RefreshTokens(ctx context.Context, refreshToken, sessID string, payload Payload) (*TokenPair, error) {
+	keyring, err := RefreshKeyring()
+	if err != nil {
+		hlog.CtxErrorf(ctx, "load refresh keyring err: %v", err)
+		return nil, err
+	}
+	claims, err := validateToken(refreshToken, keyring)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "validate refresh token err: %v", err)
+		return nil, ErrRefreshTokenInvalid
+	}
+	// checked before the rotation, which would otherwise spend the token for nothing
+	if payload.UserID == "" {
+		hlog.CtxNoticef(ctx, "refresh token of a session without user")
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	unlock, pair, err := lockRefresh(ctx, claims.ID)
+	if err != nil || pair != nil {
+		if pair != nil && !claims.CheckSum(sessID) {
+			return nil, ErrRefreshTokenInvalid
+		}
+		return pair, err
+	}
+	defer unlock()
+
+	newRefreshToken, refreshExpAt, err := RotateRefreshToken(ctx, refreshToken, sessID)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, expAt, err := GenerateToken(ctx, payload, sessID)
+	if err != nil {
+		return nil, err
+	}
+
+	pair = &TokenPair{
+		AccessToken:      accessToken,
+		ExpiresAt:        expAt,
+		RefreshToken:     newRefreshToken,
+		RefreshExpiresAt: refreshExpAt,
+	}
+	if data, err := json.Marshal(pair); err != nil {
+		hlog.CtxErrorf(ctx, "marshal refresh result err: %v", err)
+	} else if err := rediscli.GetRedisClient().Set(ctx, refreshResultKey(claims.ID), data, TokenRemovalTTL).Err(); err != nil {
+		// the caller still gets its pair, only concurrent callers would mint another one
+		hlog.CtxErrorf(ctx, "cache refresh result err: %v", err)
+	}
+	return pair, nil
+}
+
+// lockRefresh takes the refresh lock of a token, or waits for the pair of the caller holding it.
+// It returns either the unlock func or the cached pair.
+func lockRefresh(ctx context.Context, tokenID string) (func(), *TokenPair, error) {
+	rdb := rediscli.GetRedisClient()
+	deadline := time.Now().Add(refreshLockTTL)
+	for {
+		if pair, err := cachedRefresh(ctx, tokenID); err != nil || pair != nil {
+			return nil, pair, err
+		}
+
+		ok, err := rdb.SetNX(ctx, refreshLockKey(tokenID), true, refreshLockTTL).Result()
+		if err != nil {
+			hlog.CtxErrorf(ctx, "lock refresh token err: %v", err)
+			return nil, nil, err
+		}
+		if ok {
+			// the previous holder may have cached its pair right before releasing the lock
+			if pair, err := cachedRefresh(ctx, tokenID); err != nil || pair != nil {
+				rdb.Del(ctx, refreshLockKey(tokenID))
+				return nil, pair, err
+			}
+			return func() { rdb.Del(context.WithoutCancel(ctx), refreshLockKey(tokenID)) }, nil, nil
+		}
+
+		if time.Now().After(deadline) {
+			hlog.CtxNoticef(ctx, "wait for concurrent refresh timed out")
+			return nil, nil, ErrRefreshTokenInvalid
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(refreshPollInterval):
+		}
+	}
+}
+
+func cachedRefresh(ctx context.Context, tokenID string) (*TokenPair, error) {
+	data, err := rediscli.GetRedisClient().Get(ctx, refreshResultKey(tokenID)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		hlog.CtxErrorf(ctx, "get refresh result err: %v", err)
+		return nil, err
+	}
+	var pair TokenPair
+	if err := json.Unmarshal(data, &pair); err != nil {
+		hlog.CtxErrorf(ctx, "unmarshal refresh result err: %v", err)
+		return nil, err
+	}
+	return &pair, nil
+}
+
+// RotateRefreshToken consumes a refresh token and issues its successor in the same family.
+func RotateRefreshToken(ctx context.Context, refreshToken, sessID string) (string, int64, error) {
+	claims, err := removeRefreshToken(ctx, refreshToken, sessID)
+	if err != nil {
+		return "", 0, err
+	}
+
+	family := claims.Family
+	if family == "" {
+		// issued before families were tracked
+		family = uuid.New().String()
+	}
+	return generateRefreshToken(ctx, sessID, family)
+}
+
+// RemoveRefreshToken consumes a refresh token without a successor, e.g. on logout.
+func RemoveRefreshToken(ctx context.Context, refreshToken, sessID string) error {
+	_, err := removeRefreshToken(ctx, refreshToken, sessID)
+	return err
+}
+
+func generateRefreshToken(ctx context.Context, sessID, family string) (string, int64, error) {
 	tokenID := uuid.New().String()
 
 	jwtConf := config.GetJWTConfig()
 	exp := refreshExpiration(jwtConf)
 	expAt := time.Now().Add(exp).Unix()
 
-	refreshToken, err := generateToken(Payload{}, exp, tokenID, sessID, jwtConf.RefreshTokenSecret, jwtConf.Issuer)
+	keyring, err := RefreshKeyring()
+	if err != nil {
+		hlog.CtxErrorf(ctx, "load refresh keyring err: %v", err)
+		return "", 0, err
+	}
+
+	claims := newClaims(Payload{}, exp, tokenID, sessID, jwtConf.Issuer)
+	claims.Family = family
+	refreshToken, err := keyring.Sign(claims)
 	if err != nil {
 		hlog.CtxErrorf(ctx, "generate refresh token err: %v", err)
 		return "", 0, err
 	}
 
-	if err := rediscli.GetRedisClient().Set(ctx, refreshTokenKey(tokenID), true, exp).Err(); err != nil {
+	if err := cacheSessionToken(ctx, refreshTokenKey(tokenID), sessID, exp); err != nil {
 		hlog.CtxErrorf(ctx, "set refresh token to redis err: %v", err)
 		return "", 0, err
 	}
+	// the family outlives its rotated tokens, so a late replay can still be traced to the session
+	if err := rediscli.GetRedisClient().Set(ctx, refreshFamilyKey(family), sessID, exp).Err(); err != nil {
+		hlog.CtxErrorf(ctx, "set refresh token family to redis err: %v", err)
+		return "", 0, err
+	}
 
 	return refreshToken, expAt, nil
 }
 
-func RemoveRefreshToken(ctx context.Context, refreshToken, sessID string) error {
+func removeRefreshToken(ctx context.Context, refreshToken, sessID string) (*Claims, error) {
 	// 先校验token的有效性
-	jwtConf := config.GetJWTConfig()
-	claims, err := validateToken(refreshToken, jwtConf.RefreshTokenSecret)
+	keyring, err := RefreshKeyring()
 	if err != nil {
-		hlog.CtxErrorf(ctx, "validate refresh token err: %v", err)
-		return ErrRefreshTokenInvalid
+		hlog.CtxErrorf(ctx, "load refresh keyring err: %v", err)
+		return nil, err
 	}
-	if !claims.CheckSum(sessID) {
-		return ErrRefreshTokenInvalid
+	claims, err := validateToken(refreshToken, keyring)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "validate refresh token err: %v", err)
+		return nil, ErrRefreshTokenInvalid
 	}
 
-	exist, err := rediscli.GetRedisClient().
-		Get(ctx, refreshTokenKey(claims.ID)).Bool()
+	rdb := rediscli.GetRedisClient()
+	exist, err := rdb.Get(ctx, refreshTokenKey(claims.ID)).Bool()
 	if err != nil && !errors.Is(err, redis.Nil) {
 		hlog.CtxErrorf(ctx, "get refresh token from redis err: %v", err)
-		return err
+		return nil, err
 	}
 	if !exist {
-		return ErrRefreshTokenInvalid
+		// checked before the session, a stolen token is usually replayed from another one
+		if err := revokeIfRotated(ctx, claims, sessID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenInvalid
+	}
+	if !claims.CheckSum(sessID) {
+		return nil, ErrRefreshTokenInvalid
 	}
 
 	// 校验通过后，删除redis中的token
 	timeLeft := time.Until(claims.ExpiresAt.Time)
 	if timeLeft < 0 {
-		return rediscli.GetRedisClient().Del(ctx, refreshTokenKey(claims.ID)).Err()
+		return claims, rdb.Del(ctx, refreshTokenKey(claims.ID)).Err()
 	}
 
 	newTTL := TokenRemovalTTL
@@ -72,7 +256,55 @@ func RemoveRefreshToken(ctx context.Context, refreshToken, sessID string) error
 		newTTL = timeLeft
 	}
 
-	return rediscli.GetRedisClient().Expire(ctx, refreshTokenKey(claims.ID), newTTL).Err()
+	// the token stays valid for the grace window, afterwards the marker tells a replay apart
+	// from a token which has never existed
+	pipe := rdb.TxPipeline()
+	pipe.Expire(ctx, refreshTokenKey(claims.ID), newTTL)
+	pipe.Set(ctx, refreshRotatedKey(claims.ID), claims.Family, timeLeft)
+	if _, err := pipe.Exec(ctx); err != nil {
+		hlog.CtxErrorf(ctx, "rotate refresh token err: %v", err)
+		return nil, err
+	}
+	return claims, nil
+}
+
+// revokeIfRotated revokes the family of a refresh token which had already been rotated and
+// returns a RefreshTokenReuseError, it returns nil for a token which was never rotated.
+func revokeIfRotated(ctx context.Context, claims *Claims, sessID string) error {
+	rdb := rediscli.GetRedisClient()
+	if n, err := rdb.Exists(ctx, refreshRotatedKey(claims.ID)).Result(); err != nil {
+		hlog.CtxErrorf(ctx, "get rotated refresh token from redis err: %v", err)
+		return err
+	} else if n == 0 {
+		return nil
+	}
+
+	var owner string
+	if claims.Family != "" {
+		var err error
+		owner, err = rdb.Get(ctx, refreshFamilyKey(claims.Family)).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			hlog.CtxErrorf(ctx, "get refresh token family from redis err: %v", err)
+			return err
+		}
+	}
+	if owner == "" && claims.CheckSum(sessID) {
+		owner = sessID
+	}
+
+	if owner != "" {
+		if err := RevokeSession(ctx, owner); err != nil {
+			hlog.CtxErrorf(ctx, "revoke session of reused refresh token err: %v", err)
+			return err
+		}
+	}
+	if claims.Family != "" {
+		if err := rdb.Del(ctx, refreshFamilyKey(claims.Family)).Err(); err != nil {
+			hlog.CtxErrorf(ctx, "delete refresh token family err: %v", err)
+			return err
+		}
+	}
+	return &RefreshTokenReuseError{Family: claims.Family, SessionID: owner}
 }
 
 func GetRefreshTokenFromCookie(c *app.RequestContext) string {
@@ -113,6 +345,22 @@ func refreshTokenKey(t string) string {
 	return fmt.Sprintf("refresh_token:%s", t)
 }
 
+func refreshRotatedKey(t string) string {
+	return fmt.Sprintf("refresh_token_rotated:%s", t)
+}
+
+func refreshResultKey(t string) string {
+	return fmt.Sprintf("refresh_token_result:%s", t)
+}
+
+func refreshLockKey(t string) string {
+	return fmt.Sprintf("refresh_token_lock:%s", t)
+}
+
+func refreshFamilyKey(family string) string {
+	return fmt.Sprintf("refresh_token_family:%s", family)
+}
+
 func refreshExpiration(conf config.JWTConf) time.Duration {
 	if conf.RefreshExpiration > 0 {
 		return time.Duration(conf.RefreshExpiration) * time.Second
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -142,3 +143,137 @@ func TestRemoveRefreshToken_ExpiredButValidSignature(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, int64(1), exists)
 }
+
+func TestRevokeSession(t *testing.T) {
+	ctx := context.Background()
+	mr := initTestConfig()
+	defer mr.Close()
+	rediscli.Init()
+
+	refreshToken, _, err := GenerateRefreshToken(ctx, "sess-1")
+	assert.NoError(t, err)
+	otherToken, _, err := GenerateRefreshToken(ctx, "sess-2")
+	assert.NoError(t, err)
+	_, _, err = GenerateToken(ctx, Payload{UserID: "u1"}, "sess-1")
+	assert.NoError(t, err)
+
+	assert.NoError(t, RevokeSession(ctx, "sess-1"))
+	assert.ErrorIs(t, RemoveRefreshToken(ctx, refreshToken, "sess-1"), ErrRefreshTokenInvalid)
+	assert.NoError(t, RemoveRefreshToken(ctx, otherToken, "sess-2"))
+
+	keys, err := rediscli.GetRedisClient().Keys(ctx, "jwt_id_exist:*").Result()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestRotateRefreshToken_ReuseDetection(t *testing.T) {
+	ctx := context.Background()
+	mr := initTestConfig()
+	defer mr.Close()
+	rediscli.Init()
+
+	familyOf := func(tokenStr string) string {
+		token, _, err := jwt.NewParser().ParseUnverified(tokenStr, &Claims{})
+		assert.NoError(t, err)
+		return token.Claims.(*Claims).Family
+	}
+
+	first, _, err := GenerateRefreshToken(ctx, "sess-1")
+	assert.NoError(t, err)
+	second, _, err := RotateRefreshToken(ctx, first, "sess-1")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, familyOf(first))
+	assert.Equal(t, familyOf(first), familyOf(second))
+
+	other, _, err := GenerateRefreshToken(ctx, "sess-2")
+	assert.NoError(t, err)
+	assert.NotEqual(t, familyOf(first), familyOf(other))
+
+	t.Run("rotated token is accepted within the grace window", func(t *testing.T) {
+		_, _, err := RotateRefreshToken(ctx, first, "sess-1")
+		assert.NoError(t, err)
+	})
+
+	t.Run("never issued token is only invalid", func(t *testing.T) {
+		_, _, err := RotateRefreshToken(ctx, "bad", "sess-1")
+		assert.ErrorIs(t, err, ErrRefreshTokenInvalid)
+		assert.NotErrorIs(t, err, ErrRefreshTokenReused)
+	})
+
+	t.Run("replay after the grace window revokes the family", func(t *testing.T) {
+		mr.FastForward(TokenRemovalTTL + time.Second)
+
+		// replayed from another session, the family still leads to the victim's session
+		_, _, err := RotateRefreshToken(ctx, first, "sess-attacker")
+		assert.ErrorIs(t, err, ErrRefreshTokenReused)
+		assert.ErrorIs(t, err, ErrRefreshTokenInvalid)
+		var reuseErr *RefreshTokenReuseError
+		assert.ErrorAs(t, err, &reuseErr)
+		assert.Equal(t, "sess-1", reuseErr.SessionID)
+		assert.Equal(t, familyOf(first), reuseErr.Family)
+
+		_, _, err = RotateRefreshToken(ctx, second, "sess-1")
+		assert.ErrorIs(t, err, ErrRefreshTokenInvalid)
+
+		// other families are untouched
+		_, _, err = RotateRefreshToken(ctx, other, "sess-2")
+		assert.NoError(t, err)
+	})
+}
+
+func TestRefreshTokens_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	mr := initTestConfig()
+	defer mr.Close()
+	rediscli.Init()
+
+	refreshToken, _, err := GenerateRefreshToken(ctx, "sess-1")
+	assert.NoError(t, err)
+	payload := Payload{UserID: "u1", Account: "a1"}
+
+	const callers = 8
+	pairs := make([]*TokenPair, callers)
+	errList := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pairs[i], errList[i] = RefreshTokens(ctx, refreshToken, "sess-1", payload)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < callers; i++ {
+		assert.NoError(t, errList[i])
+		assert.Equal(t, pairs[0], pairs[i])
+	}
+	keys, err := rediscli.GetRedisClient().Keys(ctx, "jwt_id_exist:*").Result()
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	t.Run("cached pair is bound to the session", func(t *testing.T) {
+		_, err := RefreshTokens(ctx, refreshToken, "sess-2", payload)
+		assert.ErrorIs(t, err, ErrRefreshTokenInvalid)
+	})
+
+	t.Run("session without user gets nothing", func(t *testing.T) {
+		_, err := RefreshTokens(ctx, pairs[0].RefreshToken, "sess-1", Payload{})
+		assert.ErrorIs(t, err, ErrRefreshTokenInvalid)
+
+		// the token is not spent by the refused call, past the grace window it still refreshes
+		token, _, err := GenerateRefreshToken(ctx, "sess-3")
+		assert.NoError(t, err)
+		_, err = RefreshTokens(ctx, token, "sess-3", Payload{})
+		assert.ErrorIs(t, err, ErrRefreshTokenInvalid)
+		mr.FastForward(TokenRemovalTTL + time.Second)
+		_, err = RefreshTokens(ctx, token, "sess-3", payload)
+		assert.NoError(t, err)
+	})
+
+	t.Run("after the grace window the old token is a reuse", func(t *testing.T) {
+		mr.FastForward(TokenRemovalTTL + time.Second)
+		_, err := RefreshTokens(ctx, refreshToken, "sess-1", payload)
+		assert.ErrorIs(t, err, ErrRefreshTokenReused)
+	})
+}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"doing_now/be/biz/middleware/accesslog"
 	"doing_now/be/biz/middleware/cors"
+	"doing_now/be/biz/middleware/ipfilter"
 	"doing_now/be/biz/middleware/ratelimit"
 	"doing_now/be/biz/middleware/recovery"
 	"doing_now/be/biz/middleware/session"
@@ -16,6 +17,7 @@ func Suite() []app.HandlerFunc {
 		recovery.New(),  // panic handler
 		trace.New(),     // 链路ID
 		accesslog.New(), // 接口日志
+		ipfilter.New(),  // IP黑白名单
 		cors.New(),      // 跨域请求
 		session.New(),   // 会话
 		ratelimit.New(), // 限流
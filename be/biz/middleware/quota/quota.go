@@ -0,0 +1,48 @@
+package quota
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"doing_now/be/biz/middleware/jwt"
+	"doing_now/be/biz/model/errs"
+	quotasvc "doing_now/be/biz/service/quota"
+	"doing_now/be/biz/util/resp"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// New meters the feature against the plan of the authenticated user, it goes after
+// jwt.ValidateMW. Requests past the daily or monthly quota get 429 with errs.QuotaExceeded and
+// Retry-After; when usage cannot be counted the request passes.
+func New(feature string) app.HandlerFunc {
+	quotas := quotasvc.Default()
+
+	return func(ctx context.Context, c *app.RequestContext) {
+		userID := jwt.GetPayload(ctx).UserID
+		if userID == "" {
+			c.Next(ctx)
+			return
+		}
+
+		usage, bizErr := quotas.Consume(ctx, userID, feature)
+		if bizErr == nil {
+			c.Next(ctx)
+			return
+		}
+		if !errs.ErrorEqual(bizErr, errs.QuotaExceeded) {
+			hlog.CtxErrorf(ctx, "quota of %s for user %s not counted: %v", feature, userID, bizErr)
+			c.Next(ctx)
+			return
+		}
+
+		hlog.CtxInfof(ctx, "quota of %s used up by user %s", feature, userID)
+		retryAfter := usage.RetryAfter(time.Now())
+		c.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10))
+		resp.AbortWithErr(c, bizErr, http.StatusTooManyRequests)
+	}
+}
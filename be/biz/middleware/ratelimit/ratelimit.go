@@ -6,74 +6,172 @@ import (
 	"doing_now/be/biz/model/dto"
 	"doing_now/be/biz/model/errs"
 	"doing_now/be/biz/util/interceptor"
-	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/cloudwego/hertz/pkg/app"
 	"github.com/cloudwego/hertz/pkg/common/hlog"
 	"github.com/cloudwego/hertz/pkg/protocol/consts"
-	"github.com/hertz-contrib/sessions"
 )
 
-type rule struct {
-	interceptor *interceptor.Interceptor
-	hasSession  bool
+const (
+	HeaderLimit     = "RateLimit-Limit"
+	HeaderRemaining = "RateLimit-Remaining"
+	HeaderReset     = "RateLimit-Reset"
+	HeaderRetry     = "Retry-After"
+)
+
+type limiter struct {
+	rules     []*rule
+	fallbacks []*rule
 }
 
-// NewWithConfig creates a rate limit middleware that uses configuration from config.GetRateLimitConf()
-func New() app.HandlerFunc {
-	confList := config.GetRateLimitConf()
-	rules := make(map[string]*rule)
-
-	for _, conf := range confList {
-		if conf.Path != "" && conf.WindowSeconds > 0 && conf.Limit > 0 {
-			rules[conf.Path] = &rule{
-				interceptor: interceptor.NewInterceptor(conf.WindowSeconds, conf.Limit),
-				hasSession:  conf.HasSession,
-			}
+func newLimiter() *limiter {
+	l := &limiter{}
+	for idx, conf := range config.GetRateLimitConf() {
+		r, err := newRule(idx, conf)
+		if err != nil {
+			hlog.Errorf("Rate limit rule %d (%s) is skipped: %v", idx, conf.Path, err)
+			continue
+		}
+		if r.fallback {
+			l.fallbacks = append(l.fallbacks, r)
+		} else {
+			l.rules = append(l.rules, r)
 		}
 	}
 
-	// Default rule: window=1, limit=2, has_session=false
-	defaultRule := &rule{
-		interceptor: interceptor.NewInterceptor(1, 2),
-		hasSession:  false,
+	// Default fallback: window=1, limit=2 per route and IP
+	if len(l.fallbacks) == 0 {
+		l.fallbacks = append(l.fallbacks, &rule{
+			name:        "default",
+			prefix:      true,
+			window:      time.Second,
+			key:         []string{keyRoute, keyIP},
+			interceptor: interceptor.NewInterceptor(1, 2),
+		})
 	}
+	return l
+}
+
+var (
+	defaultLimiter     *limiter
+	defaultLimiterOnce sync.Once
+)
+
+func getLimiter() *limiter {
+	defaultLimiterOnce.Do(func() {
+		defaultLimiter = newLimiter()
+	})
+	return defaultLimiter
+}
+
+// New creates a rate limit middleware from config.GetRateLimitConf(). Every rule matching
+// the route and method of a request is checked, so per-user and global limits stack; the
+// fallback rules only apply to requests no other rule matches. The RateLimit-* headers
+// describe the rule with the least quota left.
+func New() app.HandlerFunc {
+	l := getLimiter()
 
 	return func(ctx context.Context, c *app.RequestContext) {
-		path := string(c.Request.URI().Path())
+		// the route template, the raw path when no route matches
+		route := c.FullPath()
+		if route == "" {
+			route = string(c.Request.URI().Path())
+		}
+		method := string(c.Method())
 
-		// If no rate limit configured for this path, use default rule
-		r, ok := rules[path]
-		if !ok {
-			r = defaultRule
+		var tightest *interceptor.Quota
+		for _, r := range l.match(route, method) {
+			key := r.bucket(c, route, method)
+			q, err := r.interceptor.Take(ctx, key)
+			if err != nil {
+				// Only with rate_limit_failover.fail_open, otherwise a failing Redis is replaced by the local limiter
+				hlog.CtxErrorf(ctx, "Rate limit error for key %s: %v", key, err)
+				continue
+			}
+
+			if !q.Allowed {
+				setHeaders(c, q)
+				c.Header(HeaderRetry, strconv.FormatInt(max(1, Seconds(q.RetryAfter)), 10))
+				c.AbortWithStatusJSON(consts.StatusTooManyRequests, dto.CommonResp{
+					Success: false,
+					Code:    int(errs.TooManyRequest.Code()),
+					Message: errs.TooManyRequest.Msg(),
+				})
+				return
+			}
+			if tightest == nil || q.Remaining < tightest.Remaining {
+				tightest = &q
+			}
+		}
+		if tightest != nil {
+			setHeaders(c, *tightest)
 		}
 
-		// Use path + IP as the rate limit key to avoid shared limits across different paths
-		var key string
-		if r.hasSession {
-			key = sessions.Default(c).ID()
-		} else {
-			key = c.ClientIP()
+		c.Next(ctx)
+	}
+}
+
+// match returns the rules of the request, the fallbacks when no other rule matches
+func (l *limiter) match(route, method string) []*rule {
+	matched := make([]*rule, 0, 2)
+	for _, r := range l.rules {
+		if r.match(route, method) {
+			matched = append(matched, r)
 		}
-		key = fmt.Sprintf("%s:%s", path, key)
+	}
+	if len(matched) == 0 {
+		return l.fallbacks
+	}
+	return matched
+}
 
-		allowed, err := r.interceptor.Allow(ctx, key)
+// Quota is the quota of a rule left to the caller.
+type Quota struct {
+	Name    string
+	Path    string
+	Methods []string
+	Window  time.Duration
+	interceptor.Quota
+}
+
+// Quotas returns the quotas the caller has left under every rule whose bucket does not
+// depend on the route, as the bucket of the request c, without counting it.
+func Quotas(ctx context.Context, c *app.RequestContext) ([]Quota, error) {
+	l := getLimiter()
+	quotas := make([]Quota, 0, len(l.rules))
+	for _, r := range slices.Concat(l.rules, l.fallbacks) {
+		key, ok := r.callerBucket(c)
+		if !ok {
+			continue
+		}
+		q, err := r.interceptor.Peek(ctx, key)
 		if err != nil {
-			// Fail open strategy: Log error and allow request on Redis failure
 			hlog.CtxErrorf(ctx, "Rate limit error for key %s: %v", key, err)
-			c.Next(ctx)
-			return
+			return nil, err
 		}
+		quotas = append(quotas, Quota{
+			Name:    r.name,
+			Path:    r.pattern(),
+			Methods: r.methods,
+			Window:  r.window,
+			Quota:   q,
+		})
+	}
+	return quotas, nil
+}
 
-		if !allowed {
-			c.AbortWithStatusJSON(consts.StatusTooManyRequests, dto.CommonResp{
-				Success: false,
-				Code:    int(errs.TooManyRequest.Code()),
-				Message: errs.TooManyRequest.Msg(),
-			})
-			return
-		}
+func setHeaders(c *app.RequestContext, q interceptor.Quota) {
+	c.Header(HeaderLimit, strconv.FormatInt(q.Limit, 10))
+	c.Header(HeaderRemaining, strconv.FormatInt(q.Remaining, 10))
+	c.Header(HeaderReset, strconv.FormatInt(Seconds(q.Reset), 10))
+}
 
-		c.Next(ctx)
-	}
+// Seconds rounds d up to whole seconds, as the headers give them
+func Seconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
 }
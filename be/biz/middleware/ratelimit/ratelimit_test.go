@@ -4,7 +4,9 @@ import (
 	"context"
 	"doing_now/be/biz/config"
 	db_redis "doing_now/be/biz/db/redis"
+	"doing_now/be/biz/middleware/jwt"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/alicebob/miniredis/v2"
@@ -25,6 +27,12 @@ func (m *MockSession) ID() string {
 	return m.IDVal
 }
 
+// resetLimiter makes the next New or Quotas read the rules of the config again
+func resetLimiter() {
+	defaultLimiter = nil
+	defaultLimiterOnce = sync.Once{}
+}
+
 func TestNewWithConfig(t *testing.T) {
 	// 1. Setup Config
 	configFile := "test_config.yaml"
@@ -49,6 +57,7 @@ rate_limit:
 
 	// Initialize config
 	config.Init(configFile)
+	resetLimiter()
 
 	// 2. Setup Redis Mock
 	mr, err := miniredis.Run()
@@ -167,3 +176,154 @@ rate_limit:
 		})
 	})
 }
+
+func TestRules(t *testing.T) {
+	configFile := "test_rules_config.yaml"
+	configContent := `
+rate_limit:
+  - path: "/users/:user_id"
+    methods: ["post"]
+    window_seconds: 1
+    limit: 1
+  - name: "per_user"
+    path: "/api/*"
+    key: ["user"]
+    window_seconds: 1
+    limit: 3
+  - name: "global"
+    path: "/api/*"
+    key: []
+    window_seconds: 1
+    limit: 5
+  - path: "/keyed"
+    key: ["header:X-Api-Key"]
+    window_seconds: 1
+    limit: 1
+  - path: "/broken"
+    key: ["cookie"]
+    window_seconds: 1
+    limit: 1
+  - path: "*"
+    fallback: true
+    window_seconds: 1
+    limit: 3
+`
+	if err := os.WriteFile(configFile, []byte(configContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(configFile)
+	config.Init(configFile)
+	resetLimiter()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	mockey.PatchConvey("TestRules", t, func() {
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+		user := ""
+		mockey.Mock(jwt.PeekPayload).To(func(c *app.RequestContext) (jwt.Payload, bool) {
+			return jwt.Payload{UserID: user}, user != ""
+		}).Build()
+
+		mw := New()
+		ctx := context.Background()
+
+		do := func(method, route, path string, header ...string) *app.RequestContext {
+			c := app.NewContext(0)
+			c.Request.SetMethod(method)
+			c.Request.SetRequestURI(path)
+			c.SetFullPath(route)
+			for i := 0; i+1 < len(header); i += 2 {
+				c.Request.Header.Set(header[i], header[i+1])
+			}
+			mw(ctx, c)
+			return c
+		}
+
+		t.Run("Route Template and Method", func(t *testing.T) {
+			mr.FlushAll()
+			assert.False(t, do("POST", "/users/:user_id", "/users/1").IsAborted())
+			// every user id shares the bucket of the route
+			assert.True(t, do("POST", "/users/:user_id", "/users/2").IsAborted())
+
+			// GET is not covered by the rule and falls back
+			for range 3 {
+				assert.False(t, do("GET", "/users/:user_id", "/users/1").IsAborted())
+			}
+			assert.True(t, do("GET", "/users/:user_id", "/users/1").IsAborted())
+		})
+
+		t.Run("Stacked User and Global Limits", func(t *testing.T) {
+			mr.FlushAll()
+			user = "u1"
+
+			for range 3 {
+				assert.False(t, do("GET", "/api/a", "/api/a").IsAborted())
+			}
+			// per user limit
+			assert.True(t, do("GET", "/api/b", "/api/b").IsAborted())
+
+			// the denied request stopped at the per user rule, two global requests are left
+			user = "u2"
+			assert.False(t, do("GET", "/api/a", "/api/a").IsAborted())
+			assert.False(t, do("GET", "/api/a", "/api/a").IsAborted())
+			c := do("GET", "/api/a", "/api/a")
+			assert.True(t, c.IsAborted())
+			assert.Equal(t, consts.StatusTooManyRequests, c.Response.StatusCode())
+		})
+
+		t.Run("Header Key", func(t *testing.T) {
+			mr.FlushAll()
+			assert.False(t, do("GET", "/keyed", "/keyed", "X-Api-Key", "a").IsAborted())
+			assert.True(t, do("GET", "/keyed", "/keyed", "X-Api-Key", "a").IsAborted())
+			assert.False(t, do("GET", "/keyed", "/keyed", "X-Api-Key", "b").IsAborted())
+		})
+
+		t.Run("Headers", func(t *testing.T) {
+			mr.FlushAll()
+			user = "u1"
+
+			c := do("GET", "/api/a", "/api/a")
+			// the per user rule has the least left
+			assert.Equal(t, "3", string(c.Response.Header.Peek(HeaderLimit)))
+			assert.Equal(t, "2", string(c.Response.Header.Peek(HeaderRemaining)))
+			assert.Equal(t, "1", string(c.Response.Header.Peek(HeaderReset)))
+			assert.Empty(t, c.Response.Header.Peek(HeaderRetry))
+
+			do("GET", "/api/a", "/api/a")
+			do("GET", "/api/a", "/api/a")
+			c = do("GET", "/api/a", "/api/a")
+			assert.True(t, c.IsAborted())
+			assert.Equal(t, "0", string(c.Response.Header.Peek(HeaderRemaining)))
+			assert.Equal(t, "1", string(c.Response.Header.Peek(HeaderRetry)))
+		})
+
+		t.Run("Quotas", func(t *testing.T) {
+			mr.FlushAll()
+			user = "u1"
+			do("GET", "/api/a", "/api/a")
+
+			quotas, err := Quotas(ctx, app.NewContext(0))
+			assert.NoError(t, err)
+			byName := make(map[string]Quota)
+			for _, q := range quotas {
+				byName[q.Name] = q
+			}
+			assert.Equal(t, int64(2), byName["per_user"].Remaining)
+			assert.Equal(t, int64(4), byName["global"].Remaining)
+			assert.Equal(t, "/api/*", byName["per_user"].Path)
+			assert.Equal(t, []string{"POST"}, byName["/users/:user_id#0"].Methods)
+			assert.Equal(t, "*", byName["*#5"].Path)
+			assert.NotContains(t, byName, "/broken#4")
+		})
+
+		t.Run("Invalid Rule Is Skipped", func(t *testing.T) {
+			mr.FlushAll()
+			// the configured fallback applies
+			for range 3 {
+				assert.False(t, do("GET", "/broken", "/broken").IsAborted())
+			}
+			assert.True(t, do("GET", "/broken", "/broken").IsAborted())
+		})
+	})
+}
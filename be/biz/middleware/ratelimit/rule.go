@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/middleware/jwt"
+	"doing_now/be/biz/util/interceptor"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/hertz-contrib/sessions"
+)
+
+const (
+	keyIP      = "ip"
+	keySession = "session"
+	keyUser    = "user"
+	keyRoute   = "route"
+	keyMethod  = "method"
+	keyHeader  = "header:"
+)
+
+type rule struct {
+	name        string
+	path        string
+	prefix      bool
+	methods     []string
+	key         []string
+	fallback    bool
+	window      time.Duration
+	interceptor *interceptor.Interceptor
+}
+
+func newRule(idx int, conf config.RateLimitConf) (*rule, error) {
+	if conf.Path == "" || conf.WindowSeconds <= 0 || conf.Limit <= 0 {
+		return nil, fmt.Errorf("path, window_seconds and limit are required")
+	}
+
+	key := conf.Key
+	if len(key) == 0 {
+		key = []string{keyIP}
+		if conf.HasSession {
+			key = []string{keySession}
+		}
+	}
+	for _, k := range key {
+		switch {
+		case k == keyIP, k == keySession, k == keyUser, k == keyRoute, k == keyMethod:
+		case strings.HasPrefix(k, keyHeader) && len(k) > len(keyHeader):
+		default:
+			return nil, fmt.Errorf("unknown key %q", k)
+		}
+	}
+
+	i, err := interceptor.New(conf.Algorithm, conf.WindowSeconds, conf.Limit, conf.Burst)
+	if err != nil {
+		return nil, err
+	}
+
+	name := conf.Name
+	if name == "" {
+		name = fmt.Sprintf("%s#%d", conf.Path, idx)
+	}
+	methods := make([]string, 0, len(conf.Methods))
+	for _, m := range conf.Methods {
+		methods = append(methods, strings.ToUpper(m))
+	}
+
+	path, prefix := strings.CutSuffix(conf.Path, "*")
+	return &rule{
+		name:        name,
+		path:        path,
+		prefix:      prefix,
+		methods:     methods,
+		key:         key,
+		fallback:    conf.Fallback,
+		window:      time.Duration(conf.WindowSeconds) * time.Second,
+		interceptor: i,
+	}, nil
+}
+
+// match reports whether the rule covers the route the request was matched to
+func (r *rule) match(route, method string) bool {
+	if len(r.methods) > 0 && !slices.Contains(r.methods, method) {
+		return false
+	}
+	if r.prefix {
+		return strings.HasPrefix(route, r.path)
+	}
+	return route == r.path
+}
+
+// pattern returns the path of the rule as configured
+func (r *rule) pattern() string {
+	if r.prefix {
+		return r.path + "*"
+	}
+	return r.path
+}
+
+// bucket builds the rate limit key of the request, rules never share buckets
+func (r *rule) bucket(c *app.RequestContext, route, method string) string {
+	parts := make([]string, 0, len(r.key)+1)
+	parts = append(parts, r.name)
+	for _, k := range r.key {
+		switch k {
+		case keyIP:
+			parts = append(parts, c.ClientIP())
+		case keySession:
+			parts = append(parts, sessions.Default(c).ID())
+		case keyUser:
+			if payload, ok := jwt.PeekPayload(c); ok && payload.UserID != "" {
+				parts = append(parts, "user="+payload.UserID)
+			} else {
+				parts = append(parts, "ip="+c.ClientIP())
+			}
+		case keyRoute:
+			parts = append(parts, route)
+		case keyMethod:
+			parts = append(parts, method)
+		default:
+			parts = append(parts, c.Request.Header.Get(strings.TrimPrefix(k, keyHeader)))
+		}
+	}
+	return strings.Join(parts, ":")
+}
+
+// callerBucket builds the key the caller of c has under the rule on any of its routes, ok is
+// false when the bucket differs between the routes or methods the rule covers.
+func (r *rule) callerBucket(c *app.RequestContext) (string, bool) {
+	var method string
+	for _, k := range r.key {
+		switch {
+		case k == keyRoute && r.prefix:
+			return "", false
+		case k == keyMethod:
+			if len(r.methods) != 1 {
+				return "", false
+			}
+			method = r.methods[0]
+		}
+	}
+	return r.bucket(c, r.path, method), true
+}
@@ -0,0 +1,71 @@
+package security
+
+import (
+	"context"
+	"net/http"
+
+	"doing_now/be/biz/model/dto"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/blocklist"
+	"doing_now/be/biz/service/challenge"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+const (
+	headerChallengeID       = "X-Challenge-Id"
+	headerChallengeSolution = "X-Challenge-Solution"
+)
+
+// NewChallenge makes IPs with recent login failures solve a challenge before the request is
+// processed. The challenge comes in a 403 response, the client retries the request with the
+// challenge ID and its solution in the headers. A wrong solution is answered with a new challenge.
+func NewChallenge() app.HandlerFunc {
+	challenges := challenge.NewDefault()
+	blocks := blocklist.NewDefault()
+
+	return func(ctx context.Context, c *app.RequestContext) {
+		ip := loginProtectionClientIP(c)
+		if !challenges.Required(ctx, ip) || blocks.Allowed(ctx, ip) {
+			return
+		}
+
+		id := string(c.GetHeader(headerChallengeID))
+		solution := string(c.GetHeader(headerChallengeSolution))
+		if id == "" && solution == "" {
+			abortWithChallenge(ctx, c, challenges, errs.ChallengeNeeded)
+			return
+		}
+
+		bizErr := challenges.Verify(ctx, id, solution)
+		if bizErr == nil {
+			return
+		}
+		if !errs.ErrorEqual(bizErr, errs.ChallengeFailed) {
+			// fail open like the other protections when the challenge cannot be checked
+			hlog.CtxErrorf(ctx, "Challenge verification error: %v", bizErr)
+			return
+		}
+		abortWithChallenge(ctx, c, challenges, errs.ChallengeFailed)
+	}
+}
+
+func abortWithChallenge(ctx context.Context, c *app.RequestContext, challenges *challenge.Service, code errs.Error) {
+	ch, bizErr := challenges.Issue(ctx)
+	if bizErr != nil {
+		// without a challenge to hand out the request goes on
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, dto.CommonResp{
+		Code:    int(code.Code()),
+		Message: code.Msg(),
+		Success: false,
+		Data: dto.ChallengeData{
+			ID:        ch.ID,
+			Provider:  ch.Provider,
+			Params:    ch.Params,
+			ExpiresAt: ch.ExpiresAt.Unix(),
+		},
+	})
+}
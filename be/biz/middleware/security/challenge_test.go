@@ -0,0 +1,92 @@
+package security
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"doing_now/be/biz/config"
+	db_redis "doing_now/be/biz/db/redis"
+	"doing_now/be/biz/model/dto"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/challenge"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bytedance/mockey"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChallenge(t *testing.T) {
+	mockey.PatchConvey("TestChallenge", t, func() {
+		ctx := context.Background()
+		mr := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+		mockey.Mock(config.GetChallengeConf).Return(config.ChallengeConf{After: 2}).Build()
+		mockey.Mock(challenge.NewProvider).Return(challenge.NewStub("42", time.Minute), nil).Build()
+
+		mw := NewChallenge()
+		challenges := challenge.NewDefault()
+		ip := "10.0.4.1"
+
+		makeReq := func(id, solution string) *app.RequestContext {
+			c := app.NewContext(0)
+			c.Request.SetRequestURI("/api/v1/user/login")
+			c.Request.Header.Set("X-Forwarded-For", ip)
+			if id != "" {
+				c.Request.Header.Set(headerChallengeID, id)
+			}
+			if solution != "" {
+				c.Request.Header.Set(headerChallengeSolution, solution)
+			}
+			return c
+		}
+		decode := func(c *app.RequestContext) (int, dto.ChallengeData) {
+			var resp struct {
+				Code int               `json:"code"`
+				Data dto.ChallengeData `json:"data"`
+			}
+			assert.NoError(t, json.Unmarshal(c.Response.Body(), &resp))
+			return resp.Code, resp.Data
+		}
+
+		t.Run("No Failures Pass", func(t *testing.T) {
+			mr.FlushAll()
+			_ = challenges.Failure(ctx, ip)
+			c := makeReq("", "")
+			mw(ctx, c)
+			assert.False(t, c.IsAborted())
+		})
+
+		t.Run("Challenge After Failures", func(t *testing.T) {
+			mr.FlushAll()
+			_ = challenges.Failure(ctx, ip)
+			_ = challenges.Failure(ctx, ip)
+
+			c := makeReq("", "")
+			mw(ctx, c)
+			assert.True(t, c.IsAborted())
+			assert.Equal(t, consts.StatusForbidden, c.Response.StatusCode())
+			code, data := decode(c)
+			assert.Equal(t, int(errs.ChallengeNeeded.Code()), code)
+			assert.Equal(t, challenge.ProviderStub, data.Provider)
+			assert.NotEmpty(t, data.ID)
+			assert.Equal(t, "42", data.Params["solution"])
+
+			// a wrong solution gets a new challenge
+			c = makeReq(data.ID, "41")
+			mw(ctx, c)
+			assert.True(t, c.IsAborted())
+			code, _ = decode(c)
+			assert.Equal(t, int(errs.ChallengeFailed.Code()), code)
+
+			c = makeReq(data.ID, "42")
+			mw(ctx, c)
+			assert.False(t, c.IsAborted())
+		})
+	})
+}
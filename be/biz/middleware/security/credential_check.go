@@ -28,7 +28,7 @@ func NewCredentialCheck() app.HandlerFunc {
 			return
 		}
 
-		currentCV, err := user.NewDefault().GetCredentialVersion(ctx, userID)
+		currentCV, err := user.NewDefault().GetActiveCredentialVersion(ctx, userID)
 		if err != nil {
 			// If user not found, they shouldn't be logged in.
 			if err.Code() == errs.UserNotExist.Code() {
@@ -39,8 +39,18 @@ func NewCredentialCheck() app.HandlerFunc {
 				})
 				return
 			}
+			// Disabled, locked or pending users lose their live sessions as well.
+			if err.Code() == errs.UserStatusInvalid.Code() {
+				hlog.CtxInfof(ctx, "User is not active. UserID=%s", userID)
+				c.AbortWithStatusJSON(http.StatusForbidden, dto.CommonResp{
+					Code:    int(errs.UserStatusInvalid.Code()),
+					Message: "User is not active",
+					Success: false,
+				})
+				return
+			}
 			// For DB errors, we fail open (allow request) to avoid outage, but log it.
-			hlog.CtxErrorf(ctx, "GetCredentialVersion err: %v", err)
+			hlog.CtxErrorf(ctx, "GetActiveCredentialVersion err: %v", err)
 			c.Next(ctx)
 			return
 		}
@@ -4,23 +4,30 @@ import (
 	"context"
 	"doing_now/be/biz/config"
 	"doing_now/be/biz/db/redis"
+	"doing_now/be/biz/model/domain"
 	"doing_now/be/biz/model/dto"
 	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/audit"
+	"doing_now/be/biz/service/blocklist"
+	"doing_now/be/biz/service/challenge"
+	"doing_now/be/biz/service/lockout"
 	"doing_now/be/biz/util/interceptor"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/cloudwego/hertz/pkg/app"
 	"github.com/cloudwego/hertz/pkg/common/hlog"
+	goredis "github.com/redis/go-redis/v9"
 )
 
 const (
-	keyLoginBlockHour   = "login_block_h:"
-	keyLoginBlockMinute = "login_block_m:"
-	keyLoginFailLvl     = "login_fail_level:"
-	keyLoginFail        = "login_fail:"
+	keyLoginBlock   = "login_block:"
+	keyLoginFailLvl = "login_fail_level:"
+	keyLoginFail    = "login_fail:"
 
 	keyLoginSuccess = "login_success:"
 
@@ -33,29 +40,46 @@ const (
 	defaultLevelDuration        = 30 * time.Minute
 	defaultSuccessWindowSeconds = 60
 	defaultSuccessLimit         = 10
+	scanCount                   = 100
 
-	unknownIP     = "unknown"
-	redisValueOne = "1"
+	unknownIP = "unknown"
 
-	msgLoginFailuresHoursFmt   = "Too many login failures, please try again after %v hours"
-	msgLoginFailuresMinutesFmt = "Too many login failures, please try again after %v minutes"
-	msgLoginLimitReached       = "Login limit reached, please try again later"
+	msgLoginFailures     = "Too many login failures, please try again later"
+	msgLoginLimitReached = "Login limit reached, please try again later"
+	msgAccountLocked     = "Too many login failures for this account, please try again later"
+	msgSprayBlocked      = "Too many login failures on different accounts, please try again later"
+	msgManualBlocked     = "Blocked by an administrator"
 
 	logParseRespErrFmt         = "Failed to parse response body in LoginProtection: %v"
 	logFailInterceptorErrFmt   = "FailInterceptor error: %v"
 	logSuccessRecorderErrFmt   = "LoginProtection success recorder error: %v"
-	logBlockedLevel1Fmt        = "Login protection: IP %s blocked for %v (Level 1)"
-	logBlockedLevel2Fmt        = "Login protection: IP %s blocked for %v (Level 2)"
+	logBlockedFmt              = "Login protection: IP %s blocked for %v (Tier %d)"
 	logSetLoginBlockKeysErrFmt = "Failed to set login block keys: %v"
 )
 
-type loginProtectionSettings struct {
+// loginBlockTier is a step of the escalation, failInterceptor counts the failures of an IP
+// which has reached the tier before it.
+type loginBlockTier struct {
 	failInterceptor *interceptor.Interceptor
+	block           time.Duration
+	decay           time.Duration
+}
+
+type loginProtectionSettings struct {
+	tiers           []loginBlockTier
 	successRecorder *interceptor.Interceptor
+	accounts        *lockout.Service
+	challenges      *challenge.Service
+	blocks          *blocklist.Service
+}
 
-	durationBlockMin  time.Duration
-	durationBlockHour time.Duration
-	durationFailLvl   time.Duration
+func init() {
+	blocklist.RegisterSource(blocklist.Source{
+		Target: blocklist.TargetIP,
+		Reason: blocklist.ReasonLoginFailures,
+		Prefix: rateLimitPrefix + keyLoginBlock,
+		Lift:   liftLoginBlock,
+	})
 }
 
 func NewLoginProtection() app.HandlerFunc {
@@ -64,8 +88,9 @@ func NewLoginProtection() app.HandlerFunc {
 
 	return func(ctx context.Context, c *app.RequestContext) {
 		ip := loginProtectionClientIP(c)
-
-		if loginProtectionAbortIfBlocked(ctx, c, ip, settings.durationBlockMin, settings.durationBlockHour) {
+		// the allowlist exempts the IP from the protections keyed on it, the account stays protected
+		allowed := settings.blocks.Allowed(ctx, ip)
+		if !allowed && ipProtectionAbortIfBlocked(ctx, c, settings, ip) {
 			return
 		}
 
@@ -74,10 +99,22 @@ func NewLoginProtection() app.HandlerFunc {
 			return
 		}
 
+		// the account and the email of a user share the failures, the lock and the manual block
+		subject := settings.accounts.Subject(ctx, req.Account)
+		if manualBlockAbortIfBlocked(ctx, c, settings.blocks, blocklist.TargetAccount, subject) {
+			return
+		}
+
+		if accountProtectionAbortIfLocked(ctx, c, settings.accounts, subject) {
+			return
+		}
+
 		if loginProtectionAbortIfReachSuccessLimit(ctx, c, settings.successRecorder, req.Account) {
 			return
 		}
 
+		accountProtectionDelay(ctx, settings.accounts, subject)
+
 		c.Next(ctx)
 
 		resp, ok := loginProtectionParseResp(ctx, c)
@@ -87,6 +124,8 @@ func NewLoginProtection() app.HandlerFunc {
 
 		if resp.Success {
 			loginProtectionRecordSuccess(ctx, settings.successRecorder, req.Account)
+			_ = settings.accounts.Success(ctx, subject)
+			_ = settings.challenges.Success(ctx, ip)
 			return
 		}
 
@@ -94,36 +133,33 @@ func NewLoginProtection() app.HandlerFunc {
 			return
 		}
 
-		loginProtectionHandleFailure(ctx, ip, settings.failInterceptor, settings.durationBlockMin, settings.durationBlockHour, settings.durationFailLvl)
+		if allowed {
+			// counted for the account only, the IP is not checked for spraying
+			_ = settings.accounts.Failure(ctx, "", subject)
+			return
+		}
+		loginProtectionHandleFailure(ctx, ip, settings.tiers)
+		_ = settings.accounts.Failure(ctx, ip, subject)
+		_ = settings.challenges.Failure(ctx, ip)
 	}
 }
 
-func newLoginProtectionSettings(conf config.LoginProtectionConf) loginProtectionSettings {
-	windowSeconds := conf.WindowSeconds
-	if windowSeconds <= 0 {
-		windowSeconds = defaultWindowSeconds
-	}
-
-	limit := conf.Limit
-	if limit <= 0 {
-		limit = defaultLimit
-	}
-
-	durationBlockMin := time.Duration(conf.BlockMinDuration) * time.Minute
-	if durationBlockMin <= 0 {
-		durationBlockMin = defaultBlockMinDuration
+// ipProtectionAbortIfBlocked rejects IPs blocked by hand, by the login block tiers or for spraying.
+func ipProtectionAbortIfBlocked(ctx context.Context, c *app.RequestContext, settings loginProtectionSettings, ip string) bool {
+	if manualBlockAbortIfBlocked(ctx, c, settings.blocks, blocklist.TargetIP, ip) {
+		return true
 	}
-
-	durationBlockHour := time.Duration(conf.BlockHourDuration) * time.Hour
-	if durationBlockHour <= 0 {
-		durationBlockHour = defaultBlockHourDuration
+	if loginProtectionAbortIfBlocked(ctx, c, ip, settings.tiers) {
+		return true
 	}
-
-	durationFailLvl := time.Duration(conf.LevelDuration) * time.Second
-	if durationFailLvl <= 0 {
-		durationFailLvl = defaultLevelDuration
+	if d, _ := settings.accounts.Spraying(ctx, ip); d > 0 {
+		abortBlocked(c, errs.RequestBlocked, msgSprayBlocked, 0, d)
+		return true
 	}
+	return false
+}
 
+func newLoginProtectionSettings(conf config.LoginProtectionConf) loginProtectionSettings {
 	successWindowSeconds := conf.SuccessWindowSeconds
 	if successWindowSeconds <= 0 {
 		successWindowSeconds = defaultSuccessWindowSeconds
@@ -135,14 +171,60 @@ func newLoginProtectionSettings(conf config.LoginProtectionConf) loginProtection
 	}
 
 	return loginProtectionSettings{
-		failInterceptor:   interceptor.NewInterceptor(windowSeconds, int64(limit-1)),
-		successRecorder:   interceptor.NewInterceptor(successWindowSeconds, int64(successLimit-1)),
-		durationBlockMin:  durationBlockMin,
-		durationBlockHour: durationBlockHour,
-		durationFailLvl:   durationFailLvl,
+		tiers:           newLoginBlockTiers(conf),
+		successRecorder: interceptor.NewInterceptor(successWindowSeconds, int64(successLimit-1)),
+		accounts:        lockout.NewDefault(),
+		challenges:      challenge.NewDefault(),
+		blocks:          blocklist.NewDefault(),
 	}
 }
 
+// newLoginBlockTiers builds the configured tiers, or the minute and hour tiers of the older
+// settings when no tiers are configured.
+func newLoginBlockTiers(conf config.LoginProtectionConf) []loginBlockTier {
+	tierConfs := conf.Tiers
+	if len(tierConfs) == 0 {
+		blockMin := time.Duration(conf.BlockMinDuration) * time.Minute
+		if blockMin <= 0 {
+			blockMin = defaultBlockMinDuration
+		}
+		blockHour := time.Duration(conf.BlockHourDuration) * time.Hour
+		if blockHour <= 0 {
+			blockHour = defaultBlockHourDuration
+		}
+		tierConfs = []config.LoginBlockTierConf{
+			{Failures: conf.Limit, WindowSeconds: conf.WindowSeconds, BlockSeconds: int(blockMin.Seconds()), DecaySeconds: conf.LevelDuration},
+			{Failures: conf.Limit, WindowSeconds: conf.WindowSeconds, BlockSeconds: int(blockHour.Seconds()), DecaySeconds: conf.LevelDuration},
+		}
+	}
+
+	tiers := make([]loginBlockTier, 0, len(tierConfs))
+	for _, tc := range tierConfs {
+		failures := tc.Failures
+		if failures <= 0 {
+			failures = defaultLimit
+		}
+		windowSeconds := tc.WindowSeconds
+		if windowSeconds <= 0 {
+			windowSeconds = defaultWindowSeconds
+		}
+		block := time.Duration(tc.BlockSeconds) * time.Second
+		if block <= 0 {
+			block = defaultBlockMinDuration
+		}
+		decay := time.Duration(tc.DecaySeconds) * time.Second
+		if decay <= 0 {
+			decay = defaultLevelDuration
+		}
+		tiers = append(tiers, loginBlockTier{
+			failInterceptor: interceptor.NewInterceptor(windowSeconds, int64(failures-1)),
+			block:           block,
+			decay:           decay,
+		})
+	}
+	return tiers
+}
+
 func loginProtectionClientIP(c *app.RequestContext) string {
 	ip := c.ClientIP()
 	if ip == "" {
@@ -151,30 +233,99 @@ func loginProtectionClientIP(c *app.RequestContext) string {
 	return ip
 }
 
-func loginProtectionAbortIfBlocked(ctx context.Context, c *app.RequestContext, ip string, durationBlockMin time.Duration, durationBlockHour time.Duration) bool {
+func loginProtectionAbortIfBlocked(ctx context.Context, c *app.RequestContext, ip string, tiers []loginBlockTier) bool {
 	rdb := redis.GetRedisClient()
+	key := rateLimitPrefix + keyLoginBlock + ip
 
-	if n, _ := rdb.Exists(ctx, rateLimitPrefix+keyLoginBlockHour+ip).Result(); n > 0 {
-		c.AbortWithStatusJSON(http.StatusForbidden, dto.CommonResp{
-			Code:    int(errs.RequestBlocked.Code()),
-			Message: fmt.Sprintf(msgLoginFailuresHoursFmt, durationBlockHour.Hours()),
-			Success: false,
-		})
-		return true
+	pipe := rdb.Pipeline()
+	tierCmd := pipe.Get(ctx, key)
+	ttlCmd := pipe.PTTL(ctx, key)
+	_, _ = pipe.Exec(ctx)
+
+	tier, err := tierCmd.Int()
+	if errors.Is(err, goredis.Nil) {
+		return false
+	}
+	if err != nil {
+		// the block cannot be read while Redis fails, the failure counters which went local
+		// hold the IP once one more failure would block it
+		for n, t := range tiers {
+			q, err := t.failInterceptor.Peek(ctx, keyLoginFail+strconv.Itoa(n+1)+":"+ip)
+			if err == nil && !q.Allowed {
+				abortBlocked(c, errs.RequestBlocked, msgLoginFailures, n+1, q.RetryAfter)
+				return true
+			}
+		}
+		return false
+	}
+	retryAfter := ttlCmd.Val()
+	if retryAfter < 0 {
+		// a block without expiry, the client is told the longest block
+		retryAfter = tiers[len(tiers)-1].block
 	}
 
-	if n, _ := rdb.Exists(ctx, rateLimitPrefix+keyLoginBlockMinute+ip).Result(); n > 0 {
-		c.AbortWithStatusJSON(http.StatusForbidden, dto.CommonResp{
-			Code:    int(errs.RequestBlocked.Code()),
-			Message: fmt.Sprintf(msgLoginFailuresMinutesFmt, durationBlockMin.Minutes()),
-			Success: false,
-		})
+	abortBlocked(c, errs.RequestBlocked, msgLoginFailures, tier, retryAfter)
+	return true
+}
+
+// accountProtectionAbortIfLocked rejects locked accounts, it fails
+// open when the state cannot be read.
+func accountProtectionAbortIfLocked(ctx context.Context, c *app.RequestContext, accounts *lockout.Service, subject string) bool {
+	if d, _ := accounts.Locked(ctx, subject); d > 0 {
+		abortBlocked(c, errs.AccountLocked, msgAccountLocked, 0, d)
 		return true
 	}
-
 	return false
 }
 
+// manualBlockAbortIfBlocked rejects IPs and accounts blocked by an administrator, it fails open
+// when the block cannot be read. The manual blocks are only kept in Redis, unlike the counters they
+// have no local fallback and do not hold during an outage.
+func manualBlockAbortIfBlocked(ctx context.Context, c *app.RequestContext, blocks *blocklist.Service, target, value string) bool {
+	block, _ := blocks.ManualBlock(ctx, target, value)
+	if block == nil {
+		return false
+	}
+	code := errs.RequestBlocked
+	if target == blocklist.TargetAccount {
+		code = errs.AccountLocked
+	}
+	abortBlocked(c, code, msgManualBlocked, 0, block.Remaining)
+	return true
+}
+
+// abortBlocked answers 403 with the Retry-After header, the data tells when the block ends. A
+// block without retryAfter lasts until it is lifted and has neither.
+func abortBlocked(c *app.RequestContext, bizErr errs.Error, msg string, tier int, retryAfter time.Duration) {
+	data := dto.BlockedData{Tier: tier}
+	if retryAfter > 0 {
+		data.RetryAfter = int64(math.Ceil(retryAfter.Seconds()))
+		data.BlockedUntil = time.Now().Add(retryAfter).Unix()
+		c.Header("Retry-After", strconv.FormatInt(data.RetryAfter, 10))
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, dto.CommonResp{
+		Code:    int(bizErr.Code()),
+		Message: msg,
+		Success: false,
+		Data:    data,
+	})
+}
+
+// accountProtectionDelay holds back logins of a user with recent failures, before the
+// password is checked, so guessing gets slower whichever IPs it comes from.
+func accountProtectionDelay(ctx context.Context, accounts *lockout.Service, subject string) {
+	d, _ := accounts.Delay(ctx, subject)
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
 func loginProtectionBindReq(c *app.RequestContext) (dto.LoginReq, bool) {
 	var req dto.LoginReq
 	if err := c.BindAndValidate(&req); err != nil {
@@ -226,17 +377,18 @@ func loginProtectionShouldHandleFailure(resp dto.CommonResp) bool {
 	}
 }
 
-func loginProtectionHandleFailure(
-	ctx context.Context,
-	ip string,
-	failInterceptor *interceptor.Interceptor,
-	durationBlockMin time.Duration,
-	durationBlockHour time.Duration,
-	durationFailLvl time.Duration,
-) {
+// loginProtectionHandleFailure counts the failure for the next tier of the IP, and blocks the IP
+// when the tier is reached.
+func loginProtectionHandleFailure(ctx context.Context, ip string, tiers []loginBlockTier) {
 	rdb := redis.GetRedisClient()
 
-	allowed, err := failInterceptor.Allow(ctx, keyLoginFail+ip)
+	reached := loginProtectionReachedTier(ctx, ip, len(tiers))
+	// past the last tier the last one repeats
+	next := min(reached+1, len(tiers))
+	tier := tiers[next-1]
+	failKey := keyLoginFail + strconv.Itoa(next) + ":" + ip
+
+	allowed, err := tier.failInterceptor.Allow(ctx, failKey)
 	if err != nil {
 		hlog.CtxErrorf(ctx, logFailInterceptorErrFmt, err)
 		return
@@ -246,19 +398,69 @@ func loginProtectionHandleFailure(
 		return
 	}
 
-	lvlExists, _ := rdb.Exists(ctx, keyLoginFailLvl+ip).Result()
-	if lvlExists > 0 {
-		rdb.Set(ctx, rateLimitPrefix+keyLoginBlockHour+ip, redisValueOne, durationBlockHour)
-		hlog.CtxInfof(ctx, logBlockedLevel2Fmt, ip, durationBlockHour)
+	pipe := rdb.Pipeline()
+	pipe.Set(ctx, rateLimitPrefix+keyLoginBlock+ip, next, tier.block)
+	pipe.Set(ctx, loginFailLevelKey(next, ip), next, tier.block+tier.decay)
+	pipe.Del(ctx, rateLimitPrefix+failKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		hlog.CtxErrorf(ctx, logSetLoginBlockKeysErrFmt, err)
 		return
 	}
+	hlog.CtxInfof(ctx, logBlockedFmt, ip, tier.block, next)
+	recordIPBlocked(ctx, ip, next, tier.block)
+}
 
-	pipe := rdb.Pipeline()
-	pipe.Set(ctx, rateLimitPrefix+keyLoginBlockMinute+ip, redisValueOne, durationBlockMin)
-	pipe.Set(ctx, keyLoginFailLvl+ip, redisValueOne, durationFailLvl)
-	_, err = pipe.Exec(ctx)
+// loginProtectionReachedTier returns the highest tier the IP has reached and which has not
+// decayed yet, zero when there is none. Every tier decays on its own, so an IP falls back
+// through the lower tiers it has reached.
+func loginProtectionReachedTier(ctx context.Context, ip string, n int) int {
+	pipe := redis.GetRedisClient().Pipeline()
+	cmds := make([]*goredis.IntCmd, n)
+	for i := range cmds {
+		cmds[i] = pipe.Exists(ctx, loginFailLevelKey(i+1, ip))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		hlog.CtxErrorf(ctx, logFailInterceptorErrFmt, err)
+		return 0
+	}
+	for i := n - 1; i >= 0; i-- {
+		if cmds[i].Val() > 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func loginFailLevelKey(tier int, ip string) string {
+	return keyLoginFailLvl + strconv.Itoa(tier) + ":" + ip
+}
+
+func recordIPBlocked(ctx context.Context, ip string, tier int, duration time.Duration) {
+	audit.Record(ctx, audit.Event{
+		Type:    domain.AuditIPBlocked,
+		Details: map[string]any{"ip": ip, "tier": tier, "seconds": int64(duration.Seconds())},
+	})
+}
+
+// liftLoginBlock lifts the block of the IP and forgets the tiers it reached and its failures, so
+// the escalation starts over.
+func liftLoginBlock(ctx context.Context, ip string) (bool, errs.Error) {
+	rdb := redis.GetRedisClient()
+	keys := []string{rateLimitPrefix + keyLoginBlock + ip}
+	for _, pattern := range []string{keyLoginFailLvl + "*:" + ip, rateLimitPrefix + keyLoginFail + "*:" + ip} {
+		iter := rdb.Scan(ctx, 0, pattern, scanCount).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			hlog.CtxErrorf(ctx, "scan login failures err: %v", err)
+			return false, errs.ServerError.SetErr(err)
+		}
+	}
+	n, err := rdb.Del(ctx, keys...).Result()
 	if err != nil {
-		hlog.CtxErrorf(ctx, logSetLoginBlockKeysErrFmt, err)
+		hlog.CtxErrorf(ctx, "lift login block err: %v", err)
+		return false, errs.ServerError.SetErr(err)
 	}
-	hlog.CtxInfof(ctx, logBlockedLevel1Fmt, ip, durationBlockMin)
+	return n > 0, nil
 }
@@ -2,10 +2,16 @@ package security
 
 import (
 	"context"
+	"doing_now/be/biz/config"
 	db_redis "doing_now/be/biz/db/redis"
 	"doing_now/be/biz/model/dto"
 	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/blocklist"
+	"doing_now/be/biz/service/lockout"
+	"doing_now/be/biz/util/interceptor"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -17,6 +23,17 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// mockSubject resolves logins without a database, alice logs in by account or by email
+func mockSubject() {
+	mockey.Mock((*lockout.Service).Subject).To(func(_ *lockout.Service, _ context.Context, login string) string {
+		login = strings.ToLower(login)
+		if login == "alice" || login == "alice@example.com" {
+			return lockout.UserSubject("user01")
+		}
+		return "login:" + login
+	}).Build()
+}
+
 func TestLoginProtection(t *testing.T) {
 	// Setup Redis Mock
 	mr, err := miniredis.Run()
@@ -31,6 +48,7 @@ func TestLoginProtection(t *testing.T) {
 	defer rdb.Close()
 
 	mockey.PatchConvey("TestLoginProtection", t, func() {
+		mockSubject()
 		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
 
 		mw := NewLoginProtection()
@@ -64,7 +82,7 @@ func TestLoginProtection(t *testing.T) {
 			assert.False(t, c.IsAborted())
 		})
 
-		t.Run("Block 5m Logic", func(t *testing.T) {
+		t.Run("Block Tier 1", func(t *testing.T) {
 			mr.FlushAll()
 
 			// Fail 1
@@ -77,17 +95,17 @@ func TestLoginProtection(t *testing.T) {
 			mw(ctx, c)
 			assert.False(t, c.IsAborted())
 
-			// Fail 3 (Should Trigger Block 5m)
+			// Fail 3 (Should Trigger Tier 1, 5m)
 			c = makeLoginReq(clientIP, false)
 			mw(ctx, c)
 			assert.False(t, c.IsAborted()) // The request itself is not aborted, but post-check sets block
 
 			// Verify Block Key exists
-			exists, _ := rdb.Exists(ctx, "rate_limit:"+keyLoginBlockMinute+clientIP).Result()
-			assert.Equal(t, int64(1), exists)
+			tier, _ := rdb.Get(ctx, "rate_limit:"+keyLoginBlock+clientIP).Result()
+			assert.Equal(t, "1", tier)
 
 			// Verify Fail Level exists
-			exists, _ = rdb.Exists(ctx, keyLoginFailLvl+clientIP).Result()
+			exists, _ := rdb.Exists(ctx, loginFailLevelKey(1, clientIP)).Result()
 			assert.Equal(t, int64(1), exists)
 
 			// Next Request (Should be blocked)
@@ -97,15 +115,19 @@ func TestLoginProtection(t *testing.T) {
 			mw(ctx, c)
 			assert.True(t, c.IsAborted())
 			assert.Equal(t, consts.StatusForbidden, c.Response.StatusCode())
-			assert.Contains(t, string(c.Response.Body()), "5 minutes")
+			assert.Equal(t, "300", string(c.Response.Header.Peek("Retry-After")))
+			data := decodeBlockedData(t, c)
+			assert.Equal(t, 1, data.Tier)
+			assert.Equal(t, int64(300), data.RetryAfter)
+			assert.InDelta(t, time.Now().Add(5*time.Minute).Unix(), data.BlockedUntil, 2)
 		})
 
-		t.Run("Block 24h Logic", func(t *testing.T) {
+		t.Run("Block Tier 2", func(t *testing.T) {
 			mr.FlushAll()
 
-			// Pre-condition: User is in "Level 1" (Level key exists)
-			// But Block 5m key might have expired (simulating "5 mins later")
-			rdb.Set(ctx, keyLoginFailLvl+clientIP, "1", time.Hour)
+			// Pre-condition: IP has reached tier 1 (Level key exists)
+			// But the tier 1 block has expired (simulating "5 mins later")
+			rdb.Set(ctx, loginFailLevelKey(1, clientIP), "1", time.Hour)
 
 			// Fail 1
 			c := makeLoginReq(clientIP, false)
@@ -115,17 +137,17 @@ func TestLoginProtection(t *testing.T) {
 			c = makeLoginReq(clientIP, false)
 			mw(ctx, c)
 
-			// Fail 3 (Should Trigger Block 24h)
+			// Fail 3 (Should Trigger Tier 2, 24h)
 			c = makeLoginReq(clientIP, false)
 			mw(ctx, c)
 
-			// Verify Block 24h Key exists
-			exists, _ := rdb.Exists(ctx, "rate_limit:"+keyLoginBlockHour+clientIP).Result()
-			assert.Equal(t, int64(1), exists)
+			// Verify Block Key is at tier 2
+			tier, _ := rdb.Get(ctx, "rate_limit:"+keyLoginBlock+clientIP).Result()
+			assert.Equal(t, "2", tier)
 
-			// Verify Level Key still exists (implementation does not remove it)
-			exists, _ = rdb.Exists(ctx, keyLoginFailLvl+clientIP).Result()
-			assert.Equal(t, int64(1), exists)
+			// Verify both Level Keys exist, each decays on its own
+			exists, _ := rdb.Exists(ctx, loginFailLevelKey(1, clientIP), loginFailLevelKey(2, clientIP)).Result()
+			assert.Equal(t, int64(2), exists)
 
 			// Next Request (Should be blocked 24h)
 			c = app.NewContext(0)
@@ -133,7 +155,8 @@ func TestLoginProtection(t *testing.T) {
 			c.Request.Header.Set("X-Forwarded-For", clientIP)
 			mw(ctx, c)
 			assert.True(t, c.IsAborted())
-			assert.Contains(t, string(c.Response.Body()), "24 hours")
+			assert.Equal(t, "86400", string(c.Response.Header.Peek("Retry-After")))
+			assert.Equal(t, 2, decodeBlockedData(t, c).Tier)
 		})
 
 		t.Run("System Error Should Not Count", func(t *testing.T) {
@@ -155,8 +178,270 @@ func TestLoginProtection(t *testing.T) {
 			assert.False(t, c.IsAborted())
 
 			// Verify NO Block Key
-			exists, _ := rdb.Exists(ctx, "rate_limit:"+keyLoginBlockMinute+clientIP).Result()
+			exists, _ := rdb.Exists(ctx, "rate_limit:"+keyLoginBlock+clientIP).Result()
 			assert.Equal(t, int64(0), exists)
 		})
 	})
 }
+
+func TestLoginProtectionTiers(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	defer rdb.Close()
+
+	mockey.PatchConvey("TestLoginProtectionTiers", t, func() {
+		mockSubject()
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+		mockey.Mock(config.GetLoginProtectionConf).Return(config.LoginProtectionConf{
+			Tiers: []config.LoginBlockTierConf{
+				{Failures: 2, WindowSeconds: 60, BlockSeconds: 10, DecaySeconds: 600},
+				{Failures: 1, WindowSeconds: 60, BlockSeconds: 100, DecaySeconds: 600},
+			},
+		}).Build()
+
+		mw := NewLoginProtection()
+		ctx := context.Background()
+		ip := "10.0.3.1"
+
+		fail := func() {
+			c := app.NewContext(0)
+			c.Request.SetRequestURI("/api/v1/user/login")
+			c.Request.Header.Set("X-Forwarded-For", ip)
+			respBytes, _ := json.Marshal(dto.CommonResp{Success: false, Code: int(errs.PasswordIncorrect.Code())})
+			c.Response.SetBody(respBytes)
+			mw(ctx, c)
+		}
+		blocked := func() (int, time.Duration) {
+			tier, _ := rdb.Get(ctx, "rate_limit:"+keyLoginBlock+ip).Int()
+			return tier, mr.TTL("rate_limit:" + keyLoginBlock + ip)
+		}
+
+		fail()
+		tier, _ := blocked()
+		assert.Equal(t, 0, tier)
+
+		fail()
+		tier, ttl := blocked()
+		assert.Equal(t, 1, tier)
+		assert.Equal(t, 10*time.Second, ttl)
+
+		// the second tier counts its own threshold once the first block is over
+		mr.FastForward(11 * time.Second)
+		fail()
+		tier, ttl = blocked()
+		assert.Equal(t, 2, tier)
+		assert.Equal(t, 100*time.Second, ttl)
+
+		// past the last tier the last one repeats
+		mr.FastForward(101 * time.Second)
+		fail()
+		tier, _ = blocked()
+		assert.Equal(t, 2, tier)
+
+		// once both tiers decay the escalation starts over
+		mr.FlushAll()
+		fail()
+		fail()
+		tier, _ = blocked()
+		assert.Equal(t, 1, tier)
+	})
+}
+
+func TestLoginProtectionRedisDown(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	mockey.PatchConvey("TestLoginProtectionRedisDown", t, func() {
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+		tiers := newLoginBlockTiers(config.LoginProtectionConf{
+			Tiers: []config.LoginBlockTierConf{
+				{Failures: 2, WindowSeconds: 60, BlockSeconds: 10},
+				{Failures: 1, WindowSeconds: 60, BlockSeconds: 100},
+			},
+		})
+		ctx := context.Background()
+		ip := "10.0.3.2"
+
+		// the failure counters went local, the second tier is reached
+		mockey.Mock((*interceptor.Interceptor).Peek).To(func(i *interceptor.Interceptor, ctx context.Context, key string) (interceptor.Quota, error) {
+			if key == keyLoginFail+"2:"+ip {
+				return interceptor.Quota{Allowed: false, RetryAfter: 30 * time.Second}, nil
+			}
+			return interceptor.Quota{Allowed: true}, nil
+		}).Build()
+		mr.SetError("LOADING")
+
+		c := app.NewContext(0)
+		assert.True(t, loginProtectionAbortIfBlocked(ctx, c, ip, tiers))
+		assert.Equal(t, consts.StatusForbidden, c.Response.StatusCode())
+		assert.Equal(t, "30", string(c.Response.Header.Peek("Retry-After")))
+		assert.Equal(t, 2, decodeBlockedData(t, c).Tier)
+
+		c = app.NewContext(0)
+		assert.False(t, loginProtectionAbortIfBlocked(ctx, c, "10.0.3.3", tiers))
+
+		// manual blocks have no local fallback, they are not enforced during the outage
+		c = app.NewContext(0)
+		assert.False(t, manualBlockAbortIfBlocked(ctx, c, blocklist.New(), blocklist.TargetIP, ip))
+
+		// a missing block is not a failure
+		mr.SetError("")
+		c = app.NewContext(0)
+		assert.False(t, loginProtectionAbortIfBlocked(ctx, c, ip, tiers))
+	})
+}
+
+func decodeBlockedData(t *testing.T, c *app.RequestContext) dto.BlockedData {
+	var resp struct {
+		Data dto.BlockedData `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(c.Response.Body(), &resp))
+	return resp.Data
+}
+
+func TestAccountProtection(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+
+	mockey.PatchConvey("TestAccountProtection", t, func() {
+		mockSubject()
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+		mockey.Mock(config.GetAccountProtectionConf).Return(config.AccountProtectionConf{
+			DelayAfter:    1,
+			DelayStepMs:   1,
+			LockAfter:     3,
+			LockMinutes:   15,
+			SprayAccounts: 3,
+		}).Build()
+
+		mw := NewLoginProtection()
+		ctx := context.Background()
+
+		makeLoginReq := func(ip, account string, success bool) *app.RequestContext {
+			c := app.NewContext(0)
+			c.Request.SetRequestURI("/api/v1/user/login")
+			c.Request.Header.Set("X-Forwarded-For", ip)
+			body := `{"account":"` + account + `","password":"password01"}`
+			c.Request.Header.SetContentTypeBytes([]byte(consts.MIMEApplicationJSON))
+			c.Request.Header.SetContentLength(len(body))
+			c.Request.SetBodyString(body)
+
+			resp := dto.CommonResp{Success: success}
+			if !success {
+				resp.Code = int(errs.PasswordIncorrect.Code())
+			}
+			respBytes, _ := json.Marshal(resp)
+			c.Response.SetBody(respBytes)
+			return c
+		}
+
+		t.Run("Account Lock Across IPs", func(t *testing.T) {
+			mr.FlushAll()
+
+			// one failure per IP never trips the per-IP block
+			for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+				c := makeLoginReq(ip, "alice", false)
+				mw(ctx, c)
+				assert.False(t, c.IsAborted())
+			}
+
+			c := makeLoginReq("10.0.0.4", "Alice", true)
+			mw(ctx, c)
+			assert.True(t, c.IsAborted())
+			assert.Equal(t, consts.StatusForbidden, c.Response.StatusCode())
+			assert.Contains(t, string(c.Response.Body()), fmt.Sprint(errs.AccountLocked.Code()))
+			assert.Equal(t, "900", string(c.Response.Header.Peek("Retry-After")))
+
+			// other accounts are not affected
+			c = makeLoginReq("10.0.0.4", "bob", true)
+			mw(ctx, c)
+			assert.False(t, c.IsAborted())
+		})
+
+		t.Run("Account And Email Share the Lock", func(t *testing.T) {
+			mr.FlushAll()
+
+			for i, login := range []string{"alice", "alice@example.com", "alice"} {
+				c := makeLoginReq(fmt.Sprintf("10.0.3.%d", i), login, false)
+				mw(ctx, c)
+				assert.False(t, c.IsAborted())
+			}
+
+			c := makeLoginReq("10.0.3.9", "Alice@Example.com", true)
+			mw(ctx, c)
+			assert.True(t, c.IsAborted())
+			assert.Contains(t, string(c.Response.Body()), fmt.Sprint(errs.AccountLocked.Code()))
+		})
+
+		t.Run("Success Clears Account Failures", func(t *testing.T) {
+			mr.FlushAll()
+
+			for i := 0; i < 2; i++ {
+				mw(ctx, makeLoginReq(fmt.Sprintf("10.0.1.%d", i), "alice", false))
+			}
+			mw(ctx, makeLoginReq("10.0.1.9", "alice", true))
+			mw(ctx, makeLoginReq("10.0.1.8", "alice", false))
+
+			c := makeLoginReq("10.0.1.7", "alice", true)
+			mw(ctx, c)
+			assert.False(t, c.IsAborted())
+		})
+
+		t.Run("Spraying IP Is Blocked", func(t *testing.T) {
+			mr.FlushAll()
+			ip := "10.0.2.1"
+
+			for _, account := range []string{"alice", "bob", "carol"} {
+				mw(ctx, makeLoginReq(ip, account, false))
+			}
+			// the per-IP counter would block at 3 failures as well, lift it to see the spray block
+			mr.Del("rate_limit:" + keyLoginBlock + ip)
+
+			c := makeLoginReq(ip, "dave", true)
+			mw(ctx, c)
+			assert.True(t, c.IsAborted())
+			assert.Contains(t, string(c.Response.Body()), "different accounts")
+		})
+
+		t.Run("Allowlisted IP Keeps The Account Protections", func(t *testing.T) {
+			mr.FlushAll()
+			ip := "10.0.4.1"
+			blocks := blocklist.New()
+			assert.Nil(t, blocks.Allow(ctx, "10.0.4.0/24"))
+
+			for _, account := range []string{"alice", "alice", "alice", "bob"} {
+				c := makeLoginReq(ip, account, false)
+				mw(ctx, c)
+				assert.False(t, c.IsAborted())
+			}
+			// neither the IP block nor the spray counter is kept for the allowlisted IP
+			assert.False(t, mr.Exists("rate_limit:"+keyLoginBlock+ip))
+			assert.False(t, mr.Exists("login_spray:"+ip))
+
+			c := makeLoginReq(ip, "alice", true)
+			mw(ctx, c)
+			assert.True(t, c.IsAborted())
+			assert.Contains(t, string(c.Response.Body()), fmt.Sprint(errs.AccountLocked.Code()))
+
+			// blocking the email blocks the account it identifies
+			assert.Nil(t, blocks.Block(ctx, blocklist.TargetAccount, "alice@example.com", 0, ""))
+			mr.Del("account_lock:" + lockout.UserSubject("user01"))
+			c = makeLoginReq(ip, "Alice", true)
+			mw(ctx, c)
+			assert.True(t, c.IsAborted())
+			assert.Contains(t, string(c.Response.Body()), fmt.Sprint(errs.AccountLocked.Code()))
+
+			c = makeLoginReq(ip, "bob", true)
+			mw(ctx, c)
+			assert.False(t, c.IsAborted())
+		})
+	})
+}
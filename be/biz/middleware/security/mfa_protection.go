@@ -0,0 +1,57 @@
+package security
+
+import (
+	"context"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/blocklist"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// NewMFAProtection throttles the second login step. Wrong codes are counted on the same per-IP
+// failure counter as wrong passwords, so guessing codes escalates to the same login blocks.
+func NewMFAProtection() app.HandlerFunc {
+	return newLoginFailureProtection(errs.MFACodeInvalid)
+}
+
+// NewPasskeyProtection throttles passkey logins the same way as the second login step.
+func NewPasskeyProtection() app.HandlerFunc {
+	return newLoginFailureProtection(errs.PasskeyInvalid)
+}
+
+// newLoginFailureProtection counts responses carrying failCode as login failures of the client IP.
+func newLoginFailureProtection(failCode errs.Error) app.HandlerFunc {
+	conf := config.GetLoginProtectionConf()
+	settings := newLoginProtectionSettings(conf)
+
+	return func(ctx context.Context, c *app.RequestContext) {
+		ip := loginProtectionClientIP(c)
+		if settings.blocks.Allowed(ctx, ip) {
+			return
+		}
+
+		if manualBlockAbortIfBlocked(ctx, c, settings.blocks, blocklist.TargetIP, ip) {
+			return
+		}
+
+		if loginProtectionAbortIfBlocked(ctx, c, ip, settings.tiers) {
+			return
+		}
+
+		c.Next(ctx)
+
+		resp, ok := loginProtectionParseResp(ctx, c)
+		if !ok || resp.Success {
+			return
+		}
+
+		if int32(resp.Code) != failCode.Code() {
+			return
+		}
+
+		loginProtectionHandleFailure(ctx, ip, settings.tiers)
+		_ = settings.challenges.Failure(ctx, ip)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"doing_now/be/biz/db/redis"
 	"doing_now/be/biz/model/dto"
 	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/blocklist"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -19,6 +20,22 @@ const (
 	keyRegisterBlock = "register_block:"
 )
 
+func init() {
+	blocklist.RegisterSource(blocklist.Source{
+		Target: blocklist.TargetIP,
+		Reason: blocklist.ReasonRegistration,
+		Prefix: rateLimitPrefix + keyRegisterBlock,
+		Lift: func(ctx context.Context, ip string) (bool, errs.Error) {
+			n, err := redis.GetRedisClient().Del(ctx, rateLimitPrefix+keyRegisterBlock+ip).Result()
+			if err != nil {
+				hlog.CtxErrorf(ctx, "lift register block err: %v", err)
+				return false, errs.ServerError.SetErr(err)
+			}
+			return n > 0, nil
+		},
+	})
+}
+
 // NewRegisterProtection creates a middleware that prevents registration after a successful one for a certain duration
 func NewRegisterProtection() app.HandlerFunc {
 	conf := config.GetRegisterProtectionConf()
@@ -27,6 +44,7 @@ func NewRegisterProtection() app.HandlerFunc {
 		blockMinutes = 10 // default 10 minutes
 	}
 	blockDuration := time.Duration(blockMinutes) * time.Minute
+	blocks := blocklist.NewDefault()
 
 	return func(ctx context.Context, c *app.RequestContext) {
 		ip := c.ClientIP()
@@ -34,10 +52,17 @@ func NewRegisterProtection() app.HandlerFunc {
 			ip = "unknown"
 		}
 
+		if blocks.Allowed(ctx, ip) {
+			return
+		}
+		if manualBlockAbortIfBlocked(ctx, c, blocks, blocklist.TargetIP, ip) {
+			return
+		}
+
 		rdb := redis.GetRedisClient()
 
 		// 1. Pre-check: Check if blocked
-		if n, _ := rdb.Exists(ctx, "rate_limit:"+keyRegisterBlock+ip).Result(); n > 0 {
+		if n, _ := rdb.Exists(ctx, rateLimitPrefix+keyRegisterBlock+ip).Result(); n > 0 {
 			c.JSON(http.StatusForbidden, dto.CommonResp{
 				Code:    int(errs.RequestBlocked.Code()),
 				Message: fmt.Sprintf("Registration is temporarily blocked. Please try again after %v minutes", blockMinutes),
@@ -60,7 +85,7 @@ func NewRegisterProtection() app.HandlerFunc {
 
 		// Only block if registration was successful
 		if resp.Success {
-			err := rdb.Set(ctx, "rate_limit:"+keyRegisterBlock+ip, "1", blockDuration).Err()
+			err := rdb.Set(ctx, rateLimitPrefix+keyRegisterBlock+ip, "1", blockDuration).Err()
 			if err != nil {
 				hlog.CtxErrorf(ctx, "Failed to set register block key: %v", err)
 			} else {
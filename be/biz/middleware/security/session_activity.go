@@ -0,0 +1,22 @@
+package security
+
+import (
+	"context"
+
+	"doing_now/be/biz/service/device"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/hertz-contrib/sessions"
+)
+
+// NewSessionActivity updates the last-seen time of the session's device after an authenticated
+// request. Failures are logged by the service and never fail the request.
+func NewSessionActivity() app.HandlerFunc {
+	return func(ctx context.Context, c *app.RequestContext) {
+		c.Next(ctx)
+
+		if sessID := sessions.Default(c).ID(); sessID != "" {
+			_ = device.NewDefault().Touch(ctx, sessID, c.ClientIP())
+		}
+	}
+}
@@ -7,14 +7,19 @@ import (
 	"net/http"
 
 	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
 	"github.com/hertz-contrib/sessions"
 	"github.com/rbcervilla/redisstore/v9"
 )
 
+const defaultStorePrefix = "auth_session:"
+
 func New() app.HandlerFunc {
 	conf := config.GetSessionConf()
 
 	store := NewRedisStore(conf.StorePrefix)
+	store.SignWith(conf.Keys, conf.AllowUnsigned)
 	store.Options(sessions.Options{
 		Path:     defaultString(conf.Path, "/"),
 		Domain:   conf.Domain,
@@ -42,23 +47,107 @@ func Remove(c *app.RequestContext) error {
 	return sess.Save()
 }
 
+// Destroy drops the stored data of a session by its ID, the next request with its cookie gets
+// an empty session.
+func Destroy(ctx context.Context, sessID string) error {
+	prefix := defaultString(config.GetSessionConf().StorePrefix, defaultStorePrefix)
+	return redis.GetRedisClient().Del(ctx, prefix+sessID).Err()
+}
+
 type RedisStore struct {
 	*redisstore.RedisStore
+
+	codecs        []securecookie.Codec
+	allowUnsigned bool
 }
 
 func (r *RedisStore) Options(opts sessions.Options) {
 	r.RedisStore.Options(*opts.ToGorillaOptions())
 }
 
+// SignWith signs the session ID in the cookie. The first key signs, the others only verify,
+// so a new key can be put in front and an old one dropped once its cookies have expired.
+func (r *RedisStore) SignWith(keys []string, allowUnsigned bool) {
+	r.codecs = r.codecs[:0]
+	for _, key := range keys {
+		codec := securecookie.New([]byte(key), nil)
+		// expiry is left to the redis TTL, which follows MaxAge
+		codec.MaxAge(0)
+		r.codecs = append(r.codecs, codec)
+	}
+	r.allowUnsigned = allowUnsigned
+}
+
+func (r *RedisStore) Get(req *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(req).Get(r, name)
+}
+
+func (r *RedisStore) New(req *http.Request, name string) (*gsessions.Session, error) {
+	if len(r.codecs) == 0 {
+		return r.RedisStore.New(req, name)
+	}
+
+	cookie, err := req.Cookie(name)
+	if err != nil {
+		return r.RedisStore.New(req, name)
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, r.codecs...); err != nil {
+		if !r.allowUnsigned {
+			// unknown or forged cookie, start over with a new session
+			return r.RedisStore.New(withoutCookies(req), name)
+		}
+		id = cookie.Value
+	}
+
+	// the embedded store loads the session by the plain ID found in the cookie
+	plain := withoutCookies(req)
+	plain.AddCookie(&http.Cookie{Name: name, Value: id})
+	return r.RedisStore.New(plain, name)
+}
+
+func (r *RedisStore) Save(req *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	if len(r.codecs) == 0 {
+		return r.RedisStore.Save(req, w, session)
+	}
+
+	// let the embedded store persist the session, then replace its plain cookie with a signed one
+	if err := r.RedisStore.Save(req, discardHeaders{w}, session); err != nil {
+		return err
+	}
+	if session.Options.MaxAge <= 0 {
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, r.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func withoutCookies(req *http.Request) *http.Request {
+	clone := req.Clone(req.Context())
+	clone.Header.Del("Cookie")
+	return clone
+}
+
+// discardHeaders drops the headers written through it, the body is never written by the store.
+type discardHeaders struct {
+	http.ResponseWriter
+}
+
+func (d discardHeaders) Header() http.Header {
+	return http.Header{}
+}
+
 func NewRedisStore(prefix string) *RedisStore {
 	redisStore, err := redisstore.NewRedisStore(context.Background(), redis.GetRedisClient())
 	if err != nil {
 		panic(err)
 	}
-	if prefix == "" {
-		prefix = "auth_session:"
-	}
-	redisStore.KeyPrefix(prefix)
+	redisStore.KeyPrefix(defaultString(prefix, defaultStorePrefix))
 	return &RedisStore{
 		RedisStore: redisStore,
 	}
@@ -0,0 +1,90 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	db_redis "doing_now/be/biz/db/redis"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bytedance/mockey"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+const cookieName = "auth_session_id"
+
+func newStore(t *testing.T, rdb *redis.Client, keys []string, allowUnsigned bool) *RedisStore {
+	var store *RedisStore
+	mockey.PatchConvey("new store", t, func() {
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+		store = NewRedisStore("test_session:")
+	})
+	store.SignWith(keys, allowUnsigned)
+	return store
+}
+
+// save stores a value in a new session and returns the cookie and the session ID.
+func save(t *testing.T, store *RedisStore) (*http.Cookie, string) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	sess, err := store.New(req, cookieName)
+	assert.NoError(t, err)
+	sess.Values["user_id"] = "u1"
+	assert.NoError(t, store.Save(req, rr, sess))
+
+	cookies := rr.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	return cookies[0], sess.ID
+}
+
+func load(t *testing.T, store *RedisStore, cookie *http.Cookie) (string, bool) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	sess, err := store.New(req, cookieName)
+	assert.NoError(t, err)
+	return sess.ID, !sess.IsNew && sess.Values["user_id"] == "u1"
+}
+
+func TestRedisStore_KeyRotation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	plain := newStore(t, rdb, nil, false)
+	plainCookie, plainID := save(t, plain)
+	assert.Equal(t, plainID, plainCookie.Value)
+
+	oldStore := newStore(t, rdb, []string{"old-key"}, true)
+	oldCookie, oldID := save(t, oldStore)
+	assert.NotEqual(t, oldID, oldCookie.Value)
+
+	t.Run("plain cookies accepted while rolling out", func(t *testing.T) {
+		id, ok := load(t, oldStore, plainCookie)
+		assert.True(t, ok)
+		assert.Equal(t, plainID, id)
+	})
+
+	rotated := newStore(t, rdb, []string{"new-key", "old-key"}, false)
+
+	t.Run("cookie of the previous key still loads", func(t *testing.T) {
+		id, ok := load(t, rotated, oldCookie)
+		assert.True(t, ok)
+		assert.Equal(t, oldID, id)
+	})
+
+	t.Run("new cookies use the first key", func(t *testing.T) {
+		cookie, _ := save(t, rotated)
+		_, ok := load(t, newStore(t, rdb, []string{"new-key"}, false), cookie)
+		assert.True(t, ok)
+		_, ok = load(t, oldStore, cookie)
+		assert.False(t, ok)
+	})
+
+	t.Run("plain and forged cookies rejected", func(t *testing.T) {
+		_, ok := load(t, rotated, plainCookie)
+		assert.False(t, ok)
+		_, ok = load(t, rotated, &http.Cookie{Name: cookieName, Value: oldCookie.Value + "x"})
+		assert.False(t, ok)
+	})
+}
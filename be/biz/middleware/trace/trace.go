@@ -19,6 +19,10 @@ func New() app.HandlerFunc {
 			logID = id_gen.NewID()
 		}
 		ctx = trace_info.WithLogId(ctx, logID)
+		ctx = trace_info.WithClient(ctx, trace_info.Client{
+			IP:        c.ClientIP(),
+			UserAgent: string(c.UserAgent()),
+		})
 		c.Next(ctx)
 		c.Header(headerKeyLogId, logID)
 	}
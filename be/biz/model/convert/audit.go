@@ -0,0 +1,29 @@
+package convert
+
+import (
+	"encoding/json"
+
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/storage"
+)
+
+func AuditEventRecordToDomain(m *storage.AuditEventRecord) *domain.AuditEvent {
+	if m == nil {
+		return nil
+	}
+	e := &domain.AuditEvent{
+		ID:        m.ID,
+		Type:      domain.AuditEventType(m.EventType),
+		ActorID:   m.ActorId,
+		TargetID:  m.TargetId,
+		IP:        m.IP,
+		UserAgent: m.UserAgent,
+		LogID:     m.LogId,
+		CreatedAt: m.CreatedAt,
+	}
+	if m.Details != "" {
+		// details are written by the application, a broken one is left out rather than failing the read
+		_ = json.Unmarshal([]byte(m.Details), &e.Details)
+	}
+	return e
+}
@@ -1,6 +1,8 @@
 package convert
 
 import (
+	"time"
+
 	"doing_now/be/biz/model/domain"
 	"doing_now/be/biz/model/storage"
 )
@@ -9,26 +11,45 @@ func UserDomainToRecord(u *domain.User) *storage.UserRecord {
 	if u == nil {
 		return nil
 	}
-	return &storage.UserRecord{
+	m := &storage.UserRecord{
 		GormModel: storage.GormModel{
 			CreatedAt: u.CreatedAt,
 			UpdatedAt: u.UpdatedAt,
 		},
-		UserId:  u.UserID,
-		Account: u.Account,
-		Name:    u.Name,
+		UserId:          u.UserID,
+		Account:         u.Account,
+		Name:            u.Name,
+		Status:          string(u.Status),
+		StatusReason:    u.StatusReason,
+		StatusChangedAt: u.StatusChangedAt,
+	}
+	if u.Email != "" {
+		m.Email = &u.Email
 	}
+	return m
 }
 
 func UserRecordToDomain(m *storage.UserRecord) *domain.User {
 	if m == nil {
 		return nil
 	}
-	return &domain.User{
-		UserID:    m.UserId,
-		Account:   m.Account,
-		Name:      m.Name,
-		CreatedAt: m.CreatedAt,
-		UpdatedAt: m.UpdatedAt,
+	u := &domain.User{
+		UserID:          m.UserId,
+		Account:         m.Account,
+		Name:            m.Name,
+		EmailVerified:   m.EmailVerifiedAt != nil,
+		Status:          domain.UserStatus(m.Status),
+		StatusReason:    m.StatusReason,
+		StatusChangedAt: m.StatusChangedAt,
+		CreatedAt:       m.CreatedAt,
+		UpdatedAt:       m.UpdatedAt,
+	}
+	if m.Email != nil {
+		u.Email = *m.Email
+	}
+	if m.DeletedAt != 0 {
+		deletedAt := time.Unix(int64(m.DeletedAt), 0)
+		u.DeletedAt = &deletedAt
 	}
+	return u
 }
@@ -0,0 +1,41 @@
+package domain
+
+import "time"
+
+type AuditEventType string
+
+const (
+	AuditLoginSuccess       AuditEventType = "login.success"
+	AuditLoginFailure       AuditEventType = "login.failure"
+	AuditIPBlocked          AuditEventType = "login.ip_blocked"
+	AuditIPSpraying         AuditEventType = "login.ip_spraying"
+	AuditAccountLocked      AuditEventType = "login.account_locked"
+	AuditAccountUnlocked    AuditEventType = "login.account_unlocked"
+	AuditLogout             AuditEventType = "logout"
+	AuditRefreshFailure     AuditEventType = "token.refresh_failure"
+	AuditRefreshReuse       AuditEventType = "token.refresh_reuse"
+	AuditPasswordChange     AuditEventType = "password.change"
+	AuditPasswordReset      AuditEventType = "password.reset"
+	AuditPasswordForceReset AuditEventType = "password.force_reset"
+	AuditUserStatusChange   AuditEventType = "user.status_change"
+	AuditUserRestore        AuditEventType = "user.restore"
+	AuditUserPlanChange     AuditEventType = "user.plan_change"
+	AuditSessionsRevoke     AuditEventType = "session.revoke_all"
+	AuditProtectionBlock    AuditEventType = "protection.block"
+	AuditProtectionUnblock  AuditEventType = "protection.unblock"
+	AuditAllowlistAdd       AuditEventType = "protection.allowlist_add"
+	AuditAllowlistRemove    AuditEventType = "protection.allowlist_remove"
+	AuditIPFilterChange     AuditEventType = "protection.ip_filter_change"
+)
+
+type AuditEvent struct {
+	ID        uint
+	Type      AuditEventType
+	ActorID   string
+	TargetID  string
+	IP        string
+	UserAgent string
+	LogID     string
+	Details   map[string]any
+	CreatedAt time.Time
+}
@@ -0,0 +1,14 @@
+package domain
+
+import "time"
+
+// Device is a logged-in session of a user, ID is the public handle of SessionID.
+type Device struct {
+	ID         string
+	SessionID  string
+	UserID     string
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"sort"
+	"strings"
+)
+
+const RoleAdmin = "admin"
+
+const (
+	PermAll = "*"
+
+	PermUserRead  = "user:read"
+	PermUserWrite = "user:write"
+	PermAuditRead = "audit:read"
+
+	PermProtectionRead  = "protection:read"
+	PermProtectionWrite = "protection:write"
+)
+
+// PermissionSet answers permission checks, a set granting "*" or "user:*" also grants "user:read".
+type PermissionSet map[string]struct{}
+
+func NewPermissionSet(perms []string) PermissionSet {
+	set := make(PermissionSet, len(perms))
+	for _, p := range perms {
+		set[p] = struct{}{}
+	}
+	return set
+}
+
+func (s PermissionSet) Has(perm string) bool {
+	if _, ok := s[PermAll]; ok {
+		return true
+	}
+	if _, ok := s[perm]; ok {
+		return true
+	}
+	if resource, _, ok := strings.Cut(perm, ":"); ok {
+		_, ok = s[resource+":*"]
+		return ok
+	}
+	return false
+}
+
+func (s PermissionSet) List() []string {
+	list := make([]string, 0, len(s))
+	for p := range s {
+		list = append(list, p)
+	}
+	sort.Strings(list)
+	return list
+}
@@ -2,10 +2,51 @@ package domain
 
 import "time"
 
+type UserStatus string
+
+const (
+	UserStatusPending  UserStatus = "pending"  // created but not activated yet
+	UserStatusActive   UserStatus = "active"   // the only status which may log in
+	UserStatusLocked   UserStatus = "locked"   // temporarily suspended, e.g. for security reasons
+	UserStatusDisabled UserStatus = "disabled" // turned off by an administrator
+)
+
+// userStatusTransitions lists the statuses each status may change to.
+var userStatusTransitions = map[UserStatus][]UserStatus{
+	UserStatusPending:  {UserStatusActive, UserStatusDisabled},
+	UserStatusActive:   {UserStatusLocked, UserStatusDisabled},
+	UserStatusLocked:   {UserStatusActive, UserStatusDisabled},
+	UserStatusDisabled: {UserStatusActive},
+}
+
+func (s UserStatus) Valid() bool {
+	_, ok := userStatusTransitions[s]
+	return ok
+}
+
+func (s UserStatus) Active() bool {
+	return s == UserStatusActive
+}
+
+func (s UserStatus) CanTransitionTo(to UserStatus) bool {
+	for _, next := range userStatusTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
 type User struct {
-	UserID    string
-	Account   string
-	Name      string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	UserID          string
+	Account         string
+	Name            string
+	Email           string
+	EmailVerified   bool
+	Status          UserStatus
+	StatusReason    string
+	StatusChangedAt *time.Time
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	DeletedAt       *time.Time // set on soft-deleted users only
 }
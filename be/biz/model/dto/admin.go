@@ -0,0 +1,77 @@
+package dto
+
+type AdminListUsersReq struct {
+	Page        int    `query:"page" validate:"omitempty,min=1"`
+	PageSize    int    `query:"page_size" validate:"omitempty,min=1,max=100"`
+	Account     string `query:"account" validate:"max=64"` // account prefix
+	UserID      string `query:"user_id" validate:"max=64"`
+	CreatedFrom int64  `query:"created_from" validate:"min=0"` // unix seconds, inclusive
+	CreatedTo   int64  `query:"created_to" validate:"min=0"`   // unix seconds, exclusive
+	Deleted     bool   `query:"deleted"`                       // also list soft-deleted users
+}
+
+type AdminUserInfo struct {
+	UserID          string `json:"user_id"`
+	Account         string `json:"account"`
+	Name            string `json:"name"`
+	Email           string `json:"email"`
+	EmailVerified   bool   `json:"email_verified"`
+	Status          string `json:"status"`
+	StatusReason    string `json:"status_reason"`
+	StatusChangedAt int64  `json:"status_changed_at,omitempty"`
+	CreatedAt       int64  `json:"created_at"`
+	UpdatedAt       int64  `json:"updated_at"`
+	DeletedAt       int64  `json:"deleted_at,omitempty"`
+}
+
+type AdminListUsersResp struct {
+	Users    []AdminUserInfo `json:"users"`
+	Total    int64           `json:"total"`
+	Page     int             `json:"page"`
+	PageSize int             `json:"page_size"`
+}
+
+type AdminGetUserReq struct {
+	UserID string `path:"user_id" validate:"required,max=64"`
+}
+
+type AdminGetUserResp struct {
+	AdminUserInfo
+	Roles []string `json:"roles"`
+}
+
+type AdminChangeUserStatusReq struct {
+	UserID string `path:"user_id" validate:"required,max=64"`
+	Reason string `json:"reason" validate:"max=255"`
+}
+
+type AdminChangeUserStatusResp struct{}
+
+type AdminResetPasswordReq struct {
+	UserID string `path:"user_id" validate:"required,max=64"`
+}
+
+type AdminResetPasswordResp struct {
+	// Mailed is false when the user has no verified email to send the reset link to
+	Mailed bool `json:"mailed"`
+}
+
+type AdminRevokeSessionsReq struct {
+	UserID string `path:"user_id" validate:"required,max=64"`
+}
+
+type AdminRevokeSessionsResp struct {
+	Revoked int `json:"revoked"`
+}
+
+type AdminUnlockUserReq struct {
+	UserID string `path:"user_id" validate:"required,max=64"`
+}
+
+type AdminUnlockUserResp struct{}
+
+type AdminRestoreUserReq struct {
+	UserID string `path:"user_id" validate:"required,max=64"`
+}
+
+type AdminRestoreUserResp struct{}
@@ -0,0 +1,40 @@
+package dto
+
+type AuditEventInfo struct {
+	ID        uint           `json:"id"`
+	EventType string         `json:"event_type"`
+	ActorID   string         `json:"actor_id"`
+	TargetID  string         `json:"target_id"`
+	IP        string         `json:"ip"`
+	UserAgent string         `json:"user_agent"`
+	LogID     string         `json:"log_id"`
+	Details   map[string]any `json:"details,omitempty"`
+	CreatedAt int64          `json:"created_at"`
+}
+
+type ListAuditEventsResp struct {
+	Events   []AuditEventInfo `json:"events"`
+	Total    int64            `json:"total"`
+	Page     int              `json:"page"`
+	PageSize int              `json:"page_size"`
+}
+
+type ListMyAuditEventsReq struct {
+	Page      int    `query:"page" validate:"omitempty,min=1"`
+	PageSize  int    `query:"page_size" validate:"omitempty,min=1,max=100"`
+	EventType string `query:"event_type" validate:"max=64"`
+	From      int64  `query:"from" validate:"min=0"` // unix seconds, inclusive
+	To        int64  `query:"to" validate:"min=0"`   // unix seconds, exclusive
+}
+
+type AdminListAuditEventsReq struct {
+	Page      int    `query:"page" validate:"omitempty,min=1"`
+	PageSize  int    `query:"page_size" validate:"omitempty,min=1,max=100"`
+	UserID    string `query:"user_id" validate:"max=64"` // actor or target
+	ActorID   string `query:"actor_id" validate:"max=64"`
+	TargetID  string `query:"target_id" validate:"max=64"`
+	EventType string `query:"event_type" validate:"max=64"`
+	IP        string `query:"ip" validate:"max=64"`
+	From      int64  `query:"from" validate:"min=0"` // unix seconds, inclusive
+	To        int64  `query:"to" validate:"min=0"`   // unix seconds, exclusive
+}
@@ -6,3 +6,20 @@ type CommonResp struct {
 	Message string `json:"message"`
 	Data    any    `json:"data,omitempty"`
 }
+
+// BlockedData is the data of 403 responses to blocked requests, the Retry-After header carries
+// RetryAfter as well.
+type BlockedData struct {
+	Tier         int   `json:"tier,omitempty"`
+	RetryAfter   int64 `json:"retry_after"`   // seconds
+	BlockedUntil int64 `json:"blocked_until"` // unix seconds
+}
+
+// ChallengeData is the data of 403 responses asking for a challenge. The solution is sent back with
+// the retried request in the X-Challenge-Id and X-Challenge-Solution headers.
+type ChallengeData struct {
+	ID        string         `json:"id"`
+	Provider  string         `json:"provider"`
+	Params    map[string]any `json:"params,omitempty"`
+	ExpiresAt int64          `json:"expires_at"` // unix seconds
+}
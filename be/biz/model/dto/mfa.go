@@ -0,0 +1,27 @@
+package dto
+
+type VerifyMFAReq struct {
+	Challenge string `json:"challenge" validate:"required,max=64"`
+	Code      string `json:"code" validate:"required,min=6,max=16"`
+}
+
+type EnrollTOTPReq struct{}
+
+type EnrollTOTPResp struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+}
+
+type ConfirmTOTPReq struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type ConfirmTOTPResp struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type DisableTOTPReq struct {
+	Code string `json:"code" validate:"required,min=6,max=16"`
+}
+
+type DisableTOTPResp struct{}
@@ -0,0 +1,16 @@
+package dto
+
+type OIDCAuthorizeReq struct {
+	Provider string `path:"provider" validate:"required,max=64"`
+}
+
+type OIDCAuthorizeResp struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+type OIDCCallbackReq struct {
+	Code  string `json:"code" validate:"required,max=2048"`
+	State string `json:"state" validate:"required,max=64"`
+}
+
+type OIDCLinkCallbackResp struct{}
@@ -0,0 +1,17 @@
+package dto
+
+import "github.com/go-webauthn/webauthn/protocol"
+
+type BeginPasskeyRegistrationReq struct{}
+
+type BeginPasskeyRegistrationResp struct {
+	Options *protocol.CredentialCreation `json:"options" swaggertype:"object"`
+}
+
+type FinishPasskeyRegistrationResp struct{}
+
+type BeginPasskeyLoginReq struct{}
+
+type BeginPasskeyLoginResp struct {
+	Options *protocol.CredentialAssertion `json:"options" swaggertype:"object"`
+}
@@ -0,0 +1,14 @@
+package dto
+
+type ForgotPasswordReq struct {
+	Account string `json:"account" validate:"required,min=6,max=255"` // account or email
+}
+
+type ForgotPasswordResp struct{}
+
+type ResetPasswordReq struct {
+	Token       string `json:"token" validate:"required,max=64"`
+	NewPassword string `json:"new_password" validate:"required,min=8,max=128"`
+}
+
+type ResetPasswordResp struct{}
@@ -0,0 +1,62 @@
+package dto
+
+type BlockInfo struct {
+	Target       string `json:"target"` // ip, account
+	Value        string `json:"value"`
+	Reason       string `json:"reason"`        // manual, login_failures, registration, password_spraying, account_failures
+	Detail       string `json:"detail"`        // the note of manual blocks, the tier of login blocks, failure counts otherwise
+	RetryAfter   int64  `json:"retry_after"`   // seconds, 0 for blocks lasting until they are lifted
+	BlockedUntil int64  `json:"blocked_until"` // unix seconds, 0 for blocks lasting until they are lifted
+}
+
+type AdminListBlocksResp struct {
+	Blocks []BlockInfo `json:"blocks"`
+}
+
+type AdminBlockReq struct {
+	IP      string `json:"ip" validate:"required_without=Account,excluded_with=Account,omitempty,ip"`
+	Account string `json:"account" validate:"required_without=IP,omitempty,max=128"`
+	Minutes int    `json:"minutes" validate:"min=0,max=525600"` // 0 blocks until unblocked
+	Note    string `json:"note" validate:"max=255"`
+}
+
+type AdminBlockResp struct{}
+
+type AdminUnblockReq struct {
+	IP      string `json:"ip" validate:"required_without=Account,excluded_with=Account,omitempty,ip"`
+	Account string `json:"account" validate:"required_without=IP,omitempty,max=128"`
+}
+
+type AdminUnblockResp struct {
+	// Unblocked is false when there was no block to lift
+	Unblocked bool `json:"unblocked"`
+}
+
+type AdminAllowlistResp struct {
+	Entries []string `json:"entries"`
+}
+
+type AdminAllowlistReq struct {
+	Entry string `json:"entry" validate:"required,ip|cidr"` // IP or CIDR range
+}
+
+type AdminAddAllowlistResp struct{}
+
+type AdminRemoveAllowlistResp struct {
+	Removed bool `json:"removed"`
+}
+
+type AdminIPFilterResp struct {
+	Allow []string `json:"allow"` // set at runtime
+	Deny  []string `json:"deny"`
+	// the rules of the config, they apply besides those set at runtime and cannot be changed here
+	ConfigAllow []string `json:"config_allow"`
+	ConfigDeny  []string `json:"config_deny"`
+}
+
+type AdminSetIPFilterReq struct {
+	Allow []string `json:"allow" validate:"max=1000,dive,ip|cidr"`
+	Deny  []string `json:"deny" validate:"max=1000,dive,ip|cidr"`
+}
+
+type AdminSetIPFilterResp struct{}
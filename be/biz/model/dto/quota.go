@@ -0,0 +1,25 @@
+package dto
+
+type GetQuotasReq struct{}
+
+type FeatureQuota struct {
+	Feature      string `json:"feature"`
+	Daily        int64  `json:"daily"` // 0 is unlimited
+	DailyUsed    int64  `json:"daily_used"`
+	DailyReset   int64  `json:"daily_reset"` // unix seconds, UTC midnight
+	Monthly      int64  `json:"monthly"`     // 0 is unlimited
+	MonthlyUsed  int64  `json:"monthly_used"`
+	MonthlyReset int64  `json:"monthly_reset"`
+}
+
+type GetQuotasResp struct {
+	Plan   string         `json:"plan"` // empty when the user is not metered
+	Quotas []FeatureQuota `json:"quotas"`
+}
+
+type AdminAssignPlanReq struct {
+	UserID string `path:"user_id" validate:"required,max=64"`
+	Plan   string `json:"plan" validate:"required,max=64"`
+}
+
+type AdminAssignPlanResp struct{}
@@ -0,0 +1,26 @@
+package dto
+
+type GetRateLimitsReq struct{}
+
+type RateLimitQuota struct {
+	Name          string   `json:"name"`
+	Path          string   `json:"path"`
+	Methods       []string `json:"methods"`
+	WindowSeconds int64    `json:"window_seconds"`
+	Limit         int64    `json:"limit"`
+	Remaining     int64    `json:"remaining"`
+	Reset         int64    `json:"reset"`       // seconds until the whole quota is back
+	RetryAfter    int64    `json:"retry_after"` // seconds until the next request is allowed, 0 when it is
+}
+
+type GetRateLimitsResp struct {
+	Quotas []RateLimitQuota `json:"quotas"`
+}
+
+type AdminRateLimitStatusResp struct {
+	Mode      string `json:"mode"`  // redis, or local while Redis fails
+	Since     int64  `json:"since"` // when the mode last changed, 0 before Redis ever failed
+	Failures  int    `json:"failures"`
+	Instances int    `json:"instances"`  // each instance allows its share of a limit in local mode
+	LocalKeys int    `json:"local_keys"` // counters held in process by this instance
+}
@@ -0,0 +1,8 @@
+package dto
+
+type GetPermissionsReq struct{}
+
+type GetPermissionsResp struct {
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
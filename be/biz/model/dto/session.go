@@ -0,0 +1,22 @@
+package dto
+
+type ListSessionsReq struct{}
+
+type SessionInfo struct {
+	ID         string `json:"id"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+	CreatedAt  int64  `json:"created_at"`
+	LastSeenAt int64  `json:"last_seen_at"`
+	Current    bool   `json:"current"`
+}
+
+type ListSessionsResp struct {
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+type RevokeSessionReq struct {
+	ID string `path:"id" validate:"required,max=64"`
+}
+
+type RevokeSessionResp struct{}
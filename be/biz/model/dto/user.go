@@ -4,6 +4,7 @@ type RegisterReq struct {
 	Account  string `json:"account" validate:"required,min=6,max=64"`
 	Name     string `json:"name" validate:"required,min=6,max=64"`
 	Password string `json:"password" validate:"required,min=8,max=128"`
+	Email    string `json:"email" validate:"omitempty,email,max=255"`
 }
 
 type RegisterResp struct {
@@ -11,13 +12,17 @@ type RegisterResp struct {
 }
 
 type LoginReq struct {
-	Account  string `json:"account" validate:"min=6,max=64"`
+	Account  string `json:"account" validate:"min=6,max=255"` // account or email
 	Password string `json:"password" validate:"required,min=8,max=128"`
 }
 
 type LoginResp struct {
 	AccessToken string `json:"access_token"`
 	ExpiresAt   int64  `json:"expires_at"`
+
+	// set instead of the token when the account has two-factor authentication enabled
+	MFARequired  bool   `json:"mfa_required,omitempty"`
+	MFAChallenge string `json:"mfa_challenge,omitempty"`
 }
 
 type RefreshTokenReq struct {
@@ -37,11 +42,13 @@ type LogoutResp struct{}
 type GetUserInfoReq struct{}
 
 type GetUserInfoResp struct {
-	UserID    string `json:"user_id"`
-	Account   string `json:"account"`
-	Name      string `json:"name"`
-	CreatedAt int64  `json:"created_at"`
-	UpdatedAt int64  `json:"updated_at"`
+	UserID        string `json:"user_id"`
+	Account       string `json:"account"`
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	CreatedAt     int64  `json:"created_at"`
+	UpdatedAt     int64  `json:"updated_at"`
 }
 
 type UpdateInfoReq struct {
@@ -56,3 +63,15 @@ type UpdatePasswordReq struct {
 }
 
 type UpdatePasswordResp struct{}
+
+type UpdateEmailReq struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+type UpdateEmailResp struct{}
+
+type VerifyEmailReq struct {
+	Token string `json:"token" validate:"required,max=1024"`
+}
+
+type VerifyEmailResp struct{}
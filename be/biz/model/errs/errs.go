@@ -66,9 +66,33 @@ var (
 	LoginReachLimit = New(1_0005, "login reach limit")
 	RequestBlocked  = New(1_0006, "request is blocked")
 	SessionExpired  = New(1_0007, "session expired")
+	Forbidden       = New(1_0008, "permission denied")
+	AccountLocked   = New(1_0009, "account temporarily locked")
+	ChallengeNeeded = New(1_0010, "challenge required")
+	ChallengeFailed = New(1_0011, "challenge invalid or expired")
+	QuotaExceeded   = New(1_0012, "quota exceeded")
 
-	UserNotExist          = New(2_0001, "user not exist or password incorrect")
-	PasswordIncorrect     = UserNotExist
-	UserStatusInvalid     = New(2_0002, "user is invalid")
-	UserNameDuplicatedErr = New(2_0003, "user name duplicated")
+	UserNotExist           = New(2_0001, "user not exist or password incorrect")
+	PasswordIncorrect      = UserNotExist
+	UserStatusInvalid      = New(2_0002, "user is invalid")
+	UserNameDuplicatedErr  = New(2_0003, "user name duplicated")
+	MFACodeInvalid         = New(2_0004, "mfa code invalid")
+	MFAChallengeInvalid    = New(2_0005, "mfa challenge invalid or expired")
+	MFAAlreadyEnabled      = New(2_0006, "mfa already enabled")
+	MFANotEnabled          = New(2_0007, "mfa not enabled")
+	PasskeyInvalid         = New(2_0008, "passkey verification failed")
+	PasskeyCeremonyExpired = New(2_0009, "passkey ceremony expired or not started")
+	OIDCProviderNotFound   = New(2_0010, "identity provider not found")
+	OIDCStateInvalid       = New(2_0011, "oidc state invalid or expired")
+	OIDCTokenInvalid       = New(2_0012, "oidc token invalid")
+	OIDCIdentityLinked     = New(2_0013, "identity already linked to another user")
+	OIDCIdentityNotLinked  = New(2_0014, "identity not linked to any user")
+	SessionNotFound        = New(2_0015, "session not found")
+	PasswordResetInvalid   = New(2_0016, "password reset token invalid or expired")
+	EmailDuplicated        = New(2_0017, "email already in use")
+	EmailNotVerified       = New(2_0018, "email not verified")
+	EmailVerifyInvalid     = New(2_0019, "email verification link invalid or expired")
+	UserStatusTransition   = New(2_0020, "user status transition not allowed")
+	RoleNotFound           = New(2_0021, "role not found")
+	PlanNotFound           = New(2_0022, "plan not found")
 )
@@ -0,0 +1,21 @@
+package errs
+
+import (
+	"context"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// Wrap turns the error of an action into a business error and logs it. Business errors are kept
+// as they are, any other error becomes a ServerError.
+func Wrap(ctx context.Context, action string, err error) Error {
+	if err == nil {
+		return nil
+	}
+	if bizErr, ok := err.(Error); ok {
+		hlog.CtxNoticef(ctx, "%s err: %v", action, bizErr)
+		return bizErr
+	}
+	hlog.CtxErrorf(ctx, "%s err: %v", action, err)
+	return ServerError.SetErr(err)
+}
@@ -0,0 +1,20 @@
+package storage
+
+import "time"
+
+// AuditEventRecord is append-only, it is never updated or deleted by the application.
+type AuditEventRecord struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"not null;index"`                    // 事件时间
+	EventType string    `gorm:"size:64;not null;index"`            // 事件类型，如 login.failure
+	ActorId   string    `gorm:"size:64;not null;default:'';index"` // 操作者用户ID，未登录时为空
+	TargetId  string    `gorm:"size:64;not null;default:'';index"` // 被操作的用户ID
+	IP        string    `gorm:"size:64;not null;default:''"`       // 客户端IP
+	UserAgent string    `gorm:"size:255;not null;default:''"`      // 客户端User-Agent
+	LogId     string    `gorm:"size:64;not null;default:''"`       // 请求链路ID
+	Details   string    `gorm:"type:text"`                         // 结构化详情，JSON
+}
+
+func (AuditEventRecord) TableName() string {
+	return "audit_events"
+}
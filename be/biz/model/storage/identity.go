@@ -0,0 +1,17 @@
+package storage
+
+import "time"
+
+type UserIdentityRecord struct {
+	GormModel
+	UserId     string     `gorm:"size:64;not null;index"`                                    // 用户索引
+	Provider   string     `gorm:"size:64;not null"`                                          // 配置中的IdP名称
+	Issuer     string     `gorm:"size:255;not null;uniqueIndex:idx_user_identities_iss_sub"` // ID token中的iss
+	Subject    string     `gorm:"size:255;not null;uniqueIndex:idx_user_identities_iss_sub"` // ID token中的sub
+	Email      string     `gorm:"size:255;not null"`                                         // 绑定时IdP返回的邮箱，仅展示
+	LastUsedAt *time.Time // 最近一次登录时间
+}
+
+func (UserIdentityRecord) TableName() string {
+	return "user_identities"
+}
@@ -0,0 +1,26 @@
+package storage
+
+import "time"
+
+type UserTOTPRecord struct {
+	GormModel
+	UserId       string `gorm:"size:64;not null;uniqueIndex"` // 用户唯一索引
+	Secret       string `gorm:"size:64;not null"`             // base32 TOTP密钥
+	Enabled      bool   `gorm:"default:false;not null"`       // 是否已确认启用
+	LastUsedStep int64  `gorm:"default:0;not null"`           // 最近一次使用的时间步，防重放
+}
+
+func (UserTOTPRecord) TableName() string {
+	return "user_totp"
+}
+
+type UserRecoveryCodeRecord struct {
+	GormModel
+	UserId   string     `gorm:"size:64;not null;index"` // 用户索引
+	CodeHash string     `gorm:"size:64;not null"`       // 恢复码SHA-256
+	UsedAt   *time.Time // 使用时间，为空表示未使用
+}
+
+func (UserRecoveryCodeRecord) TableName() string {
+	return "user_recovery_codes"
+}
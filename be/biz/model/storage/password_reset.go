@@ -0,0 +1,15 @@
+package storage
+
+import "time"
+
+type UserPasswordResetRecord struct {
+	GormModel
+	UserId    string     `gorm:"size:64;not null;index"`       // 用户索引
+	TokenHash string     `gorm:"size:64;not null;uniqueIndex"` // 重置令牌SHA-256
+	ExpiresAt time.Time  `gorm:"not null"`                     // 过期时间
+	UsedAt    *time.Time // 使用时间，为空表示未使用
+}
+
+func (UserPasswordResetRecord) TableName() string {
+	return "user_password_resets"
+}
@@ -0,0 +1,50 @@
+package storage
+
+import "time"
+
+type PlanRecord struct {
+	GormModel
+	Name        string `gorm:"size:64;not null;uniqueIndex"` // 套餐唯一名称
+	Description string `gorm:"size:255;not null"`            // 套餐说明
+}
+
+func (PlanRecord) TableName() string {
+	return "plans"
+}
+
+type PlanQuotaRecord struct {
+	GormModel
+	Plan    string `gorm:"size:64;not null;uniqueIndex:idx_plan_quotas_plan_feature"` // 套餐名称
+	Feature string `gorm:"size:64;not null;uniqueIndex:idx_plan_quotas_plan_feature"` // 计量功能，如 api
+	Daily   int64  `gorm:"not null;default:0"`                                        // 每日配额，0 表示不限
+	Monthly int64  `gorm:"not null;default:0"`                                        // 每月配额，0 表示不限
+}
+
+func (PlanQuotaRecord) TableName() string {
+	return "plan_quotas"
+}
+
+type UserPlanRecord struct {
+	GormModel
+	UserId string `gorm:"size:64;not null;uniqueIndex"` // 用户ID
+	Plan   string `gorm:"size:64;not null"`             // 套餐名称
+}
+
+func (UserPlanRecord) TableName() string {
+	return "user_plans"
+}
+
+// QuotaUsageRecord is the rollup of the usage counted in Redis, rewritten with the running total
+// of the period.
+type QuotaUsageRecord struct {
+	ID        uint      `gorm:"primarykey"`
+	UpdatedAt time.Time // 最近一次汇总时间
+	UserId    string    `gorm:"size:64;not null;uniqueIndex:idx_quota_usages_user_feature_period"` // 用户ID
+	Feature   string    `gorm:"size:64;not null;uniqueIndex:idx_quota_usages_user_feature_period"` // 计量功能
+	Period    string    `gorm:"size:16;not null;uniqueIndex:idx_quota_usages_user_feature_period"` // 统计周期，UTC，日为 2006-01-02，月为 2006-01
+	Used      int64     `gorm:"not null;default:0"`                                                // 周期内用量
+}
+
+func (QuotaUsageRecord) TableName() string {
+	return "quota_usages"
+}
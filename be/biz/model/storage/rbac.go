@@ -0,0 +1,31 @@
+package storage
+
+type RoleRecord struct {
+	GormModel
+	Name        string `gorm:"size:64;not null;uniqueIndex"` // 角色唯一名称
+	Description string `gorm:"size:255;not null"`            // 角色说明
+}
+
+func (RoleRecord) TableName() string {
+	return "roles"
+}
+
+type RolePermissionRecord struct {
+	GormModel
+	Role       string `gorm:"size:64;not null;uniqueIndex:idx_role_permissions_role_permission"` // 角色名称
+	Permission string `gorm:"size:64;not null;uniqueIndex:idx_role_permissions_role_permission"` // 权限，如 user:read，* 表示全部
+}
+
+func (RolePermissionRecord) TableName() string {
+	return "role_permissions"
+}
+
+type UserRoleRecord struct {
+	GormModel
+	UserId string `gorm:"size:64;not null;uniqueIndex:idx_user_roles_user_id_role"` // 用户ID
+	Role   string `gorm:"size:64;not null;uniqueIndex:idx_user_roles_user_id_role"` // 角色名称
+}
+
+func (UserRoleRecord) TableName() string {
+	return "user_roles"
+}
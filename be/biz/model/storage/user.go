@@ -15,9 +15,14 @@ type GormModel struct {
 
 type UserRecord struct {
 	GormModel
-	UserId  string `gorm:"size:64;not null;uniqueIndex"` // 用户唯一索引
-	Account string `gorm:"size:64;not null;uniqueIndex"` // 用户唯一登录账号
-	Name    string `gorm:"size:64;not null"`             // 用户姓名
+	UserId          string     `gorm:"size:64;not null;uniqueIndex"` // 用户唯一索引
+	Account         string     `gorm:"size:64;not null;uniqueIndex"` // 用户唯一登录账号
+	Name            string     `gorm:"size:64;not null"`             // 用户姓名
+	Email           *string    `gorm:"size:255;uniqueIndex"`         // 邮箱，可用于登录，为空表示未设置
+	EmailVerifiedAt *time.Time // 邮箱验证时间，为空表示未验证
+	Status          string     `gorm:"size:16;not null;default:active"` // 用户状态: pending, active, locked, disabled
+	StatusReason    string     `gorm:"size:255;not null;default:''"`    // 最近一次状态变更原因
+	StatusChangedAt *time.Time // 最近一次状态变更时间
 }
 
 func (UserRecord) TableName() string {
@@ -27,9 +32,9 @@ func (UserRecord) TableName() string {
 type UserCredentialRecord struct {
 	GormModel
 	UserId            string `gorm:"size:64;not null;uniqueIndex"` // 用户唯一索引
-	PasswordSalt      string `gorm:"size:64;not null"`
-	PasswordHash      string `gorm:"size:128;not null"`
-	CredentialVersion uint   `gorm:"default:0;not null"` // 密码凭证版本
+	PasswordSalt      string `gorm:"size:64;not null"`             // 仅旧版SHA-256哈希使用
+	PasswordHash      string `gorm:"size:128;not null"`            // 自描述哈希，如 $argon2id$...
+	CredentialVersion uint   `gorm:"default:0;not null"`           // 密码凭证版本
 }
 
 func (UserCredentialRecord) TableName() string {
@@ -0,0 +1,20 @@
+package storage
+
+import "time"
+
+type UserWebAuthnCredentialRecord struct {
+	GormModel
+	UserId          string     `gorm:"size:64;not null;index"`         // 用户索引
+	CredentialId    string     `gorm:"size:255;not null;uniqueIndex"`  // base64url凭证ID
+	PublicKey       []byte     `gorm:"not null"`                       // COSE编码公钥
+	AttestationType string     `gorm:"size:32;not null"`               // attestation格式
+	AAGUID          string     `gorm:"column:aaguid;size:36;not null"` // 认证器型号
+	SignCount       uint32     `gorm:"default:0;not null"`             // 签名计数器
+	Transports      string     `gorm:"size:128;not null"`              // 逗号分隔的transport
+	Flags           uint8      `gorm:"default:0;not null"`             // 认证器flags(BE/BS)
+	LastUsedAt      *time.Time // 最近一次登录时间
+}
+
+func (UserWebAuthnCredentialRecord) TableName() string {
+	return "user_webauthn_credentials"
+}
@@ -0,0 +1,208 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/db/mysql"
+	"doing_now/be/biz/model/convert"
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/model/storage"
+	"doing_now/be/biz/util/trace_info"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+const (
+	defaultQueueSize = 4096
+	defaultBatchSize = 100
+
+	maxUserAgentLen = 255
+)
+
+// Event is a security event. The actor defaults to the signed-in user, IP, user agent and log ID
+// are taken from the request context.
+type Event struct {
+	Type     domain.AuditEventType
+	ActorID  string
+	TargetID string
+	Details  map[string]any
+}
+
+// Writer appends events to the audit log in the background, so recording never waits for the
+// database. Events that do not fit in the queue are dropped and logged.
+type Writer struct {
+	queue     chan *storage.AuditEventRecord
+	flushes   chan chan struct{}
+	batchSize int
+}
+
+var (
+	defaultWriter *Writer
+	defaultOnce   sync.Once
+)
+
+func NewWriter(conf config.AuditConf) *Writer {
+	queueSize := conf.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	batchSize := conf.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	w := &Writer{
+		queue:     make(chan *storage.AuditEventRecord, queueSize),
+		flushes:   make(chan chan struct{}),
+		batchSize: batchSize,
+	}
+	go w.run()
+	return w
+}
+
+// Default returns the writer shared by the process, it is started on first use.
+func Default() *Writer {
+	defaultOnce.Do(func() {
+		defaultWriter = NewWriter(config.GetAuditConf())
+	})
+	return defaultWriter
+}
+
+// Record queues the event on the default writer.
+func Record(ctx context.Context, e Event) {
+	Default().Record(ctx, e)
+}
+
+// Flush waits until the events recorded so far on the default writer are written.
+func Flush(ctx context.Context) error {
+	return Default().Flush(ctx)
+}
+
+func (w *Writer) Record(ctx context.Context, e Event) {
+	if e.ActorID == "" {
+		e.ActorID = trace_info.GetUserId(ctx)
+	}
+	client := trace_info.GetClient(ctx)
+	record := &storage.AuditEventRecord{
+		CreatedAt: time.Now(),
+		EventType: string(e.Type),
+		ActorId:   e.ActorID,
+		TargetId:  e.TargetID,
+		IP:        client.IP,
+		UserAgent: truncate(client.UserAgent, maxUserAgentLen),
+		LogId:     trace_info.GetLogId(ctx),
+	}
+	if len(e.Details) > 0 {
+		details, err := json.Marshal(e.Details)
+		if err != nil {
+			hlog.CtxErrorf(ctx, "marshal audit details err: %v, event: %s", err, e.Type)
+		} else {
+			record.Details = string(details)
+		}
+	}
+
+	select {
+	case w.queue <- record:
+	default:
+		hlog.CtxErrorf(ctx, "audit queue full, event dropped: %s, actor: %s, target: %s", e.Type, e.ActorID, e.TargetID)
+	}
+}
+
+func (w *Writer) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case w.flushes <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Writer) run() {
+	for {
+		select {
+		case record := <-w.queue:
+			w.write(w.collect(record))
+		case done := <-w.flushes:
+			for len(w.queue) > 0 {
+				w.write(w.collect(<-w.queue))
+			}
+			close(done)
+		}
+	}
+}
+
+// collect adds the events already waiting to the batch, without waiting for more.
+func (w *Writer) collect(first *storage.AuditEventRecord) []*storage.AuditEventRecord {
+	batch := []*storage.AuditEventRecord{first}
+	for len(batch) < w.batchSize {
+		select {
+		case record := <-w.queue:
+			batch = append(batch, record)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (w *Writer) write(batch []*storage.AuditEventRecord) {
+	ctx := context.Background()
+	db := mysql.GetDbConn()
+	if db == nil {
+		hlog.CtxErrorf(ctx, "audit events dropped, db not initialized: %d", len(batch))
+		return
+	}
+	if err := repo.NewAuditEventRepository(db).Create(ctx, batch); err != nil {
+		hlog.CtxErrorf(ctx, "write audit events err: %v, dropped: %d", err, len(batch))
+	}
+}
+
+// Service reads the audit log.
+type Service struct{}
+
+func New() *Service {
+	return &Service{}
+}
+
+func NewDefault() *Service {
+	return New()
+}
+
+// Search returns a page of events matching the filter, newest first, and the total number of matches.
+func (s *Service) Search(ctx context.Context, filter repo.AuditEventFilter) ([]*domain.AuditEvent, int64, errs.Error) {
+	events := repo.NewAuditEventRepository(mysql.GetDbConn().WithContext(ctx))
+	list, total, err := events.Search(ctx, filter)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "search audit events err: %v", err)
+		return nil, 0, errs.ServerError.SetErr(err)
+	}
+	result := make([]*domain.AuditEvent, 0, len(list))
+	for _, e := range list {
+		result = append(result, convert.AuditEventRecordToDomain(e))
+	}
+	return result, total, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	s = s[:n]
+	// do not cut a multi-byte character in half
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
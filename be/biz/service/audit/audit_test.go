@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/db/mysql"
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/storage"
+	"doing_now/be/biz/util/trace_info"
+
+	"github.com/bytedance/mockey"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setup(t *testing.T) *gorm.DB {
+	dsn := fmt.Sprintf("file:%s_%d?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"), time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	assert.NoError(t, err)
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	assert.NoError(t, db.AutoMigrate(&storage.AuditEventRecord{}))
+	return db
+}
+
+func TestWriter(t *testing.T) {
+	mockey.PatchConvey("audit writer", t, func() {
+		db := setup(t)
+		mockey.Mock(mysql.GetDbConn).Return(db).Build()
+
+		w := NewWriter(config.AuditConf{BatchSize: 2})
+		ctx := trace_info.WithLogId(context.Background(), "log01")
+		ctx = trace_info.WithClient(ctx, trace_info.Client{IP: "10.0.0.1", UserAgent: strings.Repeat("a", 300)})
+
+		w.Record(ctx, Event{Type: domain.AuditLoginFailure, TargetID: "user01", Details: map[string]any{"account": "account01"}})
+		// the actor defaults to the signed-in user
+		w.Record(trace_info.WithUserId(ctx, "user01"), Event{Type: domain.AuditLoginSuccess, TargetID: "user01"})
+		w.Record(ctx, Event{Type: domain.AuditPasswordChange, ActorID: "admin01", TargetID: "user02"})
+		assert.NoError(t, w.Flush(context.Background()))
+
+		svc := New()
+		events, total, bizErr := svc.Search(context.Background(), repo.AuditEventFilter{UserID: "user01", Limit: 10})
+		assert.Nil(t, bizErr)
+		assert.Equal(t, int64(2), total)
+		assert.Len(t, events, 2)
+
+		failure := events[1]
+		assert.Equal(t, domain.AuditLoginFailure, failure.Type)
+		assert.Equal(t, "", failure.ActorID)
+		assert.Equal(t, "10.0.0.1", failure.IP)
+		assert.Equal(t, "log01", failure.LogID)
+		assert.Len(t, failure.UserAgent, maxUserAgentLen)
+		assert.Equal(t, map[string]any{"account": "account01"}, failure.Details)
+
+		events, total, bizErr = svc.Search(context.Background(), repo.AuditEventFilter{
+			ActorID:   "admin01",
+			EventType: string(domain.AuditPasswordChange),
+			Limit:     10,
+		})
+		assert.Nil(t, bizErr)
+		assert.Equal(t, int64(1), total)
+		assert.Equal(t, "user02", events[0].TargetID)
+
+		_, total, bizErr = svc.Search(context.Background(), repo.AuditEventFilter{From: time.Now().Add(time.Minute), Limit: 10})
+		assert.Nil(t, bizErr)
+		assert.Zero(t, total)
+	})
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "abc", truncate("abc", 5))
+	assert.Equal(t, "ab", truncate("abc", 2))
+	// "你" is three bytes and is not cut in half
+	assert.Equal(t, "a", truncate("a你", 3))
+}
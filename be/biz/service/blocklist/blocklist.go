@@ -0,0 +1,345 @@
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	rediscli "doing_now/be/biz/db/redis"
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/audit"
+	"doing_now/be/biz/util/ip"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	TargetIP      = "ip"
+	TargetAccount = "account"
+
+	ReasonLoginFailures   = "login_failures"
+	ReasonRegistration    = "registration"
+	ReasonPasswordSpray   = "password_spraying"
+	ReasonAccountFailures = "account_failures"
+	ReasonManual          = "manual"
+
+	keyManualIP      = "manual_block:ip:"
+	keyManualAccount = "manual_block:account:"
+	keyAllowlist     = "protection_allowlist"
+
+	scanCount = 100
+	// more blocks than this are not listed
+	maxBlocks = 1000
+)
+
+// Source is where a protection keeps a kind of block. The protections register their sources,
+// so the keys and the failures behind a block stay with the package which sets them.
+type Source struct {
+	Target string // ip or account
+	Reason string
+	// Prefix is the key of a block without the IP or account, empty for a protection which only
+	// counts failures
+	Prefix string
+	// Lift lifts the block of the IP or account and forgets the failures which led to it, it
+	// reports whether there was a block to lift
+	Lift func(ctx context.Context, value string) (bool, errs.Error)
+}
+
+var (
+	// sourcesMu guards the sources and the account resolver
+	sourcesMu sync.RWMutex
+	// the manual blocks come first, the lift of their source is Unblock itself
+	sources = []Source{
+		{Target: TargetIP, Reason: ReasonManual, Prefix: keyManualIP},
+		{Target: TargetAccount, Reason: ReasonManual, Prefix: keyManualAccount},
+	}
+)
+
+// RegisterSource adds the blocks of a protection to the blocks which are listed and lifted, the
+// protections register their sources when their package is initialized.
+func RegisterSource(src Source) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	sources = append(sources, src)
+}
+
+func registeredSources() []Source {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+	return sources
+}
+
+// resolveAccount maps an account to the value its manual block is kept for, the accounts are only
+// normalized until a resolver is registered.
+var resolveAccount = func(_ context.Context, account string) string {
+	return normalize(TargetAccount, account)
+}
+
+// RegisterAccountResolver sets how the accounts of manual blocks are resolved. The lockout service
+// registers the subject it counts failures on, so blocking the account or the email of a user
+// blocks the user whichever one is used to log in.
+func RegisterAccountResolver(resolve func(ctx context.Context, account string) string) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	resolveAccount = resolve
+}
+
+// Block is a blocked IP or account.
+type Block struct {
+	Target string // ip or account
+	Value  string
+	Reason string
+	// Detail is what the block stores: the note of manual blocks, the tier of login blocks, the
+	// failures of locked accounts and the accounts of spraying IPs.
+	Detail string
+	// Remaining is zero for blocks which last until they are lifted
+	Remaining time.Duration
+}
+
+// Service shows and lifts the blocks of the login and registration protection, blocks IPs and
+// accounts by hand, and keeps the allowlist of IPs the protection never blocks.
+type Service struct{}
+
+func New() *Service {
+	return &Service{}
+}
+
+func NewDefault() *Service {
+	return New()
+}
+
+// List returns the current blocks, manual ones first.
+func (s *Service) List(ctx context.Context) ([]Block, errs.Error) {
+	rdb := rediscli.GetRedisClient()
+	var blocks []Block
+	for _, src := range registeredSources() {
+		if src.Prefix == "" {
+			continue
+		}
+		iter := rdb.Scan(ctx, 0, src.Prefix+"*", scanCount).Iterator()
+		var keys []string
+		for iter.Next(ctx) && len(blocks)+len(keys) < maxBlocks {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			hlog.CtxErrorf(ctx, "scan blocks err: %v", err)
+			return nil, errs.ServerError.SetErr(err)
+		}
+		if len(keys) == 0 {
+			continue
+		}
+
+		pipe := rdb.Pipeline()
+		gets := make([]*redis.StringCmd, len(keys))
+		ttls := make([]*redis.DurationCmd, len(keys))
+		for i, key := range keys {
+			gets[i] = pipe.Get(ctx, key)
+			ttls[i] = pipe.PTTL(ctx, key)
+		}
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			hlog.CtxErrorf(ctx, "get blocks err: %v", err)
+			return nil, errs.ServerError.SetErr(err)
+		}
+
+		for i, key := range keys {
+			// expired between the scan and the get
+			if errors.Is(gets[i].Err(), redis.Nil) {
+				continue
+			}
+			blocks = append(blocks, Block{
+				Target:    src.Target,
+				Value:     strings.TrimPrefix(key, src.Prefix),
+				Reason:    src.Reason,
+				Detail:    gets[i].Val(),
+				Remaining: max(ttls[i].Val(), 0),
+			})
+		}
+	}
+	return blocks, nil
+}
+
+// Block blocks the IP or account by hand for d, or until it is lifted when d is zero.
+func (s *Service) Block(ctx context.Context, target, value string, d time.Duration, note string) errs.Error {
+	key, value, bizErr := manualKey(ctx, target, value)
+	if bizErr != nil {
+		return bizErr
+	}
+	if err := rediscli.GetRedisClient().Set(ctx, key, note, d).Err(); err != nil {
+		hlog.CtxErrorf(ctx, "set manual block err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	hlog.CtxInfof(ctx, "%s %s blocked by hand for %v", target, value, d)
+	audit.Record(ctx, audit.Event{
+		Type:    domain.AuditProtectionBlock,
+		Details: map[string]any{target: value, "seconds": int64(d.Seconds()), "note": note},
+	})
+	return nil
+}
+
+// ManualBlock returns the manual block of the IP or account, nil when there is none. The blocks
+// are only kept in Redis, while it fails they cannot be read and an error is returned.
+func (s *Service) ManualBlock(ctx context.Context, target, value string) (*Block, errs.Error) {
+	key, value, bizErr := manualKey(ctx, target, value)
+	if bizErr != nil {
+		return nil, bizErr
+	}
+	pipe := rediscli.GetRedisClient().Pipeline()
+	get := pipe.Get(ctx, key)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		hlog.CtxErrorf(ctx, "get manual block err: %v", err)
+		return nil, errs.ServerError.SetErr(err)
+	}
+	if errors.Is(get.Err(), redis.Nil) {
+		return nil, nil
+	}
+	return &Block{
+		Target:    target,
+		Value:     value,
+		Reason:    ReasonManual,
+		Detail:    get.Val(),
+		Remaining: max(ttl.Val(), 0),
+	}, nil
+}
+
+// Unblock lifts every block of the IP or account and forgets the failures which led to them, so
+// the protection starts over. It reports whether there was anything to lift.
+func (s *Service) Unblock(ctx context.Context, target, value string) (bool, errs.Error) {
+	key, value, bizErr := manualKey(ctx, target, value)
+	if bizErr != nil {
+		return false, bizErr
+	}
+
+	n, err := rediscli.GetRedisClient().Del(ctx, key).Result()
+	if err != nil {
+		hlog.CtxErrorf(ctx, "delete manual block err: %v", err)
+		return false, errs.ServerError.SetErr(err)
+	}
+	unblocked := n > 0
+	for _, src := range registeredSources() {
+		if src.Target != target || src.Lift == nil {
+			continue
+		}
+		lifted, bizErr := src.Lift(ctx, value)
+		if bizErr != nil {
+			return false, bizErr
+		}
+		unblocked = unblocked || lifted
+	}
+	if !unblocked {
+		return false, nil
+	}
+	hlog.CtxInfof(ctx, "%s %s unblocked", target, value)
+	audit.Record(ctx, audit.Event{
+		Type:    domain.AuditProtectionUnblock,
+		Details: map[string]any{target: value},
+	})
+	return true, nil
+}
+
+// Allowlist returns the IPs and CIDR ranges the protection never blocks.
+func (s *Service) Allowlist(ctx context.Context) ([]string, errs.Error) {
+	entries, err := rediscli.GetRedisClient().SMembers(ctx, keyAllowlist).Result()
+	if err != nil {
+		hlog.CtxErrorf(ctx, "get allowlist err: %v", err)
+		return nil, errs.ServerError.SetErr(err)
+	}
+	sort.Strings(entries)
+	return entries, nil
+}
+
+// Allow adds an IP or CIDR range to the allowlist, it stays until it is removed.
+func (s *Service) Allow(ctx context.Context, entry string) errs.Error {
+	prefix, bizErr := parseEntry(entry)
+	if bizErr != nil {
+		return bizErr
+	}
+	if err := rediscli.GetRedisClient().SAdd(ctx, keyAllowlist, prefix.String()).Err(); err != nil {
+		hlog.CtxErrorf(ctx, "add allowlist entry err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	audit.Record(ctx, audit.Event{
+		Type:    domain.AuditAllowlistAdd,
+		Details: map[string]any{"entry": prefix.String()},
+	})
+	return nil
+}
+
+// Disallow removes an entry from the allowlist and reports whether it was there.
+func (s *Service) Disallow(ctx context.Context, entry string) (bool, errs.Error) {
+	prefix, bizErr := parseEntry(entry)
+	if bizErr != nil {
+		return false, bizErr
+	}
+	n, err := rediscli.GetRedisClient().SRem(ctx, keyAllowlist, prefix.String()).Result()
+	if err != nil {
+		hlog.CtxErrorf(ctx, "remove allowlist entry err: %v", err)
+		return false, errs.ServerError.SetErr(err)
+	}
+	if n == 0 {
+		return false, nil
+	}
+	audit.Record(ctx, audit.Event{
+		Type:    domain.AuditAllowlistRemove,
+		Details: map[string]any{"entry": prefix.String()},
+	})
+	return true, nil
+}
+
+// Allowed reports whether the IP is on the allowlist. When the allowlist cannot be read the IP is
+// treated as not allowed, so the protection stays in place.
+func (s *Service) Allowed(ctx context.Context, clientIP string) bool {
+	entries, err := rediscli.GetRedisClient().SMembers(ctx, keyAllowlist).Result()
+	if err != nil {
+		hlog.CtxErrorf(ctx, "get allowlist err: %v", err)
+		return false
+	}
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return ip.Contains(prefixes, clientIP)
+}
+
+func parseEntry(entry string) (netip.Prefix, errs.Error) {
+	prefix, err := ip.ParsePrefix(entry)
+	if err != nil {
+		return netip.Prefix{}, errs.ParamError.SetMsg("invalid ip or cidr")
+	}
+	return prefix, nil
+}
+
+// manualKey returns the key of the manual block of the IP or account and the value it is kept for,
+// accounts are kept for what they resolve to.
+func manualKey(ctx context.Context, target, value string) (string, string, errs.Error) {
+	switch target {
+	case TargetIP:
+		value = normalize(target, value)
+		return keyManualIP + value, value, nil
+	case TargetAccount:
+		sourcesMu.RLock()
+		resolve := resolveAccount
+		sourcesMu.RUnlock()
+		value = resolve(ctx, value)
+		return keyManualAccount + value, value, nil
+	default:
+		return "", "", errs.ParamError.SetMsg("unknown block target")
+	}
+}
+
+// normalize matches accounts case-insensitively like the lockout service does.
+func normalize(target, value string) string {
+	value = strings.TrimSpace(value)
+	if target == TargetAccount {
+		return strings.ToLower(value)
+	}
+	return value
+}
@@ -0,0 +1,139 @@
+package blocklist
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	db_redis "doing_now/be/biz/db/redis"
+	"doing_now/be/biz/model/errs"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bytedance/mockey"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_Blocks(t *testing.T) {
+	mockey.PatchConvey("blocks", t, func() {
+		ctx := context.Background()
+		mr := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+
+		// protections as they register their blocks, lifting a block forgets its failures
+		prev, prevResolve := sources, resolveAccount
+		t.Cleanup(func() { sources, resolveAccount = prev, prevResolve })
+		lift := func(keys ...string) func(context.Context, string) (bool, errs.Error) {
+			return func(ctx context.Context, value string) (bool, errs.Error) {
+				var del []string
+				for _, key := range keys {
+					del = append(del, key+value)
+				}
+				n, err := rdb.Del(ctx, del...).Result()
+				assert.Nil(t, err)
+				return n > 0, nil
+			}
+		}
+		RegisterSource(Source{Target: TargetIP, Reason: ReasonLoginFailures, Prefix: "login_block:", Lift: lift("login_block:", "login_fail:")})
+		RegisterSource(Source{Target: TargetAccount, Reason: ReasonAccountFailures, Prefix: "account_lock:", Lift: lift("account_lock:")})
+
+		s := New()
+		ip := "10.0.0.1"
+		assert.Nil(t, rdb.Set(ctx, "login_block:"+ip, 2, time.Hour).Err())
+		assert.Nil(t, rdb.Set(ctx, "login_fail:"+ip, 1, time.Hour).Err())
+		assert.Nil(t, rdb.Set(ctx, "account_lock:alice", 10, time.Minute).Err())
+		assert.Nil(t, s.Block(ctx, TargetAccount, "Bob", 0, "abuse"))
+
+		blocks, bizErr := s.List(ctx)
+		assert.Nil(t, bizErr)
+		assert.Equal(t, []Block{
+			{Target: TargetAccount, Value: "bob", Reason: ReasonManual, Detail: "abuse"},
+			{Target: TargetIP, Value: ip, Reason: ReasonLoginFailures, Detail: "2", Remaining: time.Hour},
+			{Target: TargetAccount, Value: "alice", Reason: ReasonAccountFailures, Detail: "10", Remaining: time.Minute},
+		}, blocks)
+
+		block, bizErr := s.ManualBlock(ctx, TargetAccount, "BOB")
+		assert.Nil(t, bizErr)
+		assert.Equal(t, "abuse", block.Detail)
+		block, _ = s.ManualBlock(ctx, TargetIP, ip)
+		assert.Nil(t, block)
+
+		t.Run("unblock ip forgets the escalation", func(t *testing.T) {
+			ok, bizErr := s.Unblock(ctx, TargetIP, ip)
+			assert.Nil(t, bizErr)
+			assert.True(t, ok)
+			assert.Equal(t, []string{"account_lock:alice", "manual_block:account:bob"}, mr.Keys())
+
+			ok, _ = s.Unblock(ctx, TargetIP, ip)
+			assert.False(t, ok)
+		})
+
+		t.Run("unblock account", func(t *testing.T) {
+			ok, _ := s.Unblock(ctx, TargetAccount, "Bob")
+			assert.True(t, ok)
+			assert.Equal(t, []string{"account_lock:alice"}, mr.Keys())
+		})
+
+		t.Run("accounts are kept for what they resolve to", func(t *testing.T) {
+			RegisterAccountResolver(func(_ context.Context, account string) string {
+				if account = strings.ToLower(account); account == "bob" || account == "bob@example.com" {
+					return "user:u1"
+				}
+				return "login:" + account
+			})
+			assert.Nil(t, s.Block(ctx, TargetAccount, "bob@example.com", 0, "abuse"))
+
+			block, _ := s.ManualBlock(ctx, TargetAccount, "Bob")
+			assert.Equal(t, &Block{Target: TargetAccount, Value: "user:u1", Reason: ReasonManual, Detail: "abuse"}, block)
+			assert.Equal(t, []string{"account_lock:alice", "manual_block:account:user:u1"}, mr.Keys())
+
+			ok, _ := s.Unblock(ctx, TargetAccount, "bob")
+			assert.True(t, ok)
+			assert.Equal(t, []string{"account_lock:alice"}, mr.Keys())
+		})
+
+		t.Run("manual blocks cannot be read while redis fails", func(t *testing.T) {
+			assert.Nil(t, s.Block(ctx, TargetIP, ip, 0, ""))
+			mr.SetError("LOADING")
+			defer mr.SetError("")
+			block, bizErr := s.ManualBlock(ctx, TargetIP, ip)
+			assert.NotNil(t, bizErr)
+			assert.Nil(t, block)
+		})
+
+		t.Run("unknown target", func(t *testing.T) {
+			_, bizErr := s.Unblock(ctx, "user", "alice")
+			assert.NotNil(t, bizErr)
+		})
+	})
+}
+
+func TestService_Allowlist(t *testing.T) {
+	mockey.PatchConvey("allowlist", t, func() {
+		ctx := context.Background()
+		mr := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+
+		s := New()
+		assert.Nil(t, s.Allow(ctx, "10.1.2.3/16"))
+		assert.Nil(t, s.Allow(ctx, "::ffff:192.168.0.1"))
+		assert.NotNil(t, s.Allow(ctx, "10.1"))
+
+		entries, _ := s.Allowlist(ctx)
+		assert.Equal(t, []string{"10.1.0.0/16", "192.168.0.1/32"}, entries)
+
+		assert.True(t, s.Allowed(ctx, "10.1.200.7"))
+		assert.True(t, s.Allowed(ctx, "192.168.0.1"))
+		assert.False(t, s.Allowed(ctx, "192.168.0.2"))
+		assert.False(t, s.Allowed(ctx, "unknown"))
+
+		removed, _ := s.Disallow(ctx, "10.1.0.0/16")
+		assert.True(t, removed)
+		assert.False(t, s.Allowed(ctx, "10.1.200.7"))
+		removed, _ = s.Disallow(ctx, "10.1.0.0/16")
+		assert.False(t, removed)
+	})
+}
@@ -0,0 +1,169 @@
+package challenge
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/blocklist"
+	"doing_now/be/biz/util/interceptor"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+const (
+	ProviderPoW  = "pow"
+	ProviderStub = "stub"
+
+	keyChallengeFail = "challenge_fail:"
+
+	challengeIDLen = 32
+
+	defaultWindow     = 15 * time.Minute
+	defaultTTL        = 2 * time.Minute
+	defaultDifficulty = 20
+)
+
+// Challenge is issued to a client which has to solve it before its request is processed.
+// Params tell the client how to solve it and depend on the provider.
+type Challenge struct {
+	ID        string
+	Provider  string
+	Params    map[string]any
+	ExpiresAt time.Time
+}
+
+// Provider issues and verifies challenges. A challenge is verified once, whatever the outcome.
+// Implementations must be safe for concurrent use.
+type Provider interface {
+	Name() string
+	Issue(ctx context.Context) (*Challenge, error)
+	Verify(ctx context.Context, id, solution string) (bool, error)
+}
+
+func NewProvider(conf config.ChallengeConf) (Provider, error) {
+	ttl := time.Duration(conf.TTLSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	switch conf.Provider {
+	case ProviderPoW, "":
+		difficulty := conf.Difficulty
+		if difficulty <= 0 {
+			difficulty = defaultDifficulty
+		}
+		return NewPoW(difficulty, ttl), nil
+	case ProviderStub:
+		// the stub tells its solution to every client, it is built by tests with NewStub only
+		return nil, fmt.Errorf("challenge provider %q is for tests only", conf.Provider)
+	default:
+		return nil, fmt.Errorf("unknown challenge provider %q", conf.Provider)
+	}
+}
+
+// Service asks for challenges from IPs with recent login failures, between letting them log in
+// freely and blocking them.
+type Service struct {
+	provider Provider
+	failures *interceptor.Interceptor
+	enabled  bool
+}
+
+func New(conf config.ChallengeConf, provider Provider) *Service {
+	window := time.Duration(conf.WindowSeconds) * time.Second
+	if window <= 0 {
+		window = defaultWindow
+	}
+	return &Service{
+		provider: provider,
+		failures: interceptor.NewInterceptor(int(window.Seconds()), int64(conf.After)),
+		enabled:  conf.After > 0 && provider != nil,
+	}
+}
+
+func init() {
+	// the failures of an IP are no block, unblocking it forgets them all the same
+	blocklist.RegisterSource(blocklist.Source{
+		Target: blocklist.TargetIP,
+		Lift: func(ctx context.Context, ip string) (bool, errs.Error) {
+			return false, forget(ctx, ip)
+		},
+	})
+}
+
+// NewDefault panics when the configured provider is invalid, the middlewares build it at startup,
+// so a typo stops the server instead of turning challenges off.
+func NewDefault() *Service {
+	conf := config.GetChallengeConf()
+	provider, err := NewProvider(conf)
+	if err != nil {
+		panic(fmt.Errorf("init challenge provider: %w", err))
+	}
+	return New(conf, provider)
+}
+
+// Required reports whether requests of the IP have to solve a challenge. It fails open when the
+// failures cannot be read.
+func (s *Service) Required(ctx context.Context, ip string) bool {
+	return s.enabled && s.failures.ReachLimit(ctx, keyChallengeFail+ip)
+}
+
+// Failure counts a failed login of the IP.
+func (s *Service) Failure(ctx context.Context, ip string) errs.Error {
+	if !s.enabled {
+		return nil
+	}
+	if _, err := s.failures.Allow(ctx, keyChallengeFail+ip); err != nil {
+		hlog.CtxErrorf(ctx, "count challenge failure err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	return nil
+}
+
+// Success forgets the failures of the IP.
+func (s *Service) Success(ctx context.Context, ip string) errs.Error {
+	if !s.enabled {
+		return nil
+	}
+	return forget(ctx, ip)
+}
+
+// forget clears the failures of the IP, the window and limit of the counter do not matter for it.
+func forget(ctx context.Context, ip string) errs.Error {
+	if err := interceptor.NewInterceptor(0, 0).Reset(ctx, keyChallengeFail+ip); err != nil {
+		hlog.CtxErrorf(ctx, "clear challenge failures err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	return nil
+}
+
+func (s *Service) Issue(ctx context.Context) (*Challenge, errs.Error) {
+	if !s.enabled {
+		return nil, errs.ServerError.SetMsg("challenge provider not available")
+	}
+	ch, err := s.provider.Issue(ctx)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "issue challenge err: %v", err)
+		return nil, errs.ServerError.SetErr(err)
+	}
+	return ch, nil
+}
+
+// Verify checks the solution of the challenge, a wrong or expired one is ChallengeFailed.
+func (s *Service) Verify(ctx context.Context, id, solution string) errs.Error {
+	if !s.enabled {
+		return errs.ServerError.SetMsg("challenge provider not available")
+	}
+	ok, err := s.provider.Verify(ctx, id, solution)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "verify challenge err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	if !ok {
+		return errs.ChallengeFailed
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package challenge
+
+import (
+	"context"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+	"time"
+
+	"doing_now/be/biz/config"
+	db_redis "doing_now/be/biz/db/redis"
+	"doing_now/be/biz/model/errs"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bytedance/mockey"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// solve brute-forces a proof of work the way a client does.
+func solve(prefix string, difficulty int) string {
+	for i := 0; ; i++ {
+		nonce := strconv.Itoa(i)
+		if leadingZeroBits(sha256.Sum256([]byte(prefix+nonce))) >= difficulty {
+			return nonce
+		}
+	}
+}
+
+func TestPoW(t *testing.T) {
+	mockey.PatchConvey("pow", t, func() {
+		ctx := context.Background()
+		mr := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+
+		p := NewPoW(8, time.Minute)
+
+		t.Run("solved", func(t *testing.T) {
+			ch, err := p.Issue(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, ProviderPoW, ch.Provider)
+			assert.Equal(t, ch.ID, ch.Params["prefix"])
+			assert.Equal(t, 8, ch.Params["difficulty"])
+
+			solution := solve(ch.ID, 8)
+			ok, err := p.Verify(ctx, ch.ID, solution)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+
+			// a solution is accepted once
+			ok, _ = p.Verify(ctx, ch.ID, solution)
+			assert.False(t, ok)
+		})
+
+		t.Run("wrong solution drops the challenge", func(t *testing.T) {
+			ch, _ := p.Issue(ctx)
+			solution := solve(ch.ID, 8)
+			wrong := solution + "x"
+			for leadingZeroBits(sha256.Sum256([]byte(ch.ID+wrong))) >= 8 {
+				wrong += "x"
+			}
+
+			ok, _ := p.Verify(ctx, ch.ID, wrong)
+			assert.False(t, ok)
+			ok, _ = p.Verify(ctx, ch.ID, solution)
+			assert.False(t, ok)
+		})
+
+		t.Run("expired", func(t *testing.T) {
+			ch, _ := p.Issue(ctx)
+			mr.FastForward(time.Minute)
+			ok, _ := p.Verify(ctx, ch.ID, solve(ch.ID, 8))
+			assert.False(t, ok)
+		})
+
+		t.Run("unknown challenge", func(t *testing.T) {
+			ok, err := p.Verify(ctx, "unknown", "1")
+			assert.NoError(t, err)
+			assert.False(t, ok)
+		})
+	})
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	var sum [sha256.Size]byte
+	assert.Equal(t, 256, leadingZeroBits(sum))
+	sum[1] = 0x10
+	assert.Equal(t, 11, leadingZeroBits(sum))
+	sum[0] = 0x80
+	assert.Equal(t, 0, leadingZeroBits(sum))
+}
+
+func TestNewProvider(t *testing.T) {
+	p, err := NewProvider(config.ChallengeConf{})
+	assert.NoError(t, err)
+	assert.Equal(t, ProviderPoW, p.Name())
+
+	_, err = NewProvider(config.ChallengeConf{Provider: ProviderStub})
+	assert.Error(t, err)
+
+	_, err = NewProvider(config.ChallengeConf{Provider: "captcha"})
+	assert.Error(t, err)
+}
+
+func TestNewDefault(t *testing.T) {
+	mockey.PatchConvey("an invalid provider fails the startup", t, func() {
+		conf := config.ChallengeConf{After: 2}
+		mockey.Mock(config.GetChallengeConf).To(func() config.ChallengeConf { return conf }).Build()
+		assert.NotPanics(t, func() { NewDefault() })
+
+		for _, provider := range []string{ProviderStub, "powr"} {
+			conf.Provider = provider
+			assert.Panics(t, func() { NewDefault() }, provider)
+		}
+	})
+}
+
+func TestService(t *testing.T) {
+	mockey.PatchConvey("service", t, func() {
+		ctx := context.Background()
+		mr := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+
+		s := New(config.ChallengeConf{After: 2, WindowSeconds: 60}, NewStub("42", time.Minute))
+		ip := "10.0.0.1"
+
+		assert.False(t, s.Required(ctx, ip))
+		assert.Nil(t, s.Failure(ctx, ip))
+		assert.False(t, s.Required(ctx, ip))
+		assert.Nil(t, s.Failure(ctx, ip))
+		assert.True(t, s.Required(ctx, ip))
+		assert.False(t, s.Required(ctx, "10.0.0.2"))
+
+		ch, bizErr := s.Issue(ctx)
+		assert.Nil(t, bizErr)
+		assert.Equal(t, "42", ch.Params["solution"])
+		assert.Nil(t, s.Verify(ctx, ch.ID, "42"))
+		assert.True(t, errs.ErrorEqual(errs.ChallengeFailed, s.Verify(ctx, ch.ID, "41")))
+
+		t.Run("success forgets the failures", func(t *testing.T) {
+			assert.Nil(t, s.Success(ctx, ip))
+			assert.False(t, s.Required(ctx, ip))
+		})
+
+		t.Run("failures expire with the window", func(t *testing.T) {
+			_ = s.Failure(ctx, ip)
+			_ = s.Failure(ctx, ip)
+			mr.FastForward(time.Minute)
+			assert.False(t, s.Required(ctx, ip))
+		})
+
+		t.Run("disabled", func(t *testing.T) {
+			off := New(config.ChallengeConf{}, NewStub("", time.Minute))
+			for i := 0; i < 5; i++ {
+				assert.Nil(t, off.Failure(ctx, "10.0.0.3"))
+			}
+			assert.False(t, off.Required(ctx, "10.0.0.3"))
+		})
+	})
+}
@@ -0,0 +1,81 @@
+package challenge
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"math/bits"
+	"time"
+
+	"doing_now/be/biz/db/redis"
+	"doing_now/be/biz/util/random"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	keyPoW = "challenge_pow:"
+
+	// solutions are nonces, longer ones are rejected before hashing
+	maxSolutionLen = 64
+)
+
+// PoW is a hashcash-style proof of work: the client finds a solution for which
+// sha256(prefix + solution) starts with difficulty zero bits. Solving takes about 2^difficulty
+// hashes, verifying takes one.
+type PoW struct {
+	difficulty int
+	ttl        time.Duration
+}
+
+func NewPoW(difficulty int, ttl time.Duration) *PoW {
+	return &PoW{difficulty: difficulty, ttl: ttl}
+}
+
+func (p *PoW) Name() string {
+	return ProviderPoW
+}
+
+// Issue stores the difficulty under the prefix, which is the challenge ID as well.
+func (p *PoW) Issue(ctx context.Context) (*Challenge, error) {
+	id := random.SecureStr(challengeIDLen)
+	if err := redis.GetRedisClient().Set(ctx, keyPoW+id, p.difficulty, p.ttl).Err(); err != nil {
+		return nil, err
+	}
+	return &Challenge{
+		ID:       id,
+		Provider: ProviderPoW,
+		Params: map[string]any{
+			"algorithm":  "sha256",
+			"prefix":     id,
+			"difficulty": p.difficulty,
+		},
+		ExpiresAt: time.Now().Add(p.ttl),
+	}, nil
+}
+
+func (p *PoW) Verify(ctx context.Context, id, solution string) (bool, error) {
+	if id == "" || solution == "" || len(solution) > maxSolutionLen {
+		return false, nil
+	}
+	// the challenge is dropped on the first attempt, so a solution cannot be replayed
+	difficulty, err := redis.GetRedisClient().GetDel(ctx, keyPoW+id).Int()
+	if errors.Is(err, goredis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return leadingZeroBits(sha256.Sum256([]byte(id+solution))) >= difficulty, nil
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,42 @@
+package challenge
+
+import (
+	"context"
+	"time"
+
+	"doing_now/be/biz/util/random"
+)
+
+const defaultStubSolution = "stub"
+
+// Stub accepts a fixed solution for any challenge and tells it in the params. It keeps no state
+// and is for tests only, NewProvider refuses it so a deployment cannot turn challenges off by
+// configuring it.
+type Stub struct {
+	solution string
+	ttl      time.Duration
+}
+
+func NewStub(solution string, ttl time.Duration) *Stub {
+	if solution == "" {
+		solution = defaultStubSolution
+	}
+	return &Stub{solution: solution, ttl: ttl}
+}
+
+func (s *Stub) Name() string {
+	return ProviderStub
+}
+
+func (s *Stub) Issue(_ context.Context) (*Challenge, error) {
+	return &Challenge{
+		ID:        random.SecureStr(challengeIDLen),
+		Provider:  ProviderStub,
+		Params:    map[string]any{"solution": s.solution},
+		ExpiresAt: time.Now().Add(s.ttl),
+	}, nil
+}
+
+func (s *Stub) Verify(_ context.Context, id, solution string) (bool, error) {
+	return id != "" && solution == s.solution, nil
+}
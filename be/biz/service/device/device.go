@@ -0,0 +1,209 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"doing_now/be/biz/config"
+	rediscli "doing_now/be/biz/db/redis"
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/util/random"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultTTL = 7 * 24 * time.Hour
+
+	deviceIDLen     = 22
+	maxUserAgentLen = 256
+
+	fieldID         = "id"
+	fieldUserID     = "user_id"
+	fieldUserAgent  = "user_agent"
+	fieldIP         = "ip"
+	fieldCreatedAt  = "created_at"
+	fieldLastSeenAt = "last_seen_at"
+)
+
+// Service keeps the device metadata of logged-in sessions in redis. Each session has a hash
+// keyed by its ID, and each user has a hash from the public device ID to the session ID, so the
+// session ID itself is never handed out.
+type Service struct {
+	ttl time.Duration
+}
+
+func New(conf config.SessionConf) *Service {
+	s := &Service{
+		ttl: time.Duration(conf.MaxAge) * time.Second,
+	}
+	if s.ttl <= 0 {
+		s.ttl = defaultTTL
+	}
+	return s
+}
+
+func NewDefault() *Service {
+	return New(config.GetSessionConf())
+}
+
+// Record stores the device of a new login session.
+func (s *Service) Record(ctx context.Context, userID, sessID, userAgent, ip string) errs.Error {
+	if len(userAgent) > maxUserAgentLen {
+		userAgent = userAgent[:maxUserAgentLen]
+	}
+	id := random.SecureStr(deviceIDLen)
+	now := time.Now().Unix()
+
+	pipe := rediscli.GetRedisClient().TxPipeline()
+	pipe.HSet(ctx, deviceKey(sessID),
+		fieldID, id,
+		fieldUserID, userID,
+		fieldUserAgent, userAgent,
+		fieldIP, ip,
+		fieldCreatedAt, now,
+		fieldLastSeenAt, now,
+	)
+	pipe.Expire(ctx, deviceKey(sessID), s.ttl)
+	pipe.HSet(ctx, userDevicesKey(userID), id, sessID)
+	pipe.Expire(ctx, userDevicesKey(userID), s.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		hlog.CtxErrorf(ctx, "record device err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	return nil
+}
+
+// Touch updates the last-seen time and IP of a recorded session.
+func (s *Service) Touch(ctx context.Context, sessID, ip string) errs.Error {
+	rdb := rediscli.GetRedisClient()
+	userID, err := rdb.HGet(ctx, deviceKey(sessID), fieldUserID).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		hlog.CtxErrorf(ctx, "get device err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, deviceKey(sessID), fieldIP, ip, fieldLastSeenAt, time.Now().Unix())
+	pipe.Expire(ctx, deviceKey(sessID), s.ttl)
+	pipe.Expire(ctx, userDevicesKey(userID), s.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		hlog.CtxErrorf(ctx, "touch device err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	return nil
+}
+
+// List returns the sessions of a user, the most recently seen first. Sessions which have
+// expired meanwhile are dropped from the index.
+func (s *Service) List(ctx context.Context, userID string) ([]*domain.Device, errs.Error) {
+	rdb := rediscli.GetRedisClient()
+	sessIDs, err := rdb.HGetAll(ctx, userDevicesKey(userID)).Result()
+	if err != nil {
+		hlog.CtxErrorf(ctx, "list devices err: %v", err)
+		return nil, errs.ServerError.SetErr(err)
+	}
+
+	devices := make([]*domain.Device, 0, len(sessIDs))
+	var stale []string
+	for id, sessID := range sessIDs {
+		values, err := rdb.HGetAll(ctx, deviceKey(sessID)).Result()
+		if err != nil {
+			hlog.CtxErrorf(ctx, "get device err: %v", err)
+			return nil, errs.ServerError.SetErr(err)
+		}
+		if values[fieldID] != id {
+			stale = append(stale, id)
+			continue
+		}
+		devices = append(devices, toDevice(sessID, values))
+	}
+	if len(stale) > 0 {
+		if err := rdb.HDel(ctx, userDevicesKey(userID), stale...).Err(); err != nil {
+			hlog.CtxErrorf(ctx, "drop stale devices err: %v", err)
+		}
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].LastSeenAt.After(devices[j].LastSeenAt)
+	})
+	return devices, nil
+}
+
+// Find returns the session of a user by its public device ID.
+func (s *Service) Find(ctx context.Context, userID, id string) (*domain.Device, errs.Error) {
+	rdb := rediscli.GetRedisClient()
+	sessID, err := rdb.HGet(ctx, userDevicesKey(userID), id).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, errs.SessionNotFound
+		}
+		hlog.CtxErrorf(ctx, "get device err: %v", err)
+		return nil, errs.ServerError.SetErr(err)
+	}
+
+	values, err := rdb.HGetAll(ctx, deviceKey(sessID)).Result()
+	if err != nil {
+		hlog.CtxErrorf(ctx, "get device err: %v", err)
+		return nil, errs.ServerError.SetErr(err)
+	}
+	if values[fieldID] != id {
+		return nil, errs.SessionNotFound
+	}
+	return toDevice(sessID, values), nil
+}
+
+// Remove forgets the device of a session, it is called once the session has been logged out
+// or revoked.
+func (s *Service) Remove(ctx context.Context, sessID string) errs.Error {
+	rdb := rediscli.GetRedisClient()
+	values, err := rdb.HMGet(ctx, deviceKey(sessID), fieldID, fieldUserID).Result()
+	if err != nil {
+		hlog.CtxErrorf(ctx, "get device err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, deviceKey(sessID))
+	if id, ok := values[0].(string); ok {
+		if userID, ok := values[1].(string); ok {
+			pipe.HDel(ctx, userDevicesKey(userID), id)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		hlog.CtxErrorf(ctx, "remove device err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	return nil
+}
+
+func toDevice(sessID string, values map[string]string) *domain.Device {
+	createdAt, _ := strconv.ParseInt(values[fieldCreatedAt], 10, 64)
+	lastSeenAt, _ := strconv.ParseInt(values[fieldLastSeenAt], 10, 64)
+	return &domain.Device{
+		ID:         values[fieldID],
+		SessionID:  sessID,
+		UserID:     values[fieldUserID],
+		UserAgent:  values[fieldUserAgent],
+		IP:         values[fieldIP],
+		CreatedAt:  time.Unix(createdAt, 0),
+		LastSeenAt: time.Unix(lastSeenAt, 0),
+	}
+}
+
+func deviceKey(sessID string) string {
+	return fmt.Sprintf("session_device:%s", sessID)
+}
+
+func userDevicesKey(userID string) string {
+	return fmt.Sprintf("user_devices:%s", userID)
+}
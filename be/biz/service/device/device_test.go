@@ -0,0 +1,72 @@
+package device
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"doing_now/be/biz/config"
+	db_redis "doing_now/be/biz/db/redis"
+	"doing_now/be/biz/model/errs"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bytedance/mockey"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_Lifecycle(t *testing.T) {
+	mockey.PatchConvey("device lifecycle", t, func() {
+		ctx := context.Background()
+		mr := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+
+		s := New(config.SessionConf{MaxAge: 3600})
+		assert.Nil(t, s.Record(ctx, "u1", "sess-1", "Firefox", "10.0.0.1"))
+		assert.Nil(t, s.Record(ctx, "u1", "sess-2", "Safari", "10.0.0.2"))
+		assert.Nil(t, s.Record(ctx, "u2", "sess-3", "Chrome", "10.0.0.3"))
+
+		mr.FastForward(2 * time.Second)
+		assert.Nil(t, s.Touch(ctx, "sess-1", "10.0.0.9"))
+		assert.Nil(t, s.Touch(ctx, "sess-unknown", "10.0.0.9"))
+		assert.False(t, mr.Exists(deviceKey("sess-unknown")))
+
+		devices, bizErr := s.List(ctx, "u1")
+		assert.Nil(t, bizErr)
+		assert.Len(t, devices, 2)
+		byID := map[string]string{}
+		for _, d := range devices {
+			byID[d.SessionID] = d.ID
+			assert.Equal(t, "u1", d.UserID)
+		}
+		assert.NotEqual(t, "sess-1", byID["sess-1"])
+
+		t.Run("find is scoped to the user", func(t *testing.T) {
+			d, bizErr := s.Find(ctx, "u1", byID["sess-1"])
+			assert.Nil(t, bizErr)
+			assert.Equal(t, "sess-1", d.SessionID)
+			assert.Equal(t, "10.0.0.9", d.IP)
+			assert.Equal(t, "Firefox", d.UserAgent)
+
+			_, bizErr = s.Find(ctx, "u2", byID["sess-1"])
+			assert.True(t, errs.ErrorEqual(errs.SessionNotFound, bizErr))
+		})
+
+		t.Run("removed and expired sessions are not listed", func(t *testing.T) {
+			assert.Nil(t, s.Remove(ctx, "sess-1"))
+			_, bizErr := s.Find(ctx, "u1", byID["sess-1"])
+			assert.True(t, errs.ErrorEqual(errs.SessionNotFound, bizErr))
+
+			mr.Del(deviceKey("sess-2"))
+			devices, bizErr := s.List(ctx, "u1")
+			assert.Nil(t, bizErr)
+			assert.Empty(t, devices)
+			assert.False(t, mr.Exists(userDevicesKey("u1")))
+
+			devices, bizErr = s.List(ctx, "u2")
+			assert.Nil(t, bizErr)
+			assert.Len(t, devices, 1)
+		})
+	})
+}
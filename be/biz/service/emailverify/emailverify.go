@@ -0,0 +1,191 @@
+package emailverify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/db/mysql"
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/util/mailer"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultTokenTTL = 24 * time.Hour
+
+	mailSubject = "Verify your Doing Now email address"
+)
+
+// claims are carried by the verification link. The link is bound to the address it was sent to,
+// so it stops working once the user changes the email.
+type claims struct {
+	UserID    string `json:"uid"`
+	Email     string `json:"email"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Service mails signed, expiring verification links and marks the email verified once a link is
+// opened. Nothing is stored for a link, the signature is enough to trust it.
+type Service struct {
+	secret   []byte
+	tokenTTL time.Duration
+	url      string
+	mailer   mailer.Mailer
+}
+
+func New(conf config.EmailVerificationConf, m mailer.Mailer) *Service {
+	s := &Service{
+		secret:   []byte(conf.Secret),
+		tokenTTL: time.Duration(conf.TokenTTL) * time.Second,
+		url:      conf.URL,
+		mailer:   m,
+	}
+	if s.tokenTTL <= 0 {
+		s.tokenTTL = defaultTokenTTL
+	}
+	return s
+}
+
+func NewDefault() *Service {
+	m, err := mailer.Default()
+	if err != nil {
+		hlog.Errorf("init mailer err: %v", err)
+	}
+	return New(config.GetEmailVerificationConf(), m)
+}
+
+// Send mails a verification link to the unverified email of the user.
+func (s *Service) Send(ctx context.Context, u *domain.User) errs.Error {
+	if u.Email == "" || u.EmailVerified {
+		return nil
+	}
+	if s.mailer == nil || len(s.secret) == 0 {
+		hlog.CtxErrorf(ctx, "email verification not configured")
+		return errs.ServerError.SetMsg("email verification not configured")
+	}
+
+	token, err := s.sign(claims{
+		UserID:    u.UserID,
+		Email:     u.Email,
+		ExpiresAt: time.Now().Add(s.tokenTTL).Unix(),
+	})
+	if err != nil {
+		hlog.CtxErrorf(ctx, "sign email verification err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+
+	if err := s.mailer.Send(ctx, mailer.Message{
+		To:      u.Email,
+		Subject: mailSubject,
+		Body:    s.mailBody(token),
+	}); err != nil {
+		hlog.CtxErrorf(ctx, "send email verification err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+
+	hlog.CtxInfof(ctx, "email verification mailed for user id: %s", u.UserID)
+	return nil
+}
+
+// Verify marks the email of the link verified. Opening a link again is harmless.
+func (s *Service) Verify(ctx context.Context, token string) errs.Error {
+	c, ok := s.parse(token)
+	if !ok || time.Now().Unix() > c.ExpiresAt {
+		hlog.CtxNoticef(ctx, "email verification link invalid or expired")
+		return errs.EmailVerifyInvalid
+	}
+
+	err := mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		users := repo.NewUserRepository(tx)
+		u, err := users.FindByUserIDLock(ctx, c.UserID)
+		if err != nil {
+			return err
+		}
+		if u == nil || u.Email == nil || *u.Email != c.Email {
+			return errs.EmailVerifyInvalid
+		}
+		if u.EmailVerifiedAt != nil {
+			return nil
+		}
+		now := time.Now()
+		u.EmailVerifiedAt = &now
+		return users.Update(ctx, u)
+	})
+	if err != nil {
+		if bizErr, ok := err.(errs.Error); ok {
+			hlog.CtxNoticef(ctx, "verify email err: %v", bizErr)
+			return bizErr
+		}
+		hlog.CtxErrorf(ctx, "verify email err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	return nil
+}
+
+func (s *Service) sign(c claims) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(encoded)), nil
+}
+
+func (s *Service) parse(token string) (*claims, bool) {
+	if len(s.secret) == 0 {
+		return nil, false
+	}
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, s.mac(encoded)) {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, false
+	}
+	return &c, true
+}
+
+func (s *Service) mac(encoded string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte("email_verification." + encoded))
+	return h.Sum(nil)
+}
+
+func (s *Service) mailBody(token string) string {
+	link := token
+	if u, err := url.Parse(s.url); err == nil && s.url != "" {
+		q := u.Query()
+		q.Set("token", token)
+		u.RawQuery = q.Encode()
+		link = u.String()
+	}
+	validFor := fmt.Sprintf("%d minutes", int(s.tokenTTL.Minutes()))
+	if s.tokenTTL >= time.Hour {
+		validFor = fmt.Sprintf("%d hours", int(s.tokenTTL.Hours()))
+	}
+	return fmt.Sprintf("Please confirm the email address of your Doing Now account.\n\n"+
+		"Open the link below within %s:\n\n%s\n\n"+
+		"If you did not sign up, ignore this mail.\n",
+		validFor, link)
+}
@@ -0,0 +1,140 @@
+package emailverify
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/db/mysql"
+	"doing_now/be/biz/model/convert"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/model/storage"
+	"doing_now/be/biz/util/mailer"
+
+	"github.com/bytedance/mockey"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var (
+	patchOnce sync.Once
+	currentDB *gorm.DB
+)
+
+func ensurePatches() {
+	patchOnce.Do(func() {
+		mockey.Mock(mysql.GetDbConn).To(func() *gorm.DB {
+			return currentDB
+		}).Build()
+		mockey.Mock((*repo.UserRepository).FindByUserIDLock).To(func(r *repo.UserRepository, ctx context.Context, userID string) (*storage.UserRecord, error) {
+			return r.FindByUserID(ctx, userID)
+		}).Build()
+	})
+}
+
+func setup(t *testing.T) *storage.UserRecord {
+	ensurePatches()
+
+	dsn := fmt.Sprintf("file:%s_%d?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"), time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	assert.NoError(t, err)
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	assert.NoError(t, db.AutoMigrate(&storage.UserRecord{}))
+	currentDB = db
+
+	email := "alice@example.com"
+	u := &storage.UserRecord{UserId: "user01", Account: "account01", Name: "name0001", Email: &email}
+	assert.NoError(t, db.Create(u).Error)
+	return u
+}
+
+var linkRe = regexp.MustCompile(`https://\S+`)
+
+func mailedToken(t *testing.T, m *mailer.MemoryMailer, to string) string {
+	msg, ok := m.Last(to)
+	assert.True(t, ok)
+	u, err := url.Parse(linkRe.FindString(msg.Body))
+	assert.NoError(t, err)
+	return u.Query().Get("token")
+}
+
+func reload(t *testing.T, userID string) *storage.UserRecord {
+	var u storage.UserRecord
+	assert.NoError(t, currentDB.First(&u, "user_id = ?", userID).Error)
+	return &u
+}
+
+func TestService_SendAndVerify(t *testing.T) {
+	record := setup(t)
+	ctx := context.Background()
+	m := mailer.NewMemoryMailer()
+	svc := New(config.EmailVerificationConf{Secret: "secret", URL: "https://example.com/verify"}, m)
+
+	assert.Nil(t, svc.Send(ctx, convert.UserRecordToDomain(record)))
+	token := mailedToken(t, m, "alice@example.com")
+
+	t.Run("forged links are rejected", func(t *testing.T) {
+		other := New(config.EmailVerificationConf{Secret: "other"}, m)
+		bizErr := other.Verify(ctx, token)
+		assert.True(t, errs.ErrorEqual(errs.EmailVerifyInvalid, bizErr))
+
+		encoded, sig, _ := strings.Cut(token, ".")
+		forged, err := svc.sign(claims{UserID: "user02", Email: "alice@example.com", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+		assert.NoError(t, err)
+		forgedEncoded, _, _ := strings.Cut(forged, ".")
+		assert.NotEqual(t, encoded, forgedEncoded)
+		bizErr = svc.Verify(ctx, forgedEncoded+"."+sig)
+		assert.True(t, errs.ErrorEqual(errs.EmailVerifyInvalid, bizErr))
+
+		assert.Nil(t, reload(t, record.UserId).EmailVerifiedAt)
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		assert.Nil(t, svc.Verify(ctx, token))
+		assert.NotNil(t, reload(t, record.UserId).EmailVerifiedAt)
+		assert.Nil(t, svc.Verify(ctx, token))
+
+		// verified emails get no more mails
+		sent := len(m.Messages())
+		assert.Nil(t, svc.Send(ctx, convert.UserRecordToDomain(reload(t, record.UserId))))
+		assert.Len(t, m.Messages(), sent)
+	})
+
+	t.Run("link is bound to the email", func(t *testing.T) {
+		assert.NoError(t, currentDB.Model(&storage.UserRecord{}).Where("user_id = ?", record.UserId).
+			Updates(map[string]any{"email": "bob@example.com", "email_verified_at": nil}).Error)
+		bizErr := svc.Verify(ctx, token)
+		assert.True(t, errs.ErrorEqual(errs.EmailVerifyInvalid, bizErr))
+		assert.Nil(t, reload(t, record.UserId).EmailVerifiedAt)
+	})
+}
+
+func TestService_VerifyExpired(t *testing.T) {
+	record := setup(t)
+	svc := New(config.EmailVerificationConf{Secret: "secret"}, mailer.NewMemoryMailer())
+
+	token, err := svc.sign(claims{UserID: record.UserId, Email: *record.Email, ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	assert.NoError(t, err)
+	bizErr := svc.Verify(context.Background(), token)
+	assert.True(t, errs.ErrorEqual(errs.EmailVerifyInvalid, bizErr))
+}
+
+func TestService_NotConfigured(t *testing.T) {
+	record := setup(t)
+	svc := New(config.EmailVerificationConf{}, mailer.NewMemoryMailer())
+
+	bizErr := svc.Send(context.Background(), convert.UserRecordToDomain(record))
+	assert.True(t, errs.ErrorEqual(errs.ServerError, bizErr))
+	bizErr = svc.Verify(context.Background(), "a.b")
+	assert.True(t, errs.ErrorEqual(errs.EmailVerifyInvalid, bizErr))
+}
@@ -0,0 +1,207 @@
+package ipfilter
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"doing_now/be/biz/config"
+	rediscli "doing_now/be/biz/db/redis"
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/audit"
+	"doing_now/be/biz/util/ip"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+const (
+	keyAllow = "ip_filter:allow"
+	keyDeny  = "ip_filter:deny"
+
+	defaultRefresh = 10 * time.Second
+)
+
+// Rules are IPs and CIDR ranges.
+type Rules struct {
+	Allow []string
+	Deny  []string
+}
+
+type ruleSet struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+func (r *ruleSet) allowed(clientIP string) bool {
+	if ip.Contains(r.deny, clientIP) {
+		return false
+	}
+	return len(r.allow) == 0 || ip.Contains(r.allow, clientIP)
+}
+
+// Service filters requests by client IP. The rules of the config are fixed, the rules set at
+// runtime are kept in Redis, so every instance picks them up with its next reload.
+type Service struct {
+	static      Rules
+	staticRules *ruleSet
+	refresh     time.Duration
+
+	current  atomic.Pointer[ruleSet]
+	loadedAt atomic.Int64
+	loading  sync.Mutex
+}
+
+func New(conf config.IPFilterConf) *Service {
+	s := &Service{
+		static:  Rules{Allow: conf.Allow, Deny: conf.Deny},
+		refresh: time.Duration(conf.RefreshSeconds) * time.Second,
+	}
+	if s.refresh <= 0 {
+		s.refresh = defaultRefresh
+	}
+	rules, err := parseRules(s.static)
+	if err != nil {
+		// an allow list short of an entry would let in whoever it was meant to keep out
+		panic(fmt.Errorf("parse ip filter rules: %w", err))
+	}
+	s.staticRules = rules
+	return s
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultService *Service
+)
+
+// Default returns the service built from the config, the middleware and the admin API share it so
+// rules set through the API apply at once on this instance.
+func Default() *Service {
+	defaultOnce.Do(func() {
+		defaultService = New(config.GetIPFilterConf())
+	})
+	return defaultService
+}
+
+// Allowed reports whether requests of the IP are let through. The rules are reloaded when they are
+// older than the refresh interval, until then the loaded ones apply.
+func (s *Service) Allowed(ctx context.Context, clientIP string) bool {
+	rules := s.current.Load()
+	if rules == nil || time.Since(time.Unix(0, s.loadedAt.Load())) > s.refresh {
+		if s.loading.TryLock() {
+			_ = s.reload(ctx)
+			s.loading.Unlock()
+		}
+		if loaded := s.current.Load(); loaded != nil {
+			rules = loaded
+		}
+	}
+	if rules == nil {
+		// the first load is still running in another request
+		rules = s.staticRules
+	}
+	return rules.allowed(clientIP)
+}
+
+// Rules returns the rules of the config and the rules set at runtime.
+func (s *Service) Rules(ctx context.Context) (static, runtime Rules, bizErr errs.Error) {
+	runtime, bizErr = s.runtime(ctx)
+	return s.static, runtime, bizErr
+}
+
+// SetRules replaces the rules set at runtime and applies them at once.
+func (s *Service) SetRules(ctx context.Context, rules Rules) errs.Error {
+	allow, err := ip.ParsePrefixes(rules.Allow)
+	if err != nil {
+		return errs.ParamError.SetMsg(err.Error())
+	}
+	deny, err := ip.ParsePrefixes(rules.Deny)
+	if err != nil {
+		return errs.ParamError.SetMsg(err.Error())
+	}
+
+	pipe := rediscli.GetRedisClient().TxPipeline()
+	pipe.Del(ctx, keyAllow, keyDeny)
+	if len(allow) > 0 {
+		pipe.SAdd(ctx, keyAllow, members(allow)...)
+	}
+	if len(deny) > 0 {
+		pipe.SAdd(ctx, keyDeny, members(deny)...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		hlog.CtxErrorf(ctx, "set ip filter rules err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	audit.Record(ctx, audit.Event{
+		Type:    domain.AuditIPFilterChange,
+		Details: map[string]any{"allow": rules.Allow, "deny": rules.Deny},
+	})
+
+	s.loading.Lock()
+	defer s.loading.Unlock()
+	return s.reload(ctx)
+}
+
+// reload combines the rules of the config with those set at runtime. When the runtime rules cannot
+// be read or are invalid the loaded rules stay in place.
+func (s *Service) reload(ctx context.Context) errs.Error {
+	defer s.loadedAt.Store(time.Now().UnixNano())
+
+	runtime, bizErr := s.runtime(ctx)
+	if bizErr != nil {
+		if s.current.Load() == nil {
+			s.current.Store(s.staticRules)
+		}
+		return bizErr
+	}
+	rules, err := parseRules(runtime)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "parse ip filter rules err: %v", err)
+		if s.current.Load() == nil {
+			s.current.Store(s.staticRules)
+		}
+		return errs.ServerError.SetErr(err)
+	}
+
+	s.current.Store(&ruleSet{
+		allow: append(rules.allow, s.staticRules.allow...),
+		deny:  append(rules.deny, s.staticRules.deny...),
+	})
+	return nil
+}
+
+func (s *Service) runtime(ctx context.Context) (Rules, errs.Error) {
+	pipe := rediscli.GetRedisClient().Pipeline()
+	allow := pipe.SMembers(ctx, keyAllow)
+	deny := pipe.SMembers(ctx, keyDeny)
+	if _, err := pipe.Exec(ctx); err != nil {
+		hlog.CtxErrorf(ctx, "get ip filter rules err: %v", err)
+		return Rules{}, errs.ServerError.SetErr(err)
+	}
+	return Rules{Allow: allow.Val(), Deny: deny.Val()}, nil
+}
+
+// parseRules fails on the first invalid entry, skipping it could leave the allow list empty, which
+// lets every IP in.
+func parseRules(rules Rules) (*ruleSet, error) {
+	allow, err := ip.ParsePrefixes(rules.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := ip.ParsePrefixes(rules.Deny)
+	if err != nil {
+		return nil, err
+	}
+	return &ruleSet{allow: allow, deny: deny}, nil
+}
+
+func members(prefixes []netip.Prefix) []any {
+	list := make([]any, 0, len(prefixes))
+	for _, p := range prefixes {
+		list = append(list, p.String())
+	}
+	return list
+}
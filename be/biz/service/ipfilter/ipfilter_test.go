@@ -0,0 +1,81 @@
+package ipfilter
+
+import (
+	"context"
+	"testing"
+
+	"doing_now/be/biz/config"
+	db_redis "doing_now/be/biz/db/redis"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bytedance/mockey"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService(t *testing.T) {
+	mockey.PatchConvey("ip filter", t, func() {
+		ctx := context.Background()
+		mr := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+
+		conf := config.IPFilterConf{Deny: []string{"10.0.0.0/8"}}
+		s := New(conf)
+		other := New(conf)
+
+		assert.True(t, s.Allowed(ctx, "192.168.0.1"))
+		assert.False(t, s.Allowed(ctx, "10.1.2.3"))
+
+		t.Run("runtime rules apply at once and on other instances after a reload", func(t *testing.T) {
+			assert.True(t, other.Allowed(ctx, "172.16.0.1"))
+
+			assert.Nil(t, s.SetRules(ctx, Rules{Allow: []string{"172.16.0.0/12", "10.0.0.1"}, Deny: []string{"172.16.0.9"}}))
+			assert.True(t, s.Allowed(ctx, "172.16.0.1"))
+			assert.False(t, s.Allowed(ctx, "172.16.0.9"))
+			assert.False(t, s.Allowed(ctx, "192.168.0.1"))
+			// the deny list of the config still applies
+			assert.False(t, s.Allowed(ctx, "10.0.0.1"))
+
+			assert.True(t, other.Allowed(ctx, "192.168.0.1"))
+			other.loadedAt.Store(0)
+			assert.False(t, other.Allowed(ctx, "192.168.0.1"))
+
+			static, runtime, bizErr := s.Rules(ctx)
+			assert.Nil(t, bizErr)
+			assert.Equal(t, conf.Deny, static.Deny)
+			assert.ElementsMatch(t, []string{"172.16.0.0/12", "10.0.0.1/32"}, runtime.Allow)
+		})
+
+		t.Run("invalid rules are refused", func(t *testing.T) {
+			assert.NotNil(t, s.SetRules(ctx, Rules{Deny: []string{"172.16"}}))
+			assert.True(t, s.Allowed(ctx, "172.16.0.1"))
+		})
+
+		t.Run("invalid rules in redis keep the loaded rules", func(t *testing.T) {
+			mr.SAdd(keyAllow, "bad")
+			other.loadedAt.Store(0)
+			assert.True(t, other.Allowed(ctx, "172.16.0.1"))
+			assert.False(t, other.Allowed(ctx, "192.168.0.1"))
+		})
+
+		t.Run("clear", func(t *testing.T) {
+			assert.Nil(t, s.SetRules(ctx, Rules{}))
+			assert.True(t, s.Allowed(ctx, "192.168.0.1"))
+		})
+
+		t.Run("redis down keeps the loaded rules", func(t *testing.T) {
+			assert.Nil(t, s.SetRules(ctx, Rules{Deny: []string{"192.168.0.1"}}))
+			mr.Close()
+			s.loadedAt.Store(0)
+			assert.False(t, s.Allowed(ctx, "192.168.0.1"))
+			assert.True(t, s.Allowed(ctx, "192.168.0.2"))
+		})
+	})
+}
+
+func TestNew(t *testing.T) {
+	assert.NotPanics(t, func() { New(config.IPFilterConf{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.1"}}) })
+	assert.Panics(t, func() { New(config.IPFilterConf{Allow: []string{"10.0.0.0/8", "bad"}}) })
+	assert.Panics(t, func() { New(config.IPFilterConf{Deny: []string{"10.0.0"}}) })
+}
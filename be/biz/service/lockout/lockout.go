@@ -0,0 +1,328 @@
+package lockout
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/db/mysql"
+	rediscli "doing_now/be/biz/db/redis"
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/service/audit"
+	"doing_now/be/biz/service/blocklist"
+	"doing_now/be/biz/util/interceptor"
+	"doing_now/be/biz/util/mailer"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	keyAccountFail  = "account_fail:"
+	keyAccountLock  = "account_lock:"
+	keySprayIP      = "login_spray:"
+	keySprayIPBlock = "login_spray_block:"
+
+	subjectUser  = "user:"
+	subjectLogin = "login:"
+
+	defaultWindow      = 15 * time.Minute
+	defaultDelayAfter  = 3
+	defaultDelayStep   = 500 * time.Millisecond
+	defaultMaxDelay    = 5 * time.Second
+	defaultLockAfter   = 10
+	defaultLockTime    = 15 * time.Minute
+	defaultSprayWindow = 10 * time.Minute
+	defaultSprayCount  = 20
+	defaultSprayBlock  = time.Hour
+)
+
+func init() {
+	blocklist.RegisterSource(blocklist.Source{
+		Target: blocklist.TargetAccount,
+		Reason: blocklist.ReasonAccountFailures,
+		Prefix: keyAccountLock,
+		Lift: func(ctx context.Context, account string) (bool, errs.Error) {
+			return NewDefault().lift(ctx, resolve(ctx, account))
+		},
+	})
+	// manual blocks of an account are kept for its subject like the lock
+	blocklist.RegisterAccountResolver(resolve)
+	blocklist.RegisterSource(blocklist.Source{
+		Target: blocklist.TargetIP,
+		Reason: blocklist.ReasonPasswordSpray,
+		Prefix: keySprayIPBlock,
+		Lift: func(ctx context.Context, ip string) (bool, errs.Error) {
+			n, err := rediscli.GetRedisClient().Del(ctx, keySprayIPBlock+ip, keySprayIP+ip).Result()
+			if err != nil {
+				hlog.CtxErrorf(ctx, "lift spray block err: %v", err)
+				return false, errs.ServerError.SetErr(err)
+			}
+			return n > 0, nil
+		},
+	})
+}
+
+// saddScript adds a member to a set which expires a window after its first member, and returns its size.
+var saddScript = redis.NewScript(`
+redis.call("SADD", KEYS[1], ARGV[2])
+if redis.call("TTL", KEYS[1]) == -1 then
+    redis.call("EXPIRE", KEYS[1], ARGV[1])
+end
+return redis.call("SCARD", KEYS[1])
+`)
+
+// Service protects accounts against password guessing spread over many IPs. Failures are counted
+// per user, whether the login gives the account or the email: after a few the logins of the user
+// are slowed down, after more they are locked for a while. An IP failing on many different
+// accounts is blocked as a password sprayer.
+//
+// The failures are counted by an interceptor, so they go local with the rate limits while Redis
+// fails and the lock and the delay still apply. The accounts an IP failed on are only kept in
+// Redis, spraying is not detected during an outage, the login block tiers still hold each IP.
+type Service struct {
+	failures   *interceptor.Interceptor
+	window     time.Duration
+	delayAfter int64
+	delayStep  time.Duration
+	maxDelay   time.Duration
+	lockAfter  int64
+	lockTime   time.Duration
+
+	sprayWindow time.Duration
+	sprayCount  int64
+	sprayBlock  time.Duration
+}
+
+func New(conf config.AccountProtectionConf) *Service {
+	s := &Service{
+		window:      seconds(conf.WindowSeconds, defaultWindow),
+		delayAfter:  int64(conf.DelayAfter),
+		delayStep:   time.Duration(conf.DelayStepMs) * time.Millisecond,
+		maxDelay:    time.Duration(conf.MaxDelayMs) * time.Millisecond,
+		lockAfter:   int64(conf.LockAfter),
+		lockTime:    time.Duration(conf.LockMinutes) * time.Minute,
+		sprayWindow: seconds(conf.SprayWindowSeconds, defaultSprayWindow),
+		sprayCount:  int64(conf.SprayAccounts),
+		sprayBlock:  time.Duration(conf.SprayBlockMinutes) * time.Minute,
+	}
+	if s.delayAfter <= 0 {
+		s.delayAfter = defaultDelayAfter
+	}
+	if s.delayStep <= 0 {
+		s.delayStep = defaultDelayStep
+	}
+	if s.maxDelay <= 0 {
+		s.maxDelay = defaultMaxDelay
+	}
+	if s.lockAfter <= 0 {
+		s.lockAfter = defaultLockAfter
+	}
+	if s.lockTime <= 0 {
+		s.lockTime = defaultLockTime
+	}
+	if s.sprayCount <= 0 {
+		s.sprayCount = defaultSprayCount
+	}
+	if s.sprayBlock <= 0 {
+		s.sprayBlock = defaultSprayBlock
+	}
+	s.failures = interceptor.NewInterceptor(int(s.window.Seconds()), s.lockAfter)
+	return s
+}
+
+func NewDefault() *Service {
+	return New(config.GetAccountProtectionConf())
+}
+
+// Subject resolves a login identifier, an account or a verified email, to the subject its failures and
+// lock are counted on. Users are counted by their user ID, so guesses at their account and at
+// their email add up; logins of no user are counted as typed.
+func (s *Service) Subject(ctx context.Context, login string) string {
+	login = strings.TrimSpace(login)
+	users := repo.NewUserRepository(mysql.GetDbConn().WithContext(ctx))
+	u, err := users.FindByAccount(ctx, login)
+	if err == nil && u == nil && strings.Contains(login, "@") {
+		u, err = users.FindByEmail(ctx, mailer.NormalizeAddress(login))
+		// an unverified email logs no one in, like Login
+		if u != nil && u.EmailVerifiedAt == nil {
+			u = nil
+		}
+	}
+	if err != nil {
+		hlog.CtxErrorf(ctx, "resolve login subject err: %v", err)
+	}
+	if u == nil {
+		return subjectLogin + normalize(login)
+	}
+	return UserSubject(u.UserId)
+}
+
+// resolve is Subject for values which may be subjects already, the blocks of accounts are listed
+// by subject and lifted by what the admin gives.
+func resolve(ctx context.Context, value string) string {
+	if strings.HasPrefix(value, subjectUser) || strings.HasPrefix(value, subjectLogin) {
+		return value
+	}
+	return NewDefault().Subject(ctx, value)
+}
+
+// UserSubject is the subject of the user with the user ID.
+func UserSubject(userID string) string {
+	return subjectUser + userID
+}
+
+// Locked returns how long the subject stays locked, zero when it is not locked. While the lock
+// cannot be read the subject is locked once its failures reach the limit, until their window ends.
+func (s *Service) Locked(ctx context.Context, subject string) (time.Duration, errs.Error) {
+	d, bizErr := ttl(ctx, keyAccountLock+subject)
+	if bizErr == nil {
+		return d, nil
+	}
+	q, err := s.failures.Peek(ctx, keyAccountFail+subject)
+	if err != nil {
+		return 0, bizErr
+	}
+	if !q.Allowed {
+		return q.RetryAfter, nil
+	}
+	return 0, nil
+}
+
+// Spraying returns how long the IP stays blocked for spraying, zero when it is not blocked.
+func (s *Service) Spraying(ctx context.Context, ip string) (time.Duration, errs.Error) {
+	return ttl(ctx, keySprayIPBlock+ip)
+}
+
+// Delay returns how long a login of the subject is held back, it grows with the failures of
+// the subject in the window up to the maximum delay.
+func (s *Service) Delay(ctx context.Context, subject string) (time.Duration, errs.Error) {
+	q, err := s.failures.Peek(ctx, keyAccountFail+subject)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "get account failures err: %v", err)
+		return 0, errs.ServerError.SetErr(err)
+	}
+	failures := q.Limit - q.Remaining
+	if failures < s.delayAfter {
+		return 0, nil
+	}
+	return min(time.Duration(failures-s.delayAfter+1)*s.delayStep, s.maxDelay), nil
+}
+
+// Failure counts a failed login of the subject from the IP, locks the subject and blocks the IP
+// once they reach their limits.
+func (s *Service) Failure(ctx context.Context, ip, subject string) errs.Error {
+	rdb := rediscli.GetRedisClient()
+
+	q, err := s.failures.Take(ctx, keyAccountFail+subject)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "count account failure err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	// the failures may outlast the lock, every failure after it locks again
+	failures := q.Limit - q.Remaining
+	if q.Remaining == 0 {
+		locked, err := rdb.SetNX(ctx, keyAccountLock+subject, failures, s.lockTime).Result()
+		if err != nil {
+			// Locked falls back to the failures
+			hlog.CtxErrorf(ctx, "lock account err: %v", err)
+			return errs.ServerError.SetErr(err)
+		}
+		if locked {
+			hlog.CtxWarnf(ctx, "security event: account %s locked for %v after %d failures", subject, s.lockTime, failures)
+			e := audit.Event{
+				Type:    domain.AuditAccountLocked,
+				Details: map[string]any{"account": subject, "failures": failures, "seconds": int64(s.lockTime.Seconds())},
+			}
+			if userID, ok := strings.CutPrefix(subject, subjectUser); ok {
+				e.TargetID = userID
+			}
+			audit.Record(ctx, e)
+		}
+	}
+
+	if ip == "" {
+		return nil
+	}
+	accounts, err := saddScript.Run(ctx, rdb, []string{keySprayIP + ip}, int(s.sprayWindow.Seconds()), subject).Int64()
+	if err != nil {
+		hlog.CtxErrorf(ctx, "count spray accounts err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	if accounts == s.sprayCount {
+		if err := rdb.Set(ctx, keySprayIPBlock+ip, accounts, s.sprayBlock).Err(); err != nil {
+			hlog.CtxErrorf(ctx, "block spraying ip err: %v", err)
+			return errs.ServerError.SetErr(err)
+		}
+		hlog.CtxWarnf(ctx, "security event: ip %s blocked for %v, failed on %d accounts", ip, s.sprayBlock, accounts)
+		audit.Record(ctx, audit.Event{
+			Type:    domain.AuditIPSpraying,
+			Details: map[string]any{"ip": ip, "accounts": accounts, "seconds": int64(s.sprayBlock.Seconds())},
+		})
+	}
+	return nil
+}
+
+// Success forgets the failures of the subject.
+func (s *Service) Success(ctx context.Context, subject string) errs.Error {
+	if err := s.failures.Reset(ctx, keyAccountFail+subject); err != nil {
+		hlog.CtxErrorf(ctx, "clear account failures err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	return nil
+}
+
+// Unlock lifts the lock of the user and forgets the failures.
+func (s *Service) Unlock(ctx context.Context, userID string) errs.Error {
+	unlocked, bizErr := s.lift(ctx, UserSubject(userID))
+	if bizErr != nil {
+		return bizErr
+	}
+	if unlocked {
+		hlog.CtxInfof(ctx, "account of user %s unlocked", userID)
+		audit.Record(ctx, audit.Event{Type: domain.AuditAccountUnlocked, TargetID: userID})
+	}
+	return nil
+}
+
+// lift deletes the lock and the failures of the subject, and reports whether it was locked.
+func (s *Service) lift(ctx context.Context, subject string) (bool, errs.Error) {
+	n, err := rediscli.GetRedisClient().Del(ctx, keyAccountLock+subject).Result()
+	if err == nil {
+		err = s.failures.Reset(ctx, keyAccountFail+subject)
+	}
+	if err != nil {
+		hlog.CtxErrorf(ctx, "unlock account err: %v", err)
+		return false, errs.ServerError.SetErr(err)
+	}
+	return n > 0, nil
+}
+
+// normalize makes counters of unknown logins independent of the case they are typed in, accounts
+// and emails are matched case-insensitively.
+func normalize(account string) string {
+	return strings.ToLower(strings.TrimSpace(account))
+}
+
+func ttl(ctx context.Context, key string) (time.Duration, errs.Error) {
+	d, err := rediscli.GetRedisClient().PTTL(ctx, key).Result()
+	if err != nil {
+		hlog.CtxErrorf(ctx, "get ttl err: %v", err)
+		return 0, errs.ServerError.SetErr(err)
+	}
+	// negative for missing keys and keys without expiry
+	if d < 0 {
+		return 0, nil
+	}
+	return d, nil
+}
+
+func seconds(n int, def time.Duration) time.Duration {
+	if n <= 0 {
+		return def
+	}
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,203 @@
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/db/mysql"
+	db_redis "doing_now/be/biz/db/redis"
+	"doing_now/be/biz/model/storage"
+	"doing_now/be/biz/service/blocklist"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bytedance/mockey"
+	"github.com/glebarez/sqlite"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestService_Subject(t *testing.T) {
+	mockey.PatchConvey("subject", t, func() {
+		ctx := context.Background()
+		dsn := fmt.Sprintf("file:%s_%d?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"), time.Now().UnixNano())
+		db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+		assert.NoError(t, err)
+		assert.NoError(t, db.AutoMigrate(&storage.UserRecord{}))
+		email := "alice@example.com"
+		verifiedAt := time.Now()
+		assert.NoError(t, db.Create(&storage.UserRecord{UserId: "user01", Account: "alice", Name: "name0001", Email: &email, EmailVerifiedAt: &verifiedAt}).Error)
+		unverified := "bob@example.com"
+		assert.NoError(t, db.Create(&storage.UserRecord{UserId: "user02", Account: "bob", Name: "name0002", Email: &unverified}).Error)
+		mockey.Mock(mysql.GetDbConn).Return(db).Build()
+
+		s := New(config.AccountProtectionConf{})
+		// the account and the email of a user are one subject, so guesses at both add up
+		assert.Equal(t, UserSubject("user01"), s.Subject(ctx, "alice"))
+		assert.Equal(t, UserSubject("user01"), s.Subject(ctx, " Alice@Example.com"))
+		assert.Equal(t, "login:nobody", s.Subject(ctx, "Nobody"))
+		// an unverified email is counted as typed, as it logs no one in
+		assert.Equal(t, "login:bob@example.com", s.Subject(ctx, "Bob@example.com"))
+
+		t.Run("unblocking the account or the email lifts the lock of the user", func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+
+			subject := UserSubject("user01")
+			for _, login := range []string{"alice", "Alice@Example.com"} {
+				mr.Set(keyAccountLock+subject, "10")
+				mr.Set("rate_limit:"+keyAccountFail+subject, "10")
+				ok, bizErr := blocklist.New().Unblock(ctx, blocklist.TargetAccount, login)
+				assert.Nil(t, bizErr)
+				assert.True(t, ok)
+				assert.Empty(t, mr.Keys())
+			}
+		})
+	})
+}
+
+func TestService_AccountLock(t *testing.T) {
+	mockey.PatchConvey("account lock", t, func() {
+		ctx := context.Background()
+		mr := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+
+		s := New(config.AccountProtectionConf{
+			DelayAfter:  2,
+			DelayStepMs: 100,
+			MaxDelayMs:  250,
+			LockAfter:   5,
+			LockMinutes: 10,
+		})
+
+		alice := UserSubject("user01")
+		delays := []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond, 250 * time.Millisecond}
+		for i, want := range delays {
+			d, bizErr := s.Delay(ctx, alice)
+			assert.Nil(t, bizErr)
+			assert.Equal(t, want, d, "delay after %d failures", i)
+
+			locked, _ := s.Locked(ctx, alice)
+			assert.Zero(t, locked)
+
+			// failures from different IPs count for the same user
+			assert.Nil(t, s.Failure(ctx, fmt.Sprintf("10.0.0.%d", i), alice))
+		}
+
+		locked, bizErr := s.Locked(ctx, alice)
+		assert.Nil(t, bizErr)
+		assert.Equal(t, 10*time.Minute, locked)
+
+		mr.FastForward(10 * time.Minute)
+		locked, _ = s.Locked(ctx, alice)
+		assert.Zero(t, locked)
+
+		t.Run("success forgets the failures", func(t *testing.T) {
+			assert.Nil(t, s.Success(ctx, alice))
+			d, _ := s.Delay(ctx, alice)
+			assert.Zero(t, d)
+		})
+
+		t.Run("unlock", func(t *testing.T) {
+			bob := UserSubject("user02")
+			for i := 0; i < 5; i++ {
+				assert.Nil(t, s.Failure(ctx, "", bob))
+			}
+			locked, _ := s.Locked(ctx, bob)
+			assert.NotZero(t, locked)
+
+			assert.Nil(t, s.Unlock(ctx, "user02"))
+			locked, _ = s.Locked(ctx, bob)
+			assert.Zero(t, locked)
+			d, _ := s.Delay(ctx, bob)
+			assert.Zero(t, d)
+		})
+
+		t.Run("failures outlasting the lock lock again", func(t *testing.T) {
+			long := New(config.AccountProtectionConf{WindowSeconds: 3600, LockAfter: 2, LockMinutes: 10})
+			carol := UserSubject("user03")
+			for i := 0; i < 2; i++ {
+				assert.Nil(t, long.Failure(ctx, "", carol))
+			}
+			locked, _ := long.Locked(ctx, carol)
+			assert.Equal(t, 10*time.Minute, locked)
+
+			mr.FastForward(10 * time.Minute)
+			assert.Nil(t, long.Failure(ctx, "", carol))
+			locked, _ = long.Locked(ctx, carol)
+			assert.Equal(t, 10*time.Minute, locked)
+		})
+	})
+}
+
+func TestService_Spraying(t *testing.T) {
+	mockey.PatchConvey("password spraying", t, func() {
+		ctx := context.Background()
+		mr := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+
+		s := New(config.AccountProtectionConf{SprayAccounts: 3, SprayBlockMinutes: 30})
+		ip := "10.0.0.1"
+
+		// the same account again is not spraying
+		for i := 0; i < 5; i++ {
+			assert.Nil(t, s.Failure(ctx, ip, "alice"))
+		}
+		assert.Nil(t, s.Failure(ctx, ip, "bob"))
+		blocked, _ := s.Spraying(ctx, ip)
+		assert.Zero(t, blocked)
+
+		assert.Nil(t, s.Failure(ctx, ip, "carol"))
+		blocked, bizErr := s.Spraying(ctx, ip)
+		assert.Nil(t, bizErr)
+		assert.Equal(t, 30*time.Minute, blocked)
+
+		blocked, _ = s.Spraying(ctx, "10.0.0.2")
+		assert.Zero(t, blocked)
+	})
+}
+
+func TestService_RedisDown(t *testing.T) {
+	mockey.PatchConvey("redis down", t, func() {
+		ctx := context.Background()
+		mr := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+
+		s := New(config.AccountProtectionConf{DelayAfter: 1, DelayStepMs: 100, LockAfter: 2, LockMinutes: 10, SprayAccounts: 1})
+		alice := UserSubject("user01")
+		ip := "10.0.0.1"
+		mr.SetError("LOADING")
+		defer mr.SetError("")
+
+		// the failures go local, the lock cannot be stored but holds through them
+		assert.NotNil(t, s.Failure(ctx, ip, alice))
+		d, bizErr := s.Delay(ctx, alice)
+		assert.Nil(t, bizErr)
+		assert.Equal(t, 100*time.Millisecond, d)
+		locked, _ := s.Locked(ctx, alice)
+		assert.Zero(t, locked)
+
+		assert.NotNil(t, s.Failure(ctx, ip, alice))
+		locked, bizErr = s.Locked(ctx, alice)
+		assert.Nil(t, bizErr)
+		// until the window of the failures ends
+		assert.InDelta(t, float64(15*time.Minute), float64(locked), float64(time.Second))
+
+		// the accounts an IP failed on are only kept in Redis, spraying is not detected
+		blocked, bizErr := s.Spraying(ctx, ip)
+		assert.NotNil(t, bizErr)
+		assert.Zero(t, blocked)
+
+		assert.NotNil(t, s.Success(ctx, alice))
+		locked, _ = s.Locked(ctx, alice)
+		assert.Zero(t, locked)
+	})
+}
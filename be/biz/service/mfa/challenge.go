@@ -0,0 +1,114 @@
+package mfa
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"doing_now/be/biz/db/redis"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/util/random"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	challengeIDLen = 43
+
+	fieldUserID            = "user_id"
+	fieldAccount           = "account"
+	fieldCredentialVersion = "credential_version"
+	fieldAttempts          = "attempts"
+)
+
+// incrAttemptsScript counts a wrong code of a challenge which still exists, so a challenge which
+// expired meanwhile is not recreated without its TTL. It returns -1 for a missing challenge.
+var incrAttemptsScript = goredis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+    return -1
+end
+return redis.call("HINCRBY", KEYS[1], ARGV[1], 1)
+`)
+
+// Challenge is the half-finished login kept between the password step and the code step.
+type Challenge struct {
+	UserID            string
+	Account           string
+	CredentialVersion uint
+}
+
+// CreateChallenge stores the login state after a correct password and returns the opaque challenge ID.
+func (s *Service) CreateChallenge(ctx context.Context, ch Challenge) (string, errs.Error) {
+	id := random.SecureStr(challengeIDLen)
+	key := challengeKey(id)
+
+	rdb := redis.GetRedisClient()
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, key,
+		fieldUserID, ch.UserID,
+		fieldAccount, ch.Account,
+		fieldCredentialVersion, ch.CredentialVersion,
+		fieldAttempts, 0,
+	)
+	pipe.Expire(ctx, key, s.challengeTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		hlog.CtxErrorf(ctx, "create mfa challenge err: %v", err)
+		return "", errs.ServerError.SetErr(err)
+	}
+	return id, nil
+}
+
+// VerifyChallenge finishes the login with a code. The challenge is single-use and is dropped
+// once the code is accepted or the attempts are exhausted.
+func (s *Service) VerifyChallenge(ctx context.Context, challengeID, code string) (*Challenge, errs.Error) {
+	rdb := redis.GetRedisClient()
+	key := challengeKey(challengeID)
+
+	values, err := rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		hlog.CtxErrorf(ctx, "get mfa challenge err: %v", err)
+		return nil, errs.ServerError.SetErr(err)
+	}
+	if values[fieldUserID] == "" {
+		return nil, errs.MFAChallengeInvalid
+	}
+
+	cv, _ := strconv.ParseUint(values[fieldCredentialVersion], 10, 64)
+	ch := &Challenge{
+		UserID:            values[fieldUserID],
+		Account:           values[fieldAccount],
+		CredentialVersion: uint(cv),
+	}
+
+	if bizErr := s.Verify(ctx, ch.UserID, code); bizErr != nil {
+		if !errs.ErrorEqual(bizErr, errs.MFACodeInvalid) {
+			return nil, bizErr
+		}
+		attempts, err := incrAttemptsScript.Run(ctx, rdb, []string{key}, fieldAttempts).Int64()
+		if err != nil {
+			hlog.CtxErrorf(ctx, "incr mfa challenge attempts err: %v", err)
+		}
+		if attempts < 0 {
+			return nil, errs.MFAChallengeInvalid
+		}
+		if attempts >= int64(s.maxAttempts) {
+			hlog.CtxNoticef(ctx, "mfa challenge attempts exhausted for user id: %s", ch.UserID)
+			rdb.Del(ctx, key)
+		}
+		return nil, bizErr
+	}
+
+	// only the caller that deletes the challenge may finish the login
+	if n, err := rdb.Del(ctx, key).Result(); err != nil {
+		hlog.CtxErrorf(ctx, "delete mfa challenge err: %v", err)
+		return nil, errs.ServerError.SetErr(err)
+	} else if n == 0 {
+		return nil, errs.MFAChallengeInvalid
+	}
+	return ch, nil
+}
+
+func challengeKey(id string) string {
+	return fmt.Sprintf("mfa_challenge:%s", id)
+}
@@ -0,0 +1,270 @@
+package mfa
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/db/mysql"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/model/storage"
+	"doing_now/be/biz/util/encode"
+	"doing_now/be/biz/util/random"
+	"doing_now/be/biz/util/totp"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultIssuer            = "Doing Now"
+	defaultSkew              = 1
+	defaultChallengeTTL      = 5 * time.Minute
+	defaultMaxAttempts       = 5
+	defaultRecoveryCodeCount = 10
+
+	recoveryCodeHalfLen = 5
+)
+
+type Service struct {
+	issuer            string
+	skew              int
+	challengeTTL      time.Duration
+	maxAttempts       int
+	recoveryCodeCount int
+}
+
+func New(conf config.MFAConf) *Service {
+	s := &Service{
+		issuer:            conf.Issuer,
+		skew:              conf.Skew,
+		challengeTTL:      time.Duration(conf.ChallengeTTL) * time.Second,
+		maxAttempts:       conf.MaxAttempts,
+		recoveryCodeCount: conf.RecoveryCodeCount,
+	}
+	if s.issuer == "" {
+		s.issuer = defaultIssuer
+	}
+	if s.skew <= 0 {
+		s.skew = defaultSkew
+	}
+	if s.challengeTTL <= 0 {
+		s.challengeTTL = defaultChallengeTTL
+	}
+	if s.maxAttempts <= 0 {
+		s.maxAttempts = defaultMaxAttempts
+	}
+	if s.recoveryCodeCount <= 0 {
+		s.recoveryCodeCount = defaultRecoveryCodeCount
+	}
+	return s
+}
+
+func NewDefault() *Service {
+	return New(config.GetMFAConf())
+}
+
+func (s *Service) IsEnabled(ctx context.Context, userID string) (bool, errs.Error) {
+	record, err := repo.NewUserTOTPRepository(mysql.GetDbConn().WithContext(ctx)).FindByUserID(ctx, userID)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "find totp by user id err: %v", err)
+		return false, errs.ServerError.SetErr(err)
+	}
+	return record != nil && record.Enabled, nil
+}
+
+// EnrollTOTP creates a pending secret, it only takes effect after ConfirmTOTP.
+func (s *Service) EnrollTOTP(ctx context.Context, userID, account string) (string, string, errs.Error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		hlog.CtxErrorf(ctx, "generate totp secret err: %v", err)
+		return "", "", errs.ServerError.SetErr(err)
+	}
+
+	err = mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		users := repo.NewUserRepository(tx)
+		totps := repo.NewUserTOTPRepository(tx)
+
+		u, err := users.FindByUserIDLock(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return errs.UserNotExist
+		}
+
+		record, err := totps.FindByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return totps.Create(ctx, &storage.UserTOTPRecord{
+				UserId: userID,
+				Secret: secret,
+			})
+		}
+		if record.Enabled {
+			return errs.MFAAlreadyEnabled
+		}
+		record.Secret = secret
+		record.LastUsedStep = 0
+		return totps.Update(ctx, record)
+	})
+	if bizErr := errs.Wrap(ctx, "enroll totp", err); bizErr != nil {
+		return "", "", bizErr
+	}
+
+	return secret, totp.URI(s.issuer, account, secret), nil
+}
+
+// ConfirmTOTP enables the pending secret once the user proves the authenticator works,
+// and returns a fresh set of recovery codes which are only shown this once.
+func (s *Service) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, errs.Error) {
+	codes := make([]string, 0, s.recoveryCodeCount)
+	err := mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		users := repo.NewUserRepository(tx)
+		totps := repo.NewUserTOTPRepository(tx)
+		recoveryCodes := repo.NewUserRecoveryCodeRepository(tx)
+
+		u, err := users.FindByUserIDLock(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return errs.UserNotExist
+		}
+
+		record, err := totps.FindByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return errs.MFANotEnabled
+		}
+		if record.Enabled {
+			return errs.MFAAlreadyEnabled
+		}
+
+		step, ok := totp.Validate(record.Secret, code, time.Now(), s.skew)
+		if !ok {
+			return errs.MFACodeInvalid
+		}
+		record.Enabled = true
+		record.LastUsedStep = step
+		if err := totps.Update(ctx, record); err != nil {
+			return err
+		}
+
+		if err := recoveryCodes.DeleteByUserID(ctx, userID); err != nil {
+			return err
+		}
+		list := make([]*storage.UserRecoveryCodeRecord, 0, s.recoveryCodeCount)
+		for i := 0; i < s.recoveryCodeCount; i++ {
+			plain := newRecoveryCode()
+			codes = append(codes, plain)
+			list = append(list, &storage.UserRecoveryCodeRecord{
+				UserId:   userID,
+				CodeHash: encode.SHA256Hex(normalizeRecoveryCode(plain)),
+			})
+		}
+		return recoveryCodes.CreateBatch(ctx, list)
+	})
+	if bizErr := errs.Wrap(ctx, "confirm totp", err); bizErr != nil {
+		return nil, bizErr
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off, a valid TOTP or recovery code is required.
+func (s *Service) DisableTOTP(ctx context.Context, userID, code string) errs.Error {
+	err := mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		record, err := s.verifyInTx(ctx, tx, userID, code)
+		if err != nil {
+			return err
+		}
+
+		record.Enabled = false
+		record.Secret = ""
+		record.LastUsedStep = 0
+		if err := repo.NewUserTOTPRepository(tx).Update(ctx, record); err != nil {
+			return err
+		}
+		return repo.NewUserRecoveryCodeRepository(tx).DeleteByUserID(ctx, userID)
+	})
+	return errs.Wrap(ctx, "disable totp", err)
+}
+
+// Verify checks a TOTP code or consumes a recovery code of an enrolled user.
+func (s *Service) Verify(ctx context.Context, userID, code string) errs.Error {
+	err := mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		_, err := s.verifyInTx(ctx, tx, userID, code)
+		return err
+	})
+	return errs.Wrap(ctx, "verify mfa", err)
+}
+
+func (s *Service) verifyInTx(ctx context.Context, tx *gorm.DB, userID, code string) (*storage.UserTOTPRecord, error) {
+	users := repo.NewUserRepository(tx)
+	totps := repo.NewUserTOTPRepository(tx)
+	recoveryCodes := repo.NewUserRecoveryCodeRepository(tx)
+
+	// lock the user so that concurrent verifications are serialized
+	u, err := users.FindByUserIDLock(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, errs.UserNotExist
+	}
+
+	record, err := totps.FindByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || !record.Enabled {
+		return nil, errs.MFANotEnabled
+	}
+
+	if len(code) == totp.Digits {
+		step, ok := totp.Validate(record.Secret, code, time.Now(), s.skew)
+		if !ok || step <= record.LastUsedStep {
+			hlog.CtxNoticef(ctx, "totp code invalid or replayed for user id: %s", userID)
+			return nil, errs.MFACodeInvalid
+		}
+		record.LastUsedStep = step
+		if err := totps.Update(ctx, record); err != nil {
+			return nil, err
+		}
+		return record, nil
+	}
+
+	rc, err := recoveryCodes.FindUnused(ctx, userID, encode.SHA256Hex(normalizeRecoveryCode(code)))
+	if err != nil {
+		return nil, err
+	}
+	if rc == nil {
+		hlog.CtxNoticef(ctx, "recovery code invalid for user id: %s", userID)
+		return nil, errs.MFACodeInvalid
+	}
+	used, err := recoveryCodes.MarkUsed(ctx, rc.ID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !used {
+		return nil, errs.MFACodeInvalid
+	}
+	hlog.CtxInfof(ctx, "recovery code used for user id: %s", userID)
+	return record, nil
+}
+
+func newRecoveryCode() string {
+	code := strings.ToLower(random.SecureStr(2 * recoveryCodeHalfLen))
+	return code[:recoveryCodeHalfLen] + "-" + code[recoveryCodeHalfLen:]
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
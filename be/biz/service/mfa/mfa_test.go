@@ -0,0 +1,174 @@
+package mfa
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/db/mysql"
+	db_redis "doing_now/be/biz/db/redis"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/model/storage"
+	"doing_now/be/biz/util/totp"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bytedance/mockey"
+	"github.com/glebarez/sqlite"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var (
+	patchOnce sync.Once
+	currentDB *gorm.DB
+	currentRD *redis.Client
+)
+
+func ensurePatches() {
+	patchOnce.Do(func() {
+		mockey.Mock(mysql.GetDbConn).To(func() *gorm.DB {
+			return currentDB
+		}).Build()
+		mockey.Mock(db_redis.GetRedisClient).To(func() *redis.Client {
+			return currentRD
+		}).Build()
+		mockey.Mock((*repo.UserRepository).FindByUserIDLock).To(func(r *repo.UserRepository, ctx context.Context, userID string) (*storage.UserRecord, error) {
+			return r.FindByUserID(ctx, userID)
+		}).Build()
+	})
+}
+
+func setup(t *testing.T) string {
+	ensurePatches()
+
+	dsn := fmt.Sprintf("file:%s_%d?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"), time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	assert.NoError(t, err)
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	err = db.AutoMigrate(&storage.UserRecord{}, &storage.UserTOTPRecord{}, &storage.UserRecoveryCodeRecord{})
+	assert.NoError(t, err)
+	currentDB = db
+
+	mr := miniredis.RunT(t)
+	currentRD = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	userID := "user01"
+	assert.NoError(t, db.Create(&storage.UserRecord{UserId: userID, Account: "account01", Name: "name0001"}).Error)
+	return userID
+}
+
+// codeAt returns a code of a step later than any code used before in the test.
+func codeAt(t *testing.T, secret string, offset int) string {
+	code, err := totp.GenerateCode(secret, time.Now().Add(time.Duration(offset*totp.Period)*time.Second))
+	assert.NoError(t, err)
+	return code
+}
+
+func TestService_TOTPLifecycle(t *testing.T) {
+	userID := setup(t)
+	ctx := context.Background()
+	svc := New(config.MFAConf{Skew: 1, RecoveryCodeCount: 3})
+
+	enabled, bizErr := svc.IsEnabled(ctx, userID)
+	assert.Nil(t, bizErr)
+	assert.False(t, enabled)
+
+	secret, uri, bizErr := svc.EnrollTOTP(ctx, userID, "account01")
+	assert.Nil(t, bizErr)
+	assert.Contains(t, uri, secret)
+
+	// pending enrollment is not enabled yet
+	enabled, _ = svc.IsEnabled(ctx, userID)
+	assert.False(t, enabled)
+
+	_, bizErr = svc.ConfirmTOTP(ctx, userID, "000000")
+	assert.True(t, errs.ErrorEqual(errs.MFACodeInvalid, bizErr))
+
+	codes, bizErr := svc.ConfirmTOTP(ctx, userID, codeAt(t, secret, -1))
+	assert.Nil(t, bizErr)
+	assert.Len(t, codes, 3)
+
+	enabled, _ = svc.IsEnabled(ctx, userID)
+	assert.True(t, enabled)
+
+	_, _, bizErr = svc.EnrollTOTP(ctx, userID, "account01")
+	assert.True(t, errs.ErrorEqual(errs.MFAAlreadyEnabled, bizErr))
+
+	t.Run("totp replay rejected", func(t *testing.T) {
+		code := codeAt(t, secret, 0)
+		assert.Nil(t, svc.Verify(ctx, userID, code))
+		assert.True(t, errs.ErrorEqual(errs.MFACodeInvalid, svc.Verify(ctx, userID, code)))
+	})
+
+	t.Run("recovery code single use", func(t *testing.T) {
+		assert.Nil(t, svc.Verify(ctx, userID, strings.ToUpper(codes[0])))
+		assert.True(t, errs.ErrorEqual(errs.MFACodeInvalid, svc.Verify(ctx, userID, codes[0])))
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		assert.Nil(t, svc.DisableTOTP(ctx, userID, codes[1]))
+		enabled, _ := svc.IsEnabled(ctx, userID)
+		assert.False(t, enabled)
+		assert.True(t, errs.ErrorEqual(errs.MFANotEnabled, svc.Verify(ctx, userID, codes[2])))
+	})
+}
+
+func TestService_Challenge(t *testing.T) {
+	userID := setup(t)
+	ctx := context.Background()
+	svc := New(config.MFAConf{MaxAttempts: 2})
+
+	secret, _, bizErr := svc.EnrollTOTP(ctx, userID, "account01")
+	assert.Nil(t, bizErr)
+	_, bizErr = svc.ConfirmTOTP(ctx, userID, codeAt(t, secret, -1))
+	assert.Nil(t, bizErr)
+
+	t.Run("success is single use", func(t *testing.T) {
+		id, bizErr := svc.CreateChallenge(ctx, Challenge{UserID: userID, Account: "account01", CredentialVersion: 3})
+		assert.Nil(t, bizErr)
+
+		ch, bizErr := svc.VerifyChallenge(ctx, id, codeAt(t, secret, 0))
+		assert.Nil(t, bizErr)
+		assert.Equal(t, userID, ch.UserID)
+		assert.Equal(t, uint(3), ch.CredentialVersion)
+
+		_, bizErr = svc.VerifyChallenge(ctx, id, codeAt(t, secret, 1))
+		assert.True(t, errs.ErrorEqual(errs.MFAChallengeInvalid, bizErr))
+	})
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		id, bizErr := svc.CreateChallenge(ctx, Challenge{UserID: userID, Account: "account01"})
+		assert.Nil(t, bizErr)
+
+		_, bizErr = svc.VerifyChallenge(ctx, id, "000000")
+		assert.True(t, errs.ErrorEqual(errs.MFACodeInvalid, bizErr))
+		_, bizErr = svc.VerifyChallenge(ctx, id, "000000")
+		assert.True(t, errs.ErrorEqual(errs.MFACodeInvalid, bizErr))
+
+		_, bizErr = svc.VerifyChallenge(ctx, id, codeAt(t, secret, 1))
+		assert.True(t, errs.ErrorEqual(errs.MFAChallengeInvalid, bizErr))
+	})
+
+	t.Run("challenge expiring during the check is not recreated", func(t *testing.T) {
+		id, bizErr := svc.CreateChallenge(ctx, Challenge{UserID: userID, Account: "account01"})
+		assert.Nil(t, bizErr)
+
+		m := mockey.Mock((*Service).Verify).To(func(_ *Service, ctx context.Context, _, _ string) errs.Error {
+			currentRD.Del(ctx, challengeKey(id))
+			return errs.MFACodeInvalid
+		}).Build()
+		defer m.UnPatch()
+
+		_, bizErr = svc.VerifyChallenge(ctx, id, "000000")
+		assert.True(t, errs.ErrorEqual(errs.MFAChallengeInvalid, bizErr))
+		assert.Zero(t, currentRD.Exists(ctx, challengeKey(id)).Val())
+	})
+}
@@ -0,0 +1,328 @@
+package oidc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/db/mysql"
+	"doing_now/be/biz/model/convert"
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/model/storage"
+	"doing_now/be/biz/util/random"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const (
+	httpTimeout = 10 * time.Second
+	flowTTL     = 10 * time.Minute
+
+	stateLen = 43
+	nonceLen = 43
+
+	accountSuffixLen = 16
+	maxNameLen       = 64
+)
+
+var defaultScopes = []string{gooidc.ScopeOpenID, "profile", "email"}
+
+// providers caches discovery documents and key sets per issuer, so keys are not fetched on every login.
+var providers sync.Map
+
+// Flow is the state of an authorization request, it is kept in the session until the callback.
+type Flow struct {
+	Provider  string    `json:"provider"`
+	State     string    `json:"state"`
+	Nonce     string    `json:"nonce"`
+	Verifier  string    `json:"verifier"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type Service struct {
+	providers map[string]config.OIDCProviderConf
+	client    *http.Client
+}
+
+func New(conf config.OIDCConf) *Service {
+	s := &Service{
+		providers: make(map[string]config.OIDCProviderConf, len(conf.Providers)),
+		client:    &http.Client{Timeout: httpTimeout},
+	}
+	for _, p := range conf.Providers {
+		if len(p.Scopes) == 0 {
+			p.Scopes = defaultScopes
+		}
+		s.providers[p.Name] = p
+	}
+	return s
+}
+
+func NewDefault() *Service {
+	return New(config.GetOIDCConf())
+}
+
+// Authorize returns the URL to send the browser to and the flow to keep in the session.
+func (s *Service) Authorize(ctx context.Context, providerName string) (string, []byte, errs.Error) {
+	conf, ok := s.providers[providerName]
+	if !ok {
+		return "", nil, errs.OIDCProviderNotFound
+	}
+	provider, err := s.provider(conf)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "oidc discovery err, provider: %s, err: %v", providerName, err)
+		return "", nil, errs.ServerError.SetErr(err)
+	}
+
+	flow := Flow{
+		Provider:  providerName,
+		State:     random.SecureStr(stateLen),
+		Nonce:     random.SecureStr(nonceLen),
+		Verifier:  oauth2.GenerateVerifier(),
+		ExpiresAt: time.Now().Add(flowTTL),
+	}
+	state, err := json.Marshal(flow)
+	if err != nil {
+		return "", nil, errs.ServerError.SetErr(err)
+	}
+
+	authURL := oauth2Config(conf, provider).AuthCodeURL(flow.State,
+		gooidc.Nonce(flow.Nonce),
+		oauth2.S256ChallengeOption(flow.Verifier),
+	)
+	return authURL, state, nil
+}
+
+// Login finishes the authorization and signs in the user linked to the external identity,
+// an unlinked identity creates a new user when the provider allows auto provisioning.
+func (s *Service) Login(ctx context.Context, flowState []byte, state, code string) (*domain.User, uint, errs.Error) {
+	conf, identity, bizErr := s.exchange(ctx, flowState, state, code)
+	if bizErr != nil {
+		return nil, 0, bizErr
+	}
+
+	var userRecord *storage.UserRecord
+	var credentialVersion uint
+	err := mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		users := repo.NewUserRepository(tx)
+		credentials := repo.NewUserCredentialRepository(tx)
+		identities := repo.NewUserIdentityRepository(tx)
+
+		existing, err := identities.FindBySubject(ctx, identity.Issuer, identity.Subject)
+		if err != nil {
+			return err
+		}
+
+		if existing == nil {
+			if !conf.AutoProvision {
+				hlog.CtxNoticef(ctx, "oidc identity not linked, provider: %s", conf.Name)
+				return errs.OIDCIdentityNotLinked
+			}
+			userRecord, err = users.Create(ctx, &storage.UserRecord{
+				UserId:  uuid.New().String(),
+				Account: conf.Name + "_" + strings.ToLower(random.SecureStr(accountSuffixLen)),
+				Name:    identity.displayName(),
+				Status:  string(domain.UserStatusActive),
+			})
+			if err != nil {
+				return err
+			}
+			// users created by an identity provider have no password until they set one
+			if err := credentials.Create(ctx, &storage.UserCredentialRecord{UserId: userRecord.UserId}); err != nil {
+				return err
+			}
+			hlog.CtxInfof(ctx, "user provisioned by oidc provider: %s, user id: %s", conf.Name, userRecord.UserId)
+			return identities.Create(ctx, identity.record(userRecord.UserId))
+		}
+
+		userRecord, err = users.FindByUserID(ctx, existing.UserId)
+		if err != nil {
+			return err
+		}
+		if userRecord == nil {
+			return errs.UserNotExist
+		}
+		if !domain.UserStatus(userRecord.Status).Active() {
+			hlog.CtxNoticef(ctx, "user status %s for user id: %s", userRecord.Status, userRecord.UserId)
+			return errs.UserStatusInvalid
+		}
+		c, err := credentials.FindByUserID(ctx, userRecord.UserId)
+		if err != nil {
+			return err
+		}
+		if c != nil {
+			credentialVersion = c.CredentialVersion
+		}
+
+		now := time.Now()
+		existing.LastUsedAt = &now
+		return identities.Update(ctx, existing)
+	})
+	if bizErr := errs.Wrap(ctx, "oidc login", err); bizErr != nil {
+		return nil, 0, bizErr
+	}
+	return convert.UserRecordToDomain(userRecord), credentialVersion, nil
+}
+
+// Link finishes the authorization and links the external identity to a signed-in user.
+func (s *Service) Link(ctx context.Context, userID string, flowState []byte, state, code string) errs.Error {
+	_, identity, bizErr := s.exchange(ctx, flowState, state, code)
+	if bizErr != nil {
+		return bizErr
+	}
+
+	err := mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		identities := repo.NewUserIdentityRepository(tx)
+
+		u, err := repo.NewUserRepository(tx).FindByUserIDLock(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return errs.UserNotExist
+		}
+
+		existing, err := identities.FindBySubject(ctx, identity.Issuer, identity.Subject)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			if existing.UserId != userID {
+				hlog.CtxNoticef(ctx, "oidc identity already linked to user id: %s", existing.UserId)
+				return errs.OIDCIdentityLinked
+			}
+			return nil
+		}
+		return identities.Create(ctx, identity.record(userID))
+	})
+	if bizErr := errs.Wrap(ctx, "oidc link", err); bizErr != nil {
+		return bizErr
+	}
+	hlog.CtxInfof(ctx, "oidc identity linked to user id: %s", userID)
+	return nil
+}
+
+// exchange checks the flow, redeems the code and validates the ID token.
+func (s *Service) exchange(ctx context.Context, flowState []byte, state, code string) (config.OIDCProviderConf, *identity, errs.Error) {
+	var flow Flow
+	if err := json.Unmarshal(flowState, &flow); err != nil {
+		hlog.CtxNoticef(ctx, "unmarshal oidc flow err: %v", err)
+		return config.OIDCProviderConf{}, nil, errs.OIDCStateInvalid
+	}
+	if time.Now().After(flow.ExpiresAt) || subtle.ConstantTimeCompare([]byte(flow.State), []byte(state)) != 1 {
+		hlog.CtxNoticef(ctx, "oidc state mismatch or expired, provider: %s", flow.Provider)
+		return config.OIDCProviderConf{}, nil, errs.OIDCStateInvalid
+	}
+	conf, ok := s.providers[flow.Provider]
+	if !ok {
+		return config.OIDCProviderConf{}, nil, errs.OIDCProviderNotFound
+	}
+	provider, err := s.provider(conf)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "oidc discovery err, provider: %s, err: %v", conf.Name, err)
+		return conf, nil, errs.ServerError.SetErr(err)
+	}
+
+	clientCtx := gooidc.ClientContext(ctx, s.client)
+	token, err := oauth2Config(conf, provider).Exchange(clientCtx, code, oauth2.VerifierOption(flow.Verifier))
+	if err != nil {
+		hlog.CtxNoticef(ctx, "oidc code exchange err, provider: %s, err: %v", conf.Name, err)
+		return conf, nil, errs.OIDCTokenInvalid
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		hlog.CtxNoticef(ctx, "oidc token response without id_token, provider: %s", conf.Name)
+		return conf, nil, errs.OIDCTokenInvalid
+	}
+
+	idToken, err := provider.Verifier(&gooidc.Config{ClientID: conf.ClientID}).Verify(clientCtx, rawIDToken)
+	if err != nil {
+		hlog.CtxNoticef(ctx, "oidc id token invalid, provider: %s, err: %v", conf.Name, err)
+		return conf, nil, errs.OIDCTokenInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(flow.Nonce)) != 1 {
+		hlog.CtxNoticef(ctx, "oidc nonce mismatch, provider: %s", conf.Name)
+		return conf, nil, errs.OIDCTokenInvalid
+	}
+
+	id := &identity{Provider: conf.Name, Issuer: idToken.Issuer, Subject: idToken.Subject}
+	if err := idToken.Claims(id); err != nil {
+		hlog.CtxNoticef(ctx, "oidc id token claims err, provider: %s, err: %v", conf.Name, err)
+		return conf, nil, errs.OIDCTokenInvalid
+	}
+	return conf, id, nil
+}
+
+func (s *Service) provider(conf config.OIDCProviderConf) (*gooidc.Provider, error) {
+	if p, ok := providers.Load(conf.Issuer); ok {
+		return p.(*gooidc.Provider), nil
+	}
+	// the key set keeps the context for later refreshes, it must outlive the request
+	p, err := gooidc.NewProvider(gooidc.ClientContext(context.Background(), s.client), conf.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := providers.LoadOrStore(conf.Issuer, p)
+	return actual.(*gooidc.Provider), nil
+}
+
+func oauth2Config(conf config.OIDCProviderConf, provider *gooidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     conf.ClientID,
+		ClientSecret: conf.ClientSecret,
+		RedirectURL:  conf.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       conf.Scopes,
+	}
+}
+
+// identity is the verified external identity taken from the ID token.
+type identity struct {
+	Provider          string `json:"-"`
+	Issuer            string `json:"-"`
+	Subject           string `json:"-"`
+	Email             string `json:"email"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+func (i *identity) record(userID string) *storage.UserIdentityRecord {
+	now := time.Now()
+	return &storage.UserIdentityRecord{
+		UserId:     userID,
+		Provider:   i.Provider,
+		Issuer:     i.Issuer,
+		Subject:    i.Subject,
+		Email:      i.Email,
+		LastUsedAt: &now,
+	}
+}
+
+func (i *identity) displayName() string {
+	name := i.Name
+	if name == "" {
+		name = i.PreferredUsername
+	}
+	if name == "" {
+		name, _, _ = strings.Cut(i.Email, "@")
+	}
+	if name == "" {
+		name = i.Provider + " user"
+	}
+	if utf8.RuneCountInString(name) > maxNameLen {
+		name = string([]rune(name)[:maxNameLen])
+	}
+	return name
+}
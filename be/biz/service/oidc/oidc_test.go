@@ -0,0 +1,155 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/db/mysql"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/model/storage"
+	"doing_now/be/biz/service/oidc/oidctest"
+
+	"github.com/bytedance/mockey"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+const testRedirectURL = "http://localhost:8000/oidc/callback"
+
+var (
+	patchOnce sync.Once
+	currentDB *gorm.DB
+)
+
+func ensurePatches() {
+	patchOnce.Do(func() {
+		mockey.Mock(mysql.GetDbConn).To(func() *gorm.DB {
+			return currentDB
+		}).Build()
+		mockey.Mock((*repo.UserRepository).FindByUserIDLock).To(func(r *repo.UserRepository, ctx context.Context, userID string) (*storage.UserRecord, error) {
+			return r.FindByUserID(ctx, userID)
+		}).Build()
+	})
+}
+
+func setup(t *testing.T, autoProvision bool) (*Service, *oidctest.Provider) {
+	ensurePatches()
+
+	dsn := fmt.Sprintf("file:%s_%d?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"), time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	assert.NoError(t, err)
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	err = db.AutoMigrate(&storage.UserRecord{}, &storage.UserCredentialRecord{}, &storage.UserIdentityRecord{})
+	assert.NoError(t, err)
+	currentDB = db
+
+	idp, err := oidctest.New("client01", "secret01")
+	assert.NoError(t, err)
+	t.Cleanup(idp.Close)
+	idp.Subject, idp.Email, idp.Name = "sub01", "alice@example.com", "Alice"
+
+	svc := New(config.OIDCConf{Providers: []config.OIDCProviderConf{{
+		Name:          "fake",
+		Issuer:        idp.Issuer(),
+		ClientID:      idp.ClientID,
+		ClientSecret:  idp.ClientSecret,
+		RedirectURL:   testRedirectURL,
+		AutoProvision: autoProvision,
+	}}})
+	return svc, idp
+}
+
+// authorize runs the browser part of the flow and returns the session state, the state and the code.
+func authorize(t *testing.T, svc *Service, idp *oidctest.Provider) ([]byte, string, string) {
+	authURL, flow, bizErr := svc.Authorize(context.Background(), "fake")
+	assert.Nil(t, bizErr)
+	assert.Contains(t, authURL, "code_challenge_method=S256")
+	code, state, err := idp.Authorize(authURL)
+	assert.NoError(t, err)
+	return flow, state, code
+}
+
+func TestService_LoginAutoProvision(t *testing.T) {
+	svc, idp := setup(t, true)
+	ctx := context.Background()
+
+	flow, state, code := authorize(t, svc, idp)
+	u, cv, bizErr := svc.Login(ctx, flow, state, code)
+	assert.Nil(t, bizErr)
+	assert.Equal(t, "Alice", u.Name)
+	assert.True(t, strings.HasPrefix(u.Account, "fake_"))
+	assert.Equal(t, uint(0), cv)
+
+	t.Run("same identity signs in to the same user", func(t *testing.T) {
+		flow, state, code := authorize(t, svc, idp)
+		again, _, bizErr := svc.Login(ctx, flow, state, code)
+		assert.Nil(t, bizErr)
+		assert.Equal(t, u.UserID, again.UserID)
+	})
+
+	t.Run("code cannot be redeemed twice", func(t *testing.T) {
+		_, _, bizErr := svc.Login(ctx, flow, state, code)
+		assert.True(t, errs.ErrorEqual(errs.OIDCTokenInvalid, bizErr))
+	})
+
+	t.Run("state mismatch", func(t *testing.T) {
+		flow, _, code := authorize(t, svc, idp)
+		_, _, bizErr := svc.Login(ctx, flow, "forged", code)
+		assert.True(t, errs.ErrorEqual(errs.OIDCStateInvalid, bizErr))
+	})
+
+	t.Run("verifier of another flow", func(t *testing.T) {
+		flow, _, _ := authorize(t, svc, idp)
+		_, state, code := authorize(t, svc, idp)
+		// swap in the state of the second flow so only PKCE can tell them apart
+		forged := strings.Replace(string(flow), stateOf(t, flow), state, 1)
+		_, _, bizErr := svc.Login(ctx, []byte(forged), state, code)
+		assert.True(t, errs.ErrorEqual(errs.OIDCTokenInvalid, bizErr))
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		_, _, bizErr := svc.Authorize(ctx, "unknown")
+		assert.True(t, errs.ErrorEqual(errs.OIDCProviderNotFound, bizErr))
+	})
+}
+
+func TestService_Link(t *testing.T) {
+	svc, idp := setup(t, false)
+	ctx := context.Background()
+
+	assert.NoError(t, currentDB.Create(&storage.UserRecord{UserId: "user01", Account: "account01", Name: "name0001"}).Error)
+	assert.NoError(t, currentDB.Create(&storage.UserCredentialRecord{UserId: "user01", PasswordHash: "x", CredentialVersion: 4}).Error)
+	assert.NoError(t, currentDB.Create(&storage.UserRecord{UserId: "user02", Account: "account02", Name: "name0002"}).Error)
+
+	flow, state, code := authorize(t, svc, idp)
+	_, _, bizErr := svc.Login(ctx, flow, state, code)
+	assert.True(t, errs.ErrorEqual(errs.OIDCIdentityNotLinked, bizErr))
+
+	flow, state, code = authorize(t, svc, idp)
+	assert.Nil(t, svc.Link(ctx, "user01", flow, state, code))
+
+	flow, state, code = authorize(t, svc, idp)
+	u, cv, bizErr := svc.Login(ctx, flow, state, code)
+	assert.Nil(t, bizErr)
+	assert.Equal(t, "user01", u.UserID)
+	assert.Equal(t, uint(4), cv)
+
+	flow, state, code = authorize(t, svc, idp)
+	assert.True(t, errs.ErrorEqual(errs.OIDCIdentityLinked, svc.Link(ctx, "user02", flow, state, code)))
+}
+
+func stateOf(t *testing.T, flow []byte) string {
+	var f Flow
+	assert.NoError(t, json.Unmarshal(flow, &f))
+	return f.State
+}
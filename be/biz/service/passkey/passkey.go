@@ -0,0 +1,331 @@
+package passkey
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/db/mysql"
+	"doing_now/be/biz/model/convert"
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/model/storage"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultRPID          = "localhost"
+	defaultRPDisplayName = "Doing Now"
+	defaultTimeout       = 5 * time.Minute
+)
+
+type Service struct {
+	webAuthn *webauthn.WebAuthn
+}
+
+func New(conf config.WebAuthnConf) *Service {
+	timeout := time.Duration(conf.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	wconf := &webauthn.Config{
+		RPID:          conf.RPID,
+		RPDisplayName: conf.RPDisplayName,
+		RPOrigins:     conf.RPOrigins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: timeout},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: timeout},
+		},
+	}
+	if wconf.RPID == "" {
+		wconf.RPID = defaultRPID
+	}
+	if wconf.RPDisplayName == "" {
+		wconf.RPDisplayName = defaultRPDisplayName
+	}
+	return &Service{webAuthn: &webauthn.WebAuthn{Config: wconf}}
+}
+
+func NewDefault() *Service {
+	return New(config.GetWebAuthnConf())
+}
+
+// BeginRegistration returns the creation options for the browser and the ceremony state,
+// the state must be kept by the caller and passed back to FinishRegistration.
+func (s *Service) BeginRegistration(ctx context.Context, userID string) (*protocol.CredentialCreation, []byte, errs.Error) {
+	users := repo.NewUserRepository(mysql.GetDbConn().WithContext(ctx))
+	u, err := users.FindByUserID(ctx, userID)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "find user by id err: %v", err)
+		return nil, nil, errs.ServerError.SetErr(err)
+	}
+	if u == nil {
+		return nil, nil, errs.UserNotExist
+	}
+	wu, err := loadUser(ctx, mysql.GetDbConn().WithContext(ctx), u)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "load webauthn credentials err: %v", err)
+		return nil, nil, errs.ServerError.SetErr(err)
+	}
+
+	creation, session, err := s.webAuthn.BeginRegistration(wu,
+		webauthn.WithExclusions(webauthn.Credentials(wu.credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "begin webauthn registration err: %v", err)
+		return nil, nil, errs.ServerError.SetErr(err)
+	}
+	state, err := json.Marshal(session)
+	if err != nil {
+		return nil, nil, errs.ServerError.SetErr(err)
+	}
+	return creation, state, nil
+}
+
+// FinishRegistration verifies the attestation response and stores the new credential.
+func (s *Service) FinishRegistration(ctx context.Context, userID string, state, body []byte) errs.Error {
+	var session webauthn.SessionData
+	if err := json.Unmarshal(state, &session); err != nil {
+		hlog.CtxNoticef(ctx, "unmarshal webauthn session err: %v", err)
+		return errs.PasskeyCeremonyExpired
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(body)
+	if err != nil {
+		hlog.CtxNoticef(ctx, "parse webauthn creation response err: %v", err)
+		return errs.PasskeyInvalid
+	}
+
+	err = mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		u, err := repo.NewUserRepository(tx).FindByUserIDLock(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return errs.UserNotExist
+		}
+		wu, err := loadUser(ctx, tx, u)
+		if err != nil {
+			return err
+		}
+
+		credential, err := s.webAuthn.CreateCredential(wu, session, parsed)
+		if err != nil {
+			hlog.CtxNoticef(ctx, "create webauthn credential err: %v", err)
+			return errs.PasskeyInvalid
+		}
+		credentials := repo.NewUserWebAuthnCredentialRepository(tx)
+		record := credentialToRecord(userID, credential)
+		existing, err := credentials.FindByCredentialID(ctx, record.CredentialId)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			hlog.CtxNoticef(ctx, "webauthn credential already registered for user id: %s", existing.UserId)
+			return errs.PasskeyInvalid
+		}
+		return credentials.Create(ctx, record)
+	})
+	if err != nil {
+		if errs.IsDuplicatedErr(err) {
+			hlog.CtxNoticef(ctx, "webauthn credential already registered for user id: %s", userID)
+			return errs.PasskeyInvalid
+		}
+		return errs.Wrap(ctx, "finish webauthn registration", err)
+	}
+	hlog.CtxInfof(ctx, "passkey registered for user id: %s", userID)
+	return nil
+}
+
+// BeginLogin starts a discoverable login, the authenticator picks the account.
+func (s *Service) BeginLogin(ctx context.Context) (*protocol.CredentialAssertion, []byte, errs.Error) {
+	assertion, session, err := s.webAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		hlog.CtxErrorf(ctx, "begin webauthn login err: %v", err)
+		return nil, nil, errs.ServerError.SetErr(err)
+	}
+	state, err := json.Marshal(session)
+	if err != nil {
+		return nil, nil, errs.ServerError.SetErr(err)
+	}
+	return assertion, state, nil
+}
+
+// FinishLogin verifies the assertion response and returns the user with its credential version,
+// so the caller can issue the same session and tokens as a password login.
+func (s *Service) FinishLogin(ctx context.Context, state, body []byte) (*domain.User, uint, errs.Error) {
+	var session webauthn.SessionData
+	if err := json.Unmarshal(state, &session); err != nil {
+		hlog.CtxNoticef(ctx, "unmarshal webauthn session err: %v", err)
+		return nil, 0, errs.PasskeyCeremonyExpired
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(body)
+	if err != nil {
+		hlog.CtxNoticef(ctx, "parse webauthn request response err: %v", err)
+		return nil, 0, errs.PasskeyInvalid
+	}
+
+	var userRecord *storage.UserRecord
+	var credentialVersion uint
+	err = mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// lock the user so that sign counters are updated in order
+		u, err := repo.NewUserRepository(tx).FindByUserIDLock(ctx, string(parsed.Response.UserHandle))
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			hlog.CtxNoticef(ctx, "webauthn user handle not exist")
+			return errs.PasskeyInvalid
+		}
+		if !domain.UserStatus(u.Status).Active() {
+			hlog.CtxNoticef(ctx, "user status %s for user id: %s", u.Status, u.UserId)
+			return errs.UserStatusInvalid
+		}
+		wu, err := loadUser(ctx, tx, u)
+		if err != nil {
+			return err
+		}
+
+		credential, err := s.webAuthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			return wu, nil
+		}, session, parsed)
+		if err != nil {
+			hlog.CtxNoticef(ctx, "validate webauthn login err: %v", err)
+			return errs.PasskeyInvalid
+		}
+		if credential.Authenticator.CloneWarning {
+			hlog.CtxWarnf(ctx, "webauthn sign count did not increase, possible cloned authenticator for user id: %s", u.UserId)
+			return errs.PasskeyInvalid
+		}
+
+		record := wu.record(credential.ID)
+		now := time.Now()
+		record.SignCount = credential.Authenticator.SignCount
+		record.Flags = uint8(credential.Flags.ProtocolValue())
+		record.LastUsedAt = &now
+		if err := repo.NewUserWebAuthnCredentialRepository(tx).Update(ctx, record); err != nil {
+			return err
+		}
+
+		c, err := repo.NewUserCredentialRepository(tx).FindByUserID(ctx, u.UserId)
+		if err != nil {
+			return err
+		}
+		if c != nil {
+			credentialVersion = c.CredentialVersion
+		}
+		userRecord = u
+		return nil
+	})
+	if bizErr := errs.Wrap(ctx, "finish webauthn login", err); bizErr != nil {
+		return nil, 0, bizErr
+	}
+	return convert.UserRecordToDomain(userRecord), credentialVersion, nil
+}
+
+// user adapts a user record and its stored credentials to webauthn.User.
+type user struct {
+	u           *storage.UserRecord
+	records     []*storage.UserWebAuthnCredentialRecord
+	credentials []webauthn.Credential
+}
+
+func loadUser(ctx context.Context, db *gorm.DB, u *storage.UserRecord) (*user, error) {
+	records, err := repo.NewUserWebAuthnCredentialRepository(db).ListByUserID(ctx, u.UserId)
+	if err != nil {
+		return nil, err
+	}
+	wu := &user{u: u, records: records}
+	for _, r := range records {
+		credential, err := recordToCredential(r)
+		if err != nil {
+			return nil, err
+		}
+		wu.credentials = append(wu.credentials, credential)
+	}
+	return wu, nil
+}
+
+func (u *user) WebAuthnID() []byte {
+	return []byte(u.u.UserId)
+}
+
+func (u *user) WebAuthnName() string {
+	return u.u.Account
+}
+
+func (u *user) WebAuthnDisplayName() string {
+	return u.u.Name
+}
+
+func (u *user) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func (u *user) record(credentialID []byte) *storage.UserWebAuthnCredentialRecord {
+	for i, c := range u.credentials {
+		if bytes.Equal(c.ID, credentialID) {
+			return u.records[i]
+		}
+	}
+	return nil
+}
+
+func credentialToRecord(userID string, c *webauthn.Credential) *storage.UserWebAuthnCredentialRecord {
+	transports := make([]string, 0, len(c.Transport))
+	for _, t := range c.Transport {
+		transports = append(transports, string(t))
+	}
+	aaguid := ""
+	if id, err := uuid.FromBytes(c.Authenticator.AAGUID); err == nil {
+		aaguid = id.String()
+	}
+	return &storage.UserWebAuthnCredentialRecord{
+		UserId:          userID,
+		CredentialId:    base64.RawURLEncoding.EncodeToString(c.ID),
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		AAGUID:          aaguid,
+		SignCount:       c.Authenticator.SignCount,
+		Transports:      strings.Join(transports, ","),
+		Flags:           uint8(c.Flags.ProtocolValue()),
+	}
+}
+
+func recordToCredential(r *storage.UserWebAuthnCredentialRecord) (webauthn.Credential, error) {
+	id, err := base64.RawURLEncoding.DecodeString(r.CredentialId)
+	if err != nil {
+		return webauthn.Credential{}, err
+	}
+	var transports []protocol.AuthenticatorTransport
+	if r.Transports != "" {
+		for _, t := range strings.Split(r.Transports, ",") {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+	}
+	var aaguid []byte
+	if parsed, err := uuid.Parse(r.AAGUID); err == nil {
+		aaguid = parsed[:]
+	}
+	return webauthn.Credential{
+		ID:              id,
+		PublicKey:       r.PublicKey,
+		AttestationType: r.AttestationType,
+		Transport:       transports,
+		Flags:           webauthn.NewCredentialFlags(protocol.AuthenticatorFlags(r.Flags)),
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    aaguid,
+			SignCount: r.SignCount,
+		},
+	}, nil
+}
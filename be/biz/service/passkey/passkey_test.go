@@ -0,0 +1,148 @@
+package passkey
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/db/mysql"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/model/storage"
+	"doing_now/be/biz/service/passkey/passkeytest"
+
+	"github.com/bytedance/mockey"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:8000"
+)
+
+var (
+	patchOnce sync.Once
+	currentDB *gorm.DB
+)
+
+func ensurePatches() {
+	patchOnce.Do(func() {
+		mockey.Mock(mysql.GetDbConn).To(func() *gorm.DB {
+			return currentDB
+		}).Build()
+		mockey.Mock((*repo.UserRepository).FindByUserIDLock).To(func(r *repo.UserRepository, ctx context.Context, userID string) (*storage.UserRecord, error) {
+			return r.FindByUserID(ctx, userID)
+		}).Build()
+	})
+}
+
+func setup(t *testing.T) string {
+	ensurePatches()
+
+	dsn := fmt.Sprintf("file:%s_%d?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"), time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	assert.NoError(t, err)
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	err = db.AutoMigrate(&storage.UserRecord{}, &storage.UserCredentialRecord{}, &storage.UserWebAuthnCredentialRecord{})
+	assert.NoError(t, err)
+	currentDB = db
+
+	userID := "user01"
+	assert.NoError(t, db.Create(&storage.UserRecord{UserId: userID, Account: "account01", Name: "name0001"}).Error)
+	assert.NoError(t, db.Create(&storage.UserCredentialRecord{UserId: userID, PasswordHash: "x", CredentialVersion: 2}).Error)
+	return userID
+}
+
+func register(t *testing.T, svc *Service, userID string, authenticator *passkeytest.Authenticator) {
+	ctx := context.Background()
+	creation, state, bizErr := svc.BeginRegistration(ctx, userID)
+	assert.Nil(t, bizErr)
+	body, err := authenticator.Create(creation)
+	assert.NoError(t, err)
+	assert.Nil(t, svc.FinishRegistration(ctx, userID, state, body))
+}
+
+func login(t *testing.T, svc *Service, authenticator *passkeytest.Authenticator) (string, uint, errs.Error) {
+	assertion, state, bizErr := svc.BeginLogin(context.Background())
+	assert.Nil(t, bizErr)
+	body, err := authenticator.Get(assertion)
+	assert.NoError(t, err)
+	u, cv, bizErr := svc.FinishLogin(context.Background(), state, body)
+	if bizErr != nil {
+		return "", 0, bizErr
+	}
+	return u.UserID, cv, nil
+}
+
+func TestService_RegisterAndLogin(t *testing.T) {
+	userID := setup(t)
+	svc := New(config.WebAuthnConf{RPID: testRPID, RPOrigins: []string{testOrigin}})
+
+	authenticator, err := passkeytest.New(testRPID, testOrigin)
+	assert.NoError(t, err)
+	register(t, svc, userID, authenticator)
+
+	var records []*storage.UserWebAuthnCredentialRecord
+	assert.NoError(t, currentDB.Find(&records).Error)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "none", records[0].AttestationType)
+	assert.Equal(t, "internal", records[0].Transports)
+	assert.Equal(t, uint32(1), records[0].SignCount)
+
+	gotUserID, cv, bizErr := login(t, svc, authenticator)
+	assert.Nil(t, bizErr)
+	assert.Equal(t, userID, gotUserID)
+	assert.Equal(t, uint(2), cv)
+
+	assert.NoError(t, currentDB.First(records[0]).Error)
+	assert.Equal(t, uint32(2), records[0].SignCount)
+	assert.NotNil(t, records[0].LastUsedAt)
+
+	t.Run("same authenticator cannot register twice", func(t *testing.T) {
+		creation, state, bizErr := svc.BeginRegistration(context.Background(), userID)
+		assert.Nil(t, bizErr)
+		assert.Len(t, creation.Response.CredentialExcludeList, 1)
+		body, err := authenticator.Create(creation)
+		assert.NoError(t, err)
+		assert.True(t, errs.ErrorEqual(errs.PasskeyInvalid, svc.FinishRegistration(context.Background(), userID, state, body)))
+	})
+
+	t.Run("challenge of another ceremony rejected", func(t *testing.T) {
+		_, state, bizErr := svc.BeginLogin(context.Background())
+		assert.Nil(t, bizErr)
+		other, _, bizErr := svc.BeginLogin(context.Background())
+		assert.Nil(t, bizErr)
+		body, err := authenticator.Get(other)
+		assert.NoError(t, err)
+		_, _, bizErr = svc.FinishLogin(context.Background(), state, body)
+		assert.True(t, errs.ErrorEqual(errs.PasskeyInvalid, bizErr))
+	})
+
+	t.Run("cloned authenticator rejected", func(t *testing.T) {
+		authenticator.SignCount = 0
+		_, _, bizErr := login(t, svc, authenticator)
+		assert.True(t, errs.ErrorEqual(errs.PasskeyInvalid, bizErr))
+	})
+}
+
+func TestService_WrongOrigin(t *testing.T) {
+	userID := setup(t)
+	svc := New(config.WebAuthnConf{RPID: testRPID, RPOrigins: []string{testOrigin}})
+
+	authenticator, err := passkeytest.New(testRPID, "http://evil.example.com")
+	assert.NoError(t, err)
+
+	creation, state, bizErr := svc.BeginRegistration(context.Background(), userID)
+	assert.Nil(t, bizErr)
+	body, err := authenticator.Create(creation)
+	assert.NoError(t, err)
+	assert.True(t, errs.ErrorEqual(errs.PasskeyInvalid, svc.FinishRegistration(context.Background(), userID, state, body)))
+}
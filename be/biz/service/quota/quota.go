@@ -0,0 +1,436 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/db/mysql"
+	rediscli "doing_now/be/biz/db/redis"
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/model/storage"
+	"doing_now/be/biz/service/audit"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	goredis "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+const (
+	// FeatureAPI meters the calls of authenticated users to the API
+	FeatureAPI = "api"
+
+	keyUsage = "quota:"
+	keyDirty = "quota:dirty"
+	keyPlan  = "quota_plan:"
+
+	layoutDay   = "2006-01-02"
+	layoutMonth = "2006-01"
+	// counters outlive their period, so the last flush can still read them
+	counterGrace = 24 * time.Hour
+
+	defaultFlush     = time.Minute
+	defaultPlanCache = 5 * time.Minute
+	flushBatch       = 100
+)
+
+// consumeScript counts a use of the feature in the day and the month, unless either quota is
+// used up. Without the seed flag it refuses to count while a counter is missing, so the caller
+// can seed it from the rollup in MySQL first.
+// KEYS[1]: The day counter
+// KEYS[2]: The month counter
+// KEYS[3]: The set of counters to roll up
+// ARGV[1]: Daily quota, 0 is unlimited
+// ARGV[2]: Monthly quota, 0 is unlimited
+// ARGV[3]: TTL of the day counter in seconds
+// ARGV[4]: TTL of the month counter in seconds
+// ARGV[5]: "1" when ARGV[6] and ARGV[7] seed missing counters
+// ARGV[8], ARGV[9]: The members of the counters in KEYS[3]
+// Returns {1 counted, 0 quota used up or -1 not seeded, day usage, month usage}
+var consumeScript = goredis.NewScript(`
+if ARGV[5] == "1" then
+    redis.call("SET", KEYS[1], ARGV[6], "EX", ARGV[3], "NX")
+    redis.call("SET", KEYS[2], ARGV[7], "EX", ARGV[4], "NX")
+elseif redis.call("EXISTS", KEYS[1]) == 0 or redis.call("EXISTS", KEYS[2]) == 0 then
+    return {-1, 0, 0}
+end
+
+local daily = tonumber(redis.call("GET", KEYS[1]))
+local monthly = tonumber(redis.call("GET", KEYS[2]))
+local dailyLimit = tonumber(ARGV[1])
+local monthlyLimit = tonumber(ARGV[2])
+if (dailyLimit > 0 and daily >= dailyLimit) or (monthlyLimit > 0 and monthly >= monthlyLimit) then
+    return {0, daily, monthly}
+end
+
+daily = redis.call("INCR", KEYS[1])
+monthly = redis.call("INCR", KEYS[2])
+redis.call("SADD", KEYS[3], ARGV[8], ARGV[9])
+return {1, daily, monthly}
+`)
+
+// Limits are the quotas of a feature, 0 is unlimited.
+type Limits struct {
+	Daily   int64
+	Monthly int64
+}
+
+// Usage is what a user has used of a feature in the current day and month, in UTC.
+type Usage struct {
+	Feature      string
+	Limits       Limits
+	DailyUsed    int64
+	MonthlyUsed  int64
+	DailyReset   time.Time
+	MonthlyReset time.Time
+}
+
+// RetryAfter is how long until the used up quota is back, zero when none is used up.
+func (u Usage) RetryAfter(now time.Time) time.Duration {
+	if u.Limits.Monthly > 0 && u.MonthlyUsed >= u.Limits.Monthly {
+		return u.MonthlyReset.Sub(now)
+	}
+	if u.Limits.Daily > 0 && u.DailyUsed >= u.Limits.Daily {
+		return u.DailyReset.Sub(now)
+	}
+	return 0
+}
+
+type cachedLimits struct {
+	limits    map[string]Limits
+	expiresAt time.Time
+}
+
+// Service meters the features users use against the daily and monthly quotas of their plan. Usage
+// is counted in Redis and rolled up to MySQL now and then, plans and their quotas are maintained
+// in MySQL and users get a plan assigned by name.
+type Service struct {
+	defaultPlan string
+	flushEvery  time.Duration
+	planCache   time.Duration
+
+	mu     sync.Mutex
+	limits map[string]cachedLimits
+
+	flushedAt atomic.Int64
+	flushing  atomic.Bool
+}
+
+func New(conf config.QuotaConf) *Service {
+	s := &Service{
+		defaultPlan: conf.DefaultPlan,
+		flushEvery:  time.Duration(conf.FlushSeconds) * time.Second,
+		planCache:   time.Duration(conf.PlanCacheSeconds) * time.Second,
+		limits:      make(map[string]cachedLimits),
+	}
+	if s.flushEvery <= 0 {
+		s.flushEvery = defaultFlush
+	}
+	if s.planCache <= 0 {
+		s.planCache = defaultPlanCache
+	}
+	s.flushedAt.Store(time.Now().UnixNano())
+	return s
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultService *Service
+)
+
+// Default returns the service built from the config, the middleware and the handlers share it
+// along with its caches.
+func Default() *Service {
+	defaultOnce.Do(func() {
+		defaultService = New(config.GetQuotaConf())
+	})
+	return defaultService
+}
+
+// Plan returns the plan of the user, the default plan when none is assigned.
+func (s *Service) Plan(ctx context.Context, userID string) (string, errs.Error) {
+	rdb := rediscli.GetRedisClient()
+	plan, err := rdb.Get(ctx, keyPlan+userID).Result()
+	if err == nil {
+		return plan, nil
+	}
+	if !errors.Is(err, goredis.Nil) {
+		hlog.CtxErrorf(ctx, "get cached plan err: %v", err)
+	}
+
+	plan, err = repo.NewUserPlanRepository(mysql.GetDbConn().WithContext(ctx)).FindPlan(ctx, userID)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "find user plan err: %v", err)
+		return "", errs.ServerError.SetErr(err)
+	}
+	if plan == "" {
+		plan = s.defaultPlan
+	}
+	if err := rdb.Set(ctx, keyPlan+userID, plan, s.planCache).Err(); err != nil {
+		hlog.CtxErrorf(ctx, "cache plan err: %v", err)
+	}
+	return plan, nil
+}
+
+// Limits returns the quotas of the plan by feature, features without quotas are not metered.
+func (s *Service) Limits(ctx context.Context, plan string) (map[string]Limits, errs.Error) {
+	if plan == "" {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	cached, ok := s.limits[plan]
+	s.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.limits, nil
+	}
+
+	records, err := repo.NewPlanQuotaRepository(mysql.GetDbConn().WithContext(ctx)).FindByPlan(ctx, plan)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "find plan quotas err: %v", err)
+		return nil, errs.ServerError.SetErr(err)
+	}
+	limits := make(map[string]Limits, len(records))
+	for _, r := range records {
+		limits[r.Feature] = Limits{Daily: r.Daily, Monthly: r.Monthly}
+	}
+
+	s.mu.Lock()
+	s.limits[plan] = cachedLimits{limits: limits, expiresAt: time.Now().Add(s.planCache)}
+	s.mu.Unlock()
+	return limits, nil
+}
+
+// Consume counts a use of the feature by the user, it returns errs.QuotaExceeded without counting
+// when the daily or monthly quota is used up. Features the plan has no quota for are not counted.
+func (s *Service) Consume(ctx context.Context, userID, feature string) (Usage, errs.Error) {
+	plan, bizErr := s.Plan(ctx, userID)
+	if bizErr != nil {
+		return Usage{}, bizErr
+	}
+	planLimits, bizErr := s.Limits(ctx, plan)
+	if bizErr != nil {
+		return Usage{}, bizErr
+	}
+	limits, ok := planLimits[feature]
+	if !ok {
+		return Usage{Feature: feature}, nil
+	}
+
+	now := time.Now().UTC()
+	usage := newUsage(feature, limits, now)
+	day, month := now.Format(layoutDay), now.Format(layoutMonth)
+	keys := []string{usageKey(feature, userID, day), usageKey(feature, userID, month), keyDirty}
+	args := []any{
+		limits.Daily, limits.Monthly,
+		int64((usage.DailyReset.Sub(now) + counterGrace).Seconds()),
+		int64((usage.MonthlyReset.Sub(now) + counterGrace).Seconds()),
+		"0", 0, 0,
+		dirtyMember(feature, userID, day), dirtyMember(feature, userID, month),
+	}
+
+	rdb := rediscli.GetRedisClient()
+	result, err := consumeScript.Run(ctx, rdb, keys, args...).Int64Slice()
+	if err == nil && result[0] == -1 {
+		// the counters are gone, from Redis or because the period is new, go on from the rollups
+		args[4] = "1"
+		args[5], args[6] = s.rolledUp(ctx, userID, feature, day), s.rolledUp(ctx, userID, feature, month)
+		result, err = consumeScript.Run(ctx, rdb, keys, args...).Int64Slice()
+	}
+	if err != nil {
+		hlog.CtxErrorf(ctx, "consume quota err: %v", err)
+		return usage, errs.ServerError.SetErr(err)
+	}
+
+	usage.DailyUsed, usage.MonthlyUsed = result[1], result[2]
+	s.maybeFlush()
+	if result[0] == 0 {
+		return usage, errs.QuotaExceeded
+	}
+	return usage, nil
+}
+
+// Usage returns what the user has used of every feature the plan has quotas for.
+func (s *Service) Usage(ctx context.Context, userID string) (string, []Usage, errs.Error) {
+	plan, bizErr := s.Plan(ctx, userID)
+	if bizErr != nil {
+		return "", nil, bizErr
+	}
+	planLimits, bizErr := s.Limits(ctx, plan)
+	if bizErr != nil {
+		return "", nil, bizErr
+	}
+
+	features := make([]string, 0, len(planLimits))
+	for feature := range planLimits {
+		features = append(features, feature)
+	}
+	slices.Sort(features)
+
+	now := time.Now().UTC()
+	day, month := now.Format(layoutDay), now.Format(layoutMonth)
+	pipe := rediscli.GetRedisClient().Pipeline()
+	cmds := make([][2]*goredis.StringCmd, len(features))
+	for i, feature := range features {
+		cmds[i] = [2]*goredis.StringCmd{
+			pipe.Get(ctx, usageKey(feature, userID, day)),
+			pipe.Get(ctx, usageKey(feature, userID, month)),
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, goredis.Nil) {
+		hlog.CtxErrorf(ctx, "get quota usage err: %v", err)
+		return "", nil, errs.ServerError.SetErr(err)
+	}
+
+	usages := make([]Usage, 0, len(features))
+	for i, feature := range features {
+		usage := newUsage(feature, planLimits[feature], now)
+		usage.DailyUsed = s.counted(ctx, cmds[i][0], userID, feature, day)
+		usage.MonthlyUsed = s.counted(ctx, cmds[i][1], userID, feature, month)
+		usages = append(usages, usage)
+	}
+	return plan, usages, nil
+}
+
+// AssignPlan gives the user a plan, replacing the one assigned before.
+func (s *Service) AssignPlan(ctx context.Context, userID, plan string) errs.Error {
+	err := mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		u, err := repo.NewUserRepository(tx).FindByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return errs.UserNotExist
+		}
+		p, err := repo.NewPlanRepository(tx).FindByName(ctx, plan)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			return errs.PlanNotFound
+		}
+		return repo.NewUserPlanRepository(tx).Assign(ctx, userID, plan)
+	})
+	if bizErr := errs.Wrap(ctx, "assign plan", err); bizErr != nil {
+		return bizErr
+	}
+
+	if err := rediscli.GetRedisClient().Del(ctx, keyPlan+userID).Err(); err != nil {
+		hlog.CtxErrorf(ctx, "clear cached plan err: %v", err)
+	}
+	audit.Record(ctx, audit.Event{
+		Type:     domain.AuditUserPlanChange,
+		TargetID: userID,
+		Details:  map[string]any{"plan": plan},
+	})
+	hlog.CtxInfof(ctx, "plan %s assigned to user id: %s", plan, userID)
+	return nil
+}
+
+// Flush rolls the usage counted since the last flush up to MySQL.
+func (s *Service) Flush(ctx context.Context) error {
+	rdb := rediscli.GetRedisClient()
+	usages := repo.NewQuotaUsageRepository(mysql.GetDbConn().WithContext(ctx))
+	for {
+		members, err := rdb.SPopN(ctx, keyDirty, flushBatch).Result()
+		if err != nil {
+			return err
+		}
+		if len(members) == 0 {
+			return nil
+		}
+
+		for i, member := range members {
+			feature, period, userID, ok := parseDirtyMember(member)
+			if !ok {
+				continue
+			}
+			used, err := rdb.Get(ctx, usageKey(feature, userID, period)).Int64()
+			if errors.Is(err, goredis.Nil) {
+				continue
+			}
+			if err == nil {
+				err = usages.Upsert(ctx, &storage.QuotaUsageRecord{UserId: userID, Feature: feature, Period: period, Used: used})
+			}
+			if err != nil {
+				// put back what is left for the next flush
+				left := make([]any, 0, len(members)-i)
+				for _, m := range members[i:] {
+					left = append(left, m)
+				}
+				rdb.SAdd(ctx, keyDirty, left...)
+				return err
+			}
+		}
+	}
+}
+
+// maybeFlush starts a flush in the background once flushEvery has passed since the last one.
+func (s *Service) maybeFlush() {
+	if time.Since(time.Unix(0, s.flushedAt.Load())) < s.flushEvery || !s.flushing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer s.flushing.Store(false)
+		ctx := context.Background()
+		if err := s.Flush(ctx); err != nil {
+			hlog.CtxErrorf(ctx, "flush quota usage err: %v", err)
+		}
+		s.flushedAt.Store(time.Now().UnixNano())
+	}()
+}
+
+// counted reads a counter of Usage, the rollup stands in for a counter which is gone
+func (s *Service) counted(ctx context.Context, cmd *goredis.StringCmd, userID, feature, period string) int64 {
+	used, err := cmd.Int64()
+	if err != nil {
+		return s.rolledUp(ctx, userID, feature, period)
+	}
+	return used
+}
+
+// rolledUp returns the usage of the period last written to MySQL
+func (s *Service) rolledUp(ctx context.Context, userID, feature, period string) int64 {
+	m, err := repo.NewQuotaUsageRepository(mysql.GetDbConn().WithContext(ctx)).Find(ctx, userID, feature, period)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "find quota usage err: %v", err)
+		return 0
+	}
+	if m == nil {
+		return 0
+	}
+	return m.Used
+}
+
+func newUsage(feature string, limits Limits, now time.Time) Usage {
+	year, month, day := now.Date()
+	return Usage{
+		Feature:      feature,
+		Limits:       limits,
+		DailyReset:   time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC),
+		MonthlyReset: time.Date(year, month+1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func usageKey(feature, userID, period string) string {
+	return keyUsage + feature + ":" + period + ":" + userID
+}
+
+func dirtyMember(feature, userID, period string) string {
+	return feature + "|" + period + "|" + userID
+}
+
+func parseDirtyMember(member string) (feature, period, userID string, ok bool) {
+	parts := strings.SplitN(member, "|", 3)
+	if len(parts) != 3 {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
@@ -0,0 +1,167 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/db/mysql"
+	db_redis "doing_now/be/biz/db/redis"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/model/storage"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bytedance/mockey"
+	"github.com/glebarez/sqlite"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var (
+	patchOnce sync.Once
+	currentDB *gorm.DB
+	currentMR *miniredis.Miniredis
+	currentRC *redis.Client
+)
+
+func ensurePatches() {
+	patchOnce.Do(func() {
+		mockey.Mock(mysql.GetDbConn).To(func() *gorm.DB {
+			return currentDB
+		}).Build()
+		mockey.Mock(db_redis.GetRedisClient).To(func() *redis.Client {
+			return currentRC
+		}).Build()
+	})
+}
+
+func setup(t *testing.T) {
+	ensurePatches()
+
+	dsn := fmt.Sprintf("file:%s_%d?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"), time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	assert.NoError(t, err)
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	err = db.AutoMigrate(&storage.UserRecord{}, &storage.PlanRecord{}, &storage.PlanQuotaRecord{}, &storage.UserPlanRecord{}, &storage.QuotaUsageRecord{})
+	assert.NoError(t, err)
+	currentDB = db
+
+	currentMR = miniredis.RunT(t)
+	currentRC = redis.NewClient(&redis.Options{Addr: currentMR.Addr()})
+
+	assert.NoError(t, db.Create(&storage.UserRecord{UserId: "user01", Account: "account01", Name: "name0001"}).Error)
+	assert.NoError(t, db.Create(&[]*storage.PlanRecord{{Name: "free"}, {Name: "pro"}}).Error)
+	assert.NoError(t, db.Create(&[]*storage.PlanQuotaRecord{
+		{Plan: "free", Feature: FeatureAPI, Daily: 2, Monthly: 3},
+		{Plan: "pro", Feature: FeatureAPI},
+	}).Error)
+}
+
+func TestService_Consume(t *testing.T) {
+	setup(t)
+	ctx := context.Background()
+	s := New(config.QuotaConf{DefaultPlan: "free"})
+
+	// features without quotas are not metered
+	usage, bizErr := s.Consume(ctx, "user01", "export")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, int64(0), usage.DailyUsed)
+
+	for n := range 2 {
+		usage, bizErr = s.Consume(ctx, "user01", FeatureAPI)
+		assert.Nil(t, bizErr)
+		assert.Equal(t, int64(n+1), usage.DailyUsed)
+	}
+	usage, bizErr = s.Consume(ctx, "user01", FeatureAPI)
+	assert.True(t, errs.ErrorEqual(errs.QuotaExceeded, bizErr))
+	assert.Equal(t, int64(2), usage.DailyUsed)
+	now := time.Now().UTC()
+	assert.Equal(t, usage.DailyReset.Sub(now), usage.RetryAfter(now))
+
+	// a new day, the month is used up a request later
+	currentMR.Del(usageKey(FeatureAPI, "user01", now.Format(layoutDay)))
+	_, bizErr = s.Consume(ctx, "user01", FeatureAPI)
+	assert.Nil(t, bizErr)
+	usage, bizErr = s.Consume(ctx, "user01", FeatureAPI)
+	assert.True(t, errs.ErrorEqual(errs.QuotaExceeded, bizErr))
+	assert.Equal(t, int64(3), usage.MonthlyUsed)
+	assert.Equal(t, usage.MonthlyReset.Sub(now), usage.RetryAfter(now))
+
+	// unlimited on pro
+	assert.Nil(t, s.AssignPlan(ctx, "user01", "pro"))
+	for range 5 {
+		_, bizErr = s.Consume(ctx, "user01", FeatureAPI)
+		assert.Nil(t, bizErr)
+	}
+}
+
+func TestService_Rollup(t *testing.T) {
+	setup(t)
+	ctx := context.Background()
+	s := New(config.QuotaConf{DefaultPlan: "free"})
+	now := time.Now().UTC()
+
+	_, bizErr := s.Consume(ctx, "user01", FeatureAPI)
+	assert.Nil(t, bizErr)
+	assert.NoError(t, s.Flush(ctx))
+
+	var usages []storage.QuotaUsageRecord
+	assert.NoError(t, currentDB.Order("period").Find(&usages).Error)
+	assert.Len(t, usages, 2)
+	assert.Equal(t, now.Format(layoutMonth), usages[0].Period)
+	assert.Equal(t, now.Format(layoutDay), usages[1].Period)
+	assert.Equal(t, int64(1), usages[1].Used)
+
+	// Redis lost its counters, the rollups carry on
+	currentMR.FlushAll()
+	_, usageList, bizErr := s.Usage(ctx, "user01")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, int64(1), usageList[0].MonthlyUsed)
+
+	usage, bizErr := s.Consume(ctx, "user01", FeatureAPI)
+	assert.Nil(t, bizErr)
+	assert.Equal(t, int64(2), usage.DailyUsed)
+	assert.Equal(t, int64(2), usage.MonthlyUsed)
+
+	// a rollup never goes back
+	assert.NoError(t, s.Flush(ctx))
+	currentMR.Set(usageKey(FeatureAPI, "user01", now.Format(layoutDay)), "1")
+	currentMR.SAdd(keyDirty, dirtyMember(FeatureAPI, "user01", now.Format(layoutDay)))
+	assert.NoError(t, s.Flush(ctx))
+	var m storage.QuotaUsageRecord
+	assert.NoError(t, currentDB.Where("period = ?", now.Format(layoutDay)).First(&m).Error)
+	assert.Equal(t, int64(2), m.Used)
+}
+
+func TestService_AssignPlan(t *testing.T) {
+	setup(t)
+	ctx := context.Background()
+	s := New(config.QuotaConf{DefaultPlan: "free"})
+
+	plan, bizErr := s.Plan(ctx, "user01")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, "free", plan)
+
+	assert.True(t, errs.ErrorEqual(errs.PlanNotFound, s.AssignPlan(ctx, "user01", "gold")))
+	assert.True(t, errs.ErrorEqual(errs.UserNotExist, s.AssignPlan(ctx, "user02", "pro")))
+
+	// the cached plan is dropped
+	assert.Nil(t, s.AssignPlan(ctx, "user01", "pro"))
+	plan, _ = s.Plan(ctx, "user01")
+	assert.Equal(t, "pro", plan)
+	assert.Nil(t, s.AssignPlan(ctx, "user01", "free"))
+	plan, _ = s.Plan(ctx, "user01")
+	assert.Equal(t, "free", plan)
+
+	// without a default plan nobody is metered
+	_, usages, bizErr := New(config.QuotaConf{}).Usage(ctx, "user02")
+	assert.Nil(t, bizErr)
+	assert.Empty(t, usages)
+}
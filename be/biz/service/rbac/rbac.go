@@ -0,0 +1,102 @@
+package rbac
+
+import (
+	"context"
+
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/db/mysql"
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/model/storage"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"gorm.io/gorm"
+)
+
+// Service resolves the roles of users and the permissions the roles grant. Roles and their
+// permissions are maintained in MySQL, users get roles assigned by name.
+type Service struct{}
+
+func New() *Service {
+	return &Service{}
+}
+
+func NewDefault() *Service {
+	return New()
+}
+
+func (s *Service) Roles(ctx context.Context, userID string) ([]string, errs.Error) {
+	roles, err := repo.NewUserRoleRepository(mysql.GetDbConn().WithContext(ctx)).FindRoles(ctx, userID)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "find user roles err: %v", err)
+		return nil, errs.ServerError.SetErr(err)
+	}
+	return roles, nil
+}
+
+func (s *Service) Permissions(ctx context.Context, roles []string) (domain.PermissionSet, errs.Error) {
+	perms, err := repo.NewRolePermissionRepository(mysql.GetDbConn().WithContext(ctx)).FindPermissions(ctx, roles)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "find role permissions err: %v", err)
+		return nil, errs.ServerError.SetErr(err)
+	}
+	return domain.NewPermissionSet(perms), nil
+}
+
+func (s *Service) UserPermissions(ctx context.Context, userID string) (domain.PermissionSet, errs.Error) {
+	roles, bizErr := s.Roles(ctx, userID)
+	if bizErr != nil {
+		return nil, bizErr
+	}
+	return s.Permissions(ctx, roles)
+}
+
+// AssignRole gives the user a role, assigning a role the user already has is a no-op.
+func (s *Service) AssignRole(ctx context.Context, userID, role string) errs.Error {
+	err := mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		u, err := repo.NewUserRepository(tx).FindByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return errs.UserNotExist
+		}
+		r, err := repo.NewRoleRepository(tx).FindByName(ctx, role)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return errs.RoleNotFound
+		}
+
+		userRoles := repo.NewUserRoleRepository(tx)
+		roles, err := userRoles.FindRoles(ctx, userID)
+		if err != nil {
+			return err
+		}
+		for _, existing := range roles {
+			if existing == role {
+				return nil
+			}
+		}
+		return userRoles.Create(ctx, &storage.UserRoleRecord{UserId: userID, Role: role})
+	})
+	if errs.IsDuplicatedErr(err) {
+		// assigned concurrently
+		err = nil
+	}
+	if bizErr := errs.Wrap(ctx, "assign role", err); bizErr != nil {
+		return bizErr
+	}
+	hlog.CtxInfof(ctx, "role %s assigned to user id: %s", role, userID)
+	return nil
+}
+
+func (s *Service) RemoveRole(ctx context.Context, userID, role string) errs.Error {
+	err := repo.NewUserRoleRepository(mysql.GetDbConn().WithContext(ctx)).Delete(ctx, userID, role)
+	if bizErr := errs.Wrap(ctx, "remove role", err); bizErr != nil {
+		return bizErr
+	}
+	hlog.CtxInfof(ctx, "role %s removed from user id: %s", role, userID)
+	return nil
+}
@@ -0,0 +1,108 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"doing_now/be/biz/db/mysql"
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/model/storage"
+
+	"github.com/bytedance/mockey"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var (
+	patchOnce sync.Once
+	currentDB *gorm.DB
+)
+
+func ensurePatches() {
+	patchOnce.Do(func() {
+		mockey.Mock(mysql.GetDbConn).To(func() *gorm.DB {
+			return currentDB
+		}).Build()
+	})
+}
+
+func setup(t *testing.T) {
+	ensurePatches()
+
+	dsn := fmt.Sprintf("file:%s_%d?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"), time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	assert.NoError(t, err)
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	err = db.AutoMigrate(&storage.UserRecord{}, &storage.RoleRecord{}, &storage.RolePermissionRecord{}, &storage.UserRoleRecord{})
+	assert.NoError(t, err)
+	currentDB = db
+
+	assert.NoError(t, db.Create(&storage.UserRecord{UserId: "user01", Account: "account01", Name: "name0001"}).Error)
+	assert.NoError(t, db.Create(&[]*storage.RoleRecord{{Name: "admin"}, {Name: "support"}, {Name: "auditor"}}).Error)
+	assert.NoError(t, db.Create(&[]*storage.RolePermissionRecord{
+		{Role: "admin", Permission: domain.PermAll},
+		{Role: "support", Permission: domain.PermUserRead},
+		{Role: "auditor", Permission: "audit:*"},
+		{Role: "auditor", Permission: domain.PermUserRead},
+	}).Error)
+}
+
+func TestPermissionSet(t *testing.T) {
+	set := domain.NewPermissionSet([]string{"user:read", "audit:*"})
+	assert.True(t, set.Has("user:read"))
+	assert.False(t, set.Has("user:write"))
+	assert.True(t, set.Has("audit:read"))
+	assert.False(t, set.Has("audit"))
+	assert.Equal(t, []string{"audit:*", "user:read"}, set.List())
+
+	assert.True(t, domain.NewPermissionSet([]string{domain.PermAll}).Has("anything"))
+	assert.False(t, domain.NewPermissionSet(nil).Has("user:read"))
+}
+
+func TestService_Roles(t *testing.T) {
+	setup(t)
+	ctx := context.Background()
+	svc := New()
+
+	perms, bizErr := svc.UserPermissions(ctx, "user01")
+	assert.Nil(t, bizErr)
+	assert.Empty(t, perms)
+
+	assert.Nil(t, svc.AssignRole(ctx, "user01", "support"))
+	assert.Nil(t, svc.AssignRole(ctx, "user01", "support"))
+	assert.Nil(t, svc.AssignRole(ctx, "user01", "auditor"))
+
+	roles, bizErr := svc.Roles(ctx, "user01")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, []string{"auditor", "support"}, roles)
+
+	perms, bizErr = svc.UserPermissions(ctx, "user01")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, []string{"audit:*", "user:read"}, perms.List())
+	assert.False(t, perms.Has(domain.PermUserWrite))
+
+	t.Run("unknown role or user", func(t *testing.T) {
+		bizErr := svc.AssignRole(ctx, "user01", "root")
+		assert.True(t, errs.ErrorEqual(errs.RoleNotFound, bizErr))
+		bizErr = svc.AssignRole(ctx, "user02", "admin")
+		assert.True(t, errs.ErrorEqual(errs.UserNotExist, bizErr))
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		assert.Nil(t, svc.RemoveRole(ctx, "user01", "auditor"))
+		perms, bizErr := svc.UserPermissions(ctx, "user01")
+		assert.Nil(t, bizErr)
+		assert.Equal(t, []string{"user:read"}, perms.List())
+
+		// assigning again after the removal works
+		assert.Nil(t, svc.AssignRole(ctx, "user01", "auditor"))
+	})
+}
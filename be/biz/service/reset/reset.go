@@ -0,0 +1,271 @@
+package reset
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/db/mysql"
+	"doing_now/be/biz/model/domain"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/model/storage"
+	"doing_now/be/biz/service/audit"
+	"doing_now/be/biz/service/lockout"
+	"doing_now/be/biz/util/encode"
+	"doing_now/be/biz/util/mailer"
+	"doing_now/be/biz/util/password"
+	"doing_now/be/biz/util/random"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultTokenTTL = 30 * time.Minute
+
+	tokenLen = 43
+
+	mailSubject = "Reset your Doing Now password"
+)
+
+// sending tracks the reset mails of Forgot which are sent in the background
+var sending sync.WaitGroup
+
+// Service resets forgotten passwords. A reset mails a random token to the user, only its hash
+// is stored, and the token can be used once before it expires.
+type Service struct {
+	tokenTTL  time.Duration
+	url       string
+	mailer    mailer.Mailer
+	passwords *password.Manager
+}
+
+func New(conf config.PasswordResetConf, m mailer.Mailer) *Service {
+	s := &Service{
+		tokenTTL:  time.Duration(conf.TokenTTL) * time.Second,
+		url:       conf.URL,
+		mailer:    m,
+		passwords: password.NewDefault(),
+	}
+	if s.tokenTTL <= 0 {
+		s.tokenTTL = defaultTokenTTL
+	}
+	return s
+}
+
+func NewDefault() *Service {
+	m, err := mailer.Default()
+	if err != nil {
+		hlog.Errorf("init mailer err: %v", err)
+	}
+	return New(config.GetPasswordResetConf(), m)
+}
+
+// Forgot mails a reset token to the verified email of the account, the account may also be given
+// by its email. The lookup and the mail run in the background and their failures are only logged,
+// so neither the response nor its timing tells which accounts exist.
+func (s *Service) Forgot(ctx context.Context, account string) errs.Error {
+	if s.mailer == nil {
+		return errs.ServerError.SetMsg("mailer not available")
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	sending.Add(1)
+	go func() {
+		defer sending.Done()
+		s.forgot(ctx, account)
+	}()
+	return nil
+}
+
+func (s *Service) forgot(ctx context.Context, account string) {
+	users := repo.NewUserRepository(mysql.GetDbConn().WithContext(ctx))
+	u, err := users.FindByAccount(ctx, account)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "find user by account err: %v", err)
+		return
+	}
+	if u == nil && strings.Contains(account, "@") {
+		u, err = users.FindByEmail(ctx, mailer.NormalizeAddress(account))
+		if err != nil {
+			hlog.CtxErrorf(ctx, "find user by email err: %v", err)
+			return
+		}
+	}
+	if u == nil {
+		hlog.CtxNoticef(ctx, "password reset for unknown account: %s", account)
+		return
+	}
+	if u.Email == nil || u.EmailVerifiedAt == nil {
+		hlog.CtxNoticef(ctx, "password reset for user without verified email: %s", u.UserId)
+		return
+	}
+	// issue logs its own errors
+	_ = s.issue(ctx, u.UserId, *u.Email)
+}
+
+// Wait blocks until the reset mails of Forgot started so far are sent.
+func Wait() {
+	sending.Wait()
+}
+
+// ForceReset clears the password of the user and bumps the credential version, so every session
+// of the user stops working and the password cannot be used again. A reset token is then mailed
+// when the user has a verified email, mailed reports whether it was.
+func (s *Service) ForceReset(ctx context.Context, userID string) (mailed bool, bizErr errs.Error) {
+	var to string
+	err := mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		u, err := repo.NewUserRepository(tx).FindByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return errs.UserNotExist
+		}
+		if u.Email != nil && u.EmailVerifiedAt != nil {
+			to = *u.Email
+		}
+
+		credentials := repo.NewUserCredentialRepository(tx)
+		c, err := credentials.FindByUserIDLock(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			return errs.UserNotExist
+		}
+		c.PasswordSalt = ""
+		c.PasswordHash = ""
+		c.CredentialVersion += 1
+		return credentials.Update(ctx, c)
+	})
+	if bizErr := errs.Wrap(ctx, "force password reset", err); bizErr != nil {
+		return false, bizErr
+	}
+	hlog.CtxInfof(ctx, "password cleared by force reset for user id: %s", userID)
+	defer func() {
+		audit.Record(ctx, audit.Event{
+			Type:     domain.AuditPasswordForceReset,
+			TargetID: userID,
+			Details:  map[string]any{"mailed": mailed},
+		})
+	}()
+
+	if to == "" {
+		hlog.CtxNoticef(ctx, "force password reset for user without verified email: %s", userID)
+		return false, nil
+	}
+	if s.mailer == nil {
+		return false, errs.ServerError.SetMsg("mailer not available")
+	}
+	if bizErr := s.issue(ctx, userID, to); bizErr != nil {
+		return false, bizErr
+	}
+	return true, nil
+}
+
+// issue replaces the unused reset tokens of the user by a new one and mails it.
+func (s *Service) issue(ctx context.Context, userID, to string) errs.Error {
+	token := random.SecureStr(tokenLen)
+	err := mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		resets := repo.NewUserPasswordResetRepository(tx)
+		if err := resets.DeleteUnusedByUserID(ctx, userID); err != nil {
+			return err
+		}
+		return resets.Create(ctx, &storage.UserPasswordResetRecord{
+			UserId:    userID,
+			TokenHash: encode.SHA256Hex(token),
+			ExpiresAt: time.Now().Add(s.tokenTTL),
+		})
+	})
+	if bizErr := errs.Wrap(ctx, "create password reset", err); bizErr != nil {
+		return bizErr
+	}
+
+	if err := s.mailer.Send(ctx, mailer.Message{
+		To:      to,
+		Subject: mailSubject,
+		Body:    s.mailBody(token),
+	}); err != nil {
+		hlog.CtxErrorf(ctx, "send password reset mail err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+
+	hlog.CtxInfof(ctx, "password reset mailed for user id: %s", userID)
+	return nil
+}
+
+// Reset consumes the token and sets the new password. The credential version is bumped, so
+// every session issued before the reset is rejected by the credential check.
+func (s *Service) Reset(ctx context.Context, token, newPassword string) errs.Error {
+	var userID string
+	err := mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		resets := repo.NewUserPasswordResetRepository(tx)
+		credentials := repo.NewUserCredentialRepository(tx)
+
+		record, err := resets.FindByTokenHash(ctx, encode.SHA256Hex(token))
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if record == nil || record.UsedAt != nil || now.After(record.ExpiresAt) {
+			return errs.PasswordResetInvalid
+		}
+		ok, err := resets.MarkUsed(ctx, record.ID, now)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errs.PasswordResetInvalid
+		}
+
+		c, err := credentials.FindByUserIDLock(ctx, record.UserId)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			return errs.UserNotExist
+		}
+		userID = record.UserId
+
+		hash, err := s.passwords.Hash(newPassword)
+		if err != nil {
+			return err
+		}
+		c.PasswordSalt = ""
+		c.PasswordHash = hash
+		c.CredentialVersion += 1
+		if err := credentials.Update(ctx, c); err != nil {
+			return err
+		}
+
+		// tokens mailed before this one must not reset the password a second time
+		return resets.DeleteUnusedByUserID(ctx, record.UserId)
+	})
+	if bizErr := errs.Wrap(ctx, "reset password", err); bizErr != nil {
+		return bizErr
+	}
+	audit.Record(ctx, audit.Event{Type: domain.AuditPasswordReset, ActorID: userID, TargetID: userID})
+	// the mailed token proves the user owns the account, a lock from guessing has no use anymore
+	_ = lockout.NewDefault().Unlock(ctx, userID)
+	return nil
+}
+
+func (s *Service) mailBody(token string) string {
+	link := token
+	if u, err := url.Parse(s.url); err == nil && s.url != "" {
+		q := u.Query()
+		q.Set("token", token)
+		u.RawQuery = q.Encode()
+		link = u.String()
+	}
+	return fmt.Sprintf("Someone asked to reset the password of your Doing Now account.\n\n"+
+		"Use the link below within %d minutes to choose a new password:\n\n%s\n\n"+
+		"If it was not you, ignore this mail and your password stays unchanged.\n",
+		int(s.tokenTTL.Minutes()), link)
+}
@@ -0,0 +1,226 @@
+package reset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/dal/repo"
+	"doing_now/be/biz/db/mysql"
+	db_redis "doing_now/be/biz/db/redis"
+	"doing_now/be/biz/model/errs"
+	"doing_now/be/biz/model/storage"
+	"doing_now/be/biz/util/mailer"
+	"doing_now/be/biz/util/password"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bytedance/mockey"
+	"github.com/glebarez/sqlite"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var (
+	patchOnce sync.Once
+	currentDB *gorm.DB
+	currentMR *miniredis.Miniredis
+)
+
+func ensurePatches() {
+	patchOnce.Do(func() {
+		mockey.Mock(mysql.GetDbConn).To(func() *gorm.DB {
+			return currentDB
+		}).Build()
+		currentMR = miniredis.NewMiniRedis()
+		if err := currentMR.Start(); err != nil {
+			panic(err)
+		}
+		rdb := redis.NewClient(&redis.Options{Addr: currentMR.Addr()})
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+		mockey.Mock((*repo.UserCredentialRepository).FindByUserIDLock).To(func(r *repo.UserCredentialRepository, ctx context.Context, userID string) (*storage.UserCredentialRecord, error) {
+			return r.FindByUserID(ctx, userID)
+		}).Build()
+	})
+}
+
+const email = "alice@example.com"
+
+func setup(t *testing.T) string {
+	ensurePatches()
+
+	dsn := fmt.Sprintf("file:%s_%d?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"), time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	assert.NoError(t, err)
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	err = db.AutoMigrate(&storage.UserRecord{}, &storage.UserCredentialRecord{}, &storage.UserPasswordResetRecord{})
+	assert.NoError(t, err)
+	currentDB = db
+
+	hash, err := password.NewDefault().Hash("password01")
+	assert.NoError(t, err)
+	userID := "user01"
+	verifiedEmail, verifiedAt := email, time.Now()
+	assert.NoError(t, db.Create(&storage.UserRecord{
+		UserId:          userID,
+		Account:         "account01",
+		Name:            "name0001",
+		Email:           &verifiedEmail,
+		EmailVerifiedAt: &verifiedAt,
+	}).Error)
+	assert.NoError(t, db.Create(&storage.UserCredentialRecord{UserId: userID, PasswordHash: hash}).Error)
+	return userID
+}
+
+var linkRe = regexp.MustCompile(`https://\S+`)
+
+func mailedToken(t *testing.T, m *mailer.MemoryMailer, to string) string {
+	msg, ok := m.Last(to)
+	assert.True(t, ok)
+	u, err := url.Parse(linkRe.FindString(msg.Body))
+	assert.NoError(t, err)
+	return u.Query().Get("token")
+}
+
+func credential(t *testing.T, userID string) *storage.UserCredentialRecord {
+	var c storage.UserCredentialRecord
+	assert.NoError(t, currentDB.First(&c, "user_id = ?", userID).Error)
+	return &c
+}
+
+func TestService_ForgotAndReset(t *testing.T) {
+	userID := setup(t)
+	ctx := context.Background()
+	m := mailer.NewMemoryMailer()
+	svc := New(config.PasswordResetConf{URL: "https://example.com/reset?lang=en"}, m)
+
+	assert.Nil(t, svc.Forgot(ctx, "account01"))
+	Wait()
+	first := mailedToken(t, m, email)
+	assert.Len(t, first, tokenLen)
+
+	var record storage.UserPasswordResetRecord
+	assert.NoError(t, currentDB.First(&record).Error)
+	assert.NotEqual(t, first, record.TokenHash)
+
+	t.Run("a new mail replaces the outstanding token", func(t *testing.T) {
+		assert.Nil(t, svc.Forgot(ctx, "Alice@Example.com"))
+		Wait()
+		second := mailedToken(t, m, email)
+		assert.NotEqual(t, first, second)
+
+		bizErr := svc.Reset(ctx, first, "password02")
+		assert.True(t, errs.ErrorEqual(errs.PasswordResetInvalid, bizErr))
+
+		currentMR.Set("account_lock:user:"+userID, "10")
+		assert.Nil(t, svc.Reset(ctx, second, "password02"))
+		assert.False(t, currentMR.Exists("account_lock:user:"+userID), "reset unlocks the account")
+		c := credential(t, userID)
+		assert.Equal(t, uint(1), c.CredentialVersion)
+		ok, _, err := password.NewDefault().Verify(c.PasswordSalt, c.PasswordHash, "password02")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		bizErr = svc.Reset(ctx, second, "password03")
+		assert.True(t, errs.ErrorEqual(errs.PasswordResetInvalid, bizErr))
+		assert.Equal(t, uint(1), credential(t, userID).CredentialVersion)
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		assert.Nil(t, svc.Forgot(ctx, email))
+		Wait()
+		token := mailedToken(t, m, email)
+		assert.NoError(t, currentDB.Model(&storage.UserPasswordResetRecord{}).
+			Where("used_at IS NULL").Update("expires_at", time.Now().Add(-time.Second)).Error)
+
+		bizErr := svc.Reset(ctx, token, "password03")
+		assert.True(t, errs.ErrorEqual(errs.PasswordResetInvalid, bizErr))
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		bizErr := svc.Reset(ctx, "unknown", "password03")
+		assert.True(t, errs.ErrorEqual(errs.PasswordResetInvalid, bizErr))
+	})
+
+	t.Run("unknown account is not reported", func(t *testing.T) {
+		sent := len(m.Messages())
+		assert.Nil(t, svc.Forgot(ctx, "nobody@example.com"))
+		Wait()
+		assert.Len(t, m.Messages(), sent)
+	})
+}
+
+type failingMailer struct{}
+
+func (failingMailer) Send(context.Context, mailer.Message) error {
+	return errors.New("smtp unavailable")
+}
+
+func TestService_ForgotWhenMailFails(t *testing.T) {
+	setup(t)
+	svc := New(config.PasswordResetConf{}, failingMailer{})
+
+	// the account exists, yet the answer is the same as for an unknown one
+	assert.Nil(t, svc.Forgot(context.Background(), "account01"))
+	Wait()
+}
+
+func TestService_ForgotWithoutVerifiedEmail(t *testing.T) {
+	userID := setup(t)
+	assert.NoError(t, currentDB.Model(&storage.UserRecord{}).Where("user_id = ?", userID).Update("email_verified_at", nil).Error)
+	m := mailer.NewMemoryMailer()
+	svc := New(config.PasswordResetConf{}, m)
+
+	assert.Nil(t, svc.Forgot(context.Background(), "account01"))
+	Wait()
+	assert.Empty(t, m.Messages())
+
+	var count int64
+	assert.NoError(t, currentDB.Model(&storage.UserPasswordResetRecord{}).Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestService_ForceReset(t *testing.T) {
+	userID := setup(t)
+	ctx := context.Background()
+	m := mailer.NewMemoryMailer()
+	svc := New(config.PasswordResetConf{URL: "https://example.com/reset"}, m)
+
+	mailed, bizErr := svc.ForceReset(ctx, userID)
+	assert.Nil(t, bizErr)
+	assert.True(t, mailed)
+
+	c := credential(t, userID)
+	assert.Empty(t, c.PasswordHash)
+	assert.Equal(t, uint(1), c.CredentialVersion)
+
+	assert.Nil(t, svc.Reset(ctx, mailedToken(t, m, email), "password02"))
+	ok, _, err := password.NewDefault().Verify("", credential(t, userID).PasswordHash, "password02")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	t.Run("without verified email only clears the password", func(t *testing.T) {
+		assert.NoError(t, currentDB.Model(&storage.UserRecord{}).Where("user_id = ?", userID).Update("email_verified_at", nil).Error)
+		sent := len(m.Messages())
+
+		mailed, bizErr := svc.ForceReset(ctx, userID)
+		assert.Nil(t, bizErr)
+		assert.False(t, mailed)
+		assert.Len(t, m.Messages(), sent)
+		assert.Empty(t, credential(t, userID).PasswordHash)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		_, bizErr := svc.ForceReset(ctx, "nobody")
+		assert.True(t, errs.ErrorEqual(errs.UserNotExist, bizErr))
+	})
+}
@@ -2,15 +2,20 @@ package user
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
+	"doing_now/be/biz/config"
 	"doing_now/be/biz/dal/repo"
 	"doing_now/be/biz/db/mysql"
 	"doing_now/be/biz/model/convert"
 	"doing_now/be/biz/model/domain"
 	"doing_now/be/biz/model/errs"
 	"doing_now/be/biz/model/storage"
-	"doing_now/be/biz/util/encode"
-	"doing_now/be/biz/util/random"
+	"doing_now/be/biz/service/audit"
+	"doing_now/be/biz/util/mailer"
+	"doing_now/be/biz/util/password"
 
 	"github.com/cloudwego/hertz/pkg/common/hlog"
 	"github.com/google/uuid"
@@ -18,44 +23,67 @@ import (
 )
 
 type Service struct {
+	passwords            *password.Manager
+	requireVerifiedEmail bool
 }
 
 func New() *Service {
-	return &Service{}
+	return &Service{
+		passwords:            password.NewDefault(),
+		requireVerifiedEmail: config.GetEmailVerificationConf().Required,
+	}
 }
 
 func NewDefault() *Service {
 	return New()
 }
 
-func (s *Service) Register(ctx context.Context, account, name, password string) (*domain.User, errs.Error) {
+// Register creates a user, email is optional and stays unverified until the link mailed to it is opened.
+func (s *Service) Register(ctx context.Context, account, name, password, email string) (*domain.User, errs.Error) {
 	var userRecord *storage.UserRecord
 	err := mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		users := repo.NewUserRepository(tx)
 		credentials := repo.NewUserCredentialRepository(tx)
 
+		// accounts and emails share the login namespace, neither may shadow the other
 		existing, err := users.FindByAccount(ctx, account)
 		if err != nil {
 			return err
 		}
+		if existing == nil {
+			existing, err = users.FindByEmail(ctx, mailer.NormalizeAddress(account))
+			if err != nil {
+				return err
+			}
+		}
 		if existing != nil {
 			return errs.UserNameDuplicatedErr
 		}
 
-		userRecord, err = users.Create(ctx, &storage.UserRecord{
+		record := &storage.UserRecord{
 			UserId:  uuid.New().String(),
 			Account: account,
 			Name:    name,
-		})
+			Status:  string(domain.UserStatusActive),
+		}
+		if email != "" {
+			email = mailer.NormalizeAddress(email)
+			if err := checkEmailAvailable(ctx, users, email, ""); err != nil {
+				return err
+			}
+			record.Email = &email
+		}
+		userRecord, err = users.Create(ctx, record)
 		if err != nil {
 			return err
 		}
 
-		salt := random.RandStr(32)
-		hash := encode.EncodePassword(salt, password)
+		hash, err := s.passwords.Hash(password)
+		if err != nil {
+			return err
+		}
 		if err := credentials.Create(ctx, &storage.UserCredentialRecord{
 			UserId:            userRecord.UserId,
-			PasswordSalt:      salt,
 			PasswordHash:      hash,
 			CredentialVersion: 0,
 		}); err != nil {
@@ -66,7 +94,7 @@ func (s *Service) Register(ctx context.Context, account, name, password string)
 
 	if err != nil {
 		if errs.IsDuplicatedErr(err) {
-			hlog.CtxNoticef(ctx, "user name duplicated: %s", account)
+			hlog.CtxNoticef(ctx, "user name or email duplicated: %s", account)
 			return nil, errs.UserNameDuplicatedErr
 		}
 		if bizErr, ok := err.(errs.Error); ok {
@@ -79,6 +107,7 @@ func (s *Service) Register(ctx context.Context, account, name, password string)
 	return userDomain, nil
 }
 
+// Login authenticates by account or by verified email.
 func (s *Service) Login(ctx context.Context, account, password string) (*domain.User, uint, errs.Error) {
 	var userRecord *storage.UserRecord
 	var credentialVersion uint
@@ -92,6 +121,18 @@ func (s *Service) Login(ctx context.Context, account, password string) (*domain.
 			hlog.CtxErrorf(ctx, "find user by account lock err: %v", err)
 			return err
 		}
+		if u == nil && strings.Contains(account, "@") {
+			u, err = users.FindByEmailLock(ctx, mailer.NormalizeAddress(account))
+			if err != nil {
+				hlog.CtxErrorf(ctx, "find user by email lock err: %v", err)
+				return err
+			}
+			// anyone can set an address, only a verified one identifies the user
+			if u != nil && u.EmailVerifiedAt == nil {
+				hlog.CtxNoticef(ctx, "login by unverified email for user id: %s", u.UserId)
+				u = nil
+			}
+		}
 		if u == nil {
 			hlog.CtxNoticef(ctx, "user not exist: %s", account)
 			return errs.UserNotExist
@@ -110,12 +151,47 @@ func (s *Service) Login(ctx context.Context, account, password string) (*domain.
 			return errs.ServerError.SetMsg("credential not found")
 		}
 
-		// 3. Verify password
-		if encode.EncodePassword(c.PasswordSalt, password) != c.PasswordHash {
+		// 3. Verify password, users created by an identity provider have none
+		if c.PasswordHash == "" {
+			hlog.CtxNoticef(ctx, "password not set for user id: %s", userRecord.UserId)
+			return errs.PasswordIncorrect
+		}
+		ok, needsRehash, err := s.passwords.Verify(c.PasswordSalt, c.PasswordHash, password)
+		if err != nil {
+			hlog.CtxErrorf(ctx, "verify password err: %v", err)
+			return err
+		}
+		if !ok {
 			hlog.CtxNoticef(ctx, "password incorrect for user id: %s", userRecord.UserId)
 			return errs.PasswordIncorrect
 		}
 
+		// 4. Upgrade legacy or outdated hash, the password itself is unchanged so the version stays
+		if needsRehash {
+			hash, err := s.passwords.Hash(password)
+			if err != nil {
+				hlog.CtxErrorf(ctx, "rehash password err: %v", err)
+				return err
+			}
+			c.PasswordSalt = ""
+			c.PasswordHash = hash
+			if err := credentials.Update(ctx, c); err != nil {
+				hlog.CtxErrorf(ctx, "update credential err: %v", err)
+				return err
+			}
+			hlog.CtxInfof(ctx, "password hash upgraded for user id: %s", userRecord.UserId)
+		}
+
+		// 5. Checked after the password, so it does not tell whether an account exists
+		if !domain.UserStatus(userRecord.Status).Active() {
+			hlog.CtxNoticef(ctx, "user status %s for user id: %s", userRecord.Status, userRecord.UserId)
+			return errs.UserStatusInvalid
+		}
+		if s.requireVerifiedEmail && userRecord.EmailVerifiedAt == nil {
+			hlog.CtxNoticef(ctx, "email not verified for user id: %s", userRecord.UserId)
+			return errs.EmailNotVerified
+		}
+
 		credentialVersion = c.CredentialVersion
 		return nil
 	})
@@ -123,6 +199,14 @@ func (s *Service) Login(ctx context.Context, account, password string) (*domain.
 	if err != nil {
 		if bizErr, ok := err.(errs.Error); ok {
 			hlog.CtxNoticef(ctx, "login user err: %v", bizErr)
+			e := audit.Event{
+				Type:    domain.AuditLoginFailure,
+				Details: map[string]any{"account": account, "reason": bizErr.Msg()},
+			}
+			if userRecord != nil {
+				e.TargetID = userRecord.UserId
+			}
+			audit.Record(ctx, e)
 			return nil, 0, bizErr
 		}
 		hlog.CtxErrorf(ctx, "login user err: %v", err)
@@ -176,9 +260,23 @@ func (s *Service) UpdateInfo(ctx context.Context, userID, name string) errs.Erro
 	return nil
 }
 
-func (s *Service) GetCredentialVersion(ctx context.Context, userID string) (uint, errs.Error) {
-	credentials := repo.NewUserCredentialRepository(mysql.GetDbConn().WithContext(ctx))
-	c, err := credentials.FindByUserID(ctx, userID)
+// GetActiveCredentialVersion returns the credential version of an active user, users with any
+// other status get UserStatusInvalid.
+func (s *Service) GetActiveCredentialVersion(ctx context.Context, userID string) (uint, errs.Error) {
+	db := mysql.GetDbConn().WithContext(ctx)
+	u, err := repo.NewUserRepository(db).FindByUserID(ctx, userID)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "find user by user id err: %v", err)
+		return 0, errs.ServerError.SetErr(err)
+	}
+	if u == nil {
+		return 0, errs.UserNotExist
+	}
+	if !domain.UserStatus(u.Status).Active() {
+		return 0, errs.UserStatusInvalid
+	}
+
+	c, err := repo.NewUserCredentialRepository(db).FindByUserID(ctx, userID)
 	if err != nil {
 		hlog.CtxErrorf(ctx, "find credential by user id err: %v", err)
 		return 0, errs.ServerError.SetErr(err)
@@ -213,14 +311,20 @@ func (s *Service) UpdatePassword(ctx context.Context, userID, oldPassword, newPa
 		}
 
 		// 3. Verify old password
-		if encode.EncodePassword(c.PasswordSalt, oldPassword) != c.PasswordHash {
+		ok, _, err := s.passwords.Verify(c.PasswordSalt, c.PasswordHash, oldPassword)
+		if err != nil {
+			return err
+		}
+		if !ok {
 			return errs.PasswordIncorrect
 		}
 
 		// 4. Update password
-		salt := random.RandStr(32)
-		hash := encode.EncodePassword(salt, newPassword)
-		c.PasswordSalt = salt
+		hash, err := s.passwords.Hash(newPassword)
+		if err != nil {
+			return err
+		}
+		c.PasswordSalt = ""
 		c.PasswordHash = hash
 		c.CredentialVersion += 1 // Increment version
 
@@ -238,5 +342,202 @@ func (s *Service) UpdatePassword(ctx context.Context, userID, oldPassword, newPa
 		hlog.CtxErrorf(ctx, "update password err: %v", err)
 		return errs.ServerError.SetErr(err)
 	}
+	audit.Record(ctx, audit.Event{Type: domain.AuditPasswordChange, TargetID: userID})
+	return nil
+}
+
+// UpdateEmail replaces the email of a user, the new email is unverified. Setting the current
+// email again keeps its verification.
+func (s *Service) UpdateEmail(ctx context.Context, userID, email string) (*domain.User, errs.Error) {
+	email = mailer.NormalizeAddress(email)
+	var userRecord *storage.UserRecord
+	err := mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		users := repo.NewUserRepository(tx)
+		u, err := users.FindByUserIDLock(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return errs.UserNotExist
+		}
+		userRecord = u
+		if u.Email != nil && *u.Email == email {
+			return nil
+		}
+
+		if err := checkEmailAvailable(ctx, users, email, userID); err != nil {
+			return err
+		}
+		u.Email = &email
+		u.EmailVerifiedAt = nil
+		return users.Update(ctx, u)
+	})
+
+	if err != nil {
+		if errs.IsDuplicatedErr(err) {
+			hlog.CtxNoticef(ctx, "email duplicated: %s", email)
+			return nil, errs.EmailDuplicated
+		}
+		if bizErr, ok := err.(errs.Error); ok {
+			hlog.CtxNoticef(ctx, "update email err: %v", bizErr)
+			return nil, bizErr
+		}
+		hlog.CtxErrorf(ctx, "update email err: %v", err)
+		return nil, errs.ServerError.SetErr(err)
+	}
+	return convert.UserRecordToDomain(userRecord), nil
+}
+
+// checkEmailAvailable reports whether email can be given to userID, it must not belong to another
+// user either as email or as account.
+func checkEmailAvailable(ctx context.Context, users *repo.UserRepository, email, userID string) error {
+	u, err := users.FindByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		u, err = users.FindByAccount(ctx, email)
+		if err != nil {
+			return err
+		}
+	}
+	if u != nil && u.UserId != userID {
+		return errs.EmailDuplicated
+	}
+	return nil
+}
+
+// ChangeStatus moves the user to another status of the lifecycle, the reason is kept with the time
+// of the change. The credential version is bumped so the tokens of the user stop working, which
+// also ends the sessions of a user who is activated again.
+func (s *Service) ChangeStatus(ctx context.Context, userID string, status domain.UserStatus, reason string) errs.Error {
+	var from string
+	err := mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		users := repo.NewUserRepository(tx)
+		credentials := repo.NewUserCredentialRepository(tx)
+
+		u, err := users.FindByUserIDLock(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return errs.UserNotExist
+		}
+		from = u.Status
+		if !domain.UserStatus(u.Status).CanTransitionTo(status) {
+			return errs.UserStatusTransition.SetMsg(fmt.Sprintf("user status cannot change from %s to %s", u.Status, status))
+		}
+
+		now := time.Now()
+		u.Status = string(status)
+		u.StatusReason = reason
+		u.StatusChangedAt = &now
+		if err := users.Update(ctx, u); err != nil {
+			return err
+		}
+
+		c, err := credentials.FindByUserIDLock(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			return errs.ServerError.SetMsg("credential not found")
+		}
+		c.CredentialVersion += 1
+		return credentials.Update(ctx, c)
+	})
+
+	if err != nil {
+		if bizErr, ok := err.(errs.Error); ok {
+			hlog.CtxNoticef(ctx, "change user status err: %v", bizErr)
+			return bizErr
+		}
+		hlog.CtxErrorf(ctx, "change user status err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	hlog.CtxInfof(ctx, "user status changed to %s for user id: %s, reason: %s", status, userID, reason)
+	audit.Record(ctx, audit.Event{
+		Type:     domain.AuditUserStatusChange,
+		TargetID: userID,
+		Details:  map[string]any{"from": from, "to": string(status), "reason": reason},
+	})
+	return nil
+}
+
+// RevokeCredentials bumps the credential version of the user, so every token issued before is
+// rejected by the credential check and at refresh, whether or not its session is still known.
+func (s *Service) RevokeCredentials(ctx context.Context, userID string) errs.Error {
+	err := mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		credentials := repo.NewUserCredentialRepository(tx)
+		c, err := credentials.FindByUserIDLock(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			return errs.UserNotExist
+		}
+		c.CredentialVersion += 1
+		return credentials.Update(ctx, c)
+	})
+	if bizErr := errs.Wrap(ctx, "revoke credentials", err); bizErr != nil {
+		return bizErr
+	}
+	hlog.CtxInfof(ctx, "credentials revoked for user id: %s", userID)
+	return nil
+}
+
+// Search returns a page of users matching the filter and the total number of matches.
+func (s *Service) Search(ctx context.Context, filter repo.UserFilter) ([]*domain.User, int64, errs.Error) {
+	users := repo.NewUserRepository(mysql.GetDbConn().WithContext(ctx))
+	list, total, err := users.Search(ctx, filter)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "search users err: %v", err)
+		return nil, 0, errs.ServerError.SetErr(err)
+	}
+	result := make([]*domain.User, 0, len(list))
+	for _, u := range list {
+		result = append(result, convert.UserRecordToDomain(u))
+	}
+	return result, total, nil
+}
+
+// GetByUserIDUnscoped is GetByUserID which also finds soft-deleted users.
+func (s *Service) GetByUserIDUnscoped(ctx context.Context, userID string) (*domain.User, errs.Error) {
+	users := repo.NewUserRepository(mysql.GetDbConn().WithContext(ctx))
+	u, err := users.FindByUserIDUnscoped(ctx, userID)
+	if err != nil {
+		hlog.CtxErrorf(ctx, "get user by id unscoped err: %v", err)
+		return nil, errs.ServerError.SetErr(err)
+	}
+	if u == nil {
+		return nil, errs.UserNotExist
+	}
+	return convert.UserRecordToDomain(u), nil
+}
+
+// Restore brings back a soft-deleted user together with the credential. The account and email
+// stay reserved while the user is deleted, so restoring never collides with another user.
+func (s *Service) Restore(ctx context.Context, userID string) errs.Error {
+	err := mysql.GetDbConn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		restored, err := repo.NewUserRepository(tx).Restore(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !restored {
+			return errs.UserNotExist.SetMsg("deleted user not found")
+		}
+		return repo.NewUserCredentialRepository(tx).Restore(ctx, userID)
+	})
+
+	if err != nil {
+		if bizErr, ok := err.(errs.Error); ok {
+			hlog.CtxNoticef(ctx, "restore user err: %v", bizErr)
+			return bizErr
+		}
+		hlog.CtxErrorf(ctx, "restore user err: %v", err)
+		return errs.ServerError.SetErr(err)
+	}
+	hlog.CtxInfof(ctx, "user restored, user id: %s", userID)
+	audit.Record(ctx, audit.Event{Type: domain.AuditUserRestore, TargetID: userID})
 	return nil
 }
@@ -10,8 +10,11 @@ import (
 
 	"doing_now/be/biz/dal/repo"
 	"doing_now/be/biz/db/mysql"
+	"doing_now/be/biz/model/domain"
 	"doing_now/be/biz/model/errs"
 	"doing_now/be/biz/model/storage"
+	"doing_now/be/biz/util/encode"
+	"doing_now/be/biz/util/password"
 
 	"github.com/bytedance/mockey"
 	"github.com/glebarez/sqlite"
@@ -63,11 +66,11 @@ func TestService_Register(t *testing.T) {
 
 	svc := New()
 
-	u, bizErr := svc.Register(context.Background(), "account01", "name0001", "password01")
+	u, bizErr := svc.Register(context.Background(), "account01", "name0001", "password01", "")
 	assert.Nil(t, bizErr)
 	assert.NotEmpty(t, u.UserID)
 
-	_, bizErr = svc.Register(context.Background(), "account01", "name0001", "password01")
+	_, bizErr = svc.Register(context.Background(), "account01", "name0001", "password01", "")
 	assert.True(t, errs.ErrorEqual(errs.UserNameDuplicatedErr, bizErr))
 }
 
@@ -76,7 +79,7 @@ func TestService_Login(t *testing.T) {
 	currentDB = setupSQLite(t)
 
 	svc := New()
-	_, bizErr := svc.Register(context.Background(), "account01", "name0001", "password01")
+	_, bizErr := svc.Register(context.Background(), "account01", "name0001", "password01", "")
 	assert.Nil(t, bizErr)
 
 	_, _, bizErr = svc.Login(context.Background(), "not_exist", "password01")
@@ -99,10 +102,173 @@ func TestService_GetByUserID(t *testing.T) {
 	_, bizErr := svc.GetByUserID(context.Background(), "u1")
 	assert.True(t, errs.ErrorEqual(errs.UserNotExist, bizErr))
 
-	u, bizErr := svc.Register(context.Background(), "account01", "name0001", "password01")
+	u, bizErr := svc.Register(context.Background(), "account01", "name0001", "password01", "")
 	assert.Nil(t, bizErr)
 
 	out, bizErr := svc.GetByUserID(context.Background(), u.UserID)
 	assert.Nil(t, bizErr)
 	assert.Equal(t, u.UserID, out.UserID)
 }
+
+func TestService_Login_RehashLegacyPassword(t *testing.T) {
+	ensurePatches()
+	currentDB = setupSQLite(t)
+
+	svc := New()
+	u, bizErr := svc.Register(context.Background(), "account01", "name0001", "password01", "")
+	assert.Nil(t, bizErr)
+
+	// downgrade the stored credential to a legacy salted SHA-256 hash
+	err := currentDB.Model(&storage.UserCredentialRecord{}).Where("user_id = ?", u.UserID).
+		Updates(map[string]any{"password_salt": "salt", "password_hash": encode.EncodePassword("salt", "password01")}).Error
+	assert.NoError(t, err)
+
+	_, _, bizErr = svc.Login(context.Background(), "account01", "badpassword")
+	assert.True(t, errs.ErrorEqual(errs.PasswordIncorrect, bizErr))
+
+	_, cv, bizErr := svc.Login(context.Background(), "account01", "password01")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, uint(0), cv)
+
+	var c storage.UserCredentialRecord
+	assert.NoError(t, currentDB.Where("user_id = ?", u.UserID).First(&c).Error)
+	assert.Equal(t, password.AlgorithmArgon2id, password.Identify(c.PasswordHash))
+	assert.Empty(t, c.PasswordSalt)
+
+	_, _, bizErr = svc.Login(context.Background(), "account01", "password01")
+	assert.Nil(t, bizErr)
+}
+
+func TestService_LoginByEmail(t *testing.T) {
+	ensurePatches()
+	currentDB = setupSQLite(t)
+	ctx := context.Background()
+
+	svc := New()
+	u, bizErr := svc.Register(ctx, "account01", "name0001", "password01", " Alice@Example.com ")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, "alice@example.com", u.Email)
+	assert.False(t, u.EmailVerified)
+
+	// an unverified email is no login identifier
+	_, _, bizErr = svc.Login(ctx, "alice@example.com", "password01")
+	assert.True(t, errs.ErrorEqual(errs.UserNotExist, bizErr))
+
+	assert.NoError(t, currentDB.Model(&storage.UserRecord{}).Where("user_id = ?", u.UserID).Update("email_verified_at", time.Now()).Error)
+	out, _, bizErr := svc.Login(ctx, "ALICE@example.com", "password01")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, u.UserID, out.UserID)
+
+	_, _, bizErr = svc.Login(ctx, "alice@example.com", "badpassword")
+	assert.True(t, errs.ErrorEqual(errs.PasswordIncorrect, bizErr))
+
+	t.Run("verified email required", func(t *testing.T) {
+		svc := New()
+		svc.requireVerifiedEmail = true
+
+		assert.NoError(t, currentDB.Model(&storage.UserRecord{}).Where("user_id = ?", u.UserID).Update("email_verified_at", nil).Error)
+		_, _, bizErr := svc.Login(ctx, "account01", "badpassword")
+		assert.True(t, errs.ErrorEqual(errs.PasswordIncorrect, bizErr))
+		_, _, bizErr = svc.Login(ctx, "account01", "password01")
+		assert.True(t, errs.ErrorEqual(errs.EmailNotVerified, bizErr))
+
+		assert.NoError(t, currentDB.Model(&storage.UserRecord{}).Where("user_id = ?", u.UserID).Update("email_verified_at", time.Now()).Error)
+		_, _, bizErr = svc.Login(ctx, "alice@example.com", "password01")
+		assert.Nil(t, bizErr)
+	})
+
+	t.Run("accounts and emails do not shadow each other", func(t *testing.T) {
+		_, bizErr := svc.Register(ctx, "alice@example.com", "name0002", "password02", "")
+		assert.True(t, errs.ErrorEqual(errs.UserNameDuplicatedErr, bizErr))
+		_, bizErr = svc.Register(ctx, "account02", "name0002", "password02", "alice@example.com")
+		assert.True(t, errs.ErrorEqual(errs.EmailDuplicated, bizErr))
+		_, bizErr = svc.Register(ctx, "account02", "name0002", "password02", "account01")
+		assert.True(t, errs.ErrorEqual(errs.EmailDuplicated, bizErr))
+	})
+}
+
+func TestService_UpdateEmail(t *testing.T) {
+	ensurePatches()
+	currentDB = setupSQLite(t)
+	ctx := context.Background()
+
+	svc := New()
+	u, bizErr := svc.Register(ctx, "account01", "name0001", "password01", "alice@example.com")
+	assert.Nil(t, bizErr)
+	_, bizErr = svc.Register(ctx, "account02", "name0002", "password02", "bob@example.com")
+	assert.Nil(t, bizErr)
+	assert.NoError(t, currentDB.Model(&storage.UserRecord{}).Where("user_id = ?", u.UserID).Update("email_verified_at", time.Now()).Error)
+
+	out, bizErr := svc.UpdateEmail(ctx, u.UserID, "Alice@example.com")
+	assert.Nil(t, bizErr)
+	assert.True(t, out.EmailVerified)
+
+	_, bizErr = svc.UpdateEmail(ctx, u.UserID, "bob@example.com")
+	assert.True(t, errs.ErrorEqual(errs.EmailDuplicated, bizErr))
+
+	out, bizErr = svc.UpdateEmail(ctx, u.UserID, "carol@example.com")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, "carol@example.com", out.Email)
+	assert.False(t, out.EmailVerified)
+
+	_, _, bizErr = svc.Login(ctx, "alice@example.com", "password01")
+	assert.True(t, errs.ErrorEqual(errs.UserNotExist, bizErr))
+	// the new email logs in once it is verified
+	_, _, bizErr = svc.Login(ctx, "carol@example.com", "password01")
+	assert.True(t, errs.ErrorEqual(errs.UserNotExist, bizErr))
+	assert.NoError(t, currentDB.Model(&storage.UserRecord{}).Where("user_id = ?", u.UserID).Update("email_verified_at", time.Now()).Error)
+	_, _, bizErr = svc.Login(ctx, "carol@example.com", "password01")
+	assert.Nil(t, bizErr)
+}
+
+func TestUserStatus_Transitions(t *testing.T) {
+	assert.True(t, domain.UserStatusPending.CanTransitionTo(domain.UserStatusActive))
+	assert.True(t, domain.UserStatusActive.CanTransitionTo(domain.UserStatusLocked))
+	assert.True(t, domain.UserStatusLocked.CanTransitionTo(domain.UserStatusActive))
+	assert.True(t, domain.UserStatusDisabled.CanTransitionTo(domain.UserStatusActive))
+
+	assert.False(t, domain.UserStatusActive.CanTransitionTo(domain.UserStatusActive))
+	assert.False(t, domain.UserStatusActive.CanTransitionTo(domain.UserStatusPending))
+	assert.False(t, domain.UserStatusDisabled.CanTransitionTo(domain.UserStatusLocked))
+	assert.False(t, domain.UserStatus("deleted").CanTransitionTo(domain.UserStatusActive))
+	assert.False(t, domain.UserStatus("deleted").Valid())
+}
+
+func TestService_ChangeStatus(t *testing.T) {
+	ensurePatches()
+	currentDB = setupSQLite(t)
+	ctx := context.Background()
+
+	svc := New()
+	u, bizErr := svc.Register(ctx, "account01", "name0001", "password01", "")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, domain.UserStatusActive, u.Status)
+
+	cv, bizErr := svc.GetActiveCredentialVersion(ctx, u.UserID)
+	assert.Nil(t, bizErr)
+	assert.Equal(t, uint(0), cv)
+
+	assert.Nil(t, svc.ChangeStatus(ctx, u.UserID, domain.UserStatusDisabled, "terms violation"))
+	out, bizErr := svc.GetByUserID(ctx, u.UserID)
+	assert.Nil(t, bizErr)
+	assert.Equal(t, domain.UserStatusDisabled, out.Status)
+	assert.Equal(t, "terms violation", out.StatusReason)
+	assert.NotNil(t, out.StatusChangedAt)
+
+	_, bizErr = svc.GetActiveCredentialVersion(ctx, u.UserID)
+	assert.True(t, errs.ErrorEqual(errs.UserStatusInvalid, bizErr))
+	_, _, bizErr = svc.Login(ctx, "account01", "badpassword")
+	assert.True(t, errs.ErrorEqual(errs.PasswordIncorrect, bizErr))
+	_, _, bizErr = svc.Login(ctx, "account01", "password01")
+	assert.True(t, errs.ErrorEqual(errs.UserStatusInvalid, bizErr))
+
+	bizErr = svc.ChangeStatus(ctx, u.UserID, domain.UserStatusLocked, "")
+	assert.True(t, errs.ErrorEqual(errs.UserStatusTransition, bizErr))
+	bizErr = svc.ChangeStatus(ctx, "unknown", domain.UserStatusActive, "")
+	assert.True(t, errs.ErrorEqual(errs.UserNotExist, bizErr))
+
+	assert.Nil(t, svc.ChangeStatus(ctx, u.UserID, domain.UserStatusActive, "appeal accepted"))
+	_, cv, bizErr = svc.Login(ctx, "account01", "password01")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, uint(2), cv)
+}
@@ -13,3 +13,9 @@ func EncodePassword(salt, password string) string {
 
 	return hex.EncodeToString(h.Sum(nil))
 }
+
+// SHA256Hex digests high-entropy tokens (recovery codes, reset tokens) before they are persisted.
+func SHA256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,140 @@
+package interceptor
+
+import (
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	AlgorithmFixedWindow   = "fixed_window"
+	AlgorithmSlidingLog    = "sliding_log"
+	AlgorithmSlidingWindow = "sliding_window"
+	AlgorithmTokenBucket   = "token_bucket"
+)
+
+// The scripts below take the time from Redis, so every instance sees the same clock.
+// ARGV[3] is "1" to count the request and "0" to only check whether it would be allowed.
+// They return {allowed, remaining, reset, retry after}: allowed is 1 (Allowed) or 0 (Denied),
+// reset is the milliseconds until the whole quota is back and retry after the milliseconds
+// until a request is allowed again. Denied requests are not counted.
+
+// slidingLogScript keeps the time of every allowed request in the window in a sorted set. It is
+// exact, and it keeps up to limit entries per key.
+// KEYS[1]: The rate limit key
+// ARGV[1]: Window duration in milliseconds
+// ARGV[2]: Max limit count
+// ARGV[4]: A unique member for the request
+var slidingLogScript = goredis.NewScript(`
+local key = KEYS[1]
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+
+local t = redis.call("TIME")
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now - window)
+local count = redis.call("ZCARD", key)
+local allowed = 0
+if count < limit then
+    allowed = 1
+    if ARGV[3] == "1" then
+        redis.call("ZADD", key, now, ARGV[4])
+        redis.call("PEXPIRE", key, window)
+        count = count + 1
+    end
+end
+
+local reset, retry = 0, 0
+if count > 0 then
+    local newest = redis.call("ZRANGE", key, -1, -1, "WITHSCORES")
+    reset = tonumber(newest[2]) + window - now
+end
+if count >= limit then
+    local oldest = redis.call("ZRANGE", key, 0, 0, "WITHSCORES")
+    retry = tonumber(oldest[2]) + window - now
+end
+return {allowed, math.max(0, limit - count), reset, retry}
+`)
+
+// slidingWindowScript counts requests in fixed windows, kept as fields of a hash, and weighs the
+// previous window by the part of it which still overlaps the sliding window.
+// KEYS[1]: The rate limit key
+// ARGV[1]: Window duration in milliseconds
+// ARGV[2]: Max limit count
+var slidingWindowScript = goredis.NewScript(`
+local key = KEYS[1]
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+
+local t = redis.call("TIME")
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local index = math.floor(now / window)
+local elapsed = now % window
+
+local current = tonumber(redis.call("HGET", key, tostring(index)) or "0")
+local previous = tonumber(redis.call("HGET", key, tostring(index - 1)) or "0")
+local weight = 1 - elapsed / window
+local allowed = 0
+if previous * weight + current < limit then
+    allowed = 1
+    if ARGV[3] == "1" then
+        current = redis.call("HINCRBY", key, tostring(index), 1)
+        for _, field in ipairs(redis.call("HKEYS", key)) do
+            if tonumber(field) < index - 1 then
+                redis.call("HDEL", key, field)
+            end
+        end
+        redis.call("PEXPIRE", key, window * 2)
+    end
+end
+
+local reset, retry = 0, 0
+if current > 0 then
+    reset = 2 * window - elapsed
+elseif previous > 0 then
+    reset = window - elapsed
+end
+if previous * weight + current >= limit then
+    if current >= limit then
+        -- the current window has to fade out of the next one
+        retry = window - elapsed + math.floor(window * (1 - limit / current)) + 1
+    else
+        retry = math.floor(window * (1 - (limit - current) / previous)) - elapsed + 1
+    end
+end
+return {allowed, math.max(0, math.floor(limit - previous * weight - current)), reset, math.max(0, retry)}
+`)
+
+// tokenBucketScript refills a bucket of burst tokens at limit tokens per window, every request
+// takes one token. Bursts up to the bucket size pass at once.
+// KEYS[1]: The rate limit key
+// ARGV[1]: Bucket size
+// ARGV[2]: Refill rate in tokens per millisecond
+var tokenBucketScript = goredis.NewScript(`
+local key = KEYS[1]
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+
+local t = redis.call("TIME")
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+
+local state = redis.call("HMGET", key, "tokens", "ts")
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+if tokens >= 1 then
+    allowed = 1
+    if ARGV[3] == "1" then
+        tokens = tokens - 1
+        redis.call("HSET", key, "tokens", tostring(tokens), "ts", tostring(now))
+        -- the key goes once the bucket would be full again
+        redis.call("PEXPIRE", key, math.ceil((capacity - tokens) / rate) + 1000)
+    end
+end
+
+local retry = 0
+if tokens < 1 then
+    retry = math.ceil((1 - tokens) / rate)
+end
+return {allowed, math.floor(tokens), math.ceil((capacity - tokens) / rate), retry}
+`)
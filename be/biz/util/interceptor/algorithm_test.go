@@ -0,0 +1,160 @@
+package interceptor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	db_redis "doing_now/be/biz/db/redis"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bytedance/mockey"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterceptor_Algorithms(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := context.Background()
+	key := "test_ip"
+
+	// allow counts the allowed requests out of n
+	allow := func(t *testing.T, i *Interceptor, n int) int {
+		allowed := 0
+		for range n {
+			ok, err := i.Allow(ctx, key)
+			assert.NoError(t, err)
+			if ok {
+				allowed++
+			}
+		}
+		return allowed
+	}
+
+	mockey.PatchConvey("TestInterceptor_Algorithms", t, func() {
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+		start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		t.Run("Unknown Algorithm", func(t *testing.T) {
+			_, err := New("leaky", 1, 1, 0)
+			assert.Error(t, err)
+
+			i, err := New("", 1, 1, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, AlgorithmFixedWindow, i.algorithm)
+		})
+
+		t.Run("Sliding Log", func(t *testing.T) {
+			mr.FlushAll()
+			mr.SetTime(start)
+			i, _ := New(AlgorithmSlidingLog, 10, 3, 0)
+
+			assert.Equal(t, 3, allow(t, i, 5))
+			assert.True(t, i.ReachLimit(ctx, key))
+
+			// no second batch at the boundary of a fixed window
+			mr.SetTime(start.Add(9 * time.Second))
+			assert.Equal(t, 0, allow(t, i, 1))
+
+			mr.SetTime(start.Add(10*time.Second + time.Millisecond))
+			assert.False(t, i.ReachLimit(ctx, key))
+			assert.Equal(t, 3, allow(t, i, 5))
+		})
+
+		t.Run("Sliding Window", func(t *testing.T) {
+			mr.FlushAll()
+			// the middle of a window
+			mr.SetTime(start.Add(5 * time.Second))
+			i, _ := New(AlgorithmSlidingWindow, 10, 4, 0)
+
+			assert.Equal(t, 4, allow(t, i, 6))
+			assert.True(t, i.ReachLimit(ctx, key))
+
+			// a quarter into the next window three quarters of the previous count still weigh
+			mr.SetTime(start.Add(12500 * time.Millisecond))
+			assert.Equal(t, 1, allow(t, i, 3))
+
+			// two windows later nothing weighs any more
+			mr.SetTime(start.Add(30 * time.Second))
+			assert.Equal(t, 4, allow(t, i, 6))
+			fields, _ := rdb.HKeys(ctx, "rate_limit:"+key).Result()
+			assert.Len(t, fields, 1)
+		})
+
+		t.Run("Token Bucket", func(t *testing.T) {
+			mr.FlushAll()
+			mr.SetTime(start)
+			// one token per second, bursts of 5
+			i, _ := New(AlgorithmTokenBucket, 10, 10, 5)
+
+			assert.Equal(t, 5, allow(t, i, 8))
+			assert.True(t, i.ReachLimit(ctx, key))
+
+			mr.SetTime(start.Add(2500 * time.Millisecond))
+			assert.False(t, i.ReachLimit(ctx, key))
+			assert.Equal(t, 2, allow(t, i, 5))
+
+			// the bucket fills up to its size only
+			mr.SetTime(start.Add(time.Minute))
+			assert.Equal(t, 5, allow(t, i, 8))
+		})
+
+		t.Run("Quota", func(t *testing.T) {
+			mr.FlushAll()
+			mr.SetTime(start.Add(2 * time.Second))
+
+			fixed := NewInterceptor(10, 2)
+			q, err := fixed.Take(ctx, key)
+			assert.NoError(t, err)
+			assert.Equal(t, Quota{Allowed: true, Limit: 2, Remaining: 1, Reset: 10 * time.Second}, q)
+			fixed.Take(ctx, key)
+			q, _ = fixed.Peek(ctx, key)
+			assert.False(t, q.Allowed)
+			assert.Equal(t, int64(0), q.Remaining)
+			assert.Equal(t, 10*time.Second, q.RetryAfter)
+
+			mr.FlushAll()
+			log, _ := New(AlgorithmSlidingLog, 10, 2, 0)
+			log.Take(ctx, key)
+			mr.SetTime(start.Add(4 * time.Second))
+			q, _ = log.Take(ctx, key)
+			assert.Equal(t, Quota{Allowed: true, Limit: 2, Remaining: 0, Reset: 10 * time.Second, RetryAfter: 8 * time.Second}, q)
+			q, _ = log.Take(ctx, key)
+			assert.False(t, q.Allowed)
+
+			mr.FlushAll()
+			// a quarter into the window
+			mr.SetTime(start.Add(2500 * time.Millisecond))
+			window, _ := New(AlgorithmSlidingWindow, 10, 4, 0)
+			for range 4 {
+				window.Take(ctx, key)
+			}
+			q, _ = window.Peek(ctx, key)
+			assert.False(t, q.Allowed)
+			assert.Equal(t, int64(0), q.Remaining)
+			assert.Equal(t, 17500*time.Millisecond, q.Reset)
+			// 4 of 4 weigh less than the limit once a millisecond of the next window has passed
+			assert.Equal(t, 7501*time.Millisecond, q.RetryAfter)
+
+			mr.FlushAll()
+			mr.SetTime(start)
+			bucket, _ := New(AlgorithmTokenBucket, 10, 10, 2)
+			bucket.Take(ctx, key)
+			q, _ = bucket.Take(ctx, key)
+			assert.Equal(t, Quota{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second, RetryAfter: time.Second}, q)
+		})
+
+		t.Run("Reset", func(t *testing.T) {
+			mr.FlushAll()
+			mr.SetTime(start)
+			for _, algorithm := range []string{AlgorithmSlidingLog, AlgorithmSlidingWindow, AlgorithmTokenBucket} {
+				i, _ := New(algorithm, 10, 1, 0)
+				allow(t, i, 1)
+				assert.True(t, i.ReachLimit(ctx, key), algorithm)
+				assert.NoError(t, i.Reset(ctx, key))
+				assert.False(t, i.ReachLimit(ctx, key), algorithm)
+			}
+		})
+	})
+}
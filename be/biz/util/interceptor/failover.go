@@ -0,0 +1,241 @@
+package interceptor
+
+import (
+	"context"
+	"doing_now/be/biz/config"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+const (
+	ModeRedis = "redis"
+	ModeLocal = "local"
+
+	defaultFailureThreshold = 5
+	defaultOpenDuration     = 10 * time.Second
+	// sweepInterval is how often expired local counters are dropped
+	sweepInterval = time.Minute
+)
+
+// Status describes whether the interceptors count in Redis or locally.
+type Status struct {
+	Mode string
+	// Since is when the mode last changed, zero before Redis ever failed
+	Since time.Time
+	// Failures is the number of consecutive Redis errors
+	Failures  int
+	Instances int
+	LocalKeys int
+}
+
+// failover sends the interceptors to the in-process limiter while Redis fails. A circuit breaker
+// shared by every interceptor stops calling Redis after threshold consecutive errors, and lets a
+// single probe through once openFor has passed.
+type failover struct {
+	failOpen  bool
+	instances int64
+	threshold int
+	openFor   time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time // zero while the breaker is closed
+	since    time.Time
+	probing  bool
+
+	local *localStore
+}
+
+func newFailover(conf config.RateLimitFailoverConf) *failover {
+	f := &failover{
+		failOpen:  conf.FailOpen,
+		instances: int64(max(1, conf.Instances)),
+		threshold: conf.FailureThreshold,
+		openFor:   time.Duration(conf.OpenSeconds) * time.Second,
+		local:     newLocalStore(),
+	}
+	if f.threshold <= 0 {
+		f.threshold = defaultFailureThreshold
+	}
+	if f.openFor <= 0 {
+		f.openFor = defaultOpenDuration
+	}
+	return f
+}
+
+var (
+	defaultFailover     *failover
+	defaultFailoverOnce sync.Once
+)
+
+func getFailover() *failover {
+	defaultFailoverOnce.Do(func() {
+		defaultFailover = newFailover(config.GetRateLimitFailoverConf())
+	})
+	return defaultFailover
+}
+
+// FailoverStatus tells whether the interceptors currently count in Redis or locally.
+func FailoverStatus() Status {
+	return getFailover().status()
+}
+
+// useRedis reports whether the call should go to Redis, false while the breaker is open.
+func (f *failover) useRedis() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.openedAt.IsZero() {
+		return true
+	}
+	if f.probing || time.Since(f.openedAt) < f.openFor {
+		return false
+	}
+	f.probing = true
+	return true
+}
+
+func (f *failover) success() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.openedAt.IsZero() {
+		hlog.Warnf("Rate limit Redis recovered, leaving local mode after %s", time.Since(f.since).Round(time.Second))
+		f.since = time.Now()
+	}
+	f.failures = 0
+	f.openedAt = time.Time{}
+	f.probing = false
+}
+
+func (f *failover) failure(ctx context.Context, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// a caller which gave up is no sign of Redis failing, but a probe it carried is over and
+	// another one is due after openFor
+	if ctx.Err() != nil {
+		if f.probing {
+			f.probing = false
+			f.openedAt = time.Now()
+		}
+		return
+	}
+
+	f.failures++
+	if f.probing {
+		f.probing = false
+		f.openedAt = time.Now()
+		hlog.CtxWarnf(ctx, "Rate limit Redis probe failed, staying in local mode: %v", err)
+		return
+	}
+	if f.openedAt.IsZero() && f.failures >= f.threshold {
+		f.openedAt = time.Now()
+		f.since = f.openedAt
+		hlog.CtxWarnf(ctx, "Rate limit Redis failed %d times, switching to local mode for %s: %v", f.failures, f.openFor, err)
+	}
+}
+
+func (f *failover) status() Status {
+	f.mu.Lock()
+	mode := ModeRedis
+	if !f.openedAt.IsZero() {
+		mode = ModeLocal
+	}
+	s := Status{
+		Mode:      mode,
+		Since:     f.since,
+		Failures:  f.failures,
+		Instances: int(f.instances),
+	}
+	f.mu.Unlock()
+
+	s.LocalKeys = f.local.len()
+	return s
+}
+
+// localLimit is the share of limit of one instance
+func (f *failover) localLimit(limit int64) int64 {
+	return max(1, (limit+f.instances-1)/f.instances)
+}
+
+// localStore counts requests in fixed windows in memory, whatever the algorithm of the
+// interceptor, like fixedWindowScript does in Redis.
+type localStore struct {
+	mu      sync.Mutex
+	entries map[string]*localEntry
+	swept   time.Time
+}
+
+type localEntry struct {
+	count   int64
+	expires time.Time
+}
+
+func newLocalStore() *localStore {
+	return &localStore{
+		entries: make(map[string]*localEntry),
+		swept:   time.Now(),
+	}
+}
+
+func (s *localStore) take(key string, window time.Duration, limit int64, consume bool) Quota {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	e, ok := s.entries[key]
+	if !ok || !now.Before(e.expires) {
+		e = &localEntry{expires: now.Add(window)}
+		if consume {
+			s.entries[key] = e
+		}
+	}
+
+	allowed := e.count < limit
+	if consume {
+		e.count++
+	}
+
+	q := Quota{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: max(0, limit-e.count),
+	}
+	if e.count > 0 {
+		q.Reset = e.expires.Sub(now)
+	}
+	if e.count >= limit {
+		q.RetryAfter = q.Reset
+	}
+	return q
+}
+
+func (s *localStore) reset(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+}
+
+func (s *localStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// sweep drops the expired counters now and then, the caller holds the lock
+func (s *localStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < sweepInterval {
+		return
+	}
+	s.swept = now
+	for key, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
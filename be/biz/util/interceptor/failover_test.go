@@ -0,0 +1,145 @@
+package interceptor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"doing_now/be/biz/config"
+	db_redis "doing_now/be/biz/db/redis"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bytedance/mockey"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterceptor_Failover(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := context.Background()
+	key := "test_ip"
+
+	// useFailover replaces the failover of every interceptor for one test
+	useFailover := func(t *testing.T, conf config.RateLimitFailoverConf) *failover {
+		getFailover()
+		prev := defaultFailover
+		defaultFailover = newFailover(conf)
+		t.Cleanup(func() { defaultFailover = prev })
+		return defaultFailover
+	}
+
+	mockey.PatchConvey("TestInterceptor_Failover", t, func() {
+		mockey.Mock(db_redis.GetRedisClient).Return(rdb).Build()
+
+		t.Run("Local Limit While Redis Fails", func(t *testing.T) {
+			mr.FlushAll()
+			useFailover(t, config.RateLimitFailoverConf{Instances: 3, FailureThreshold: 2, OpenSeconds: 60})
+			i := NewInterceptor(10, 5)
+
+			mr.SetError("LOADING")
+			defer mr.SetError("")
+
+			// a third of the limit, rounded up
+			for n := range 3 {
+				allowed, err := i.Allow(ctx, key)
+				assert.NoError(t, err)
+				assert.Equal(t, n < 2, allowed)
+			}
+			assert.True(t, i.ReachLimit(ctx, key))
+
+			status := FailoverStatus()
+			assert.Equal(t, ModeLocal, status.Mode)
+			assert.Equal(t, 2, status.Failures)
+			assert.Equal(t, 3, status.Instances)
+			assert.Equal(t, 1, status.LocalKeys)
+
+			// the open breaker keeps Redis out even once it is back
+			mr.SetError("")
+			allowed, _ := i.Allow(ctx, key)
+			assert.False(t, allowed)
+			assert.Equal(t, 2, FailoverStatus().Failures)
+
+			assert.ErrorIs(t, i.Reset(ctx, key), errRedisUnavailable)
+			assert.False(t, i.ReachLimit(ctx, key))
+		})
+
+		t.Run("Probe Closes the Breaker", func(t *testing.T) {
+			mr.FlushAll()
+			f := useFailover(t, config.RateLimitFailoverConf{FailureThreshold: 1, OpenSeconds: 60})
+			i := NewInterceptor(10, 1)
+
+			mr.SetError("LOADING")
+			i.Allow(ctx, key)
+			mr.SetError("")
+			assert.Equal(t, ModeLocal, FailoverStatus().Mode)
+
+			// the breaker has been open long enough
+			f.openedAt = time.Now().Add(-time.Minute)
+			allowed, err := i.Allow(ctx, key)
+			assert.NoError(t, err)
+			// counted in Redis again, where nothing was counted yet
+			assert.True(t, allowed)
+			status := FailoverStatus()
+			assert.Equal(t, ModeRedis, status.Mode)
+			assert.Equal(t, 0, status.Failures)
+			assert.False(t, status.Since.IsZero())
+		})
+
+		t.Run("Failed Probe Keeps the Breaker Open", func(t *testing.T) {
+			mr.FlushAll()
+			f := useFailover(t, config.RateLimitFailoverConf{FailureThreshold: 1, OpenSeconds: 60})
+			i := NewInterceptor(10, 1)
+
+			mr.SetError("LOADING")
+			defer mr.SetError("")
+			i.Allow(ctx, key)
+
+			opened := time.Now().Add(-time.Minute)
+			f.openedAt = opened
+			i.Allow(ctx, key)
+			assert.Equal(t, ModeLocal, FailoverStatus().Mode)
+			assert.True(t, f.openedAt.After(opened))
+		})
+
+		t.Run("Cancelled Probe Lets Another Probe Through", func(t *testing.T) {
+			mr.FlushAll()
+			f := useFailover(t, config.RateLimitFailoverConf{FailureThreshold: 1, OpenSeconds: 60})
+			i := NewInterceptor(10, 1)
+
+			mr.SetError("LOADING")
+			i.Allow(ctx, key)
+			mr.SetError("")
+
+			// the probe goes to Redis with a request which has already given up
+			opened := time.Now().Add(-time.Minute)
+			f.openedAt = opened
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			i.Allow(cancelled, key)
+			assert.False(t, f.probing)
+			assert.True(t, f.openedAt.After(opened))
+			assert.Equal(t, 1, FailoverStatus().Failures)
+
+			f.openedAt = opened
+			allowed, err := i.Allow(ctx, key)
+			assert.NoError(t, err)
+			assert.True(t, allowed)
+			assert.Equal(t, ModeRedis, FailoverStatus().Mode)
+		})
+
+		t.Run("Fail Open", func(t *testing.T) {
+			mr.FlushAll()
+			useFailover(t, config.RateLimitFailoverConf{FailOpen: true, FailureThreshold: 1})
+			i := NewInterceptor(10, 1)
+
+			mr.SetError("LOADING")
+			defer mr.SetError("")
+			_, err := i.Allow(ctx, key)
+			assert.Error(t, err)
+			_, err = i.Allow(ctx, key)
+			assert.ErrorIs(t, err, errRedisUnavailable)
+			assert.False(t, i.ReachLimit(ctx, key))
+		})
+	})
+}
@@ -3,67 +3,203 @@ package interceptor
 import (
 	"context"
 	"doing_now/be/biz/db/redis"
+	"doing_now/be/biz/util/random"
+	"errors"
+	"fmt"
+	"strconv"
 	"time"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	goredis "github.com/redis/go-redis/v9"
 )
 
-// luaScript ensures atomicity of INCR + EXPIRE and provides self-healing for keys without TTL.
+// fixedWindowScript ensures atomicity of INCR + EXPIRE and provides self-healing for keys without TTL.
+// Unlike the other algorithms it counts denied requests too.
 // KEYS[1]: The rate limit key
 // ARGV[1]: Window duration in seconds
 // ARGV[2]: Max limit count
-const luaScript = `
+// ARGV[3]: "1" to count the request, "0" to only check whether it would be allowed
+// Returns {allowed, remaining, reset, retry after} like the scripts of algorithm.go
+var fixedWindowScript = goredis.NewScript(`
 local key = KEYS[1]
 local window = ARGV[1]
 local limit = tonumber(ARGV[2])
 
-local current = redis.call("INCR", key)
-
-if current == 1 then
-    redis.call("EXPIRE", key, window)
-else
-    if redis.call("TTL", key) == -1 then
+local current
+if ARGV[3] == "1" then
+    current = redis.call("INCR", key)
+    if current == 1 then
         redis.call("EXPIRE", key, window)
+    else
+        if redis.call("TTL", key) == -1 then
+            redis.call("EXPIRE", key, window)
+        end
     end
+else
+    current = tonumber(redis.call("GET", key) or "0")
 end
 
-if current > limit then
-    return 0 -- Denied
+local reset = math.max(0, redis.call("PTTL", key))
+local allowed = 1
+if current > limit or (ARGV[3] == "0" and current >= limit) then
+    allowed = 0 -- Denied
+end
+local retry = 0
+if current >= limit then
+    retry = reset
 end
-return 1 -- Allowed
-`
+return {allowed, math.max(0, limit - current), reset, retry}
+`)
+
+// Quota is the state of a key after a request, or before the next one for Peek.
+type Quota struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	// Reset is how long until the whole quota is back
+	Reset time.Duration
+	// RetryAfter is how long until a request is allowed again, zero when it is now
+	RetryAfter time.Duration
+}
+
+var errRedisUnavailable = errors.New("rate limit redis is unavailable")
 
 type Interceptor struct {
-	window time.Duration
-	limit  int64
+	algorithm string
+	window    time.Duration
+	limit     int64
+	burst     int64
 }
 
+// NewInterceptor creates a fixed-window interceptor.
 func NewInterceptor(windowSeconds int, limit int64) *Interceptor {
 	return &Interceptor{
-		window: time.Duration(windowSeconds) * time.Second,
-		limit:  limit,
+		algorithm: AlgorithmFixedWindow,
+		window:    time.Duration(windowSeconds) * time.Second,
+		limit:     limit,
 	}
 }
 
-func (i *Interceptor) Allow(ctx context.Context, key string) (bool, error) {
-	redisKey := "rate_limit:" + key
+// New creates an interceptor with the algorithm, fixed window when it is empty. burst is the
+// bucket size of the token bucket, limit when it is zero, the other algorithms ignore it.
+func New(algorithm string, windowSeconds int, limit, burst int64) (*Interceptor, error) {
+	switch algorithm {
+	case "":
+		algorithm = AlgorithmFixedWindow
+	case AlgorithmFixedWindow, AlgorithmSlidingLog, AlgorithmSlidingWindow, AlgorithmTokenBucket:
+	default:
+		return nil, fmt.Errorf("unknown rate limit algorithm %q", algorithm)
+	}
+	if burst <= 0 {
+		burst = limit
+	}
+	return &Interceptor{
+		algorithm: algorithm,
+		window:    time.Duration(windowSeconds) * time.Second,
+		limit:     limit,
+		burst:     burst,
+	}, nil
+}
 
-	// Execute Lua script
-	// Pass window in seconds
-	result, err := redis.GetRedisClient().
-		Eval(ctx, luaScript, []string{redisKey}, int(i.window.Seconds()), i.limit).Result()
+func (i *Interceptor) Allow(ctx context.Context, key string) (bool, error) {
+	q, err := i.Take(ctx, key)
 	if err != nil {
 		return false, err
 	}
-
-	// Result is 1 (Allowed) or 0 (Denied)
-	return result.(int64) == 1, nil
+	return q.Allowed, nil
 }
 
-// ReachLimit checks if the key has reached the limit without incrementing.
+// ReachLimit checks if the key has reached the limit without incrementing, that is whether the
+// next request would be denied.
 func (i *Interceptor) ReachLimit(ctx context.Context, key string) bool {
-	redisKey := "rate_limit:" + key
-	count, err := redis.GetRedisClient().Get(ctx, redisKey).Int64()
+	q, err := i.Peek(ctx, key)
+	return err == nil && !q.Allowed
+}
+
+// Take counts a request of the key and returns the quota left.
+func (i *Interceptor) Take(ctx context.Context, key string) (Quota, error) {
+	return i.run(ctx, "rate_limit:"+key, true)
+}
+
+// Peek returns the quota of the key without counting a request, Allowed tells whether the next
+// request would be allowed.
+func (i *Interceptor) Peek(ctx context.Context, key string) (Quota, error) {
+	return i.run(ctx, "rate_limit:"+key, false)
+}
+
+// run counts the request in Redis, or in process while Redis fails unless the failover is
+// configured to fail open. consume counts the request.
+func (i *Interceptor) run(ctx context.Context, redisKey string, consume bool) (Quota, error) {
+	f := getFailover()
+	if f.useRedis() {
+		q, err := i.runRedis(ctx, redisKey, consume)
+		if err == nil {
+			f.success()
+			return q, nil
+		}
+		f.failure(ctx, err)
+		if f.failOpen {
+			return Quota{}, err
+		}
+		hlog.CtxErrorf(ctx, "Rate limit falls back to the local limiter for key %s: %v", redisKey, err)
+	} else if f.failOpen {
+		return Quota{}, errRedisUnavailable
+	}
+	return f.local.take(redisKey, i.window, f.localLimit(i.limit), consume), nil
+}
+
+// runRedis runs the script of the algorithm, consume counts the request.
+func (i *Interceptor) runRedis(ctx context.Context, redisKey string, consume bool) (Quota, error) {
+	flag := "0"
+	if consume {
+		flag = "1"
+	}
+	windowMs := i.window.Milliseconds()
+
+	var result []int64
+	var err error
+	rdb := redis.GetRedisClient()
+	limit := i.limit
+	switch i.algorithm {
+	case AlgorithmFixedWindow:
+		// Pass window in seconds
+		result, err = fixedWindowScript.Run(ctx, rdb, []string{redisKey}, int(i.window.Seconds()), i.limit, flag).Int64Slice()
+	case AlgorithmSlidingLog:
+		result, err = slidingLogScript.Run(ctx, rdb, []string{redisKey}, windowMs, i.limit, flag, random.SecureStr(16)).Int64Slice()
+	case AlgorithmSlidingWindow:
+		result, err = slidingWindowScript.Run(ctx, rdb, []string{redisKey}, windowMs, i.limit, flag).Int64Slice()
+	case AlgorithmTokenBucket:
+		rate := float64(i.limit) / float64(windowMs)
+		limit = i.burst
+		result, err = tokenBucketScript.Run(ctx, rdb, []string{redisKey}, i.burst, strconv.FormatFloat(rate, 'g', -1, 64), flag).Int64Slice()
+	}
 	if err != nil {
-		return false
+		return Quota{}, err
+	}
+	if len(result) != 4 {
+		return Quota{}, fmt.Errorf("unexpected rate limit script result %v", result)
+	}
+
+	return Quota{
+		Allowed:    result[0] == 1,
+		Limit:      limit,
+		Remaining:  result[1],
+		Reset:      time.Duration(result[2]) * time.Millisecond,
+		RetryAfter: time.Duration(result[3]) * time.Millisecond,
+	}, nil
+}
+
+// Reset forgets the count of the key.
+func (i *Interceptor) Reset(ctx context.Context, key string) error {
+	f := getFailover()
+	f.local.reset("rate_limit:" + key)
+	if !f.useRedis() {
+		return errRedisUnavailable
+	}
+	if err := redis.GetRedisClient().Del(ctx, "rate_limit:"+key).Err(); err != nil {
+		f.failure(ctx, err)
+		return err
 	}
-	return count >= i.limit
+	f.success()
+	return nil
 }
@@ -107,5 +107,16 @@ func TestInterceptor_Allow_LuaScript(t *testing.T) {
 			val, _ := mr.Get(redisKey)
 			assert.Equal(t, "3", val)
 		})
+
+		t.Run("Reset", func(t *testing.T) {
+			mr.FlushAll()
+			interceptor := NewInterceptor(10, 1)
+
+			_, _ = interceptor.Allow(ctx, key)
+			assert.True(t, interceptor.ReachLimit(ctx, key))
+
+			assert.NoError(t, interceptor.Reset(ctx, key))
+			assert.False(t, interceptor.ReachLimit(ctx, key))
+		})
 	})
 }
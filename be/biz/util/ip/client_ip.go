@@ -0,0 +1,40 @@
+package ip
+
+import (
+	"fmt"
+	"net"
+
+	"doing_now/be/biz/config"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+var defaultClientIPHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+
+// ClientIPFunc resolves the client IP behind the trusted proxies. The headers are only read when
+// the connection comes from a trusted proxy, and X-Forwarded-For is read from the right, skipping
+// the trusted proxies, so a client cannot pass itself off as another IP by sending the header.
+func ClientIPFunc(conf config.ClientIPConf) app.ClientIP {
+	headers := conf.Headers
+	if len(headers) == 0 {
+		headers = defaultClientIPHeaders
+	}
+
+	prefixes, err := ParsePrefixes(conf.TrustedProxies)
+	if err != nil {
+		// dropping the list would take the proxies for the clients behind them
+		panic(fmt.Errorf("parse trusted proxies: %w", err))
+	}
+	trusted := make([]*net.IPNet, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		trusted = append(trusted, &net.IPNet{
+			IP:   net.IP(prefix.Addr().AsSlice()),
+			Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
+		})
+	}
+
+	return app.ClientIPWithOption(app.ClientIPOptions{
+		RemoteIPHeaders: headers,
+		TrustedCIDRs:    trusted,
+	})
+}
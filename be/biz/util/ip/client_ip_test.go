@@ -0,0 +1,79 @@
+package ip
+
+import (
+	"net"
+	"testing"
+
+	"doing_now/be/biz/config"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/test/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIPFunc(t *testing.T) {
+	newCtx := func(remote string, xff string) *app.RequestContext {
+		c := app.NewContext(0)
+		conn := mock.NewConn("")
+		c.SetConn(&remoteConn{Conn: conn, addr: &net.TCPAddr{IP: net.ParseIP(remote), Port: 1234}})
+		if xff != "" {
+			c.Request.Header.Set("X-Forwarded-For", xff)
+		}
+		return c
+	}
+
+	fn := ClientIPFunc(config.ClientIPConf{TrustedProxies: []string{"10.0.0.0/8", "127.0.0.1"}})
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		want   string
+	}{
+		{"no proxy", "1.2.3.4", "", "1.2.3.4"},
+		{"untrusted remote cannot set the header", "1.2.3.4", "5.6.7.8", "1.2.3.4"},
+		{"trusted proxy", "10.0.0.1", "5.6.7.8", "5.6.7.8"},
+		{"spoofed hop before the proxy chain", "10.0.0.1", "9.9.9.9, 5.6.7.8, 10.0.0.2", "5.6.7.8"},
+		{"loopback proxy", "127.0.0.1", "5.6.7.8", "5.6.7.8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, fn(newCtx(tt.remote, tt.xff)))
+		})
+	}
+
+	t.Run("no trusted proxies", func(t *testing.T) {
+		fn := ClientIPFunc(config.ClientIPConf{})
+		assert.Equal(t, "10.0.0.1", fn(newCtx("10.0.0.1", "5.6.7.8")))
+	})
+
+	t.Run("invalid trusted proxies fail at startup", func(t *testing.T) {
+		assert.Panics(t, func() { ClientIPFunc(config.ClientIPConf{TrustedProxies: []string{"10.0.0.0/8", "lb"}}) })
+	})
+}
+
+func TestParsePrefix(t *testing.T) {
+	prefixes, err := ParsePrefixes([]string{"10.1.2.3/16", " 192.168.0.1 ", "::ffff:172.16.0.1", "2001:db8::/32"})
+	assert.NoError(t, err)
+	assert.Equal(t, "10.1.0.0/16", prefixes[0].String())
+	assert.Equal(t, "192.168.0.1/32", prefixes[1].String())
+	assert.Equal(t, "172.16.0.1/32", prefixes[2].String())
+
+	assert.True(t, Contains(prefixes, "10.1.255.1"))
+	assert.True(t, Contains(prefixes, "::ffff:192.168.0.1"))
+	assert.True(t, Contains(prefixes, "2001:db8::1"))
+	assert.False(t, Contains(prefixes, "10.2.0.1"))
+	assert.False(t, Contains(prefixes, ""))
+
+	_, err = ParsePrefixes([]string{"10.0.0.0/8", "10.0.0"})
+	assert.Error(t, err)
+}
+
+type remoteConn struct {
+	*mock.Conn
+	addr net.Addr
+}
+
+func (c *remoteConn) RemoteAddr() net.Addr {
+	return c.addr
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultDir = "./mail"
+
+// FileMailer writes every message into its own .eml file, for local development.
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir, from string) *FileMailer {
+	if dir == "" {
+		dir = defaultDir
+	}
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+	return &FileMailer{dir: dir, from: from}
+}
+
+func (m *FileMailer) Send(_ context.Context, msg Message) error {
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return err
+	}
+	// the recipient is user input, keep it out of the path
+	to := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, msg.To)
+	name := fmt.Sprintf("%d_%s.eml", time.Now().UnixNano(), to)
+	return os.WriteFile(filepath.Join(m.dir, name), format(m.from, msg), 0o600)
+}
@@ -0,0 +1,60 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"doing_now/be/biz/config"
+)
+
+const (
+	DriverSMTP   = "smtp"
+	DriverFile   = "file"
+	DriverMemory = "memory"
+)
+
+// Message is a plain-text mail.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultMailer Mailer
+	defaultErr    error
+)
+
+// Default returns the mailer built from the config.
+func Default() (Mailer, error) {
+	defaultOnce.Do(func() {
+		defaultMailer, defaultErr = New(config.GetMailerConf())
+	})
+	return defaultMailer, defaultErr
+}
+
+func New(conf config.MailerConf) (Mailer, error) {
+	switch conf.Driver {
+	case DriverSMTP:
+		return NewSMTPMailer(conf.SMTP, conf.From)
+	case DriverMemory:
+		return NewMemoryMailer(), nil
+	case DriverFile, "":
+		return NewFileMailer(conf.Dir, conf.From), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer driver %q", conf.Driver)
+	}
+}
+
+// NormalizeAddress is the form addresses are stored and looked up in.
+func NormalizeAddress(addr string) string {
+	return strings.ToLower(strings.TrimSpace(addr))
+}
@@ -0,0 +1,129 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"doing_now/be/biz/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSMTP accepts a single mail without TLS or auth and returns its data.
+func fakeSMTP(t *testing.T) (string, int, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	data := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		reply("220 fake")
+		var body strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					data <- body.String()
+					reply("250 ok")
+					continue
+				}
+				body.WriteString(line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO":
+				reply("250 fake")
+			case "DATA":
+				inData = true
+				reply("354 go")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return host, p, data
+}
+
+func TestNew(t *testing.T) {
+	m, err := New(config.MailerConf{})
+	assert.NoError(t, err)
+	assert.IsType(t, &FileMailer{}, m)
+
+	m, err = New(config.MailerConf{Driver: DriverMemory})
+	assert.NoError(t, err)
+	assert.IsType(t, &MemoryMailer{}, m)
+
+	_, err = New(config.MailerConf{Driver: DriverSMTP})
+	assert.Error(t, err)
+
+	_, err = New(config.MailerConf{Driver: "pigeon"})
+	assert.Error(t, err)
+}
+
+func TestSMTPMailer_Send(t *testing.T) {
+	host, port, data := fakeSMTP(t)
+	m, err := NewSMTPMailer(config.SMTPConf{Host: host, Port: port}, "from@example.com")
+	assert.NoError(t, err)
+
+	err = m.Send(context.Background(), Message{To: "to@example.com", Subject: "hi\r\nBcc: x@example.com", Body: "line 1\nline 2"})
+	assert.NoError(t, err)
+
+	got := <-data
+	assert.Contains(t, got, "To: to@example.com\r\n")
+	assert.Contains(t, got, "Subject: hiBcc: x@example.com\r\n")
+	assert.Contains(t, got, "\r\n\r\nline 1\r\nline 2")
+}
+
+func TestFileMailer_Send(t *testing.T) {
+	dir := t.TempDir()
+	m := NewFileMailer(dir, "")
+	assert.NoError(t, m.Send(context.Background(), Message{To: "../to@example.com", Subject: "hi", Body: "body"}))
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.True(t, strings.HasSuffix(entries[0].Name(), "_.._to@example.com.eml"))
+	content, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "Subject: hi\r\n")
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := NewMemoryMailer()
+	ctx := context.Background()
+	assert.NoError(t, m.Send(ctx, Message{To: "a@example.com", Body: "1"}))
+	assert.NoError(t, m.Send(ctx, Message{To: "b@example.com", Body: "2"}))
+	assert.NoError(t, m.Send(ctx, Message{To: "a@example.com", Body: "3"}))
+
+	assert.Len(t, m.Messages(), 3)
+	msg, ok := m.Last("a@example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "3", msg.Body)
+	_, ok = m.Last("c@example.com")
+	assert.False(t, ok)
+}
@@ -0,0 +1,54 @@
+package password
+
+import (
+	"errors"
+
+	"doing_now/be/biz/config"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const defaultBcryptCost = 12
+
+type bcryptHasher struct {
+	cost int
+}
+
+func newBcryptHasher(conf config.BcryptConf) *bcryptHasher {
+	cost := conf.Cost
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = defaultBcryptCost
+	}
+	return &bcryptHasher{cost: cost}
+}
+
+func (h *bcryptHasher) Algorithm() string {
+	return AlgorithmBcrypt
+}
+
+func (h *bcryptHasher) Hash(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (h *bcryptHasher) Verify(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return false, ErrMalformedHash
+}
+
+func (h *bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return true
+	}
+	return cost != h.cost
+}
@@ -0,0 +1,113 @@
+package password
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/util/encode"
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmLegacy   = "sha256"
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+	ErrMalformedHash    = errors.New("malformed password hash")
+)
+
+// Hasher hashes passwords into self-describing strings and verifies them.
+type Hasher interface {
+	Algorithm() string
+	Hash(password string) (string, error)
+	Verify(encoded, password string) (bool, error)
+	// NeedsRehash reports whether encoded was produced with parameters other than the hasher's own.
+	NeedsRehash(encoded string) bool
+}
+
+// Manager hashes new passwords with the configured algorithm and verifies any supported format,
+// including the legacy salted SHA-256 hashes.
+type Manager struct {
+	current Hasher
+	hashers map[string]Hasher
+}
+
+// New builds the manager of the configured algorithm, argon2id when none is configured. An unknown
+// algorithm is an error rather than a silent fallback.
+func New(conf config.PasswordConf) (*Manager, error) {
+	argon := newArgon2idHasher(conf.Argon2id)
+	bc := newBcryptHasher(conf.Bcrypt)
+
+	var current Hasher
+	switch conf.Algorithm {
+	case "", AlgorithmArgon2id:
+		current = argon
+	case AlgorithmBcrypt:
+		current = bc
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownAlgorithm, conf.Algorithm)
+	}
+
+	return &Manager{
+		current: current,
+		hashers: map[string]Hasher{
+			AlgorithmArgon2id: argon,
+			AlgorithmBcrypt:   bc,
+		},
+	}, nil
+}
+
+func NewDefault() *Manager {
+	m, err := New(config.GetPasswordConf())
+	if err != nil {
+		panic(fmt.Errorf("init password manager: %w", err))
+	}
+	return m
+}
+
+// Hash returns the encoded hash of password using the current algorithm.
+func (m *Manager) Hash(password string) (string, error) {
+	return m.current.Hash(password)
+}
+
+// Verify checks password against the stored hash. salt is only used by legacy SHA-256 hashes.
+// needsRehash is true when the password matched but the stored hash should be upgraded.
+func (m *Manager) Verify(salt, encoded, password string) (ok bool, needsRehash bool, err error) {
+	alg := Identify(encoded)
+	if alg == AlgorithmLegacy {
+		expected := encode.EncodePassword(salt, password)
+		ok = subtle.ConstantTimeCompare([]byte(expected), []byte(encoded)) == 1
+		return ok, ok, nil
+	}
+
+	h, exist := m.hashers[alg]
+	if !exist {
+		return false, false, ErrUnknownAlgorithm
+	}
+
+	ok, err = h.Verify(encoded, password)
+	if err != nil || !ok {
+		return false, false, err
+	}
+
+	return true, alg != m.current.Algorithm() || h.NeedsRehash(encoded), nil
+}
+
+// Identify returns the algorithm of an encoded hash. Hashes without a "$" prefix are legacy SHA-256 hex digests.
+func Identify(encoded string) string {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return AlgorithmArgon2id
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return AlgorithmBcrypt
+	case strings.HasPrefix(encoded, "$"):
+		return ""
+	default:
+		return AlgorithmLegacy
+	}
+}
@@ -0,0 +1,104 @@
+package password
+
+import (
+	"testing"
+
+	"doing_now/be/biz/config"
+	"doing_now/be/biz/util/encode"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testArgon2Conf = config.Argon2idConf{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+}
+
+func TestManager_Argon2id(t *testing.T) {
+	m := newManager(t, config.PasswordConf{Algorithm: AlgorithmArgon2id, Argon2id: testArgon2Conf})
+
+	encoded, err := m.Hash("password01")
+	assert.NoError(t, err)
+	assert.Equal(t, AlgorithmArgon2id, Identify(encoded))
+	assert.Contains(t, encoded, "$m=1024,t=1,p=1$")
+
+	ok, rehash, err := m.Verify("", encoded, "password01")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, _, err = m.Verify("", encoded, "password02")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	t.Run("params changed", func(t *testing.T) {
+		conf := testArgon2Conf
+		conf.Iterations = 2
+		m2 := newManager(t, config.PasswordConf{Algorithm: AlgorithmArgon2id, Argon2id: conf})
+
+		ok, rehash, err := m2.Verify("", encoded, "password01")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.True(t, rehash)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		_, _, err := m.Verify("", "$argon2id$v=19$m=1024$bad", "password01")
+		assert.ErrorIs(t, err, ErrMalformedHash)
+	})
+}
+
+func TestManager_Bcrypt(t *testing.T) {
+	m := newManager(t, config.PasswordConf{Algorithm: AlgorithmBcrypt, Bcrypt: config.BcryptConf{Cost: 4}})
+
+	encoded, err := m.Hash("password01")
+	assert.NoError(t, err)
+	assert.Equal(t, AlgorithmBcrypt, Identify(encoded))
+
+	ok, rehash, err := m.Verify("", encoded, "password01")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	// switching the current algorithm asks for a rehash
+	m2 := newManager(t, config.PasswordConf{Algorithm: AlgorithmArgon2id, Argon2id: testArgon2Conf})
+	ok, rehash, err = m2.Verify("", encoded, "password01")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+}
+
+func TestManager_Legacy(t *testing.T) {
+	m := newManager(t, config.PasswordConf{Argon2id: testArgon2Conf})
+	legacy := encode.EncodePassword("salt", "password01")
+	assert.Equal(t, AlgorithmLegacy, Identify(legacy))
+
+	ok, rehash, err := m.Verify("salt", legacy, "password01")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	ok, rehash, err = m.Verify("salt", legacy, "password02")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.False(t, rehash)
+
+	_, _, err = m.Verify("", "$unknown$abc", "password01")
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(config.PasswordConf{Algorithm: "md5"})
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+
+	m := newManager(t, config.PasswordConf{Argon2id: testArgon2Conf})
+	assert.Equal(t, AlgorithmArgon2id, m.current.Algorithm())
+}
+
+func newManager(t *testing.T, conf config.PasswordConf) *Manager {
+	t.Helper()
+	m, err := New(conf)
+	assert.NoError(t, err)
+	return m
+}
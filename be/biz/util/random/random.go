@@ -1,6 +1,7 @@
 package random
 
 import (
+	crand "crypto/rand"
 	"math/rand"
 	"strings"
 	"time"
@@ -35,3 +36,22 @@ func RandStr(n int) string {
 	}
 	return sb.String()
 }
+
+// SecureStr generates random string from crypto/rand, use it for secrets and one-time codes.
+func SecureStr(n int) string {
+	sb := strings.Builder{}
+	sb.Grow(n)
+	buf := make([]byte, n)
+	for sb.Len() < n {
+		if _, err := crand.Read(buf); err != nil {
+			panic(err)
+		}
+		for _, b := range buf {
+			// drop the biased tail so every letter has the same chance
+			if idx := int(b & letterIdMask); idx < len(letters) && sb.Len() < n {
+				sb.WriteByte(letters[idx])
+			}
+		}
+	}
+	return sb.String()
+}
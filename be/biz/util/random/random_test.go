@@ -13,3 +13,11 @@ func TestRandStr(t *testing.T) {
 		assert.Equal(t, i, len(s))
 	}
 }
+
+func TestSecureStr(t *testing.T) {
+	for i := 0; i <= 10; i++ {
+		s := SecureStr(i)
+		assert.Equal(t, i, len(s))
+	}
+	assert.NotEqual(t, SecureStr(32), SecureStr(32))
+}
@@ -0,0 +1,57 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHOTP_RFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	cases := map[int64]string{
+		59:         "94287082",
+		1111111109: "07081804",
+		1111111111: "14050471",
+		1234567890: "89005924",
+		2000000000: "69279037",
+	}
+	for ts, want := range cases {
+		assert.Equal(t, want, hotp(key, uint64(ts/Period), 8))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(59, 0)
+
+	code, err := GenerateCode(secret, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "287082", code)
+
+	step, ok := Validate(secret, code, now, 1)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	// one step of clock drift is accepted
+	_, ok = Validate(secret, code, now.Add(Period*time.Second), 1)
+	assert.True(t, ok)
+	_, ok = Validate(secret, code, now.Add(2*Period*time.Second), 1)
+	assert.False(t, ok)
+
+	_, ok = Validate(secret, "000000", now, 1)
+	assert.False(t, ok)
+	_, ok = Validate(secret, "12345", now, 1)
+	assert.False(t, ok)
+}
+
+func TestGenerateSecretAndURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	uri := URI("Doing Now", "account01", secret)
+	assert.Contains(t, uri, "otpauth://totp/Doing%20Now:account01?")
+	assert.Contains(t, uri, "secret="+secret)
+}
@@ -6,6 +6,16 @@ import (
 
 type logIdKey struct{}
 
+type clientKey struct{}
+
+type userIdKey struct{}
+
+// Client describes who sent the request.
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
 func WithLogId(ctx context.Context, logId string) context.Context {
 	return context.WithValue(ctx, logIdKey{}, logId)
 }
@@ -17,3 +27,23 @@ func GetLogId(ctx context.Context) string {
 	}
 	return ""
 }
+
+func WithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+func GetClient(ctx context.Context) Client {
+	client, _ := ctx.Value(clientKey{}).(Client)
+	return client
+}
+
+// WithUserId keeps the id of the signed-in user, for code below the handlers that has to know
+// who made the request.
+func WithUserId(ctx context.Context, userId string) context.Context {
+	return context.WithValue(ctx, userIdKey{}, userId)
+}
+
+func GetUserId(ctx context.Context) string {
+	userId, _ := ctx.Value(userIdKey{}).(string)
+	return userId
+}
@@ -14,3 +14,20 @@ func TestTraceInfo(t *testing.T) {
 
 	assert.Equal(t, logId, GetLogId(ctx))
 }
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, Client{}, GetClient(ctx))
+
+	client := Client{IP: "127.0.0.1", UserAgent: "test"}
+	ctx = WithClient(ctx, client)
+	assert.Equal(t, client, GetClient(ctx))
+}
+
+func TestUserId(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, "", GetUserId(ctx))
+
+	ctx = WithUserId(ctx, "user01")
+	assert.Equal(t, "user01", GetUserId(ctx))
+}
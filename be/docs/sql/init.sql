@@ -7,9 +7,15 @@ CREATE TABLE `users` (
   `user_id` varchar(64) NOT NULL COMMENT '用户唯一标识ID',
   `account` varchar(64) NOT NULL COMMENT '用户登录账号',
   `name` varchar(64) NOT NULL COMMENT '用户姓名',
+  `email` varchar(255) DEFAULT NULL COMMENT '邮箱，可用于登录，为空表示未设置',
+  `email_verified_at` datetime(3) DEFAULT NULL COMMENT '邮箱验证时间，为空表示未验证',
+  `status` varchar(16) NOT NULL DEFAULT 'active' COMMENT '用户状态: pending, active, locked, disabled',
+  `status_reason` varchar(255) NOT NULL DEFAULT '' COMMENT '最近一次状态变更原因',
+  `status_changed_at` datetime(3) DEFAULT NULL COMMENT '最近一次状态变更时间',
   PRIMARY KEY (`id`),
   UNIQUE KEY `idx_users_user_id` (`user_id`),
-  UNIQUE KEY `idx_users_account` (`account`)
+  UNIQUE KEY `idx_users_account` (`account`),
+  UNIQUE KEY `idx_users_email` (`email`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='用户表';
 
 DROP TABLE IF EXISTS `user_credentials`;
@@ -19,10 +25,204 @@ CREATE TABLE `user_credentials` (
   `updated_at` datetime(3) DEFAULT NULL COMMENT '更新时间',
   `deleted_at` bigint unsigned DEFAULT '0' COMMENT '删除时间戳(软删除)',
   `user_id` varchar(64) NOT NULL COMMENT '用户唯一标识ID',
-  `password_salt` varchar(64) NOT NULL COMMENT '密码盐',
-  `password_hash` varchar(128) NOT NULL COMMENT '密码哈希',
+  `password_salt` varchar(64) NOT NULL COMMENT '密码盐，仅旧版SHA-256哈希使用',
+  `password_hash` varchar(128) NOT NULL COMMENT '密码哈希，自描述格式(argon2id/bcrypt)',
   `credential_version` int unsigned NOT NULL DEFAULT '0' COMMENT '密码凭证版本，每次修改密码的时候+1',
   PRIMARY KEY (`id`),
   UNIQUE KEY `idx_users_user_id` (`user_id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='用户密码凭证表';
 
+
+DROP TABLE IF EXISTS `user_totp`;
+CREATE TABLE `user_totp` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT '主键ID',
+  `created_at` datetime(3) DEFAULT NULL COMMENT '创建时间',
+  `updated_at` datetime(3) DEFAULT NULL COMMENT '更新时间',
+  `deleted_at` bigint unsigned DEFAULT '0' COMMENT '删除时间戳(软删除)',
+  `user_id` varchar(64) NOT NULL COMMENT '用户唯一标识ID',
+  `secret` varchar(64) NOT NULL COMMENT 'base32 TOTP密钥',
+  `enabled` tinyint(1) NOT NULL DEFAULT '0' COMMENT '是否已确认启用',
+  `last_used_step` bigint NOT NULL DEFAULT '0' COMMENT '最近一次使用的时间步，防重放',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_user_totp_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='用户TOTP两步验证表';
+
+DROP TABLE IF EXISTS `user_recovery_codes`;
+CREATE TABLE `user_recovery_codes` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT '主键ID',
+  `created_at` datetime(3) DEFAULT NULL COMMENT '创建时间',
+  `updated_at` datetime(3) DEFAULT NULL COMMENT '更新时间',
+  `deleted_at` bigint unsigned DEFAULT '0' COMMENT '删除时间戳(软删除)',
+  `user_id` varchar(64) NOT NULL COMMENT '用户唯一标识ID',
+  `code_hash` varchar(64) NOT NULL COMMENT '恢复码SHA-256',
+  `used_at` datetime(3) DEFAULT NULL COMMENT '使用时间，为空表示未使用',
+  PRIMARY KEY (`id`),
+  KEY `idx_user_recovery_codes_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='用户两步验证恢复码表';
+
+DROP TABLE IF EXISTS `user_webauthn_credentials`;
+CREATE TABLE `user_webauthn_credentials` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT '主键ID',
+  `created_at` datetime(3) DEFAULT NULL COMMENT '创建时间',
+  `updated_at` datetime(3) DEFAULT NULL COMMENT '更新时间',
+  `deleted_at` bigint unsigned DEFAULT '0' COMMENT '删除时间戳(软删除)',
+  `user_id` varchar(64) NOT NULL COMMENT '用户唯一标识ID',
+  `credential_id` varchar(255) NOT NULL COMMENT 'base64url编码的凭证ID',
+  `public_key` blob NOT NULL COMMENT 'COSE编码公钥',
+  `attestation_type` varchar(32) NOT NULL COMMENT 'attestation格式',
+  `aaguid` varchar(36) NOT NULL COMMENT '认证器型号AAGUID',
+  `sign_count` int unsigned NOT NULL DEFAULT '0' COMMENT '签名计数器，用于检测克隆',
+  `transports` varchar(128) NOT NULL COMMENT '逗号分隔的transport',
+  `flags` tinyint unsigned NOT NULL DEFAULT '0' COMMENT '认证器flags(BE/BS)',
+  `last_used_at` datetime(3) DEFAULT NULL COMMENT '最近一次登录时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_user_webauthn_credentials_credential_id` (`credential_id`),
+  KEY `idx_user_webauthn_credentials_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='用户通行密钥凭证表';
+
+DROP TABLE IF EXISTS `user_identities`;
+CREATE TABLE `user_identities` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT '主键ID',
+  `created_at` datetime(3) DEFAULT NULL COMMENT '创建时间',
+  `updated_at` datetime(3) DEFAULT NULL COMMENT '更新时间',
+  `deleted_at` bigint unsigned DEFAULT '0' COMMENT '删除时间戳(软删除)',
+  `user_id` varchar(64) NOT NULL COMMENT '用户唯一标识ID',
+  `provider` varchar(64) NOT NULL COMMENT '配置中的IdP名称',
+  `issuer` varchar(255) NOT NULL COMMENT 'ID token中的iss',
+  `subject` varchar(255) NOT NULL COMMENT 'ID token中的sub',
+  `email` varchar(255) NOT NULL COMMENT '绑定时IdP返回的邮箱，仅展示',
+  `last_used_at` datetime(3) DEFAULT NULL COMMENT '最近一次登录时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_user_identities_iss_sub` (`issuer`,`subject`),
+  KEY `idx_user_identities_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='用户第三方身份绑定表';
+
+DROP TABLE IF EXISTS `user_password_resets`;
+CREATE TABLE `user_password_resets` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT '主键ID',
+  `created_at` datetime(3) DEFAULT NULL COMMENT '创建时间',
+  `updated_at` datetime(3) DEFAULT NULL COMMENT '更新时间',
+  `deleted_at` bigint unsigned DEFAULT '0' COMMENT '删除时间戳(软删除)',
+  `user_id` varchar(64) NOT NULL COMMENT '用户唯一标识ID',
+  `token_hash` varchar(64) NOT NULL COMMENT '重置令牌SHA-256',
+  `expires_at` datetime(3) NOT NULL COMMENT '过期时间',
+  `used_at` datetime(3) DEFAULT NULL COMMENT '使用时间，为空表示未使用',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_user_password_resets_token_hash` (`token_hash`),
+  KEY `idx_user_password_resets_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='用户密码重置令牌表';
+
+DROP TABLE IF EXISTS `roles`;
+CREATE TABLE `roles` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT '主键ID',
+  `created_at` datetime(3) DEFAULT NULL COMMENT '创建时间',
+  `updated_at` datetime(3) DEFAULT NULL COMMENT '更新时间',
+  `deleted_at` bigint unsigned DEFAULT '0' COMMENT '删除时间戳(软删除)',
+  `name` varchar(64) NOT NULL COMMENT '角色唯一名称',
+  `description` varchar(255) NOT NULL COMMENT '角色说明',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_roles_name` (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='角色表';
+
+DROP TABLE IF EXISTS `role_permissions`;
+CREATE TABLE `role_permissions` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT '主键ID',
+  `created_at` datetime(3) DEFAULT NULL COMMENT '创建时间',
+  `updated_at` datetime(3) DEFAULT NULL COMMENT '更新时间',
+  `deleted_at` bigint unsigned DEFAULT '0' COMMENT '删除时间戳(软删除)',
+  `role` varchar(64) NOT NULL COMMENT '角色名称',
+  `permission` varchar(64) NOT NULL COMMENT '权限，如 user:read，* 表示全部',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_role_permissions_role_permission` (`role`,`permission`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='角色权限表';
+
+DROP TABLE IF EXISTS `user_roles`;
+CREATE TABLE `user_roles` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT '主键ID',
+  `created_at` datetime(3) DEFAULT NULL COMMENT '创建时间',
+  `updated_at` datetime(3) DEFAULT NULL COMMENT '更新时间',
+  `deleted_at` bigint unsigned DEFAULT '0' COMMENT '删除时间戳(软删除)',
+  `user_id` varchar(64) NOT NULL COMMENT '用户唯一标识ID',
+  `role` varchar(64) NOT NULL COMMENT '角色名称',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_user_roles_user_id_role` (`user_id`,`role`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='用户角色表';
+
+DROP TABLE IF EXISTS `plans`;
+CREATE TABLE `plans` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT '主键ID',
+  `created_at` datetime(3) DEFAULT NULL COMMENT '创建时间',
+  `updated_at` datetime(3) DEFAULT NULL COMMENT '更新时间',
+  `deleted_at` bigint unsigned DEFAULT '0' COMMENT '删除时间戳(软删除)',
+  `name` varchar(64) NOT NULL COMMENT '套餐唯一名称',
+  `description` varchar(255) NOT NULL COMMENT '套餐说明',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_plans_name` (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='套餐表';
+
+DROP TABLE IF EXISTS `plan_quotas`;
+CREATE TABLE `plan_quotas` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT '主键ID',
+  `created_at` datetime(3) DEFAULT NULL COMMENT '创建时间',
+  `updated_at` datetime(3) DEFAULT NULL COMMENT '更新时间',
+  `deleted_at` bigint unsigned DEFAULT '0' COMMENT '删除时间戳(软删除)',
+  `plan` varchar(64) NOT NULL COMMENT '套餐名称',
+  `feature` varchar(64) NOT NULL COMMENT '计量功能，如 api',
+  `daily` bigint NOT NULL DEFAULT '0' COMMENT '每日配额，0 表示不限',
+  `monthly` bigint NOT NULL DEFAULT '0' COMMENT '每月配额，0 表示不限',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_plan_quotas_plan_feature` (`plan`,`feature`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='套餐配额表';
+
+DROP TABLE IF EXISTS `user_plans`;
+CREATE TABLE `user_plans` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT '主键ID',
+  `created_at` datetime(3) DEFAULT NULL COMMENT '创建时间',
+  `updated_at` datetime(3) DEFAULT NULL COMMENT '更新时间',
+  `deleted_at` bigint unsigned DEFAULT '0' COMMENT '删除时间戳(软删除)',
+  `user_id` varchar(64) NOT NULL COMMENT '用户ID',
+  `plan` varchar(64) NOT NULL COMMENT '套餐名称',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_user_plans_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='用户套餐表';
+
+DROP TABLE IF EXISTS `quota_usages`;
+CREATE TABLE `quota_usages` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT '主键ID',
+  `updated_at` datetime(3) DEFAULT NULL COMMENT '最近一次汇总时间',
+  `user_id` varchar(64) NOT NULL COMMENT '用户ID',
+  `feature` varchar(64) NOT NULL COMMENT '计量功能',
+  `period` varchar(16) NOT NULL COMMENT '统计周期，UTC，日为 2006-01-02，月为 2006-01',
+  `used` bigint NOT NULL DEFAULT '0' COMMENT '周期内用量',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_quota_usages_user_feature_period` (`user_id`,`feature`,`period`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='配额用量汇总表';
+
+DROP TABLE IF EXISTS `audit_events`;
+CREATE TABLE `audit_events` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT '主键ID',
+  `created_at` datetime(3) NOT NULL COMMENT '事件时间',
+  `event_type` varchar(64) NOT NULL COMMENT '事件类型，如 login.failure',
+  `actor_id` varchar(64) NOT NULL DEFAULT '' COMMENT '操作者用户ID，未登录时为空',
+  `target_id` varchar(64) NOT NULL DEFAULT '' COMMENT '被操作的用户ID',
+  `ip` varchar(64) NOT NULL DEFAULT '' COMMENT '客户端IP',
+  `user_agent` varchar(255) NOT NULL DEFAULT '' COMMENT '客户端User-Agent',
+  `log_id` varchar(64) NOT NULL DEFAULT '' COMMENT '请求链路ID',
+  `details` text COMMENT '结构化详情，JSON',
+  PRIMARY KEY (`id`),
+  KEY `idx_audit_events_created_at` (`created_at`),
+  KEY `idx_audit_events_event_type` (`event_type`),
+  KEY `idx_audit_events_actor_id` (`actor_id`),
+  KEY `idx_audit_events_target_id` (`target_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci COMMENT='安全审计日志表，只追加';
+
+INSERT INTO `roles` (`created_at`, `updated_at`, `name`, `description`) VALUES
+  (NOW(3), NOW(3), 'admin', '管理员，拥有全部权限');
+INSERT INTO `role_permissions` (`created_at`, `updated_at`, `role`, `permission`) VALUES
+  (NOW(3), NOW(3), 'admin', '*');
+INSERT INTO `plans` (`created_at`, `updated_at`, `name`, `description`) VALUES
+  (NOW(3), NOW(3), 'free', '免费套餐'),
+  (NOW(3), NOW(3), 'pro', '付费套餐');
+INSERT INTO `plan_quotas` (`created_at`, `updated_at`, `plan`, `feature`, `daily`, `monthly`) VALUES
+  (NOW(3), NOW(3), 'free', 'api', 1000, 20000),
+  (NOW(3), NOW(3), 'pro', 'api', 100000, 0);
@@ -6,7 +6,9 @@ import (
 	"doing_now/be/biz/config"
 	"doing_now/be/biz/db"
 	"doing_now/be/biz/middleware"
+	"doing_now/be/biz/util/ip"
 	"doing_now/be/biz/util/logger"
+	"doing_now/be/biz/util/password"
 	_ "doing_now/be/docs"
 
 	"github.com/cloudwego/hertz/pkg/app/server"
@@ -43,6 +45,9 @@ func NewEngine() *server.Hertz {
 			return vd.Struct(req)
 		}),
 	)
+	h.SetClientIPFunc(ip.ClientIPFunc(config.GetClientIPConf()))
+	// an unknown password algorithm would fail every login, it fails the start instead
+	password.NewDefault()
 	h.Use(middleware.Suite()...)
 
 	register(h)
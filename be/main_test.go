@@ -3,9 +3,17 @@ package main_test
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"math/big"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
@@ -23,7 +31,16 @@ import (
 	"doing_now/be/biz/model/dto"
 	"doing_now/be/biz/model/errs"
 	"doing_now/be/biz/model/storage"
+	"doing_now/be/biz/service/audit"
+	"doing_now/be/biz/service/challenge"
+	"doing_now/be/biz/service/oidc/oidctest"
+	"doing_now/be/biz/service/passkey/passkeytest"
+	quotasvc "doing_now/be/biz/service/quota"
+	rbacsvc "doing_now/be/biz/service/rbac"
+	"doing_now/be/biz/service/reset"
 	usersvc "doing_now/be/biz/service/user"
+	"doing_now/be/biz/util/mailer"
+	"doing_now/be/biz/util/totp"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/bytedance/mockey"
@@ -32,11 +49,13 @@ import (
 	"github.com/cloudwego/hertz/pkg/common/ut"
 	"github.com/cloudwego/hertz/pkg/protocol"
 	"github.com/glebarez/sqlite"
+	webauthnprotocol "github.com/go-webauthn/webauthn/protocol"
 	jwtlib "github.com/golang-jwt/jwt/v5"
 	"gorm.io/gorm"
 )
 
 var testEngine *server.Hertz
+var testIdP *oidctest.Provider
 var baseConfPath string
 var baseConfContent string
 
@@ -45,6 +64,20 @@ func TestMain(t *testing.M) {
 	if err != nil {
 		panic(err)
 	}
+	testIdP, err = oidctest.New("doing-now", "doing-now-secret")
+	if err != nil {
+		panic(err)
+	}
+	defer testIdP.Close()
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	signingKeyDER, err := x509.MarshalPKCS8PrivateKey(signingKey)
+	if err != nil {
+		panic(err)
+	}
+	signingKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: signingKeyDER})
 	dir, err := os.MkdirTemp("", "doing_now_test_conf_*")
 	if err != nil {
 		panic(err)
@@ -68,7 +101,18 @@ jwt:
   refresh_expiration: 7200
   access_token_secret: "accesstoken-secret"
   refresh_token_secret: "refreshtoken-secret"
+  refresh_token_secrets:
+    - id: "refresh-2"
+      secret: "refreshtoken-secret-2"
+    - id: "refresh-1"
+      secret: "refreshtoken-secret-1"
   issuer: "test"
+  signing_key_id: "test-es256"
+  access_token_keys:
+    - id: "test-es256"
+      algorithm: "ES256"
+      private_key: |
+        ` + strings.ReplaceAll(strings.TrimSpace(string(signingKeyPEM)), "\n", "\n        ") + `
 
 cors:
   allow_origins:
@@ -89,6 +133,9 @@ session:
   secure: false
   http_only: true
   same_site: "Strict"
+  keys:
+    - "session-key-2"
+    - "session-key-1"
 
 rate_limit:
   - path: "/api/v1/user/register"
@@ -111,6 +158,155 @@ rate_limit:
     window_seconds: 1
     limit: 100
     has_session: false
+  - path: "/api/v1/user/mfa/verify"
+    window_seconds: 1
+    limit: 100
+    has_session: false
+  - path: "/api/v1/user/mfa/totp/disable"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/api/v1/user/passkey/register/begin"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/api/v1/user/passkey/register/finish"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/api/v1/user/passkey/login/begin"
+    window_seconds: 1
+    limit: 100
+    has_session: false
+  - path: "/api/v1/user/passkey/login/finish"
+    window_seconds: 1
+    limit: 100
+    has_session: false
+  - path: "/api/v1/user/oidc/fake/authorize"
+    window_seconds: 1
+    limit: 100
+    has_session: false
+  - path: "/api/v1/user/oidc/callback"
+    window_seconds: 1
+    limit: 100
+    has_session: false
+  - path: "/api/v1/user/password/forgot"
+    window_seconds: 1
+    limit: 100
+    has_session: false
+  - path: "/api/v1/user/password/reset"
+    window_seconds: 1
+    limit: 100
+    has_session: false
+  - path: "/api/v1/user/verify_email"
+    window_seconds: 1
+    limit: 100
+    has_session: false
+  - path: "/api/v1/user/update_email"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/api/v1/user/permissions"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/api/v1/user/sessions"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/api/v1/user/rate_limits"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/api/v1/user/quotas"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/api/v1/admin/users"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/api/v1/user/audit_events"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/api/v1/admin/audit_events"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/api/v1/admin/blocks"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/api/v1/admin/blocks/unblock"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/api/v1/admin/allowlist"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/api/v1/admin/allowlist/remove"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/api/v1/admin/ip_filter"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/api/v1/admin/rate_limit/status"
+    window_seconds: 1
+    limit: 100
+    has_session: true
+  - path: "/.well-known/jwks.json"
+    window_seconds: 1
+    limit: 1000
+    has_session: false
+  - path: "/api/v1/admin/users/:user_id/*"
+    methods: ["POST"]
+    window_seconds: 1
+    limit: 100
+    key: ["user"]
+
+account_protection:
+  delay_step_ms: 1
+  max_delay_ms: 10
+  lock_after: 5
+
+challenge:
+  after: 2
+
+webauthn:
+  rp_id: "localhost"
+  rp_display_name: "Doing Now"
+  rp_origins:
+    - "http://localhost:8000"
+  timeout: 300
+
+oidc:
+  providers:
+    - name: "fake"
+      issuer: "` + testIdP.Issuer() + `"
+      client_id: "doing-now"
+      client_secret: "doing-now-secret"
+      redirect_url: "http://localhost:8000/oidc/callback"
+      auto_provision: true
+
+mailer:
+  driver: "memory"
+  from: "no-reply@example.com"
+
+password_reset:
+  token_ttl: 600
+  url: "http://localhost:8000/reset_password"
+
+authz:
+  token_roles: true
+
+email_verification:
+  secret: "email-verification-secret"
+  token_ttl: 600
+  url: "http://localhost:8000/verify_email"
 `
 	conf := []byte(confStr)
 	if err := os.WriteFile(confPath, conf, 0600); err != nil {
@@ -120,6 +316,8 @@ rate_limit:
 	baseConfContent = confStr
 	config.Init(baseConfPath)
 	redisdb.Init()
+	// the stub tells clients its solution, the tests solve challenges with it
+	mockey.Mock(challenge.NewProvider).Return(challenge.NewStub("solved", time.Minute), nil).Build()
 
 	testEngine = be.NewEngine()
 	os.Exit(t.Run())
@@ -160,7 +358,9 @@ func newSQLiteDB(t *testing.T) *gorm.DB {
 	sqlDB.SetMaxOpenConns(1)
 	sqlDB.SetMaxIdleConns(1)
 
-	err = db.AutoMigrate(&storage.UserRecord{}, &storage.UserCredentialRecord{})
+	err = db.AutoMigrate(&storage.UserRecord{}, &storage.UserCredentialRecord{}, &storage.UserTOTPRecord{}, &storage.UserRecoveryCodeRecord{}, &storage.UserWebAuthnCredentialRecord{}, &storage.UserIdentityRecord{}, &storage.UserPasswordResetRecord{}, &storage.AuditEventRecord{},
+		&storage.RoleRecord{}, &storage.RolePermissionRecord{}, &storage.UserRoleRecord{},
+		&storage.PlanRecord{}, &storage.PlanQuotaRecord{}, &storage.UserPlanRecord{}, &storage.QuotaUsageRecord{})
 	assert.Nil(t, err)
 	return db
 }
@@ -235,6 +435,15 @@ func mergeCookieHeader(existing string, add string) string {
 	return existing + "; " + add
 }
 
+func refreshTokenFromCookie(cookieHeader string) string {
+	for _, part := range strings.Split(cookieHeader, ";") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(part), "refresh_token="); ok {
+			return v
+		}
+	}
+	return ""
+}
+
 func setCookie(existing, name, value string) string {
 	cookies := map[string]string{}
 	for _, part := range strings.Split(existing, ";") {
@@ -272,11 +481,23 @@ func parseAccessToken(t *testing.T, rr *ut.ResponseRecorder) string {
 func parseAccessClaims(t *testing.T, accessToken string) *jwtmw.Claims {
 	t.Helper()
 
-	conf := config.GetJWTConfig()
+	// verify offline with the published keys, the way other services do
+	rr := perform(testEngine, http.MethodGet, "/.well-known/jwks.json", "")
+	assert.DeepEqual(t, http.StatusOK, rr.Code)
+	var jwks jwtmw.JWKSet
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &jwks))
+
 	claims := &jwtmw.Claims{}
 	_, err := jwtlib.ParseWithClaims(accessToken, claims, func(token *jwtlib.Token) (any, error) {
-		return []byte(conf.AccessTokenSecret), nil
-	})
+		for _, k := range jwks.Keys {
+			if k.Kid == token.Header["kid"] {
+				x, _ := base64.RawURLEncoding.DecodeString(k.X)
+				y, _ := base64.RawURLEncoding.DecodeString(k.Y)
+				return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+			}
+		}
+		return nil, fmt.Errorf("kid %v not found", token.Header["kid"])
+	}, jwtlib.WithValidMethods([]string{"ES256"}))
 	assert.Nil(t, err)
 	return claims
 }
@@ -298,7 +519,9 @@ func loginAndGetAuth(t *testing.T, h *server.Hertz, ip, account, name, password
 	if sessCookieName == "" {
 		sessCookieName = "auth_session_id"
 	}
-	sessID := cookieMap[sessCookieName]
+	// the cookie carries the signed session ID when session keys are configured
+	sessCookie := cookieMap[sessCookieName]
+	sessID := sessCookie
 
 	if sessID == "" || !claims.CheckSum(sessID) {
 		storePrefix := config.GetSessionConf().StorePrefix
@@ -320,7 +543,10 @@ func loginAndGetAuth(t *testing.T, h *server.Hertz, ip, account, name, password
 	assert.True(t, sessID != "")
 	assert.True(t, claims.CheckSum(sessID))
 
-	cookieHeader := sessCookieName + "=" + sessID
+	if sessCookie == "" {
+		sessCookie = sessID
+	}
+	cookieHeader := sessCookieName + "=" + sessCookie
 	if rt, ok := cookieMap["refresh_token"]; ok && rt != "" {
 		cookieHeader = cookieHeader + "; refresh_token=" + rt
 	}
@@ -339,7 +565,7 @@ func deleteAccessTokenExistKey(t *testing.T, accessToken string) {
 
 func mustCreateUserViaService(t *testing.T, account, name, password string) *domain.User {
 	t.Helper()
-	u, bizErr := usersvc.NewDefault().Register(context.Background(), account, name, password)
+	u, bizErr := usersvc.NewDefault().Register(context.Background(), account, name, password, "")
 	assert.Nil(t, bizErr)
 	assert.True(t, u != nil)
 	return u
@@ -466,9 +692,12 @@ func TestUserLogin(t *testing.T) {
 			mustCreateUserViaService(t, account, name, password)
 
 			for i := 0; i < 3; i++ {
+				// the third attempt has to solve a challenge first
 				rr := perform(h, http.MethodPost, "/api/v1/user/login",
 					`{"account":"`+account+`","password":"badpassword"}`,
 					ut.Header{Key: "X-Forwarded-For", Value: ip},
+					ut.Header{Key: "X-Challenge-Id", Value: "any"},
+					ut.Header{Key: "X-Challenge-Solution", Value: "solved"},
 				)
 				assert.DeepEqual(t, http.StatusOK, rr.Code)
 				resp := decodeCommonResp(t, rr.Body.Bytes())
@@ -486,9 +715,72 @@ func TestUserLogin(t *testing.T) {
 			assert.DeepEqual(t, int(errs.RequestBlocked.Code()), resp.Code)
 		})
 
-		t.Run("LoginProtection前置逻辑: 小时block key存在返回403", func(t *testing.T) {
+		t.Run("Challenge: 连续失败后登录与注册需先完成challenge", func(t *testing.T) {
+			redisdb.GetRedisClient().FlushAll(context.Background())
+			account := "account_chal10"
+			name := "name_chal10"
+			password := "password10"
+			mustCreateUserViaService(t, account, name, password)
+			loginBody := `{"account":"` + account + `","password":"` + password + `"}`
+
+			for i := 0; i < 2; i++ {
+				rr := perform(h, http.MethodPost, "/api/v1/user/login",
+					`{"account":"`+account+`","password":"badpassword"}`,
+					ut.Header{Key: "X-Forwarded-For", Value: ip},
+				)
+				assert.DeepEqual(t, int(errs.PasswordIncorrect.Code()), decodeCommonResp(t, rr.Body.Bytes()).Code)
+			}
+
+			decodeChallenge := func(body []byte) (int, dto.ChallengeData) {
+				var resp struct {
+					Code int               `json:"code"`
+					Data dto.ChallengeData `json:"data"`
+				}
+				assert.Nil(t, json.Unmarshal(body, &resp))
+				return resp.Code, resp.Data
+			}
+
+			rr := perform(h, http.MethodPost, "/api/v1/user/register",
+				`{"account":"account_chal11","name":"name_chal11","password":"password11"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+			)
+			assert.DeepEqual(t, http.StatusForbidden, rr.Code)
+			code, _ := decodeChallenge(rr.Body.Bytes())
+			assert.DeepEqual(t, int(errs.ChallengeNeeded.Code()), code)
+
+			rr = perform(h, http.MethodPost, "/api/v1/user/login", loginBody, ut.Header{Key: "X-Forwarded-For", Value: ip})
+			assert.DeepEqual(t, http.StatusForbidden, rr.Code)
+			code, data := decodeChallenge(rr.Body.Bytes())
+			assert.DeepEqual(t, int(errs.ChallengeNeeded.Code()), code)
+			assert.DeepEqual(t, "stub", data.Provider)
+			assert.True(t, data.ExpiresAt > time.Now().Unix())
+
+			rr = perform(h, http.MethodPost, "/api/v1/user/login", loginBody,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "X-Challenge-Id", Value: data.ID},
+				ut.Header{Key: "X-Challenge-Solution", Value: "wrong"},
+			)
+			assert.DeepEqual(t, http.StatusForbidden, rr.Code)
+			code, data = decodeChallenge(rr.Body.Bytes())
+			assert.DeepEqual(t, int(errs.ChallengeFailed.Code()), code)
+
+			rr = perform(h, http.MethodPost, "/api/v1/user/login", loginBody,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "X-Challenge-Id", Value: data.ID},
+				ut.Header{Key: "X-Challenge-Solution", Value: data.Params["solution"].(string)},
+			)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			assert.True(t, decodeCommonResp(t, rr.Body.Bytes()).Success)
+
+			// a successful login forgets the failures of the IP
+			rr = perform(h, http.MethodPost, "/api/v1/user/login", loginBody, ut.Header{Key: "X-Forwarded-For", Value: ip})
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			assert.True(t, decodeCommonResp(t, rr.Body.Bytes()).Success)
+		})
+
+		t.Run("LoginProtection前置逻辑: 第二级block key存在返回403", func(t *testing.T) {
 			redisdb.GetRedisClient().FlushAll(context.Background())
-			err := redisdb.GetRedisClient().Set(context.Background(), "rate_limit:login_block_h:"+ip, "1", 0).Err()
+			err := redisdb.GetRedisClient().Set(context.Background(), "rate_limit:login_block:"+ip, "2", 0).Err()
 			assert.Nil(t, err)
 
 			rr := perform(h, http.MethodPost, "/api/v1/user/login", `{}`, ut.Header{Key: "X-Forwarded-For", Value: ip})
@@ -498,16 +790,23 @@ func TestUserLogin(t *testing.T) {
 			assert.DeepEqual(t, int(errs.RequestBlocked.Code()), resp.Code)
 		})
 
-		t.Run("LoginProtection前置逻辑: 分钟block key存在返回403", func(t *testing.T) {
+		t.Run("LoginProtection前置逻辑: 第一级block返回Retry-After与解封时间", func(t *testing.T) {
 			redisdb.GetRedisClient().FlushAll(context.Background())
-			err := redisdb.GetRedisClient().Set(context.Background(), "rate_limit:login_block_m:"+ip, "1", 0).Err()
+			err := redisdb.GetRedisClient().Set(context.Background(), "rate_limit:login_block:"+ip, "1", 5*time.Minute).Err()
 			assert.Nil(t, err)
 
 			rr := perform(h, http.MethodPost, "/api/v1/user/login", `{}`, ut.Header{Key: "X-Forwarded-For", Value: ip})
 			assert.DeepEqual(t, http.StatusForbidden, rr.Code)
-			resp := decodeCommonResp(t, rr.Body.Bytes())
-			assert.False(t, resp.Success)
+			assert.DeepEqual(t, "300", rr.Header().Get("Retry-After"))
+			var resp struct {
+				Code int             `json:"code"`
+				Data dto.BlockedData `json:"data"`
+			}
+			assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &resp))
 			assert.DeepEqual(t, int(errs.RequestBlocked.Code()), resp.Code)
+			assert.DeepEqual(t, 1, resp.Data.Tier)
+			assert.DeepEqual(t, int64(300), resp.Data.RetryAfter)
+			assert.True(t, resp.Data.BlockedUntil >= time.Now().Add(299*time.Second).Unix())
 		})
 
 		t.Run("LoginProtection后置逻辑: 非账户类失败不计入login_fail", func(t *testing.T) {
@@ -526,21 +825,21 @@ func TestUserLogin(t *testing.T) {
 			assert.False(t, resp.Success)
 			assert.DeepEqual(t, int(errs.ServerError.Code()), resp.Code)
 
-			exists, err := redisdb.GetRedisClient().Exists(context.Background(), "rate_limit:login_fail:"+ip).Result()
+			exists, err := redisdb.GetRedisClient().Exists(context.Background(), "rate_limit:login_fail:1:"+ip).Result()
 			assert.Nil(t, err)
 			assert.DeepEqual(t, int64(0), exists)
 		})
 
-		t.Run("LoginProtection后置逻辑: Level2触发小时block key", func(t *testing.T) {
+		t.Run("LoginProtection后置逻辑: 已到第一级时触发第二级block", func(t *testing.T) {
 			redisdb.GetRedisClient().FlushAll(context.Background())
 			account := "account_lvl2_10"
 			name := "name_lvl2_10"
 			password := "password10"
 			mustCreateUserViaService(t, account, name, password)
 
-			err := redisdb.GetRedisClient().Set(context.Background(), "rate_limit:login_fail:"+ip, "2", 0).Err()
+			err := redisdb.GetRedisClient().Set(context.Background(), "rate_limit:login_fail:2:"+ip, "2", 0).Err()
 			assert.Nil(t, err)
-			err = redisdb.GetRedisClient().Set(context.Background(), "login_fail_level:"+ip, "1", 0).Err()
+			err = redisdb.GetRedisClient().Set(context.Background(), "login_fail_level:1:"+ip, "1", 0).Err()
 			assert.Nil(t, err)
 
 			rr := perform(h, http.MethodPost, "/api/v1/user/login",
@@ -552,9 +851,9 @@ func TestUserLogin(t *testing.T) {
 			assert.False(t, resp.Success)
 			assert.DeepEqual(t, int(errs.PasswordIncorrect.Code()), resp.Code)
 
-			exists, err := redisdb.GetRedisClient().Exists(context.Background(), "rate_limit:login_block_h:"+ip).Result()
+			tier, err := redisdb.GetRedisClient().Get(context.Background(), "rate_limit:login_block:"+ip).Result()
 			assert.Nil(t, err)
-			assert.DeepEqual(t, int64(1), exists)
+			assert.DeepEqual(t, "2", tier)
 
 			rr2 := perform(h, http.MethodPost, "/api/v1/user/login",
 				`{"account":"`+account+`","password":"badpassword"}`,
@@ -665,6 +964,60 @@ func TestRefreshToken(t *testing.T) {
 			cookies := cookiesFromRecorder(t, rr)
 			assert.True(t, cookies["refresh_token"] != "")
 		})
+
+		t.Run("正常: 同一refresh_token并发刷新得到同一组token", func(t *testing.T) {
+			redisdb.GetRedisClient().FlushAll(context.Background())
+			_, cookieHeader := loginAndGetAuth(t, h, ip, account, name, password)
+			headers := []ut.Header{
+				{Key: "X-Forwarded-For", Value: ip},
+				{Key: "Cookie", Value: cookieHeader},
+			}
+
+			first := decodeCommonResp(t, perform(h, http.MethodPost, "/api/v1/user/refresh_token", `{}`, headers...).Body.Bytes())
+			second := decodeCommonResp(t, perform(h, http.MethodPost, "/api/v1/user/refresh_token", `{}`, headers...).Body.Bytes())
+			assert.True(t, first.Success)
+			assert.True(t, second.Success)
+			assert.DeepEqual(t, first.Data, second.Data)
+		})
+
+		t.Run("安全: 已轮换的refresh_token在宽限期后重放，整个token家族与session被注销", func(t *testing.T) {
+			redisdb.GetRedisClient().FlushAll(context.Background())
+			accessToken, cookieHeader := loginAndGetAuth(t, h, ip, account, name, password)
+			headers := []ut.Header{
+				{Key: "X-Forwarded-For", Value: ip},
+				{Key: "Cookie", Value: cookieHeader},
+			}
+
+			rr := perform(h, http.MethodPost, "/api/v1/user/refresh_token", `{}`, headers...)
+			assert.True(t, decodeCommonResp(t, rr.Body.Bytes()).Success)
+			rotatedCookie := setCookie(cookieHeader, "refresh_token", cookiesFromRecorder(t, rr)["refresh_token"])
+
+			// the grace window of the old token is over
+			oldClaims := &jwtmw.Claims{}
+			_, _, err := jwtlib.NewParser().ParseUnverified(refreshTokenFromCookie(cookieHeader), oldClaims)
+			assert.Nil(t, err)
+			assert.Nil(t, redisdb.GetRedisClient().Del(context.Background(), "refresh_token:"+oldClaims.ID, "refresh_token_result:"+oldClaims.ID).Err())
+
+			rr = perform(h, http.MethodPost, "/api/v1/user/refresh_token", `{}`, headers...)
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.False(t, resp.Success)
+			assert.DeepEqual(t, int(errs.Unauthorized.Code()), resp.Code)
+
+			rr = perform(h, http.MethodPost, "/api/v1/user/refresh_token", `{}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Cookie", Value: rotatedCookie},
+			)
+			resp = decodeCommonResp(t, rr.Body.Bytes())
+			assert.False(t, resp.Success)
+			assert.DeepEqual(t, int(errs.Unauthorized.Code()), resp.Code)
+
+			rr = perform(h, http.MethodGet, "/api/v1/user/info", "",
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Authorization", Value: accessToken},
+				ut.Header{Key: "Cookie", Value: rotatedCookie},
+			)
+			assert.DeepEqual(t, http.StatusUnauthorized, rr.Code)
+		})
 	})
 }
 
@@ -799,3 +1152,1211 @@ func TestAuthorizedEndpoints(t *testing.T) {
 		})
 	})
 }
+
+func TestSessionManagement(t *testing.T) {
+	mockey.PatchConvey("登录设备管理", t, func() {
+		h := newTestServer(t)
+		db := newSQLiteDB(t)
+		patchSQLiteMySQLConn(t, db)
+
+		ip := "127.0.0.1"
+		account := "account35"
+		name := "name0035"
+		password := "password35"
+		mustCreateUserViaService(t, account, name, password)
+
+		accessToken, cookieHeader := loginAndGetAuth(t, h, ip, account, name, password)
+		otherToken, otherCookie := loginAndGetAuth(t, h, "127.0.0.2", account, name, password)
+
+		authHeaders := func(token, cookies string) []ut.Header {
+			return []ut.Header{
+				{Key: "X-Forwarded-For", Value: ip},
+				{Key: "Authorization", Value: token},
+				{Key: "Cookie", Value: cookies},
+			}
+		}
+		listSessions := func(t *testing.T) []any {
+			rr := perform(h, http.MethodGet, "/api/v1/user/sessions", "", authHeaders(accessToken, cookieHeader)...)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.True(t, resp.Success)
+			data, ok := resp.Data.(map[string]any)
+			assert.True(t, ok)
+			list, _ := data["sessions"].([]any)
+			return list
+		}
+
+		var otherID string
+		t.Run("正常: 列出两个会话并标记当前会话", func(t *testing.T) {
+			list := listSessions(t)
+			assert.DeepEqual(t, 2, len(list))
+			for _, item := range list {
+				s := item.(map[string]any)
+				assert.True(t, s["id"] != "")
+				if s["current"] == true {
+					assert.DeepEqual(t, ip, s["ip"])
+				} else {
+					assert.DeepEqual(t, "127.0.0.2", s["ip"])
+					otherID = s["id"].(string)
+				}
+			}
+			assert.True(t, otherID != "")
+		})
+
+		t.Run("handler拦截: 注销不存在的会话返回业务错误", func(t *testing.T) {
+			rr := perform(h, http.MethodDelete, "/api/v1/user/sessions/unknown", "", authHeaders(accessToken, cookieHeader)...)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.False(t, resp.Success)
+			assert.DeepEqual(t, int(errs.SessionNotFound.Code()), resp.Code)
+		})
+
+		t.Run("正常: 注销其他会话后其access与refresh token失效，当前会话不受影响", func(t *testing.T) {
+			rr := perform(h, http.MethodDelete, "/api/v1/user/sessions/"+otherID, "", authHeaders(accessToken, cookieHeader)...)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.True(t, resp.Success)
+
+			rr = perform(h, http.MethodGet, "/api/v1/user/info", "", authHeaders(otherToken, otherCookie)...)
+			assert.DeepEqual(t, http.StatusUnauthorized, rr.Code)
+
+			rr = perform(h, http.MethodPost, "/api/v1/user/refresh_token", `{}`,
+				ut.Header{Key: "X-Forwarded-For", Value: "127.0.0.2"},
+				ut.Header{Key: "Cookie", Value: otherCookie},
+			)
+			resp = decodeCommonResp(t, rr.Body.Bytes())
+			assert.False(t, resp.Success)
+			assert.DeepEqual(t, int(errs.Unauthorized.Code()), resp.Code)
+
+			rr = perform(h, http.MethodGet, "/api/v1/user/info", "", authHeaders(accessToken, cookieHeader)...)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			assert.DeepEqual(t, 1, len(listSessions(t)))
+		})
+	})
+}
+
+func TestPasswordReset(t *testing.T) {
+	mockey.PatchConvey("忘记密码与重置密码", t, func() {
+		h := newTestServer(t)
+		db := newSQLiteDB(t)
+		patchSQLiteMySQLConn(t, db)
+
+		ip := "127.0.0.1"
+		account := "account36"
+		email := "alice@example.com"
+		name := "name0036"
+		password := "password36"
+		u := mustCreateUserViaService(t, account, name, password)
+		err := db.Model(&storage.UserRecord{}).Where("user_id = ?", u.UserID).
+			Updates(map[string]any{"email": email, "email_verified_at": time.Now()}).Error
+		assert.Nil(t, err)
+
+		accessToken, cookieHeader := loginAndGetAuth(t, h, ip, account, name, password)
+
+		m, err := mailer.Default()
+		assert.Nil(t, err)
+		mem := m.(*mailer.MemoryMailer)
+
+		forgot := func(t *testing.T, account string) dto.CommonResp {
+			rr := perform(h, http.MethodPost, "/api/v1/user/password/forgot", `{"account":"`+account+`"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip})
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			reset.Wait()
+			return decodeCommonResp(t, rr.Body.Bytes())
+		}
+		resetPassword := func(t *testing.T, token, newPassword string) dto.CommonResp {
+			rr := perform(h, http.MethodPost, "/api/v1/user/password/reset", `{"token":"`+token+`","new_password":"`+newPassword+`"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip})
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			return decodeCommonResp(t, rr.Body.Bytes())
+		}
+
+		t.Run("正常: 未知账号同样返回成功且不发送邮件", func(t *testing.T) {
+			sent := len(mem.Messages())
+			resp := forgot(t, "nobody@example.com")
+			assert.True(t, resp.Success)
+			assert.DeepEqual(t, sent, len(mem.Messages()))
+		})
+
+		var token string
+		t.Run("正常: 发送重置邮件", func(t *testing.T) {
+			resp := forgot(t, account)
+			assert.True(t, resp.Success)
+
+			msg, ok := mem.Last(email)
+			assert.True(t, ok)
+			idx := strings.Index(msg.Body, "http://localhost:8000/reset_password?")
+			assert.True(t, idx >= 0)
+			link, err := url.Parse(strings.Fields(msg.Body[idx:])[0])
+			assert.Nil(t, err)
+			token = link.Query().Get("token")
+			assert.True(t, token != "")
+		})
+
+		t.Run("handler拦截: 无效令牌返回业务错误", func(t *testing.T) {
+			resp := resetPassword(t, "unknown", "password37")
+			assert.False(t, resp.Success)
+			assert.DeepEqual(t, int(errs.PasswordResetInvalid.Code()), resp.Code)
+		})
+
+		t.Run("正常: 重置后旧会话失效，新密码可登录", func(t *testing.T) {
+			resp := resetPassword(t, token, "password37")
+			assert.True(t, resp.Success)
+
+			rr := perform(h, http.MethodGet, "/api/v1/user/info", "",
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Authorization", Value: accessToken},
+				ut.Header{Key: "Cookie", Value: cookieHeader},
+			)
+			assert.DeepEqual(t, http.StatusForbidden, rr.Code)
+
+			rr = perform(h, http.MethodPost, "/api/v1/user/login", `{"account":"`+account+`","password":"`+password+`"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: "127.0.0.2"})
+			assert.False(t, decodeCommonResp(t, rr.Body.Bytes()).Success)
+
+			loginAndGetAuth(t, h, "127.0.0.3", account, name, "password37")
+		})
+
+		t.Run("handler拦截: 令牌只能使用一次", func(t *testing.T) {
+			resp := resetPassword(t, token, "password38")
+			assert.False(t, resp.Success)
+			assert.DeepEqual(t, int(errs.PasswordResetInvalid.Code()), resp.Code)
+		})
+	})
+}
+
+func TestEmailVerification(t *testing.T) {
+	mockey.PatchConvey("邮箱验证与邮箱登录", t, func() {
+		h := newTestServer(t)
+		db := newSQLiteDB(t)
+		patchSQLiteMySQLConn(t, db)
+
+		m, err := mailer.Default()
+		assert.Nil(t, err)
+		mem := m.(*mailer.MemoryMailer)
+
+		account := "account37"
+		email := "bob@example.com"
+		name := "name0037"
+		password := "password37"
+
+		post := func(t *testing.T, ip, path, body string, headers ...ut.Header) dto.CommonResp {
+			headers = append(headers, ut.Header{Key: "X-Forwarded-For", Value: ip})
+			rr := perform(h, http.MethodPost, path, body, headers...)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			return decodeCommonResp(t, rr.Body.Bytes())
+		}
+		mailedToken := func(t *testing.T, to string) string {
+			msg, ok := mem.Last(to)
+			assert.True(t, ok)
+			idx := strings.Index(msg.Body, "http://localhost:8000/verify_email?")
+			assert.True(t, idx >= 0)
+			link, err := url.Parse(strings.Fields(msg.Body[idx:])[0])
+			assert.Nil(t, err)
+			return link.Query().Get("token")
+		}
+		userInfo := func(t *testing.T, accessToken, cookieHeader string) map[string]any {
+			rr := perform(h, http.MethodGet, "/api/v1/user/info", "",
+				ut.Header{Key: "Authorization", Value: accessToken},
+				ut.Header{Key: "Cookie", Value: cookieHeader},
+			)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			data, _ := decodeCommonResp(t, rr.Body.Bytes()).Data.(map[string]any)
+			return data
+		}
+
+		var token string
+		t.Run("正常: 注册时填写邮箱发送验证链接", func(t *testing.T) {
+			resp := post(t, "127.0.1.1", "/api/v1/user/register",
+				`{"account":"`+account+`","name":"`+name+`","password":"`+password+`","email":"Bob@Example.com"}`)
+			assert.True(t, resp.Success)
+			token = mailedToken(t, email)
+			assert.True(t, token != "")
+		})
+
+		t.Run("handler拦截: 邮箱已被使用", func(t *testing.T) {
+			resp := post(t, "127.0.1.2", "/api/v1/user/register",
+				`{"account":"account38","name":"name0038","password":"password38","email":"`+email+`"}`)
+			assert.False(t, resp.Success)
+			assert.DeepEqual(t, int(errs.EmailDuplicated.Code()), resp.Code)
+		})
+
+		t.Run("handler拦截: 要求验证邮箱时未验证不能登录", func(t *testing.T) {
+			conf := config.GetEmailVerificationConf()
+			conf.Required = true
+			mocker := mockey.Mock(config.GetEmailVerificationConf).Return(conf).Build()
+			defer mocker.UnPatch()
+
+			resp := post(t, "127.0.1.3", "/api/v1/user/login", `{"account":"`+account+`","password":"`+password+`"}`)
+			assert.False(t, resp.Success)
+			assert.DeepEqual(t, int(errs.EmailNotVerified.Code()), resp.Code)
+
+			// an unverified email is no login identifier at all
+			resp = post(t, "127.0.1.3", "/api/v1/user/login", `{"account":"`+email+`","password":"`+password+`"}`)
+			assert.False(t, resp.Success)
+			assert.DeepEqual(t, int(errs.UserNotExist.Code()), resp.Code)
+		})
+
+		t.Run("handler拦截: 篡改的验证链接无效", func(t *testing.T) {
+			resp := post(t, "127.0.1.4", "/api/v1/user/verify_email", `{"token":"`+token+`x"}`)
+			assert.False(t, resp.Success)
+			assert.DeepEqual(t, int(errs.EmailVerifyInvalid.Code()), resp.Code)
+		})
+
+		var accessToken, cookieHeader string
+		t.Run("正常: 验证邮箱后可用邮箱登录", func(t *testing.T) {
+			resp := post(t, "127.0.1.4", "/api/v1/user/verify_email", `{"token":"`+token+`"}`)
+			assert.True(t, resp.Success)
+
+			conf := config.GetEmailVerificationConf()
+			conf.Required = true
+			mocker := mockey.Mock(config.GetEmailVerificationConf).Return(conf).Build()
+			defer mocker.UnPatch()
+
+			accessToken, cookieHeader = loginAndGetAuth(t, h, "127.0.1.5", "BOB@example.com", name, password)
+			info := userInfo(t, accessToken, cookieHeader)
+			assert.DeepEqual(t, account, info["account"])
+			assert.DeepEqual(t, email, info["email"])
+			assert.DeepEqual(t, true, info["email_verified"])
+		})
+
+		t.Run("正常: 更换邮箱后需重新验证，旧链接失效", func(t *testing.T) {
+			resp := post(t, "127.0.1.5", "/api/v1/user/update_email", `{"email":"carol@example.com"}`,
+				ut.Header{Key: "Authorization", Value: accessToken},
+				ut.Header{Key: "Cookie", Value: cookieHeader},
+			)
+			assert.True(t, resp.Success)
+			info := userInfo(t, accessToken, cookieHeader)
+			assert.DeepEqual(t, "carol@example.com", info["email"])
+			assert.DeepEqual(t, false, info["email_verified"])
+
+			resp = post(t, "127.0.1.4", "/api/v1/user/verify_email", `{"token":"`+token+`"}`)
+			assert.False(t, resp.Success)
+			assert.DeepEqual(t, int(errs.EmailVerifyInvalid.Code()), resp.Code)
+
+			resp = post(t, "127.0.1.4", "/api/v1/user/verify_email", `{"token":"`+mailedToken(t, "carol@example.com")+`"}`)
+			assert.True(t, resp.Success)
+			assert.DeepEqual(t, true, userInfo(t, accessToken, cookieHeader)["email_verified"])
+		})
+	})
+}
+
+func TestUserStatus(t *testing.T) {
+	mockey.PatchConvey("用户状态", t, func() {
+		h := newTestServer(t)
+		db := newSQLiteDB(t)
+		patchSQLiteMySQLConn(t, db)
+
+		ip := "127.0.0.1"
+		account := "account39"
+		name := "name0039"
+		password := "password39"
+		u := mustCreateUserViaService(t, account, name, password)
+
+		accessToken, cookieHeader := loginAndGetAuth(t, h, ip, account, name, password)
+		svc := usersvc.NewDefault()
+
+		t.Run("handler拦截: 禁用后已登录会话的access与refresh token均失效", func(t *testing.T) {
+			assert.Nil(t, svc.ChangeStatus(context.Background(), u.UserID, domain.UserStatusDisabled, "test"))
+
+			rr := perform(h, http.MethodGet, "/api/v1/user/info", "",
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Authorization", Value: accessToken},
+				ut.Header{Key: "Cookie", Value: cookieHeader},
+			)
+			assert.DeepEqual(t, http.StatusForbidden, rr.Code)
+			assert.DeepEqual(t, int(errs.UserStatusInvalid.Code()), decodeCommonResp(t, rr.Body.Bytes()).Code)
+
+			rr = perform(h, http.MethodPost, "/api/v1/user/refresh_token", `{}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Cookie", Value: cookieHeader},
+			)
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.False(t, resp.Success)
+			assert.DeepEqual(t, int(errs.UserStatusInvalid.Code()), resp.Code)
+		})
+
+		t.Run("handler拦截: 禁用的用户不能登录", func(t *testing.T) {
+			rr := perform(h, http.MethodPost, "/api/v1/user/login", `{"account":"`+account+`","password":"`+password+`"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: "127.0.0.2"})
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.False(t, resp.Success)
+			assert.DeepEqual(t, int(errs.UserStatusInvalid.Code()), resp.Code)
+		})
+
+		t.Run("正常: 重新启用后旧会话仍失效，可重新登录", func(t *testing.T) {
+			assert.Nil(t, svc.ChangeStatus(context.Background(), u.UserID, domain.UserStatusActive, "test"))
+
+			rr := perform(h, http.MethodPost, "/api/v1/user/refresh_token", `{}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Cookie", Value: cookieHeader},
+			)
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.False(t, resp.Success)
+
+			accessToken, cookieHeader = loginAndGetAuth(t, h, "127.0.0.3", account, name, password)
+			rr = perform(h, http.MethodGet, "/api/v1/user/info", "",
+				ut.Header{Key: "X-Forwarded-For", Value: "127.0.0.3"},
+				ut.Header{Key: "Authorization", Value: accessToken},
+				ut.Header{Key: "Cookie", Value: cookieHeader},
+			)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+		})
+	})
+}
+
+func TestPermissions(t *testing.T) {
+	mockey.PatchConvey("角色与权限", t, func() {
+		h := newTestServer(t)
+		db := newSQLiteDB(t)
+		patchSQLiteMySQLConn(t, db)
+		assert.Nil(t, db.Create(&storage.RoleRecord{Name: domain.RoleAdmin}).Error)
+		assert.Nil(t, db.Create(&storage.RolePermissionRecord{Role: domain.RoleAdmin, Permission: domain.PermAll}).Error)
+
+		ip := "127.0.0.1"
+		account := "account40"
+		name := "name0040"
+		password := "password40"
+		u := mustCreateUserViaService(t, account, name, password)
+
+		getPermissions := func(t *testing.T, accessToken, cookieHeader string) map[string]any {
+			rr := perform(h, http.MethodGet, "/api/v1/user/permissions", "",
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Authorization", Value: accessToken},
+				ut.Header{Key: "Cookie", Value: cookieHeader},
+			)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.True(t, resp.Success)
+			data, _ := resp.Data.(map[string]any)
+			return data
+		}
+
+		t.Run("正常: 普通用户没有角色", func(t *testing.T) {
+			accessToken, cookieHeader := loginAndGetAuth(t, h, ip, account, name, password)
+			assert.DeepEqual(t, 0, len(parseAccessClaims(t, accessToken).Roles))
+
+			data := getPermissions(t, accessToken, cookieHeader)
+			assert.DeepEqual(t, []any{}, data["roles"])
+			assert.DeepEqual(t, []any{}, data["permissions"])
+		})
+
+		t.Run("正常: 分配角色后新token携带角色", func(t *testing.T) {
+			assert.Nil(t, rbacsvc.NewDefault().AssignRole(context.Background(), u.UserID, domain.RoleAdmin))
+
+			accessToken, cookieHeader := loginAndGetAuth(t, h, ip, account, name, password)
+			assert.DeepEqual(t, []string{domain.RoleAdmin}, parseAccessClaims(t, accessToken).Roles)
+
+			data := getPermissions(t, accessToken, cookieHeader)
+			assert.DeepEqual(t, []any{domain.RoleAdmin}, data["roles"])
+			assert.DeepEqual(t, []any{domain.PermAll}, data["permissions"])
+		})
+	})
+}
+
+func TestRateLimits(t *testing.T) {
+	mockey.PatchConvey("限流配额", t, func() {
+		h := newTestServer(t)
+		db := newSQLiteDB(t)
+		patchSQLiteMySQLConn(t, db)
+
+		ip := "127.0.0.1"
+		account := "account47"
+		name := "name0047"
+		password := "password47"
+		mustCreateUserViaService(t, account, name, password)
+
+		t.Run("正常: 返回限流响应头与当前配额", func(t *testing.T) {
+			accessToken, cookieHeader := loginAndGetAuth(t, h, ip, account, name, password)
+
+			rr := perform(h, http.MethodGet, "/api/v1/user/rate_limits", "",
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Authorization", Value: accessToken},
+				ut.Header{Key: "Cookie", Value: cookieHeader},
+			)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			assert.DeepEqual(t, "100", rr.Header().Get("RateLimit-Limit"))
+			assert.DeepEqual(t, "99", rr.Header().Get("RateLimit-Remaining"))
+			assert.DeepEqual(t, "1", rr.Header().Get("RateLimit-Reset"))
+
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.True(t, resp.Success)
+			data, _ := resp.Data.(map[string]any)
+			quotas, _ := data["quotas"].([]any)
+			var found map[string]any
+			for _, q := range quotas {
+				if quota, _ := q.(map[string]any); quota["path"] == "/api/v1/user/rate_limits" {
+					found = quota
+				}
+			}
+			assert.NotNil(t, found)
+			assert.DeepEqual(t, float64(100), found["limit"])
+			// reading the quotas does not count
+			assert.DeepEqual(t, float64(99), found["remaining"])
+			assert.DeepEqual(t, float64(0), found["retry_after"])
+		})
+
+		t.Run("异常: 未登录", func(t *testing.T) {
+			rr := perform(h, http.MethodGet, "/api/v1/user/rate_limits", "", ut.Header{Key: "X-Forwarded-For", Value: ip})
+			assert.DeepEqual(t, http.StatusUnauthorized, rr.Code)
+		})
+	})
+}
+
+func TestQuotas(t *testing.T) {
+	mockey.PatchConvey("套餐配额", t, func() {
+		h := newTestServer(t)
+		db := newSQLiteDB(t)
+		patchSQLiteMySQLConn(t, db)
+		assert.Nil(t, db.Create(&storage.RoleRecord{Name: domain.RoleAdmin}).Error)
+		assert.Nil(t, db.Create(&storage.RolePermissionRecord{Role: domain.RoleAdmin, Permission: domain.PermAll}).Error)
+		assert.Nil(t, db.Create(&storage.PlanRecord{Name: "tiny"}).Error)
+		assert.Nil(t, db.Create(&storage.PlanQuotaRecord{Plan: "tiny", Feature: quotasvc.FeatureAPI, Daily: 3, Monthly: 100}).Error)
+
+		ip := "127.0.0.1"
+		admin := mustCreateUserViaService(t, "account48", "name0048", "password48")
+		assert.Nil(t, rbacsvc.NewDefault().AssignRole(context.Background(), admin.UserID, domain.RoleAdmin))
+		adminToken, adminCookie := loginAndGetAuth(t, h, ip, "account48", "name0048", "password48")
+		u := mustCreateUserViaService(t, "account49", "name0049", "password49")
+		accessToken, cookieHeader := loginAndGetAuth(t, h, ip, "account49", "name0049", "password49")
+
+		call := func(method, url, body, token, cookie string) (*ut.ResponseRecorder, dto.CommonResp) {
+			rr := perform(h, method, url, body,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Authorization", Value: token},
+				ut.Header{Key: "Cookie", Value: cookie},
+			)
+			return rr, decodeCommonResp(t, rr.Body.Bytes())
+		}
+		planURL := "/api/v1/admin/users/" + u.UserID + "/plan"
+
+		t.Run("正常: 未指定套餐时不计量", func(t *testing.T) {
+			for range 5 {
+				rr, _ := call(http.MethodGet, "/api/v1/user/info", "", accessToken, cookieHeader)
+				assert.DeepEqual(t, http.StatusOK, rr.Code)
+			}
+			_, resp := call(http.MethodGet, "/api/v1/user/quotas", "", accessToken, cookieHeader)
+			data, _ := resp.Data.(map[string]any)
+			assert.DeepEqual(t, "", data["plan"])
+			assert.DeepEqual(t, []any{}, data["quotas"])
+		})
+
+		t.Run("异常: 套餐不存在", func(t *testing.T) {
+			_, resp := call(http.MethodPost, planURL, `{"plan":"gold"}`, adminToken, adminCookie)
+			assert.DeepEqual(t, int(errs.PlanNotFound.Code()), resp.Code)
+		})
+
+		t.Run("正常: 配额用尽后返回429", func(t *testing.T) {
+			_, resp := call(http.MethodPost, planURL, `{"plan":"tiny"}`, adminToken, adminCookie)
+			assert.True(t, resp.Success)
+
+			for range 3 {
+				rr, _ := call(http.MethodGet, "/api/v1/user/info", "", accessToken, cookieHeader)
+				assert.DeepEqual(t, http.StatusOK, rr.Code)
+			}
+			rr, resp := call(http.MethodGet, "/api/v1/user/info", "", accessToken, cookieHeader)
+			assert.DeepEqual(t, http.StatusTooManyRequests, rr.Code)
+			assert.DeepEqual(t, int(errs.QuotaExceeded.Code()), resp.Code)
+			assert.True(t, rr.Header().Get("Retry-After") != "")
+
+			// the quotas stay readable
+			rr, resp = call(http.MethodGet, "/api/v1/user/quotas", "", accessToken, cookieHeader)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			data, _ := resp.Data.(map[string]any)
+			assert.DeepEqual(t, "tiny", data["plan"])
+			quotas, _ := data["quotas"].([]any)
+			assert.DeepEqual(t, 1, len(quotas))
+			q, _ := quotas[0].(map[string]any)
+			assert.DeepEqual(t, float64(3), q["daily"])
+			assert.DeepEqual(t, float64(3), q["daily_used"])
+			assert.DeepEqual(t, float64(3), q["monthly_used"])
+
+			// the admin is on no plan
+			rr, _ = call(http.MethodGet, "/api/v1/user/info", "", adminToken, adminCookie)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+		})
+
+		t.Run("正常: 用量汇总写入数据库", func(t *testing.T) {
+			assert.Nil(t, quotasvc.Default().Flush(context.Background()))
+			var usages []storage.QuotaUsageRecord
+			assert.Nil(t, db.Where("user_id = ?", u.UserID).Order("period").Find(&usages).Error)
+			assert.DeepEqual(t, 2, len(usages))
+			for _, usage := range usages {
+				assert.DeepEqual(t, int64(3), usage.Used)
+			}
+		})
+	})
+}
+
+func TestAdminUsers(t *testing.T) {
+	mockey.PatchConvey("管理员用户管理", t, func() {
+		h := newTestServer(t)
+		db := newSQLiteDB(t)
+		patchSQLiteMySQLConn(t, db)
+		assert.Nil(t, db.Create(&storage.RoleRecord{Name: domain.RoleAdmin}).Error)
+		assert.Nil(t, db.Create(&storage.RolePermissionRecord{Role: domain.RoleAdmin, Permission: domain.PermAll}).Error)
+
+		ip := "127.0.0.1"
+		admin := mustCreateUserViaService(t, "account41", "name0041", "password41")
+		assert.Nil(t, rbacsvc.NewDefault().AssignRole(context.Background(), admin.UserID, domain.RoleAdmin))
+		adminToken, adminCookie := loginAndGetAuth(t, h, ip, "account41", "name0041", "password41")
+
+		target := mustCreateUserViaService(t, "account42", "name0042", "password42")
+		targetToken, targetCookie := loginAndGetAuth(t, h, ip, "account42", "name0042", "password42")
+
+		call := func(method, url, token, cookie string) (*ut.ResponseRecorder, dto.CommonResp) {
+			body := ""
+			if method == http.MethodPost {
+				body = `{"reason":"test"}`
+			}
+			rr := perform(h, method, url, body,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Authorization", Value: token},
+				ut.Header{Key: "Cookie", Value: cookie},
+			)
+			return rr, decodeCommonResp(t, rr.Body.Bytes())
+		}
+		adminCall := func(method, url string) (*ut.ResponseRecorder, dto.CommonResp) {
+			return call(method, url, adminToken, adminCookie)
+		}
+		userURL := "/api/v1/admin/users/" + target.UserID
+
+		t.Run("权限不足: 普通用户不能访问管理接口", func(t *testing.T) {
+			rr, resp := call(http.MethodGet, "/api/v1/admin/users", targetToken, targetCookie)
+			assert.DeepEqual(t, http.StatusForbidden, rr.Code)
+			assert.DeepEqual(t, int(errs.Forbidden.Code()), resp.Code)
+		})
+
+		t.Run("正常: 按账号前缀分页查询与查看详情", func(t *testing.T) {
+			rr, resp := adminCall(http.MethodGet, "/api/v1/admin/users?account=account4&page_size=1")
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			data, _ := resp.Data.(map[string]any)
+			assert.DeepEqual(t, float64(2), data["total"])
+			users, _ := data["users"].([]any)
+			assert.DeepEqual(t, 1, len(users))
+
+			rr, resp = adminCall(http.MethodGet, "/api/v1/admin/users?page_size=101")
+			assert.DeepEqual(t, http.StatusBadRequest, rr.Code)
+
+			rr, resp = adminCall(http.MethodGet, userURL)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			data, _ = resp.Data.(map[string]any)
+			assert.DeepEqual(t, "account42", data["account"])
+			assert.DeepEqual(t, string(domain.UserStatusActive), data["status"])
+			assert.DeepEqual(t, []any{}, data["roles"])
+		})
+
+		t.Run("正常: 禁用后用户会话失效，启用后可重新登录", func(t *testing.T) {
+			rr, _ := adminCall(http.MethodPost, userURL+"/disable")
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+
+			rr, _ = call(http.MethodGet, "/api/v1/user/info", targetToken, targetCookie)
+			assert.DeepEqual(t, http.StatusUnauthorized, rr.Code)
+
+			_, resp := adminCall(http.MethodGet, userURL)
+			data, _ := resp.Data.(map[string]any)
+			assert.DeepEqual(t, string(domain.UserStatusDisabled), data["status"])
+			assert.DeepEqual(t, "test", data["status_reason"])
+
+			rr, _ = adminCall(http.MethodPost, userURL+"/enable")
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			targetToken, targetCookie = loginAndGetAuth(t, h, ip, "account42", "name0042", "password42")
+		})
+
+		t.Run("正常: 注销用户所有会话", func(t *testing.T) {
+			_, resp := adminCall(http.MethodPost, userURL+"/revoke_sessions")
+			assert.True(t, resp.Success)
+			data, _ := resp.Data.(map[string]any)
+			assert.DeepEqual(t, float64(1), data["revoked"])
+
+			rr, _ := call(http.MethodGet, "/api/v1/user/info", targetToken, targetCookie)
+			assert.DeepEqual(t, http.StatusUnauthorized, rr.Code)
+		})
+
+		t.Run("正常: 没有设备记录的会话同样被注销", func(t *testing.T) {
+			token, cookie := loginAndGetAuth(t, h, ip, "account42", "name0042", "password42")
+			assert.Nil(t, redisdb.GetRedisClient().Del(context.Background(), "user_devices:"+target.UserID).Err())
+
+			_, resp := adminCall(http.MethodPost, userURL+"/revoke_sessions")
+			assert.True(t, resp.Success)
+			data, _ := resp.Data.(map[string]any)
+			assert.DeepEqual(t, float64(0), data["revoked"])
+
+			// the bumped credential version rejects it
+			rr, resp := call(http.MethodGet, "/api/v1/user/info", token, cookie)
+			assert.DeepEqual(t, http.StatusForbidden, rr.Code)
+			assert.DeepEqual(t, int(errs.SessionExpired.Code()), resp.Code)
+		})
+
+		t.Run("正常: 强制重置密码后旧密码不可用", func(t *testing.T) {
+			_, resp := adminCall(http.MethodPost, userURL+"/reset_password")
+			assert.True(t, resp.Success)
+			data, _ := resp.Data.(map[string]any)
+			assert.DeepEqual(t, false, data["mailed"])
+
+			rr := perform(h, http.MethodPost, "/api/v1/user/login", `{"account":"account42","password":"password42"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: "127.0.0.2"})
+			assert.False(t, decodeCommonResp(t, rr.Body.Bytes()).Success)
+		})
+
+		t.Run("正常: 解锁因登录失败被锁定的账号", func(t *testing.T) {
+			for i := 0; i < 5; i++ {
+				rr := perform(h, http.MethodPost, "/api/v1/user/login", `{"account":"account42","password":"wrong0042"}`,
+					ut.Header{Key: "X-Forwarded-For", Value: fmt.Sprintf("10.0.42.%d", i)})
+				assert.False(t, decodeCommonResp(t, rr.Body.Bytes()).Success)
+			}
+			rr := perform(h, http.MethodPost, "/api/v1/user/login", `{"account":"account42","password":"wrong0042"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: "10.0.42.9"})
+			assert.DeepEqual(t, http.StatusForbidden, rr.Code)
+			assert.DeepEqual(t, int(errs.AccountLocked.Code()), decodeCommonResp(t, rr.Body.Bytes()).Code)
+
+			_, resp := adminCall(http.MethodPost, userURL+"/unlock")
+			assert.True(t, resp.Success)
+
+			rr = perform(h, http.MethodPost, "/api/v1/user/login", `{"account":"account42","password":"wrong0042"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: "10.0.42.9"})
+			assert.DeepEqual(t, int(errs.PasswordIncorrect.Code()), decodeCommonResp(t, rr.Body.Bytes()).Code)
+		})
+
+		t.Run("正常: 恢复已删除用户", func(t *testing.T) {
+			_, resp := adminCall(http.MethodPost, userURL+"/restore")
+			assert.DeepEqual(t, int(errs.UserNotExist.Code()), resp.Code)
+
+			assert.Nil(t, db.Where("user_id = ?", target.UserID).Delete(&storage.UserRecord{}).Error)
+			assert.Nil(t, db.Where("user_id = ?", target.UserID).Delete(&storage.UserCredentialRecord{}).Error)
+
+			_, resp = adminCall(http.MethodGet, "/api/v1/admin/users?account=account42&deleted=true")
+			data, _ := resp.Data.(map[string]any)
+			users, _ := data["users"].([]any)
+			assert.DeepEqual(t, 1, len(users))
+			deleted, _ := users[0].(map[string]any)
+			assert.True(t, deleted["deleted_at"] != nil)
+
+			_, resp = adminCall(http.MethodPost, userURL+"/restore")
+			assert.True(t, resp.Success)
+
+			var c storage.UserCredentialRecord
+			assert.Nil(t, db.First(&c, "user_id = ?", target.UserID).Error)
+		})
+	})
+}
+
+func TestAuditEvents(t *testing.T) {
+	mockey.PatchConvey("安全审计日志", t, func() {
+		h := newTestServer(t)
+		db := newSQLiteDB(t)
+		patchSQLiteMySQLConn(t, db)
+		assert.Nil(t, db.Create(&storage.RoleRecord{Name: "auditor"}).Error)
+		assert.Nil(t, db.Create(&storage.RolePermissionRecord{Role: "auditor", Permission: domain.PermAuditRead}).Error)
+
+		ip := "127.0.0.1"
+		u := mustCreateUserViaService(t, "account43", "name0043", "password43")
+		auditor := mustCreateUserViaService(t, "account44", "name0044", "password44")
+		assert.Nil(t, rbacsvc.NewDefault().AssignRole(context.Background(), auditor.UserID, "auditor"))
+
+		rr := perform(h, http.MethodPost, "/api/v1/user/login", `{"account":"account43","password":"wrong0043"}`,
+			ut.Header{Key: "X-Forwarded-For", Value: ip},
+			ut.Header{Key: "User-Agent", Value: "audit-test"},
+		)
+		assert.False(t, decodeCommonResp(t, rr.Body.Bytes()).Success)
+		accessToken, cookieHeader := loginAndGetAuth(t, h, ip, "account43", "name0043", "password43")
+		auditorToken, auditorCookie := loginAndGetAuth(t, h, ip, "account44", "name0044", "password44")
+
+		list := func(t *testing.T, url, token, cookie string) (int, map[string]any) {
+			assert.Nil(t, audit.Flush(context.Background()))
+			rr := perform(h, http.MethodGet, url, "",
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Authorization", Value: token},
+				ut.Header{Key: "Cookie", Value: cookie},
+			)
+			data, _ := decodeCommonResp(t, rr.Body.Bytes()).Data.(map[string]any)
+			return rr.Code, data
+		}
+
+		t.Run("正常: 用户查询自己的登录失败与成功记录", func(t *testing.T) {
+			code, data := list(t, "/api/v1/user/audit_events", accessToken, cookieHeader)
+			assert.DeepEqual(t, http.StatusOK, code)
+			assert.DeepEqual(t, float64(2), data["total"])
+			events, _ := data["events"].([]any)
+			success, _ := events[0].(map[string]any)
+			failure, _ := events[1].(map[string]any)
+			assert.DeepEqual(t, string(domain.AuditLoginSuccess), success["event_type"])
+			assert.DeepEqual(t, string(domain.AuditLoginFailure), failure["event_type"])
+			assert.DeepEqual(t, u.UserID, failure["target_id"])
+			assert.DeepEqual(t, ip, failure["ip"])
+			assert.DeepEqual(t, "audit-test", failure["user_agent"])
+			assert.True(t, failure["log_id"] != "")
+		})
+
+		t.Run("权限不足: 普通用户不能查询所有日志", func(t *testing.T) {
+			code, _ := list(t, "/api/v1/admin/audit_events", accessToken, cookieHeader)
+			assert.DeepEqual(t, http.StatusForbidden, code)
+		})
+
+		t.Run("正常: 审计员按类型查询所有用户的日志", func(t *testing.T) {
+			code, data := list(t, "/api/v1/admin/audit_events?event_type=login.success", auditorToken, auditorCookie)
+			assert.DeepEqual(t, http.StatusOK, code)
+			assert.DeepEqual(t, float64(2), data["total"])
+		})
+
+		t.Run("正常: 登出记录在自己的日志中", func(t *testing.T) {
+			rr := perform(h, http.MethodPost, "/api/v1/user/logout", `{}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Authorization", Value: accessToken},
+				ut.Header{Key: "Cookie", Value: cookieHeader},
+			)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+
+			code, data := list(t, "/api/v1/admin/audit_events?event_type=logout&user_id="+u.UserID, auditorToken, auditorCookie)
+			assert.DeepEqual(t, http.StatusOK, code)
+			assert.DeepEqual(t, float64(1), data["total"])
+		})
+	})
+}
+
+func TestAdminProtection(t *testing.T) {
+	mockey.PatchConvey("管理员封禁管理", t, func() {
+		h := newTestServer(t)
+		db := newSQLiteDB(t)
+		patchSQLiteMySQLConn(t, db)
+		redisdb.GetRedisClient().FlushAll(context.Background())
+		assert.Nil(t, db.Create(&storage.RoleRecord{Name: domain.RoleAdmin}).Error)
+		assert.Nil(t, db.Create(&storage.RolePermissionRecord{Role: domain.RoleAdmin, Permission: domain.PermAll}).Error)
+
+		adminIP := "127.0.0.1"
+		ip := "10.0.9.1"
+		admin := mustCreateUserViaService(t, "account45", "name0045", "password45")
+		assert.Nil(t, rbacsvc.NewDefault().AssignRole(context.Background(), admin.UserID, domain.RoleAdmin))
+		adminToken, adminCookie := loginAndGetAuth(t, h, adminIP, "account45", "name0045", "password45")
+		target := mustCreateUserViaService(t, "account46", "name0046", "password46")
+
+		adminCall := func(method, url, body string) (*ut.ResponseRecorder, dto.CommonResp) {
+			rr := perform(h, method, url, body,
+				ut.Header{Key: "X-Forwarded-For", Value: adminIP},
+				ut.Header{Key: "Authorization", Value: adminToken},
+				ut.Header{Key: "Cookie", Value: adminCookie},
+			)
+			return rr, decodeCommonResp(t, rr.Body.Bytes())
+		}
+		login := func(password string) (*ut.ResponseRecorder, dto.CommonResp) {
+			rr := perform(h, http.MethodPost, "/api/v1/user/login",
+				`{"account":"account46","password":"`+password+`"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "X-Challenge-Id", Value: "any"},
+				ut.Header{Key: "X-Challenge-Solution", Value: "solved"},
+			)
+			return rr, decodeCommonResp(t, rr.Body.Bytes())
+		}
+		listBlocks := func() []map[string]any {
+			_, resp := adminCall(http.MethodGet, "/api/v1/admin/blocks", "")
+			data, _ := resp.Data.(map[string]any)
+			blocks, _ := data["blocks"].([]any)
+			list := make([]map[string]any, 0, len(blocks))
+			for _, b := range blocks {
+				m, _ := b.(map[string]any)
+				list = append(list, m)
+			}
+			return list
+		}
+
+		t.Run("正常: 查看并解除登录失败导致的IP封禁", func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				login("badpassword")
+			}
+			rr, _ := login("password46")
+			assert.DeepEqual(t, http.StatusForbidden, rr.Code)
+
+			blocks := listBlocks()
+			assert.DeepEqual(t, 1, len(blocks))
+			assert.DeepEqual(t, "ip", blocks[0]["target"])
+			assert.DeepEqual(t, ip, blocks[0]["value"])
+			assert.DeepEqual(t, "login_failures", blocks[0]["reason"])
+			assert.DeepEqual(t, "1", blocks[0]["detail"])
+			assert.True(t, blocks[0]["retry_after"].(float64) > 0)
+
+			_, resp := adminCall(http.MethodPost, "/api/v1/admin/blocks/unblock", `{"ip":"`+ip+`"}`)
+			data, _ := resp.Data.(map[string]any)
+			assert.DeepEqual(t, true, data["unblocked"])
+			assert.DeepEqual(t, 0, len(listBlocks()))
+
+			_, resp = login("password46")
+			assert.True(t, resp.Success)
+		})
+
+		t.Run("正常: 手动封禁账号直到解封", func(t *testing.T) {
+			rr, _ := adminCall(http.MethodPost, "/api/v1/admin/blocks", `{"account":"account46","note":"abuse"}`)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+
+			rr, resp := login("password46")
+			assert.DeepEqual(t, http.StatusForbidden, rr.Code)
+			assert.DeepEqual(t, int(errs.AccountLocked.Code()), resp.Code)
+			assert.DeepEqual(t, "", rr.Header().Get("Retry-After"))
+
+			blocks := listBlocks()
+			assert.DeepEqual(t, 1, len(blocks))
+			assert.DeepEqual(t, "user:"+target.UserID, blocks[0]["value"])
+			assert.DeepEqual(t, "manual", blocks[0]["reason"])
+			assert.DeepEqual(t, "abuse", blocks[0]["detail"])
+			assert.DeepEqual(t, float64(0), blocks[0]["retry_after"])
+
+			adminCall(http.MethodPost, "/api/v1/admin/blocks/unblock", `{"account":"account46"}`)
+			_, resp = login("password46")
+			assert.True(t, resp.Success)
+		})
+
+		t.Run("正常: 白名单IP不会被封禁", func(t *testing.T) {
+			rr, _ := adminCall(http.MethodPost, "/api/v1/admin/allowlist", `{"entry":"10.0.9.0/24"}`)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			adminCall(http.MethodPost, "/api/v1/admin/blocks", `{"ip":"`+ip+`","minutes":10}`)
+
+			_, resp := login("password46")
+			assert.True(t, resp.Success)
+
+			adminCall(http.MethodPost, "/api/v1/admin/blocks", `{"account":"account46"}`)
+			rr, resp = login("password46")
+			assert.DeepEqual(t, http.StatusForbidden, rr.Code)
+			assert.DeepEqual(t, int(errs.AccountLocked.Code()), resp.Code)
+			adminCall(http.MethodPost, "/api/v1/admin/blocks/unblock", `{"account":"account46"}`)
+
+			_, resp = adminCall(http.MethodGet, "/api/v1/admin/allowlist", "")
+			data, _ := resp.Data.(map[string]any)
+			assert.DeepEqual(t, []any{"10.0.9.0/24"}, data["entries"])
+
+			_, resp = adminCall(http.MethodPost, "/api/v1/admin/allowlist/remove", `{"entry":"10.0.9.0/24"}`)
+			data, _ = resp.Data.(map[string]any)
+			assert.DeepEqual(t, true, data["removed"])
+
+			rr, resp = login("password46")
+			assert.DeepEqual(t, http.StatusForbidden, rr.Code)
+			assert.DeepEqual(t, int(errs.RequestBlocked.Code()), resp.Code)
+			assert.DeepEqual(t, "600", rr.Header().Get("Retry-After"))
+		})
+
+		t.Run("正常: 运行时设置IP过滤规则", func(t *testing.T) {
+			rr, _ := adminCall(http.MethodPost, "/api/v1/admin/ip_filter", `{"deny":["10.0.8.0/24"]}`)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+
+			rr = perform(h, http.MethodGet, "/ping", "", ut.Header{Key: "X-Forwarded-For", Value: "10.0.8.7"})
+			assert.DeepEqual(t, http.StatusForbidden, rr.Code)
+			assert.DeepEqual(t, int(errs.RequestBlocked.Code()), decodeCommonResp(t, rr.Body.Bytes()).Code)
+
+			_, resp := adminCall(http.MethodGet, "/api/v1/admin/ip_filter", "")
+			data, _ := resp.Data.(map[string]any)
+			assert.DeepEqual(t, []any{"10.0.8.0/24"}, data["deny"])
+			assert.DeepEqual(t, []any{}, data["allow"])
+
+			rr, _ = adminCall(http.MethodPost, "/api/v1/admin/ip_filter", `{"deny":["10.0.8"]}`)
+			assert.DeepEqual(t, http.StatusBadRequest, rr.Code)
+
+			adminCall(http.MethodPost, "/api/v1/admin/ip_filter", `{}`)
+			rr = perform(h, http.MethodGet, "/ping", "", ut.Header{Key: "X-Forwarded-For", Value: "10.0.8.7"})
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+		})
+
+		t.Run("正常: 查看限流状态", func(t *testing.T) {
+			rr, resp := adminCall(http.MethodGet, "/api/v1/admin/rate_limit/status", "")
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			data, _ := resp.Data.(map[string]any)
+			assert.DeepEqual(t, "redis", data["mode"])
+			assert.DeepEqual(t, float64(1), data["instances"])
+		})
+
+		t.Run("参数错误: IP与账号同时给出或白名单格式错误", func(t *testing.T) {
+			rr, _ := adminCall(http.MethodPost, "/api/v1/admin/blocks", `{"ip":"`+ip+`","account":"account46"}`)
+			assert.DeepEqual(t, http.StatusBadRequest, rr.Code)
+			rr, _ = adminCall(http.MethodPost, "/api/v1/admin/blocks/unblock", `{}`)
+			assert.DeepEqual(t, http.StatusBadRequest, rr.Code)
+			rr, _ = adminCall(http.MethodPost, "/api/v1/admin/allowlist", `{"entry":"10.0.9"}`)
+			assert.DeepEqual(t, http.StatusBadRequest, rr.Code)
+		})
+	})
+}
+
+func TestMFALogin(t *testing.T) {
+	mockey.PatchConvey("TOTP两步验证登录", t, func() {
+		h := newTestServer(t)
+		db := newSQLiteDB(t)
+		patchSQLiteMySQLConn(t, db)
+
+		ip := "127.0.0.1"
+		account := "account40"
+		name := "name0040"
+		password := "password40"
+		mustCreateUserViaService(t, account, name, password)
+
+		accessToken, cookieHeader := loginAndGetAuth(t, h, ip, account, name, password)
+		authHeaders := []ut.Header{
+			{Key: "X-Forwarded-For", Value: ip},
+			{Key: "Authorization", Value: accessToken},
+			{Key: "Cookie", Value: cookieHeader},
+		}
+
+		rr := perform(h, http.MethodPost, "/api/v1/user/mfa/totp/enroll", `{}`, authHeaders...)
+		resp := decodeCommonResp(t, rr.Body.Bytes())
+		assert.True(t, resp.Success)
+		secret := resp.Data.(map[string]any)["secret"].(string)
+
+		code, err := totp.GenerateCode(secret, time.Now().Add(-totp.Period*time.Second))
+		assert.Nil(t, err)
+		rr = perform(h, http.MethodPost, "/api/v1/user/mfa/totp/confirm", `{"code":"`+code+`"}`, authHeaders...)
+		resp = decodeCommonResp(t, rr.Body.Bytes())
+		assert.True(t, resp.Success)
+		recoveryCodes := resp.Data.(map[string]any)["recovery_codes"].([]any)
+		assert.True(t, len(recoveryCodes) > 0)
+
+		login := func() string {
+			rr := perform(h, http.MethodPost, "/api/v1/user/login",
+				`{"account":"`+account+`","password":"`+password+`"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+			)
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.True(t, resp.Success)
+			data := resp.Data.(map[string]any)
+			assert.DeepEqual(t, true, data["mfa_required"])
+			assert.DeepEqual(t, "", data["access_token"])
+			assert.DeepEqual(t, 0, len(cookiesFromRecorder(t, rr)["refresh_token"]))
+			return data["mfa_challenge"].(string)
+		}
+
+		t.Run("正常: 密码正确后返回challenge，验证码通过后下发token", func(t *testing.T) {
+			challenge := login()
+			code, err := totp.GenerateCode(secret, time.Now())
+			assert.Nil(t, err)
+			rr := perform(h, http.MethodPost, "/api/v1/user/mfa/verify",
+				`{"challenge":"`+challenge+`","code":"`+code+`"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+			)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			at := parseAccessToken(t, rr)
+			assert.True(t, at != "")
+			assert.True(t, cookiesFromRecorder(t, rr)["refresh_token"] != "")
+		})
+
+		t.Run("正常: 恢复码可完成登录", func(t *testing.T) {
+			challenge := login()
+			rr := perform(h, http.MethodPost, "/api/v1/user/mfa/verify",
+				`{"challenge":"`+challenge+`","code":"`+recoveryCodes[0].(string)+`"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+			)
+			assert.True(t, parseAccessToken(t, rr) != "")
+		})
+
+		t.Run("LoginProtection: 关闭TOTP时连续验证码错误触发IP block", func(t *testing.T) {
+			disableIP := "127.0.0.41"
+			headers := []ut.Header{
+				{Key: "X-Forwarded-For", Value: disableIP},
+				{Key: "Authorization", Value: accessToken},
+				{Key: "Cookie", Value: cookieHeader},
+			}
+			for i := 0; i < 3; i++ {
+				rr := perform(h, http.MethodPost, "/api/v1/user/mfa/totp/disable", `{"code":"abcdefgh"}`, headers...)
+				resp := decodeCommonResp(t, rr.Body.Bytes())
+				assert.DeepEqual(t, int(errs.MFACodeInvalid.Code()), resp.Code)
+			}
+
+			code, err := totp.GenerateCode(secret, time.Now())
+			assert.Nil(t, err)
+			rr := perform(h, http.MethodPost, "/api/v1/user/mfa/totp/disable", `{"code":"`+code+`"}`, headers...)
+			assert.DeepEqual(t, http.StatusForbidden, rr.Code)
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.DeepEqual(t, int(errs.RequestBlocked.Code()), resp.Code)
+		})
+
+		t.Run("LoginProtection: 连续验证码错误触发IP block", func(t *testing.T) {
+			redisdb.GetRedisClient().FlushAll(context.Background())
+			challenge := login()
+			for i := 0; i < 3; i++ {
+				rr := perform(h, http.MethodPost, "/api/v1/user/mfa/verify",
+					`{"challenge":"`+challenge+`","code":"abcdefgh"}`,
+					ut.Header{Key: "X-Forwarded-For", Value: ip},
+				)
+				resp := decodeCommonResp(t, rr.Body.Bytes())
+				assert.DeepEqual(t, int(errs.MFACodeInvalid.Code()), resp.Code)
+			}
+
+			rr := perform(h, http.MethodPost, "/api/v1/user/mfa/verify",
+				`{"challenge":"`+challenge+`","code":"abcdefgh"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+			)
+			assert.DeepEqual(t, http.StatusForbidden, rr.Code)
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.DeepEqual(t, int(errs.RequestBlocked.Code()), resp.Code)
+		})
+	})
+}
+
+// decodeOptions re-decodes the options field of a passkey begin response.
+func decodeOptions(t *testing.T, rr *ut.ResponseRecorder, v any) {
+	resp := decodeCommonResp(t, rr.Body.Bytes())
+	assert.True(t, resp.Success)
+	raw, err := json.Marshal(resp.Data.(map[string]any)["options"])
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(raw, v))
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	mockey.PatchConvey("通行密钥注册与登录", t, func() {
+		h := newTestServer(t)
+		db := newSQLiteDB(t)
+		patchSQLiteMySQLConn(t, db)
+
+		ip := "127.0.0.1"
+		account := "account41"
+		name := "name0041"
+		password := "password41"
+		u := mustCreateUserViaService(t, account, name, password)
+
+		authenticator, err := passkeytest.New("localhost", "http://localhost:8000")
+		assert.Nil(t, err)
+
+		accessToken, cookieHeader := loginAndGetAuth(t, h, ip, account, name, password)
+		authHeaders := []ut.Header{
+			{Key: "X-Forwarded-For", Value: ip},
+			{Key: "Authorization", Value: accessToken},
+			{Key: "Cookie", Value: cookieHeader},
+		}
+
+		rr := perform(h, http.MethodPost, "/api/v1/user/passkey/register/begin", `{}`, authHeaders...)
+		var creation webauthnprotocol.CredentialCreation
+		decodeOptions(t, rr, &creation)
+		body, err := authenticator.Create(&creation)
+		assert.Nil(t, err)
+
+		rr = perform(h, http.MethodPost, "/api/v1/user/passkey/register/finish", string(body), authHeaders...)
+		resp := decodeCommonResp(t, rr.Body.Bytes())
+		assert.True(t, resp.Success)
+
+		t.Run("异常: challenge只能使用一次", func(t *testing.T) {
+			rr := perform(h, http.MethodPost, "/api/v1/user/passkey/register/finish", string(body), authHeaders...)
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.DeepEqual(t, int(errs.PasskeyCeremonyExpired.Code()), resp.Code)
+		})
+
+		t.Run("正常: 无需账号密码，通行密钥登录后下发token", func(t *testing.T) {
+			rr := perform(h, http.MethodPost, "/api/v1/user/passkey/login/begin", `{}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+			)
+			var assertion webauthnprotocol.CredentialAssertion
+			decodeOptions(t, rr, &assertion)
+			sessCookie := cookieHeaderFromRecorder(t, rr)
+			body, err := authenticator.Get(&assertion)
+			assert.Nil(t, err)
+
+			rr = perform(h, http.MethodPost, "/api/v1/user/passkey/login/finish", string(body),
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Cookie", Value: sessCookie},
+			)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			at := parseAccessToken(t, rr)
+			assert.DeepEqual(t, u.UserID, parseAccessClaims(t, at).UserID)
+			assert.True(t, cookiesFromRecorder(t, rr)["refresh_token"] != "")
+
+			cookie := sessCookie
+			for k, v := range cookiesFromRecorder(t, rr) {
+				cookie = setCookie(cookie, k, v)
+			}
+			rr = perform(h, http.MethodGet, "/api/v1/user/info", "",
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Authorization", Value: at},
+				ut.Header{Key: "Cookie", Value: cookie},
+			)
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.True(t, resp.Success)
+		})
+
+		t.Run("异常: 未开始ceremony直接完成登录", func(t *testing.T) {
+			rr := perform(h, http.MethodPost, "/api/v1/user/passkey/login/finish", string(body),
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+			)
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.DeepEqual(t, int(errs.PasskeyCeremonyExpired.Code()), resp.Code)
+		})
+	})
+}
+
+func TestOIDCLogin(t *testing.T) {
+	mockey.PatchConvey("OIDC第三方登录与绑定", t, func() {
+		h := newTestServer(t)
+		db := newSQLiteDB(t)
+		patchSQLiteMySQLConn(t, db)
+
+		ip := "127.0.0.1"
+		authorize := func(path string, headers ...ut.Header) (string, string, string) {
+			rr := perform(h, http.MethodPost, path, `{}`, append(headers, ut.Header{Key: "X-Forwarded-For", Value: ip})...)
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.True(t, resp.Success)
+			code, state, err := testIdP.Authorize(resp.Data.(map[string]any)["authorization_url"].(string))
+			assert.Nil(t, err)
+			return cookieHeaderFromRecorder(t, rr), code, state
+		}
+
+		t.Run("正常: 首次登录自动创建用户并下发token", func(t *testing.T) {
+			testIdP.Subject, testIdP.Email, testIdP.Name = "oidc-sub-01", "bob@example.com", "Bob"
+			cookie, code, state := authorize("/api/v1/user/oidc/fake/authorize")
+
+			rr := perform(h, http.MethodPost, "/api/v1/user/oidc/callback",
+				`{"code":"`+code+`","state":"`+state+`"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Cookie", Value: cookie},
+			)
+			assert.DeepEqual(t, http.StatusOK, rr.Code)
+			at := parseAccessToken(t, rr)
+			assert.True(t, cookiesFromRecorder(t, rr)["refresh_token"] != "")
+
+			for k, v := range cookiesFromRecorder(t, rr) {
+				cookie = setCookie(cookie, k, v)
+			}
+			rr = perform(h, http.MethodGet, "/api/v1/user/info", "",
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Authorization", Value: at},
+				ut.Header{Key: "Cookie", Value: cookie},
+			)
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.True(t, resp.Success)
+			assert.DeepEqual(t, "Bob", resp.Data.(map[string]any)["name"])
+
+			// the flow is removed from the session after the first callback
+			rr = perform(h, http.MethodPost, "/api/v1/user/oidc/callback",
+				`{"code":"`+code+`","state":"`+state+`"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Cookie", Value: cookie},
+			)
+			resp = decodeCommonResp(t, rr.Body.Bytes())
+			assert.DeepEqual(t, int(errs.OIDCStateInvalid.Code()), resp.Code)
+		})
+
+		t.Run("正常: 已登录用户绑定外部账号后可通过IdP登录", func(t *testing.T) {
+			account := "account42"
+			password := "password42"
+			u := mustCreateUserViaService(t, account, "name0042", password)
+			accessToken, cookieHeader := loginAndGetAuth(t, h, ip, account, "name0042", password)
+			authHeaders := []ut.Header{
+				{Key: "Authorization", Value: accessToken},
+				{Key: "Cookie", Value: cookieHeader},
+			}
+
+			testIdP.Subject = "oidc-sub-02"
+			cookie, code, state := authorize("/api/v1/user/oidc/fake/link", authHeaders...)
+			for _, part := range strings.Split(cookie, "; ") {
+				k, v, _ := strings.Cut(part, "=")
+				cookieHeader = setCookie(cookieHeader, k, v)
+			}
+			rr := perform(h, http.MethodPost, "/api/v1/user/oidc/link/callback",
+				`{"code":"`+code+`","state":"`+state+`"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Authorization", Value: accessToken},
+				ut.Header{Key: "Cookie", Value: cookieHeader},
+			)
+			resp := decodeCommonResp(t, rr.Body.Bytes())
+			assert.True(t, resp.Success)
+
+			cookie, code, state = authorize("/api/v1/user/oidc/fake/authorize")
+			rr = perform(h, http.MethodPost, "/api/v1/user/oidc/callback",
+				`{"code":"`+code+`","state":"`+state+`"}`,
+				ut.Header{Key: "X-Forwarded-For", Value: ip},
+				ut.Header{Key: "Cookie", Value: cookie},
+			)
+			assert.DeepEqual(t, u.UserID, parseAccessClaims(t, parseAccessToken(t, rr)).UserID)
+		})
+	})
+}
@@ -4,8 +4,12 @@ package main
 
 import (
 	handler "doing_now/be/biz/handler"
+	"doing_now/be/biz/middleware/authz"
 	"doing_now/be/biz/middleware/jwt"
+	"doing_now/be/biz/middleware/quota"
 	"doing_now/be/biz/middleware/security"
+	"doing_now/be/biz/model/domain"
+	quotasvc "doing_now/be/biz/service/quota"
 
 	"github.com/cloudwego/hertz/pkg/app/server"
 )
@@ -13,21 +17,73 @@ import (
 // customizeRegister registers customize routers.
 func customizedRegister(r *server.Hertz) {
 	r.GET("/ping", handler.Ping)
+	r.GET("/.well-known/jwks.json", handler.JWKS)
 
 	api := r.Group("/api/v1")
 	{
 		user := api.Group("/user")
 		{
-			user.POST("/register", security.NewRegisterProtection(), handler.Register)
-			user.POST("/login", security.NewLoginProtection(), handler.Login)
+			user.POST("/register", security.NewRegisterProtection(), security.NewChallenge(), handler.Register)
+			user.POST("/login", security.NewLoginProtection(), security.NewChallenge(), handler.Login)
 			user.POST("/refresh_token", handler.RefreshToken)
-			loginUser := user.Group("/", jwt.ValidateMW(), security.NewCredentialCheck())
+			user.POST("/mfa/verify", security.NewMFAProtection(), handler.VerifyMFA)
+			user.POST("/passkey/login/begin", handler.BeginPasskeyLogin)
+			user.POST("/passkey/login/finish", security.NewPasskeyProtection(), handler.FinishPasskeyLogin)
+			user.POST("/oidc/:provider/authorize", handler.OIDCAuthorize)
+			user.POST("/oidc/callback", handler.OIDCCallback)
+			user.POST("/password/forgot", handler.ForgotPassword)
+			user.POST("/password/reset", handler.ResetPassword)
+			user.POST("/verify_email", handler.VerifyEmail)
+			// not metered, so users can still read their quotas once one is used up
+			user.GET("/quotas", jwt.ValidateMW(), security.NewCredentialCheck(), security.NewSessionActivity(), handler.GetQuotas)
+			loginUser := user.Group("/", jwt.ValidateMW(), security.NewCredentialCheck(), security.NewSessionActivity(), quota.New(quotasvc.FeatureAPI))
 			{
 				loginUser.POST("/logout", handler.Logout)
 				loginUser.GET("/info", handler.GetUserInfo)
 				loginUser.POST("/update_info", handler.UpdateInfo)
 				loginUser.POST("/update_password", handler.UpdatePassword)
+				loginUser.POST("/update_email", handler.UpdateEmail)
+				loginUser.POST("/mfa/totp/enroll", handler.EnrollTOTP)
+				loginUser.POST("/mfa/totp/confirm", handler.ConfirmTOTP)
+				loginUser.POST("/mfa/totp/disable", security.NewMFAProtection(), handler.DisableTOTP)
+				loginUser.POST("/passkey/register/begin", handler.BeginPasskeyRegistration)
+				loginUser.POST("/passkey/register/finish", handler.FinishPasskeyRegistration)
+				loginUser.POST("/oidc/:provider/link", handler.OIDCLink)
+				loginUser.POST("/oidc/link/callback", handler.OIDCLinkCallback)
+				loginUser.GET("/permissions", handler.GetPermissions)
+				loginUser.GET("/rate_limits", handler.GetRateLimits)
+				loginUser.GET("/audit_events", handler.ListMyAuditEvents)
+				loginUser.GET("/sessions", handler.ListSessions)
+				loginUser.DELETE("/sessions/:id", handler.RevokeSession)
 			}
 		}
+
+		admin := api.Group("/admin", jwt.ValidateMW(), security.NewCredentialCheck(), security.NewSessionActivity())
+		{
+			read := authz.Require(domain.PermUserRead)
+			write := authz.Require(domain.PermUserWrite)
+			admin.GET("/users", read, handler.AdminListUsers)
+			admin.GET("/users/:user_id", read, handler.AdminGetUser)
+			admin.POST("/users/:user_id/disable", write, handler.AdminDisableUser)
+			admin.POST("/users/:user_id/enable", write, handler.AdminEnableUser)
+			admin.POST("/users/:user_id/reset_password", write, handler.AdminResetPassword)
+			admin.POST("/users/:user_id/revoke_sessions", write, handler.AdminRevokeSessions)
+			admin.POST("/users/:user_id/unlock", write, handler.AdminUnlockUser)
+			admin.POST("/users/:user_id/restore", write, handler.AdminRestoreUser)
+			admin.POST("/users/:user_id/plan", write, handler.AdminAssignPlan)
+			admin.GET("/audit_events", authz.Require(domain.PermAuditRead), handler.AdminListAuditEvents)
+
+			protectionRead := authz.Require(domain.PermProtectionRead)
+			protectionWrite := authz.Require(domain.PermProtectionWrite)
+			admin.GET("/blocks", protectionRead, handler.AdminListBlocks)
+			admin.POST("/blocks", protectionWrite, handler.AdminBlock)
+			admin.POST("/blocks/unblock", protectionWrite, handler.AdminUnblock)
+			admin.GET("/allowlist", protectionRead, handler.AdminListAllowlist)
+			admin.POST("/allowlist", protectionWrite, handler.AdminAddAllowlist)
+			admin.POST("/allowlist/remove", protectionWrite, handler.AdminRemoveAllowlist)
+			admin.GET("/ip_filter", protectionRead, handler.AdminGetIPFilter)
+			admin.POST("/ip_filter", protectionWrite, handler.AdminSetIPFilter)
+			admin.GET("/rate_limit/status", protectionRead, handler.AdminGetRateLimitStatus)
+		}
 	}
 }